@@ -9,25 +9,34 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// GetAlbums is the function called when a user accesses /albums on the frontend.
+// GetAlbums is the function called when a user accesses /albums on the
+// frontend. Albums are paged, sorted and filtered by the list parameters.
 func GetAlbums(c *gin.Context) {
 	c.Header("Content-Type", "application/json; charset=UTF-8")
-	c.Header("Access-Control-Allow-Origin", "*")
+
+	o, ok := listOptions(c, db.AlbumSorts)
+	if !ok {
+		return
+	}
 	
-	albums, err := db.DB.AllAlbumsByTitle()
+	albums, total, err := db.DB.ListAlbums(o)
 	if err != nil {
 		util.Logger.Print(err)
-		c.JSON(500, ErrGeneric)
+		c.IndentedJSON(500, serverErr)
 		return
 	}
 
+	lastID := 0
+	if len(albums) > 0 {
+		lastID = albums[len(albums)-1].ID
+	}
+	listHeaders(c, o, total, len(albums), lastID)
 	c.IndentedJSON(200, albums)
 	return
 }
 
 func GetAlbum(c *gin.Context)	{
 	c.Header("Content-Type", "application/json; charset=UTF-8")
-	c.Header("Access-Control-Allow-Origin", "*")
 
 	sID := c.Param("id")
 	id, err := strconv.Atoi(sID)
@@ -43,6 +52,9 @@ func GetAlbum(c *gin.Context)	{
 		c.JSON(500, ErrGeneric)
 		return
 	}
+	if !itemAccessible(c, &album, "album ID not found") {
+		return
+	}
 
 	songs, err := db.DB.SongsForAlbum(album.ID)
 	if err != nil {
@@ -50,15 +62,21 @@ func GetAlbum(c *gin.Context)	{
 		c.JSON(200, ErrGeneric)
 		return
 	}
+	if err := db.SongSlice(songs).LoadCredits(); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
 
+	// Songs are grouped by disc, in disc and then track order
 	type AlbumResponse struct{
-		album db.Album   `json:"album"`
-		songs []db.Song  `json:"songs"`
+		Album db.Album   `json:"album"`
+		Discs []db.Disc  `json:"discs"`
 	}
 
 	resp := AlbumResponse{
-		album: album,
-		songs: songs,
+		Album: album,
+		Discs: db.SongSlice(songs).Discs(),
 	}
 	c.IndentedJSON(200, resp)
 	return
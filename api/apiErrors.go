@@ -28,6 +28,8 @@ func (e Error) Err() string {
 }
 
 var (
+	authErr = ErrorResponse{&Error{Code: 401, Message: "Authentication Required"}}
+	credentialsErr = ErrorResponse{&Error{Code: 401, Message: "Invalid Username or Password"}}
 	permissionErr = ErrorResponse{&Error{Code: 403, Message: "Permission Denied"}}
 	serverErr = ErrorResponse{&Error{Code: 500, Message: "Server Error"}}
 )
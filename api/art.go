@@ -1,14 +1,17 @@
 package api
 
 import (
-	"bytes"
 	"database/sql"
-	"image"
-	"image/jpeg"
-	"image/png"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
 	"strconv"
+	"time"
 
 	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/thumbnail"
+	"github.com/eHoward1996/aiomst/util"
 
 	"github.com/gin-gonic/gin"
 )
@@ -39,39 +42,90 @@ func GetArt(c *gin.Context) {
 		c.IndentedJSON(500, serverErr)
 		return
 	}
-
-	stream, err := art.Stream()
-	if err != nil {
-		c.IndentedJSON(404, err)
+	if !itemAccessible(c, art, "Art ID not found") {
 		return
 	}
-	
-	img, imgFormat, err := image.Decode(stream)
-	if err != nil {
-		c.IndentedJSON(404, err)
-		return
+
+	ServeArt(c, art)
+}
+
+// ServeArt writes art to the response. A size parameter resizes it to fit
+// within a square, rounded up to one of the cached sizes, and WebP or AVIF is
+// sent to clients which accept them. The original file is sent when neither
+// applies. Responses carry a Last-Modified header and an ETag, and
+// http.ServeContent takes care of the conditional logic.
+func ServeArt(c *gin.Context, art *db.Art) {
+	size := 0
+	if s := c.Query("size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			c.IndentedJSON(400, "Invalid integer art size")
+			return
+		}
+		size = thumbnail.Size(n)
+	}
+
+	// Art which cannot be decoded is sent as it is
+	source, err := thumbnail.SourceFormat(art)
+	format := source
+	if err == nil {
+		format = thumbnail.Negotiate(c.GetHeader("Accept"), source)
+		c.Header("Vary", "Accept")
+	} else {
+		size = 0
 	}
 
-	buffer := bytes.NewBuffer(nil)
-	if imgFormat == "jpeg" {
-		// JPEG, lossy encoding, default quality
-		if err := jpeg.Encode(buffer, img, nil); err != nil {
+	var stream io.ReadSeeker
+	if size == 0 && format == source {
+		if stream, err = art.Stream(); err != nil {
 			c.IndentedJSON(404, err)
 			return
 		}
-		c.Header("Content-Type", "image/jpeg")
 	} else {
-		// Always send PNG as a backup
-		// PNG, lossless encoding
-		if err := png.Encode(buffer, img); err != nil {
+		file, err := thumbnail.Get(art, size, format)
+		if err != nil && format != thumbnail.Negotiate("", source) {
+			// Fall back to JPEG or PNG when the encoder fails
+			format = thumbnail.Negotiate("", source)
+			file, err = thumbnail.Get(art, size, format)
+		}
+		if err != nil {
+			util.Logger.Print(err)
 			c.IndentedJSON(404, err)
 			return
 		}
-		c.Header("Content-Type", "image/png")
+		if stream, err = os.Open(file); err != nil {
+			util.Logger.Print(err)
+			c.IndentedJSON(500, serverErr)
+			return
+		}
 	}
+	if closer, ok := stream.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	mime, ok := thumbnail.MIMETypes[format]
+	if !ok {
+		// Sniff the type, as embedded art's path is its audio file
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(stream, head)
+		mime = http.DetectContentType(head[:n])
+		if _, err := stream.Seek(0, io.SeekStart); err != nil {
+			c.IndentedJSON(500, serverErr)
+			return
+		}
+	}
+
+	c.Header("Content-Type", mime)
+	c.Header("Last-Modified", util.UNIXtoRFC1123(art.LastModified))
+	c.Header("ETag", artETag(art, size, format))
+
+	http.ServeContent(
+		c.Writer, c.Request, "", time.Unix(art.LastModified, 0), stream)
+}
 
-	// Serve content directly, account for range headers, and enabling caching.
-	// http.ServeContent(w, r, art.FileName, time.Unix(art.LastModified, 0), bytes.NewReader(buffer.Bytes()))
-	c.Data(200, c.ContentType(), buffer.Bytes())
-	return
+// artETag builds a strong entity tag for art at a size and format from the
+// properties of its file which change whenever the file is rewritten
+func artETag(art *db.Art, size int, format string) string {
+	return fmt.Sprintf(`"%x-%x-%x-%x-%s"`,
+		art.ID, art.LastModified, art.FileSize, size, format)
 }
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"fmt"
 	"strconv"
 
 	"github.com/eHoward1996/aiomst/db"
@@ -16,41 +15,60 @@ type ArtistResponse struct {
 	Songs 		[]db.Song  		`json:"songs"`
 }
 
+// GetArtists lists the artists with songs in the libraries the user may
+// access, paged, sorted and filtered by the list parameters
 func GetArtists(c *gin.Context)	{
 	c.Header("Content-Type", "application/json; charset=UTF-8")
-	c.Header("Access-Control-Allow-Origin", "*")
-	
-	if limit := c.Query("limit"); limit != "" {
-		var offset, count int
-		if n, err := fmt.Sscanf(limit, "%d,%d", &offset, &count); n < 2 || err != nil {
-			c.IndentedJSON(400, "Invalid comma-seperated integer pair.")
-			return
-		}
-
-		artists, err := db.DB.LimitArtists(offset, count)
-		if err != nil {
-			util.Logger.Print(err)
-			c.IndentedJSON(500, serverErr)
-			return
-		}
-
-		c.IndentedJSON(200, artists)
+
+	o, ok := listOptions(c, db.ArtistSorts)
+	if !ok {
 		return
 	}
 
-	artists, err := db.DB.AllArtistsByTitle()
+	artists, total, err := db.DB.ListArtists(o)
 	if err != nil {
 		util.Logger.Print(err)
-		c.JSON(500, ErrGeneric)
+		c.IndentedJSON(500, serverErr)
 		return
 	}
 
+	lastID := 0
+	if len(artists) > 0 {
+		lastID = artists[len(artists)-1].ID
+	}
+	listHeaders(c, o, total, len(artists), lastID)
 	c.IndentedJSON(200, artists)
 }
 
+// GetArtistIndex lists the artists with songs in the libraries the user may
+// access, grouped by the first letter of their sort title
+func GetArtistIndex(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	libraries, ok := libraryFilter(c)
+	if !ok {
+		return
+	}
+
+	artists, err := db.DB.ArtistsInLibraries(libraries)
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	type ArtistIndexResponse struct {
+		IgnoredArticles []string         `json:"ignoredArticles"`
+		Index           []db.ArtistIndex `json:"index"`
+	}
+	c.IndentedJSON(200, ArtistIndexResponse{
+		IgnoredArticles: util.Current().Tags.IgnoredArticles,
+		Index:           db.IndexArtists(artists),
+	})
+}
+
 func GetArtist(c *gin.Context) {
 	c.Header("Content-Type", "application/json; charset=UTF-8")
-	c.Header("Access-Control-Allow-Origin", "*")
 
 	sID := c.Param("id")
 	util.Logger.Print(sID)
@@ -67,6 +85,9 @@ func GetArtist(c *gin.Context) {
 		c.JSON(500, serverErr)
 		return
 	}
+	if !itemAccessible(c, &artist, "artist ID not found") {
+		return
+	}
 
 	albums, err := db.DB.AlbumsForArtist(artist.ID)
 	if err != nil {
@@ -75,22 +96,37 @@ func GetArtist(c *gin.Context) {
 		return
 	}
 	
+	// Albums of other artists with songs crediting this one
+	appearsOn, err := db.DB.AlbumAppearancesForArtist(artist.ID)
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+	
 	songs, err := db.DB.SongsForArtist(artist.ID)
 	if err != nil {
 		util.Logger.Print(err)
 		c.JSON(200, ErrGeneric)
 		return
 	}
+	if err := db.SongSlice(songs).LoadCredits(); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
 	
 	type ArtistResponse struct {
 		Artist  	db.Artist  `json:"artist"`
 		Albums		[]db.Album `json:"albums"`
+		AppearsOn []db.Album `json:"appearsOn"`
 		Songs 		[]db.Song  `json:"songs"`
 	}
 
 	resp := ArtistResponse{
 		Artist: artist,
 		Albums: albums,
+		AppearsOn: appearsOn,
 		Songs: songs,
 	}
 	c.IndentedJSON(200, resp)
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Context keys set by Authenticate
+const (
+	userKey    = "user"
+	sessionKey = "session"
+)
+
+// credentials is the body of a login request
+type credentials struct {
+	Username string `form:"username" json:"username"`
+	Password string `form:"password" json:"password"`
+	Client   string `form:"client" json:"client"`
+}
+
+// requestKey returns the session or API key sent with a request. It may be
+// sent as a bearer token, in the X-API-Key header, or as the key query
+// parameter for clients such as <audio> elements which cannot set headers.
+func requestKey(c *gin.Context) string {
+	if h := c.GetHeader("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	if h := c.GetHeader("X-API-Key"); h != "" {
+		return h
+	}
+	return c.Query("key")
+}
+
+// Authenticate is a middleware which rejects requests that do not carry a
+// valid session or API key. The authenticated user is stored in the context
+// and is available through CurrentUser.
+func Authenticate(c *gin.Context) {
+	key := requestKey(c)
+	if key == "" {
+		c.AbortWithStatusJSON(401, authErr)
+		return
+	}
+
+	user := &db.User{}
+	session := &db.Session{Key: key}
+	err := session.Load()
+	switch {
+	case err == nil:
+		if session.Expired() {
+			if err := session.Delete(); err != nil {
+				util.Logger.Print(err)
+			}
+			c.AbortWithStatusJSON(401, authErr)
+			return
+		}
+		user.ID = session.UserID
+		c.Set(sessionKey, session)
+	case err == sql.ErrNoRows:
+		// Not a session, try an API key
+		apiKey := &db.APIKey{Key: key}
+		if err := apiKey.Load(); err != nil {
+			if err != sql.ErrNoRows {
+				util.Logger.Print(err)
+				c.AbortWithStatusJSON(500, serverErr)
+				return
+			}
+			c.AbortWithStatusJSON(401, authErr)
+			return
+		}
+		user.ID = apiKey.UserID
+	default:
+		util.Logger.Print(err)
+		c.AbortWithStatusJSON(500, serverErr)
+		return
+	}
+
+	if err := user.Load(); err != nil {
+		if err != sql.ErrNoRows {
+			util.Logger.Print(err)
+			c.AbortWithStatusJSON(500, serverErr)
+			return
+		}
+		c.AbortWithStatusJSON(401, authErr)
+		return
+	}
+
+	SetCurrentUser(c, user)
+	c.Next()
+}
+
+// RequireRole returns a middleware which rejects users without at least the
+// privileges of role. It must run after Authenticate.
+func RequireRole(role int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if user == nil || !user.HasRole(role) {
+			c.AbortWithStatusJSON(403, permissionErr)
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentUser returns the user authenticated for this request, or nil
+func CurrentUser(c *gin.Context) *db.User {
+	if v, ok := c.Get(userKey); ok {
+		return v.(*db.User)
+	}
+	return nil
+}
+
+// SetCurrentUser stores the user authenticated for this request, for
+// middlewares other than Authenticate
+func SetCurrentUser(c *gin.Context, u *db.User) {
+	c.Set(userKey, u)
+}
+
+// requestClient returns the name of the client making the request: the
+// client the session was created for, or the Subsonic c parameter
+func requestClient(c *gin.Context) string {
+	if v, ok := c.Get(sessionKey); ok {
+		return v.(*db.Session).Client
+	}
+	return c.Request.FormValue("c")
+}
+
+// PostLogin checks a username and password and issues a new session
+func PostLogin(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	var creds credentials
+	if err := c.ShouldBind(&creds); err != nil || creds.Username == "" {
+		c.IndentedJSON(400, ErrMissingParam)
+		return
+	}
+	if creds.Client == "" {
+		creds.Client = c.Request.UserAgent()
+	}
+
+	user := &db.User{Username: creds.Username}
+	if err := user.Load(); err != nil {
+		if err != sql.ErrNoRows {
+			util.Logger.Print(err)
+			c.IndentedJSON(500, serverErr)
+			return
+		}
+
+		// Unknown users get the same response as wrong passwords
+		c.IndentedJSON(401, credentialsErr)
+		return
+	}
+	if !user.CheckPassword(creds.Password) {
+		c.IndentedJSON(401, credentialsErr)
+		return
+	}
+
+	session, err := db.NewSession(user, creds.Client)
+	if err == nil {
+		err = session.Save()
+	}
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	// Opportunistically clean up sessions which have expired
+	if err := db.DB.PurgeSessions(); err != nil {
+		util.Logger.Print(err)
+	}
+
+	c.IndentedJSON(200, gin.H{
+		"session": session,
+		"user":    userResponse(user),
+	})
+}
+
+// PostLogout ends the session used to make the request
+func PostLogout(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	v, ok := c.Get(sessionKey)
+	if !ok {
+		c.IndentedJSON(400, "Request was not made with a session key")
+		return
+	}
+
+	if err := v.(*db.Session).Delete(); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	c.Status(204)
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetGenres lists the genres with songs in the libraries the user may access,
+// along with their song and album counts
+func GetGenres(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	libraries, ok := libraryFilter(c)
+	if !ok {
+		return
+	}
+
+	genres, err := db.DB.GenresInLibraries(libraries)
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+	c.IndentedJSON(200, genres)
+}
+
+// GetGenreAlbums returns a genre along with the albums of its songs, paged,
+// sorted and filtered by the list parameters
+func GetGenreAlbums(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	genre, o, ok := loadGenreList(c, db.AlbumSorts)
+	if !ok {
+		return
+	}
+
+	albums, total, err := db.DB.ListAlbums(o)
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	lastID := 0
+	if len(albums) > 0 {
+		lastID = albums[len(albums)-1].ID
+	}
+	listHeaders(c, o, total, len(albums), lastID)
+
+	type GenreAlbumsResponse struct {
+		Genre  db.Genre   `json:"genre"`
+		Albums []db.Album `json:"albums"`
+	}
+	c.IndentedJSON(200, GenreAlbumsResponse{Genre: genre, Albums: albums})
+}
+
+// GetGenreSongs returns a genre along with its songs, paged, sorted and
+// filtered by the list parameters
+func GetGenreSongs(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	genre, o, ok := loadGenreList(c, db.SongSorts)
+	if !ok {
+		return
+	}
+
+	songs, total, err := db.DB.ListSongs(o)
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	lastID := 0
+	if len(songs) > 0 {
+		lastID = songs[len(songs)-1].ID
+	}
+	listHeaders(c, o, total, len(songs), lastID)
+
+	type GenreSongsResponse struct {
+		Genre db.Genre  `json:"genre"`
+		Songs []db.Song `json:"songs"`
+	}
+	c.IndentedJSON(200, GenreSongsResponse{Genre: genre, Songs: songs})
+}
+
+// loadGenreList loads the genre named by the id parameter, counting its songs
+// in the libraries the request is limited to, along with the list options of
+// the request limited to the genre. False is returned, after writing a
+// response, when either could not be loaded.
+func loadGenreList(c *gin.Context, sorts []string) (db.Genre, db.ListOptions, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(400, "Invalid integer genre ID")
+		return db.Genre{}, db.ListOptions{}, false
+	}
+
+	o, ok := listOptions(c, sorts)
+	if !ok {
+		return db.Genre{}, o, false
+	}
+
+	genre, err := db.DB.GenreInLibraries(id, o.Libraries)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// Genres only in libraries the user may not access are not revealed
+			c.IndentedJSON(404, "genre ID not found")
+			return db.Genre{}, o, false
+		}
+
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return db.Genre{}, o, false
+	}
+	o.GenreID = genre.ID
+	return genre, o, true
+}
@@ -0,0 +1,293 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// libraryRequest is the body of a request creating or changing a library.
+// Fields which are not sent are left unchanged.
+type libraryRequest struct {
+	Name         string `form:"name" json:"name"`
+	Root         string `form:"root" json:"root"`
+	Type         string `form:"type" json:"type"`
+	ScanInterval *int64 `form:"scanInterval" json:"scanInterval"`
+	Shared       *bool  `form:"shared" json:"shared"`
+	UserIDs      []int  `form:"userIds" json:"userIds"`
+}
+
+// libraryFilter returns the IDs of the libraries a request is limited to: the
+// one named by the library parameter, or else every library the user may
+// access. Nil is returned for admins, who may access every library. False is
+// returned, after writing a response, when the parameter is invalid or names
+// a library the user may not access.
+func libraryFilter(c *gin.Context) ([]int, bool) {
+	if c.Query("library") != "" {
+		l, ok := loadLibrary(c, c.Query("library"))
+		if !ok {
+			return nil, false
+		}
+		return []int{l.ID}, true
+	}
+
+	IDs, err := db.DB.LibraryIDsForUser(CurrentUser(c))
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return nil, false
+	}
+	return IDs, true
+}
+
+// accessor is an item which only users who may access its library may see
+type accessor interface {
+	AccessibleBy(u *db.User) (bool, error)
+}
+
+// itemAccessible reports whether the user making a request may access an
+// item's library. False is returned, after writing a response, when the item
+// may not be accessed; it is reported as not found with the given message, so
+// items the user may not access are not revealed.
+func itemAccessible(c *gin.Context, item accessor, notFound string) bool {
+	ok, err := item.AccessibleBy(CurrentUser(c))
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return false
+	}
+	if !ok {
+		c.IndentedJSON(404, notFound)
+		return false
+	}
+	return true
+}
+
+// songAccessible reports whether the user making a request may access a
+// song's library. False is returned, after writing a response, when the song
+// may not be accessed.
+func songAccessible(c *gin.Context, song *db.Song) bool {
+	return itemAccessible(c, song, "song ID not found")
+}
+
+// loadLibraryParam loads the library selected by the id path parameter and
+// checks the user may access it. False is returned, after writing a
+// response, when it could not be loaded.
+func loadLibraryParam(c *gin.Context) (*db.Library, bool) {
+	return loadLibrary(c, c.Param("id"))
+}
+
+// loadLibrary loads the library with the given ID and checks the user may
+// access it. False is returned, after writing a response, when it could not
+// be loaded.
+func loadLibrary(c *gin.Context, sID string) (*db.Library, bool) {
+	id, err := strconv.Atoi(sID)
+	if err != nil {
+		c.IndentedJSON(400, "Invalid integer library ID")
+		return nil, false
+	}
+
+	l := &db.Library{ID: id}
+	if err := l.Load(); err != nil {
+		if err == sql.ErrNoRows {
+			c.IndentedJSON(404, "library ID not found")
+			return nil, false
+		}
+
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return nil, false
+	}
+
+	if !l.AccessibleBy(CurrentUser(c)) {
+		// Do not reveal libraries the user may not access
+		c.IndentedJSON(404, "library ID not found")
+		return nil, false
+	}
+	return l, true
+}
+
+// libraryResponse hides a library's access list from users who are not
+// admins
+func libraryResponse(c *gin.Context, l db.Library) db.Library {
+	if !CurrentUser(c).HasRole(db.RoleAdmin) {
+		l.UserIDs = make([]int, 0)
+	}
+	return l
+}
+
+// applyLibraryRequest copies the fields sent in a request onto a library and
+// checks the result. An error describing the problem is returned when the
+// library is invalid.
+func applyLibraryRequest(l *db.Library, req libraryRequest) error {
+	if req.Root != "" {
+		root := path.Clean(util.ExpandHomeDir(req.Root))
+		if root != l.Root {
+			// Scan the new root as soon as possible
+			l.Root = root
+			l.LastScan = 0
+		}
+	}
+	if req.Name != "" {
+		l.Name = req.Name
+	}
+	if l.Name == "" {
+		l.Name = path.Base(l.Root)
+	}
+	if req.Type != "" {
+		l.Type = req.Type
+	}
+	if req.ScanInterval != nil {
+		l.ScanInterval = *req.ScanInterval
+	}
+	if req.Shared != nil {
+		l.Shared = *req.Shared
+	}
+	if req.UserIDs != nil {
+		l.UserIDs = req.UserIDs
+	}
+
+	if !path.IsAbs(l.Root) {
+		return fmt.Errorf("library root must be an absolute path: %q", l.Root)
+	}
+	if info, err := os.Stat(l.Root); err != nil || !info.IsDir() {
+		return fmt.Errorf("library root is not a folder: %q", l.Root)
+	}
+	if !db.LibraryTypes[l.Type] {
+		return fmt.Errorf("unknown library type: %q", l.Type)
+	}
+	if l.ScanInterval < 0 {
+		return fmt.Errorf("invalid scan interval: %d", l.ScanInterval)
+	}
+	if util.C.ReadOnly && l.Contains(path.Dir(util.C.SqlFilePath())) {
+		return fmt.Errorf("library root contains the database, which is written to")
+	}
+
+	libraries, err := db.DB.AllLibraries()
+	if err != nil {
+		return err
+	}
+	for i := range libraries {
+		if libraries[i].ID != l.ID && libraries[i].Overlaps(l) {
+			return fmt.Errorf("library root overlaps library %s", libraries[i])
+		}
+	}
+
+	for _, id := range l.UserIDs {
+		if err := (&db.User{ID: id}).Load(); err != nil {
+			return fmt.Errorf("user ID not found: %d", id)
+		}
+	}
+	return nil
+}
+
+// GetLibraries returns every library the user may access
+func GetLibraries(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	libraries, err := db.DB.LibrariesForUser(CurrentUser(c))
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	for i := range libraries {
+		libraries[i] = libraryResponse(c, libraries[i])
+	}
+	c.IndentedJSON(200, libraries)
+}
+
+// GetLibrary returns a single library
+func GetLibrary(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	l, ok := loadLibraryParam(c)
+	if !ok {
+		return
+	}
+
+	c.IndentedJSON(200, libraryResponse(c, *l))
+}
+
+// PostLibrary creates a new library. It is scanned and watched within a
+// minute. The name defaults to the root's folder name, and the type to music.
+func PostLibrary(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	var req libraryRequest
+	if err := c.ShouldBind(&req); err != nil || req.Root == "" {
+		c.IndentedJSON(400, ErrMissingParam)
+		return
+	}
+
+	l := db.NewLibrary("", "", db.LibraryMusic, 0)
+	if err := applyLibraryRequest(l, req); err != nil {
+		c.IndentedJSON(400, err.Error())
+		return
+	}
+
+	if err := l.Save(); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	util.Logger.Printf("API: Created library %s", l)
+	c.IndentedJSON(201, l)
+}
+
+// PutLibrary changes a library's settings. Changing its root rescans it.
+func PutLibrary(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	l, ok := loadLibraryParam(c)
+	if !ok {
+		return
+	}
+
+	var req libraryRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.IndentedJSON(400, ErrMissingParam)
+		return
+	}
+	if err := applyLibraryRequest(l, req); err != nil {
+		c.IndentedJSON(400, err.Error())
+		return
+	}
+
+	if err := l.Update(); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	c.IndentedJSON(200, l)
+}
+
+// DeleteLibrary removes a library along with everything scanned from it. The
+// files themselves are left untouched.
+func DeleteLibrary(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	l, ok := loadLibraryParam(c)
+	if !ok {
+		return
+	}
+
+	if err := l.Delete(); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	util.Logger.Printf("API: Deleted library %s", l)
+	c.Status(204)
+}
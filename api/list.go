@@ -0,0 +1,178 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/eHoward1996/aiomst/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// encodeCursor returns the opaque cursor continuing a list after the item
+// with the given ID. Only the ID is kept, and the item is compared with the
+// others by the sort in use when the cursor is decoded, so reloading a change
+// to the ignored articles between pages may skip or repeat items.
+func encodeCursor(ID int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(ID)))
+}
+
+// decodeCursor returns the ID of the item a cursor continues a list after
+func decodeCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	ID, err := strconv.Atoi(string(b))
+	if err != nil || ID < 1 {
+		return 0, fmt.Errorf("invalid cursor: %q", cursor)
+	}
+	return ID, nil
+}
+
+// intQuery parses an optional, non-negative integer parameter, which is zero
+// when it is not set. False is returned, after writing a response, when it is
+// invalid.
+func intQuery(c *gin.Context, name string) (int, bool) {
+	s := c.Query(name)
+	if s == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		c.IndentedJSON(400, "Invalid integer "+name)
+		return 0, false
+	}
+	return n, true
+}
+
+// limitQuery parses the limit parameter, an older comma-seperated offset and
+// count, into offset and count, which are left alone when it is unset. False
+// is returned, after writing a response, when it is invalid.
+func limitQuery(c *gin.Context, offset, count *int) bool {
+	limit := c.Query("limit")
+	if limit == "" {
+		return true
+	}
+
+	var o, n int
+	if m, err := fmt.Sscanf(limit, "%d,%d", &o, &n); m < 2 || err != nil || o < 0 || n < 0 {
+		c.IndentedJSON(400, "Invalid comma-seperated integer pair.")
+		return false
+	}
+	*offset, *count = o, n
+	return true
+}
+
+// listOptions parses the paging, sorting and filtering parameters shared by
+// the list endpoints, the sort being one of sorts. False is returned, after
+// writing a response, when one is invalid.
+func listOptions(c *gin.Context, sorts []string) (db.ListOptions, bool) {
+	var o db.ListOptions
+	var ok bool
+	if o.Libraries, ok = libraryFilter(c); !ok {
+		return o, false
+	}
+
+	if o.Sort = c.Query("sort"); o.Sort != "" {
+		known := false
+		for _, s := range sorts {
+			known = known || s == o.Sort
+		}
+		if !known {
+			c.IndentedJSON(400, "Invalid sort, one of: "+strings.Join(sorts, ", "))
+			return o, false
+		}
+	}
+	switch c.Query("order") {
+	case "", "asc":
+	case "desc":
+		o.Desc = true
+	default:
+		c.IndentedJSON(400, "Invalid order, asc or desc")
+		return o, false
+	}
+
+	if !limitQuery(c, &o.Offset, &o.Count) {
+		return o, false
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		ID, err := decodeCursor(cursor)
+		if err != nil {
+			c.IndentedJSON(400, "Invalid cursor")
+			return o, false
+		}
+		o.After = ID
+	}
+
+	ints := []struct {
+		name  string
+		value *int
+	}{
+		{"offset", &o.Offset},
+		{"count", &o.Count},
+		{"fromYear", &o.FromYear},
+		{"toYear", &o.ToYear},
+		{"genre", &o.GenreID},
+		{"minBitrate", &o.MinBitrate},
+		{"maxBitrate", &o.MaxBitrate},
+	}
+	for _, i := range ints {
+		// Unset parameters keep the offset and count given by limit
+		if c.Query(i.name) == "" {
+			continue
+		}
+		if *i.value, ok = intQuery(c, i.name); !ok {
+			return o, false
+		}
+	}
+
+	addedSince, ok := intQuery(c, "addedSince")
+	if !ok {
+		return o, false
+	}
+	o.AddedSince = int64(addedSince)
+
+	if fileTypes := c.Query("fileType"); fileTypes != "" {
+		for _, name := range strings.Split(fileTypes, ",") {
+			t, ok := db.FileTypeMap["."+strings.ToLower(strings.TrimSpace(name))]
+			if !ok {
+				c.IndentedJSON(400, "Invalid file type "+name)
+				return o, false
+			}
+			o.FileTypes = append(o.FileTypes, t)
+		}
+	}
+	return o, true
+}
+
+// listHeaders describes a page of n items of a list, the last of which has
+// lastID, with the number of items in the whole list, and links to the next
+// page when there may be one. Pages requested by offset link to the next
+// offset, and others to the next cursor.
+func listHeaders(c *gin.Context, o db.ListOptions, total, n, lastID int) {
+	c.Header("X-Total-Count", strconv.Itoa(total))
+
+	u := *c.Request.URL
+	q := u.Query()
+	byOffset := o.After == 0 && (q.Get("offset") != "" || q.Get("limit") != "")
+	if o.Count == 0 || n < o.Count || (byOffset && o.Offset+n >= total) {
+		return
+	}
+
+	cursor := encodeCursor(lastID)
+	c.Header("X-Next-Cursor", cursor)
+
+	q.Del("limit")
+	if byOffset {
+		q.Set("offset", strconv.Itoa(o.Offset+n))
+	} else {
+		q.Del("offset")
+		q.Set("cursor", cursor)
+	}
+	q.Set("count", strconv.Itoa(o.Count))
+	u.RawQuery = q.Encode()
+	c.Header("Link", "<"+u.String()+">; rel=\"next\"")
+}
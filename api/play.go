@@ -0,0 +1,160 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/scrobble"
+	"github.com/eHoward1996/aiomst/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultPlayCount is the number of plays or songs returned by the play
+// history endpoints when no limit is specified
+const defaultPlayCount = 50
+
+// PlayResponse is a play along with the song which was played
+type PlayResponse struct {
+	db.Play
+	Song db.Song `json:"song"`
+}
+
+// playRequest is the body of a request reporting a play. Time is a UNIX
+// timestamp, which defaults to now.
+type playRequest struct {
+	SongID int    `form:"songId" json:"songId"`
+	Time   int64  `form:"time" json:"time"`
+	Client string `form:"client" json:"client"`
+}
+
+// playLimit parses the limit parameter, a comma-seperated offset and count.
+// False is returned, after writing a response, when it is invalid.
+func playLimit(c *gin.Context) (int, int, bool) {
+	offset, count := 0, defaultPlayCount
+	if limit := c.Query("limit"); limit != "" {
+		if n, err := fmt.Sscanf(limit, "%d,%d", &offset, &count); n < 2 || err != nil {
+			c.IndentedJSON(400, "Invalid comma-seperated integer pair.")
+			return 0, 0, false
+		}
+	}
+	return offset, count, true
+}
+
+// GetPlays returns the authenticated user's play history, newest first
+func GetPlays(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	offset, count, ok := playLimit(c)
+	if !ok {
+		return
+	}
+
+	plays, err := db.DB.PlaysForUser(CurrentUser(c).ID, offset, count)
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	res := make([]PlayResponse, 0, len(plays))
+	for _, p := range plays {
+		song := db.Song{ID: p.SongID}
+		if err := song.Load(); err != nil {
+			// Songs removed from the library are left out of the history
+			if err == sql.ErrNoRows {
+				continue
+			}
+			util.Logger.Print(err)
+			c.IndentedJSON(500, serverErr)
+			return
+		}
+		res = append(res, PlayResponse{Play: p, Song: song})
+	}
+
+	c.IndentedJSON(200, res)
+}
+
+// PostPlay records a play of a song reported by a client. A play of the same
+// song which was already recorded, for example from the client's stream, is
+// returned with 200 instead of 201.
+func PostPlay(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	var req playRequest
+	if err := c.ShouldBind(&req); err != nil || req.SongID == 0 {
+		c.IndentedJSON(400, ErrMissingParam)
+		return
+	}
+	if req.Client == "" {
+		req.Client = requestClient(c)
+	}
+
+	song := &db.Song{ID: req.SongID}
+	if err := song.Load(); err != nil {
+		if err == sql.ErrNoRows {
+			c.IndentedJSON(404, "song ID not found")
+			return
+		}
+
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	if scrobble.Default == nil {
+		c.IndentedJSON(503, "play tracking is not running")
+		return
+	}
+
+	play, recorded, err := scrobble.Default.Record(
+		CurrentUser(c), song, req.Time, req.Client)
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	code := 201
+	if !recorded {
+		code = 200
+	}
+	c.IndentedJSON(code, PlayResponse{Play: *play, Song: *song})
+}
+
+// playedSongs responds with a list of songs from the authenticated user's play
+// history, loaded by query
+func playedSongs(c *gin.Context, query func(userID, offset, count int) ([]db.PlayedSong, error)) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	offset, count, ok := playLimit(c)
+	if !ok {
+		return
+	}
+
+	songs, err := query(CurrentUser(c).ID, offset, count)
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	c.IndentedJSON(200, songs)
+}
+
+// GetMostPlayed returns the songs the authenticated user played most often
+func GetMostPlayed(c *gin.Context) {
+	playedSongs(c, db.DB.MostPlayedSongs)
+}
+
+// GetRecentlyPlayed returns the songs the authenticated user played, most
+// recently played first
+func GetRecentlyPlayed(c *gin.Context) {
+	playedSongs(c, db.DB.RecentlyPlayedSongs)
+}
+
+// GetNeverPlayed returns the songs the authenticated user has not played
+func GetNeverPlayed(c *gin.Context) {
+	playedSongs(c, db.DB.NeverPlayedSongs)
+}
@@ -0,0 +1,480 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/playlist"
+	"github.com/eHoward1996/aiomst/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PlaylistResponse is a playlist along with its songs
+type PlaylistResponse struct {
+	db.Playlist
+	Songs []db.Song `json:"songs"`
+}
+
+// ImportResponse is an imported playlist along with the entries which could
+// not be found in the library
+type ImportResponse struct {
+	db.Playlist
+	Missing []string `json:"missing"`
+}
+
+// playlistRequest is the body of a request creating or changing a playlist.
+// Fields which are not sent are left unchanged.
+type playlistRequest struct {
+	Name    string  `form:"name" json:"name"`
+	Comment *string `form:"comment" json:"comment"`
+	SongIDs []int   `form:"songIds" json:"songIds"`
+}
+
+// moveRequest is the body of a request moving a song within a playlist
+type moveRequest struct {
+	From *int `form:"from" json:"from"`
+	To   *int `form:"to" json:"to"`
+}
+
+// loadPlaylistParam loads the playlist selected by the id path parameter and
+// checks the user is allowed to see it, or to change it if edit is set.
+// False is returned, after writing a response, when it could not be loaded.
+func loadPlaylistParam(c *gin.Context, edit bool) (*db.Playlist, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(400, "Invalid integer playlist ID")
+		return nil, false
+	}
+
+	p := &db.Playlist{ID: id}
+	if err := p.Load(); err != nil {
+		if err == sql.ErrNoRows {
+			c.IndentedJSON(404, "playlist ID not found")
+			return nil, false
+		}
+
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return nil, false
+	}
+
+	user := CurrentUser(c)
+	if !p.VisibleTo(user) {
+		// Do not reveal other users' playlists
+		c.IndentedJSON(404, "playlist ID not found")
+		return nil, false
+	}
+	if edit && !p.EditableBy(user) {
+		c.IndentedJSON(403, permissionErr)
+		return nil, false
+	}
+	return p, true
+}
+
+// checkSongIDs verifies every song ID exists in a library the user may
+// access. False is returned, after writing a response, when one does not.
+func checkSongIDs(c *gin.Context, IDs []int) bool {
+	user := CurrentUser(c)
+	for _, id := range IDs {
+		song := &db.Song{ID: id}
+		err := song.Load()
+		if err == sql.ErrNoRows {
+			c.IndentedJSON(400, fmt.Sprintf("song ID %d not found", id))
+			return false
+		} else if err != nil {
+			util.Logger.Print(err)
+			c.IndentedJSON(500, serverErr)
+			return false
+		}
+
+		ok, err := song.AccessibleBy(user)
+		if err != nil {
+			util.Logger.Print(err)
+			c.IndentedJSON(500, serverErr)
+			return false
+		}
+		if !ok {
+			// Do not reveal songs in other users' libraries
+			c.IndentedJSON(400, fmt.Sprintf("song ID %d not found", id))
+			return false
+		}
+	}
+	return true
+}
+
+// writePlaylist responds with a playlist and the songs of it the user may
+// access
+func writePlaylist(c *gin.Context, code int, p *db.Playlist) {
+	// Reload for the current song count and length
+	if err := p.Load(); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	songs, err := p.SongsFor(CurrentUser(c))
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	c.IndentedJSON(code, PlaylistResponse{Playlist: *p, Songs: songs})
+}
+
+// GetPlaylists returns the playlists of the authenticated user, along with
+// the shared playlists
+func GetPlaylists(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	playlists, err := db.DB.PlaylistsForUser(CurrentUser(c).ID)
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	c.IndentedJSON(200, playlists)
+}
+
+// GetPlaylist returns a playlist and its songs
+func GetPlaylist(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	p, ok := loadPlaylistParam(c, false)
+	if !ok {
+		return
+	}
+
+	writePlaylist(c, 200, p)
+}
+
+// PostPlaylist creates a playlist owned by the authenticated user
+func PostPlaylist(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	var req playlistRequest
+	if err := c.ShouldBind(&req); err != nil || req.Name == "" {
+		c.IndentedJSON(400, ErrMissingParam)
+		return
+	}
+	if !checkSongIDs(c, req.SongIDs) {
+		return
+	}
+
+	p := &db.Playlist{
+		UserID: CurrentUser(c).ID,
+		Name:   req.Name,
+	}
+	if req.Comment != nil {
+		p.Comment = *req.Comment
+	}
+	if err := p.Save(); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+	if err := db.DB.SetPlaylistSongs(p.ID, req.SongIDs); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	writePlaylist(c, 201, p)
+}
+
+// PutPlaylist renames a playlist or changes its comment
+func PutPlaylist(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	p, ok := loadPlaylistParam(c, true)
+	if !ok {
+		return
+	}
+
+	var req playlistRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.IndentedJSON(400, ErrMissingParam)
+		return
+	}
+	if req.Name != "" {
+		p.Name = req.Name
+	}
+	if req.Comment != nil {
+		p.Comment = *req.Comment
+	}
+
+	if err := p.Update(); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	writePlaylist(c, 200, p)
+}
+
+// PutPlaylistSongs replaces the songs of a playlist, which is used to reorder
+// it as well
+func PutPlaylistSongs(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	p, ok := loadPlaylistParam(c, true)
+	if !ok {
+		return
+	}
+
+	var req playlistRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.IndentedJSON(400, ErrMissingParam)
+		return
+	}
+	if !checkSongIDs(c, req.SongIDs) {
+		return
+	}
+
+	if err := db.DB.SetPlaylistSongs(p.ID, req.SongIDs); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	writePlaylist(c, 200, p)
+}
+
+// PostPlaylistSongs appends songs to a playlist
+func PostPlaylistSongs(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	p, ok := loadPlaylistParam(c, true)
+	if !ok {
+		return
+	}
+
+	var req playlistRequest
+	if err := c.ShouldBind(&req); err != nil || len(req.SongIDs) == 0 {
+		c.IndentedJSON(400, ErrMissingParam)
+		return
+	}
+	if !checkSongIDs(c, req.SongIDs) {
+		return
+	}
+
+	if err := db.DB.AppendPlaylistSongs(p.ID, req.SongIDs); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	writePlaylist(c, 200, p)
+}
+
+// PostPlaylistMove moves the song at one position of a playlist to another
+func PostPlaylistMove(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	p, ok := loadPlaylistParam(c, true)
+	if !ok {
+		return
+	}
+
+	var req moveRequest
+	if err := c.ShouldBind(&req); err != nil || req.From == nil || req.To == nil {
+		c.IndentedJSON(400, ErrMissingParam)
+		return
+	}
+
+	if err := db.DB.MovePlaylistSong(p.ID, *req.From, *req.To); err != nil {
+		if err == db.ErrPlaylistPosition {
+			c.IndentedJSON(400, err.Error())
+			return
+		}
+
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	writePlaylist(c, 200, p)
+}
+
+// DeletePlaylistSong removes the song at a position of a playlist
+func DeletePlaylistSong(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	p, ok := loadPlaylistParam(c, true)
+	if !ok {
+		return
+	}
+
+	position, err := strconv.Atoi(c.Param("position"))
+	if err != nil {
+		c.IndentedJSON(400, "Invalid integer position")
+		return
+	}
+
+	if err := db.DB.RemovePlaylistSong(p.ID, position); err != nil {
+		if err == db.ErrPlaylistPosition {
+			c.IndentedJSON(400, err.Error())
+			return
+		}
+
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	writePlaylist(c, 200, p)
+}
+
+// DeletePlaylist removes a playlist
+func DeletePlaylist(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	p, ok := loadPlaylistParam(c, true)
+	if !ok {
+		return
+	}
+
+	if err := p.Delete(); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	c.Status(204)
+}
+
+// GetPlaylistExport writes a playlist as an M3U8, PLS or XSPF file, chosen
+// by the format parameter
+func GetPlaylistExport(c *gin.Context) {
+	p, ok := loadPlaylistParam(c, false)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", playlist.M3U8)
+	mimeType, ok := playlist.MIMETypes[format]
+	if !ok {
+		c.JSON(400, playlist.ErrUnknownFormat.Error())
+		return
+	}
+
+	songs, err := p.SongsFor(CurrentUser(c))
+	if err != nil {
+		util.Logger.Print(err)
+		c.JSON(500, serverErr)
+		return
+	}
+
+	var b bytes.Buffer
+	if err := playlist.Encode(&b, format, playlist.FromSongs(p.Name, songs)); err != nil {
+		util.Logger.Print(err)
+		c.JSON(500, serverErr)
+		return
+	}
+
+	c.Header("Content-Disposition",
+		fmt.Sprintf("attachment; filename=%q", p.Name+"."+format))
+	c.Data(200, mimeType+"; charset=UTF-8", b.Bytes())
+}
+
+// PostPlaylistImport creates a playlist owned by the authenticated user from
+// an M3U8, PLS or XSPF file. The file is either uploaded as the file form
+// field or sent as the request body, with its format given by the format
+// parameter. Relative paths are resolved against the roots of the libraries
+// the user may access, and songs in other libraries are reported as missing.
+func PostPlaylistImport(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	var body io.Reader = c.Request.Body
+	name := c.Query("name")
+	format := c.Query("format")
+	if header, err := c.FormFile("file"); err == nil {
+		f, err := header.Open()
+		if err != nil {
+			util.Logger.Print(err)
+			c.IndentedJSON(500, serverErr)
+			return
+		}
+		defer f.Close()
+		body = f
+
+		if format == "" {
+			format, _ = playlist.Format(header.Filename)
+		}
+		if name == "" {
+			name = strings.TrimSuffix(header.Filename, path.Ext(header.Filename))
+		}
+	}
+
+	// Limit uploads to a sensible size
+	data, err := ioutil.ReadAll(io.LimitReader(body, 8<<20))
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	p, err := playlist.Decode(bytes.NewReader(data), format)
+	if err != nil {
+		c.IndentedJSON(400, err.Error())
+		return
+	}
+
+	libraries, err := db.DB.LibrariesForUser(CurrentUser(c))
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+	roots := make([]string, 0, len(libraries))
+	for _, l := range libraries {
+		roots = append(roots, l.Root)
+	}
+
+	IDs, missing, err := playlist.Resolve(p, roots, CurrentUser(c))
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	// The playlist's own name takes precedence over the file name
+	if p.Name != "" && c.Query("name") == "" {
+		name = p.Name
+	}
+	if name == "" {
+		name = "Imported Playlist"
+	}
+
+	pl := &db.Playlist{UserID: CurrentUser(c).ID, Name: name}
+	if err := pl.Save(); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+	if err := db.DB.SetPlaylistSongs(pl.ID, IDs); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+	if err := pl.Load(); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	res := ImportResponse{Playlist: *pl, Missing: make([]string, 0, len(missing))}
+	for _, e := range missing {
+		res.Missing = append(res.Missing, e.Path)
+	}
+	c.IndentedJSON(201, res)
+}
@@ -11,68 +11,90 @@ import (
 
 const allTypes = "artists,albums,songs,folders"
 
-// SearchResponse represents the JSON output for /api/search
+// defaultSearchCount is the number of results of each type returned when no
+// limit is specified
+const defaultSearchCount = 20
+
+// SearchResponse represents the JSON output for /api/search. Totals holds the
+// number of matches of each type, before the limit is applied.
 type SearchResponse struct {
-	Artists []db.Artist 	`json:"artists"`
-	Albums  []db.Album  	`json:"albums"`
-	Songs   []db.Song   	`json:"songs"`
-	// Folders []db.Folder 	`json:"folders"`
+	Artists []db.ArtistHit 	`json:"artists,omitempty"`
+	Albums  []db.AlbumHit  	`json:"albums,omitempty"`
+	Songs   []db.SongHit   	`json:"songs,omitempty"`
+	Folders []db.FolderHit 	`json:"folders,omitempty"`
+	Totals  map[string]int64 `json:"totals"`
+	Offset  int              `json:"offset"`
+	Count   int              `json:"count"`
 }
 
-
+// GetSearch searches the libraries using the full text search index. Results
+// of each type are ranked by relevance and paginated with the limit
+// parameter, a comma-seperated offset and count. The library parameter limits
+// the search to a single library.
 func GetSearch(c *gin.Context)	{
 	c.Header("Content-Type", "application/json; charset=UTF-8")
-	c.Header("Access-Control-Allow-Origin", "*")
-	
+
 	query := c.Query("q")
 	if query == ""	{
 		c.IndentedJSON(400, "No search query specified.")
 		return
 	}
 
-	resp := new(SearchResponse)
+	match, err := db.ParseSearch(query)
+	if err != nil {
+		c.IndentedJSON(400, err.Error())
+		return
+	}
+
+	libraries, ok := libraryFilter(c)
+	if !ok {
+		return
+	}
+
+	offset, count := 0, defaultSearchCount
+	if !limitQuery(c, &offset, &count) {
+		return
+	}
+
+	resp := &SearchResponse{
+		Totals: make(map[string]int64),
+		Offset: offset,
+		Count:  count,
+	}
 	types := c.Query("types")
 	if types == ""	{
 		types = allTypes
 	}
 
 	for _, t := range strings.Split(types, ",") 	{
+		var kind string
 		switch t {
 		case "artists":
-			artists, err := db.DB.SearchArtists(query)
-			if err != nil {
-				util.Logger.Print(err)
-				c.IndentedJSON(500, serverErr)
-				return
-			}
-			resp.Artists = artists
+			kind = db.KindArtist
+			resp.Artists, err = db.DB.SearchArtists(match, libraries, offset, count)
 		case "albums":
-			albums, err := db.DB.SearchAlbums(query)
-			if err != nil {
-				util.Logger.Print(err)
-				c.IndentedJSON(500, serverErr)
-				return
-			}
-			resp.Albums = albums
+			kind = db.KindAlbum
+			resp.Albums, err = db.DB.SearchAlbums(match, libraries, offset, count)
 		case "songs":
-			songs, err := db.DB.SearchSongs(query)
-			if err != nil {
-				util.Logger.Print(err)
-				c.IndentedJSON(500, serverErr)
-				return
-			}
-			resp.Songs = songs
-		// case "folders":
-		// 	folders, err := db.DB.SearchFolders(query)
-		// 	if err != nil {
-		// 		util.Logger.Print(err)
-		// 		c.IndentedJSON(500, serverErr)
-		// 		return
-		// 	}
-		// 	resp.Folders = folders
+			kind = db.KindSong
+			resp.Songs, err = db.DB.SearchSongs(match, libraries, offset, count)
+		case "folders":
+			kind = db.KindFolder
+			resp.Folders, err = db.DB.SearchFolders(match, libraries, offset, count)
+		default:
+			c.IndentedJSON(400, "Unknown search type: "+t)
+			return
+		}
+		if err == nil {
+			resp.Totals[t], err = db.DB.CountSearch(kind, match, libraries)
+		}
+		if err != nil {
+			util.Logger.Print(err)
+			c.IndentedJSON(500, serverErr)
+			return
 		}
 	}
 
 	c.IndentedJSON(200, resp)
 	return
-}
\ No newline at end of file
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"fmt"
 	"strconv"
 
 	"github.com/eHoward1996/aiomst/db"
@@ -10,35 +9,28 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// GetSongs lists the songs in the libraries the user may access, paged,
+// sorted and filtered by the list parameters
 func GetSongs(c *gin.Context) {
 	c.Header("Content-Type", "application/json; charset=UTF-8")
-	c.Header("Access-Control-Allow-Origin", "*")
-	
-	if limit := c.Query("limit"); limit != "" {
-		var offset, count int
-		if n, err := fmt.Sscanf(limit, "%d,%d", &offset, &count); n < 2 || err != nil {
-			c.IndentedJSON(400, "Invalid comma-seperated integer pair.")
-			return
-		}
-
-		songs, err := db.DB.LimitSongs(offset, count)
-		if err != nil {
-			util.Logger.Print(err)
-			c.IndentedJSON(500, serverErr)
-			return
-		}
-
-		c.IndentedJSON(200, songs)
+
+	o, ok := listOptions(c, db.SongSorts)
+	if !ok {
 		return
 	}
 
-	songs, err := db.DB.AllSongsByTitle()
+	songs, total, err := db.DB.ListSongs(o)
 	if err != nil {
 		util.Logger.Print(err)
-		c.JSON(500, serverErr)
+		c.IndentedJSON(500, serverErr)
 		return
 	}
 
+	lastID := 0
+	if len(songs) > 0 {
+		lastID = songs[len(songs)-1].ID
+	}
+	listHeaders(c, o, total, len(songs), lastID)
 	c.IndentedJSON(200, songs)
 }
 
@@ -57,6 +49,9 @@ func GetSong(c *gin.Context) {
 		c.IndentedJSON(500, ErrGeneric)
 		return
 	}
+	if !songAccessible(c, &song) {
+		return
+	}
 
 	c.IndentedJSON(200, song)
 }
\ No newline at end of file
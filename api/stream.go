@@ -2,25 +2,41 @@ package api
 
 import (
 	"database/sql"
-	"io"
+	"fmt"
+	"net/http"
+	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/scrobble"
+	"github.com/eHoward1996/aiomst/transcode"
 	"github.com/eHoward1996/aiomst/util"
 
 	"github.com/gin-gonic/gin"
 )
 
+// GetStream serves the audio file for a song. Raw files honor Range, If-Range,
+// If-Modified-Since and If-None-Match so clients are able to seek and resume
+// playback. The optional format and maxBitRate parameters transcode the song
+// on the fly.
 func GetStream(c *gin.Context) {
-	c.Header("Access-Control-Allow-Origin", "*")
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Accept-Ranges", "bytes")
+	// The ID may either be part of the path (/stream/:id) or the query (/stream?id=)
+	sID := c.Param("id")
+	if sID == "" {
+		sID = c.Query("id")
+	}
+	if sID == "" {
+		c.JSON(400, ErrMissingParam)
+		return
+	}
 
 	// Attempt to load the song with matching ID
-	id, err := strconv.Atoi(c.Param("id"))
+	id, err := strconv.Atoi(sID)
 	if err != nil {
 		util.Logger.Print(err)
-		c.JSON(400, ErrGeneric)
+		c.JSON(400, "Invalid integer song ID")
 		return
 	}
 
@@ -37,35 +53,186 @@ func GetStream(c *gin.Context) {
 		c.JSON(500, serverErr)
 		return
 	}
+	if !songAccessible(c, song) {
+		return
+	}
+
+	// Optional transcoding parameters
+	maxBitRate := 0
+	if sRate := c.Query("maxBitRate"); sRate != "" {
+		if maxBitRate, err = strconv.Atoi(sRate); err != nil || maxBitRate < 0 {
+			c.JSON(400, "Invalid integer maxBitRate")
+			return
+		}
+	}
+
+	if err := StreamSong(c, song, c.Query("format"), maxBitRate); err != nil {
+		c.JSON(400, err.Error())
+		return
+	}
+}
+
+// StreamSong serves a song either as its raw file or transcoded, depending on
+// the requested format and maximum bit rate. An error is returned, before
+// anything is written, when the format is unknown. Streams are counted
+// towards a play of the song by the current user.
+func StreamSong(c *gin.Context, song *db.Song, format string, maxBitRate int) error {
+	profile, ok, err := transcodeProfile(song, format, maxBitRate)
+	if err != nil {
+		return err
+	}
+	if ok {
+		TranscodeSong(c, song, profile, maxBitRate)
+
+		// The size of the encoded stream is estimated from its bit rate
+		size := int64(song.Length) * int64(profile.BitRate(maxBitRate)) * 1000 / 8
+		recordStream(c, song, size)
+		return nil
+	}
+
+	ServeSong(c, song)
+	recordStream(c, song, song.FileSize)
+	return nil
+}
+
+// recordStream counts the bytes written for a stream of a song, out of size,
+// towards a play by the current user. Guests do not record plays.
+func recordStream(c *gin.Context, song *db.Song, size int64) {
+	user := CurrentUser(c)
+	if scrobble.Default == nil || user == nil || !user.HasRole(db.RoleUser) {
+		return
+	}
+
+	if c.Writer.Status() < 300 && c.Writer.Size() > 0 {
+		scrobble.Default.Streamed(
+			user, song, int64(c.Writer.Size()), size, requestClient(c))
+	}
+}
+
+// lossless is the set of file types which are always transcoded when a client
+// asks for a format
+var lossless = map[int]bool{
+	db.APE:  true,
+	db.FLAC: true,
+	db.WMA:  true,
+	db.WV:   true,
+}
+
+// profileCodec maps a transcode profile to the file type it produces, so
+// songs which are already in the requested format are not transcoded
+var profileCodec = map[string]int{
+	"mp3": db.MP3,
+	"ogg": db.OGG,
+	"aac": db.M4A,
+}
+
+// transcodeProfile decides whether a song must be transcoded to satisfy the
+// requested format and maximum bit rate, returning the profile to use.
+func transcodeProfile(
+	song *db.Song, format string, maxBitRate int) (transcode.Profile, bool, error) {
 
+	format = strings.ToLower(format)
+	if format == "raw" || (format == "" && maxBitRate == 0) {
+		return transcode.Profile{}, false, nil
+	}
+	if format == "" {
+		format = transcode.DefaultFormat
+	}
+
+	profile, ok := transcode.Lookup(format)
+	if !ok {
+		return profile, false, transcode.ErrUnknownProfile
+	}
+
+	// Lossless files are always transcoded
+	if lossless[song.FileTypeID] {
+		return profile, true, nil
+	}
+
+	// Lossy files are only transcoded into a different format, or when they
+	// exceed the maximum bit rate
+	if codec, ok := profileCodec[profile.Name]; !ok || codec != song.FileTypeID {
+		return profile, true, nil
+	}
+	if maxBitRate > 0 && song.Bitrate > maxBitRate {
+		return profile, true, nil
+	}
+	return profile, false, nil
+}
+
+// TranscodeSong encodes a song using profile and streams the result to the
+// client. Transcoded streams can not be seeked, so ranges are not supported.
+// The response is only committed once the encoder produces its first bytes,
+// so an encoder which fails to start is reported as a server error.
+func TranscodeSong(c *gin.Context, song *db.Song, profile transcode.Profile, maxBitRate int) {
+	w := &flushWriter{w: c.Writer, start: func() {
+		c.Header("Content-Type", profile.MIMEType)
+		c.Header("Accept-Ranges", "none")
+		c.Header("Cache-Control", "no-cache")
+		c.Status(200)
+	}}
+	err := transcode.Default.Transcode(
+		c.Request.Context(), w, song.Path, profile, maxBitRate)
+	if c.Request.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		util.Logger.Printf("API: Stream: Error transcoding %s: %v", song.Path, err)
+	}
+	if !c.Writer.Written() {
+		c.JSON(500, serverErr)
+	}
+}
+
+// flushWriter flushes each write so encoded audio reaches the client as soon
+// as it is produced. start is called before the first write.
+type flushWriter struct {
+	w     gin.ResponseWriter
+	start func()
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if f.start != nil {
+		f.start()
+		f.start = nil
+	}
+	n, err := f.w.Write(p)
+	f.w.Flush()
+	return n, err
+}
+
+// ServeSong writes the file belonging to song to the response. The response
+// carries the song's MIME type, a Last-Modified header and an ETag, and
+// http.ServeContent takes care of the conditional and (multipart) range logic.
+func ServeSong(c *gin.Context, song *db.Song) {
 	stream, err := song.Stream()
 	if err != nil {
 		util.Logger.Print(err)
 		c.JSON(500, serverErr)
 		return
 	}
+	defer stream.Close()
 
-	rs, ok := toReadSeeker(stream)
+	mime, ok := db.MIMEMap[song.FileTypeID]
 	if !ok {
-		util.Logger.Print("Unable to create readSeeker")
-		c.JSON(500, serverErr)
-		return
+		mime = "application/octet-stream"
 	}
 
-	c.Stream(func(w io.Writer) bool {
-		for {
-			_, err := io.CopyN(w, rs, 2048)
-			if err != nil && err != io.EOF {
-				return false
-			} else if err == io.EOF {
-				return false
-			}
-			return true
-		}
-	})
+	c.Header("Content-Type", mime)
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("Last-Modified", util.UNIXtoRFC1123(song.LastModified))
+	c.Header("ETag", songETag(song))
+
+	http.ServeContent(
+		c.Writer, c.Request, path.Base(song.Path),
+		time.Unix(song.LastModified, 0), stream)
 }
 
-func toReadSeeker(in io.Reader) (io.ReadSeeker, bool) {
-	s, ok := in.(io.ReadSeeker)
-	return s, ok
+// songETag builds a strong entity tag for a song from the properties of its
+// file which change whenever the file is rewritten.
+func songETag(song *db.Song) string {
+	return fmt.Sprintf(`"%x-%x-%x"`, song.ID, song.LastModified, song.FileSize)
 }
@@ -0,0 +1,78 @@
+package subsonic
+
+import (
+	"strconv"
+
+	"github.com/eHoward1996/aiomst/api"
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/scrobble"
+
+	"github.com/gin-gonic/gin"
+)
+
+// scrobbleSongs implements the scrobble method. It records plays of one or
+// more songs, each optionally with the time it was played in milliseconds.
+// Now playing notifications, sent with submission=false, are accepted but not
+// recorded.
+func scrobbleSongs(c *gin.Context) {
+	if c.Request.FormValue("submission") == "false" {
+		write(c, newResponse())
+		return
+	}
+
+	user := api.CurrentUser(c)
+	if !user.HasRole(db.RoleUser) {
+		writeError(c, errNotAuthorized, "Guests can not scrobble")
+		return
+	}
+	if scrobble.Default == nil {
+		writeError(c, errGeneric, "Play tracking is not running")
+		return
+	}
+
+	// FormValue parses the form, which holds every repeated parameter
+	if _, ok := idParam(c); !ok {
+		return
+	}
+	ids, times := c.Request.Form["id"], c.Request.Form["time"]
+
+	// Check every song before recording any plays
+	songs := make([]*db.Song, 0, len(ids))
+	played := make([]int64, len(ids))
+	for i, s := range ids {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			writeError(c, errNotFound, "Invalid ID: "+s)
+			return
+		}
+
+		song := &db.Song{ID: id}
+		if err := song.Load(); err != nil {
+			loadError(c, "Song", err)
+			return
+		}
+		if !accessible(c, song, "Song") {
+			return
+		}
+		songs = append(songs, song)
+
+		if i < len(times) {
+			ms, err := strconv.ParseInt(times[i], 10, 64)
+			if err != nil {
+				writeError(c, errGeneric, "Invalid integer parameter: time")
+				return
+			}
+			played[i] = ms / 1000
+		}
+	}
+
+	for i, song := range songs {
+		if _, _, err := scrobble.Default.Record(
+			user, song, played[i], c.Request.FormValue("c")); err != nil {
+			serverError(c, err)
+			return
+		}
+	}
+
+	write(c, newResponse())
+}
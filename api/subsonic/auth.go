@@ -0,0 +1,124 @@
+package subsonic
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+
+	"github.com/eHoward1996/aiomst/api"
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authenticate checks the credentials sent with every Subsonic request against
+// the user accounts. The plain scheme (p=, optionally hex encoded with an
+// "enc:" prefix) checks the user's password. As only a hash of the password
+// is stored, the token scheme (t= and s=) is computed with one of the user's
+// API keys in place of the password: t is md5(apiKey + salt). The OpenSubsonic
+// apiKey= parameter is accepted on its own.
+func authenticate(c *gin.Context) {
+	if key := c.Request.FormValue("apiKey"); key != "" {
+		apiKey := &db.APIKey{Key: key}
+		if err := apiKey.Load(); err != nil {
+			if err != sql.ErrNoRows {
+				util.Logger.Print(err)
+			}
+			writeError(c, errBadCredentials, "Invalid API key")
+			c.Abort()
+			return
+		}
+
+		user := &db.User{ID: apiKey.UserID}
+		if err := user.Load(); err != nil {
+			if err != sql.ErrNoRows {
+				util.Logger.Print(err)
+			}
+			writeError(c, errBadCredentials, "Invalid API key")
+			c.Abort()
+			return
+		}
+
+		api.SetCurrentUser(c, user)
+		c.Next()
+		return
+	}
+
+	username := c.Request.FormValue("u")
+	if username == "" {
+		writeError(c, errMissingParam, "Required parameter is missing: u")
+		c.Abort()
+		return
+	}
+
+	token, pass := c.Request.FormValue("t"), c.Request.FormValue("p")
+	if token == "" && pass == "" {
+		writeError(c, errMissingParam, "Required parameter is missing: p or t")
+		c.Abort()
+		return
+	}
+
+	user := &db.User{Username: username}
+	if err := user.Load(); err != nil {
+		if err != sql.ErrNoRows {
+			util.Logger.Print(err)
+		}
+		writeError(c, errBadCredentials, "Wrong username or password")
+		c.Abort()
+		return
+	}
+
+	ok := false
+	if token != "" {
+		keys, err := db.DB.APIKeysForUser(user.ID)
+		if err != nil {
+			util.Logger.Print(err)
+		}
+		for _, k := range keys {
+			if checkToken(k.Key, token, c.Request.FormValue("s")) {
+				ok = true
+				break
+			}
+		}
+	} else {
+		p, err := decodePassword(pass)
+		ok = err == nil && user.CheckPassword(p)
+	}
+
+	if !ok {
+		writeError(c, errBadCredentials, "Wrong username or password")
+		c.Abort()
+		return
+	}
+
+	api.SetCurrentUser(c, user)
+	c.Next()
+}
+
+// decodePassword decodes a plain password, which may be hex encoded with an
+// "enc:" prefix
+func decodePassword(p string) (string, error) {
+	if !strings.HasPrefix(p, "enc:") {
+		return p, nil
+	}
+
+	b, err := hex.DecodeString(strings.TrimPrefix(p, "enc:"))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// checkToken verifies that token is the md5 sum of secret and salt
+func checkToken(secret, token, salt string) bool {
+	sum := md5.Sum([]byte(secret + salt))
+	return equal(hex.EncodeToString(sum[:]), strings.ToLower(token))
+}
+
+// equal compares two strings in constant time
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
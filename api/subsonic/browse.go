@@ -0,0 +1,296 @@
+package subsonic
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/eHoward1996/aiomst/api"
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// intParam returns an optional integer parameter, or def when it is not set.
+// False is returned, after writing an error, when the parameter is invalid.
+func intParam(c *gin.Context, name string, def int) (int, bool) {
+	s := c.Request.FormValue(name)
+	if s == "" {
+		return def, true
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		writeError(c, errGeneric, "Invalid integer parameter: "+name)
+		return 0, false
+	}
+	return n, true
+}
+
+// idParam returns the required integer id parameter. False is returned, after
+// writing an error, when it is missing or invalid.
+func idParam(c *gin.Context) (int, bool) {
+	s := c.Request.FormValue("id")
+	if s == "" {
+		writeError(c, errMissingParam, "Required parameter is missing: id")
+		return 0, false
+	}
+
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		writeError(c, errNotFound, "Invalid ID: "+s)
+		return 0, false
+	}
+	return id, true
+}
+
+// loadError writes the error for a failed load of a single object
+func loadError(c *gin.Context, kind string, err error) {
+	if err == sql.ErrNoRows {
+		writeError(c, errNotFound, kind+" not found")
+		return
+	}
+
+	serverError(c, err)
+}
+
+// ping is used to test connectivity with the server
+func ping(c *gin.Context) {
+	write(c, newResponse())
+}
+
+// getLicense reports a valid license
+func getLicense(c *gin.Context) {
+	r := newResponse()
+	r.License = &License{Valid: true}
+	write(c, r)
+}
+
+// musicFolderFilter returns the IDs of the libraries a request is limited to:
+// the one named by the musicFolderId parameter, or else every library the
+// user may access. Nil is returned for admins, who may access every library.
+// False is returned, after writing an error, when the parameter is invalid.
+func musicFolderFilter(c *gin.Context) ([]int, bool) {
+	id, ok := intParam(c, "musicFolderId", 0)
+	if !ok {
+		return nil, false
+	}
+	user := api.CurrentUser(c)
+
+	if id != 0 {
+		l := &db.Library{ID: id}
+		if err := l.Load(); err != nil && err != sql.ErrNoRows {
+			loadError(c, "Music folder", err)
+			return nil, false
+		} else if err == sql.ErrNoRows || !l.AccessibleBy(user) {
+			writeError(c, errNotFound, "Music folder not found")
+			return nil, false
+		}
+		return []int{id}, true
+	}
+
+	IDs, err := db.DB.LibraryIDsForUser(user)
+	if err != nil {
+		serverError(c, err)
+		return nil, false
+	}
+	return IDs, true
+}
+
+// getMusicFolders returns the libraries the user may access
+func getMusicFolders(c *gin.Context) {
+	libraries, err := db.DB.LibrariesForUser(api.CurrentUser(c))
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	folders := make([]MusicFolder, 0, len(libraries))
+	for _, l := range libraries {
+		folders = append(folders, MusicFolder{ID: l.ID, Name: l.Name})
+	}
+
+	r := newResponse()
+	r.MusicFolders = &MusicFolders{Folders: folders}
+	write(c, r)
+}
+
+// getIndexes returns the artists in the libraries the request is limited to,
+// grouped by their first letter
+func getIndexes(c *gin.Context) {
+	libraries, ok := musicFolderFilter(c)
+	if !ok {
+		return
+	}
+
+	artists, err := db.DB.ArtistsInLibraries(libraries)
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	indexes := &Indexes{
+		LastModified:    util.ScanTime() * 1000,
+		IgnoredArticles: ignoredArticles(),
+		Index:           make([]Index, 0),
+	}
+	for _, group := range db.IndexArtists(artists) {
+		index := Index{Name: group.Name}
+		for _, a := range group.Artists {
+			index.Artists = append(index.Artists, Artist{
+				ID:   strconv.Itoa(a.ID),
+				Name: a.Title,
+			})
+		}
+		indexes.Index = append(indexes.Index, index)
+	}
+
+	r := newResponse()
+	r.Indexes = indexes
+	write(c, r)
+}
+
+// getArtists returns the artists in the libraries the request is limited to,
+// grouped by their first letter
+func getArtists(c *gin.Context) {
+	libraries, ok := musicFolderFilter(c)
+	if !ok {
+		return
+	}
+
+	artists, err := db.DB.ArtistsInLibraries(libraries)
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	converted, err := artistsID3(artists)
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+	byID := make(map[int]ArtistID3, len(converted))
+	for i, a := range artists {
+		byID[a.ID] = converted[i]
+	}
+
+	result := &ArtistsID3{
+		IgnoredArticles: ignoredArticles(),
+		Index:           make([]IndexID3, 0),
+	}
+	for _, group := range db.IndexArtists(artists) {
+		index := IndexID3{Name: group.Name}
+		for _, a := range group.Artists {
+			index.Artists = append(index.Artists, byID[a.ID])
+		}
+		result.Index = append(result.Index, index)
+	}
+
+	r := newResponse()
+	r.Artists = result
+	write(c, r)
+}
+
+// getArtist returns an artist along with their albums, followed by the albums
+// of other artists they appear on
+func getArtist(c *gin.Context) {
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	artist := db.Artist{ID: id}
+	if err := artist.Load(); err != nil {
+		loadError(c, "Artist", err)
+		return
+	}
+	if !accessible(c, &artist, "Artist") {
+		return
+	}
+
+	albums, err := db.DB.AlbumsForArtist(artist.ID)
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+	appearsOn, err := db.DB.AlbumAppearancesForArtist(artist.ID)
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+	albums = append(albums, appearsOn...)
+
+	converted, err := albumsID3(albums)
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	r := newResponse()
+	r.Artist = &ArtistWithAlbumsID3{
+		ArtistID3: toArtistID3(artist, len(albums)),
+		Albums:    converted,
+	}
+	write(c, r)
+}
+
+// getAlbum returns an album along with its songs
+func getAlbum(c *gin.Context) {
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	album := db.Album{ID: id}
+	if err := album.Load(); err != nil {
+		loadError(c, "Album", err)
+		return
+	}
+	if !accessible(c, &album, "Album") {
+		return
+	}
+
+	songs, err := db.DB.SongsForAlbum(album.ID)
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	out := AlbumWithSongsID3{
+		AlbumID3: toAlbumID3(album, db.AlbumTotals{
+			Songs:  len(songs),
+			Length: db.SongSlice(songs).Length(),
+		}),
+		Songs: make([]Child, 0, len(songs)),
+	}
+	for _, s := range songs {
+		out.Songs = append(out.Songs, toChild(s, album.ArtID))
+	}
+	for _, d := range db.SongSlice(songs).Discs() {
+		if d.Subtitle != "" {
+			out.DiscTitles = append(out.DiscTitles, DiscTitle{Disc: d.Disc, Title: d.Subtitle})
+		}
+	}
+
+	r := newResponse()
+	r.Album = &out
+	write(c, r)
+}
+
+// getSong returns a single song
+func getSong(c *gin.Context) {
+	song, ok := loadSong(c)
+	if !ok {
+		return
+	}
+
+	converted, err := children([]db.Song{*song})
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	r := newResponse()
+	r.Song = &converted[0]
+	write(c, r)
+}
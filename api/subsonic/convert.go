@@ -0,0 +1,138 @@
+package subsonic
+
+import (
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// ignoredArticles returns the articles skipped when sorting artists, as
+// Subsonic clients expect them
+func ignoredArticles() string {
+	return strings.Join(util.Current().Tags.IgnoredArticles, " ")
+}
+
+// coverArtID returns the cover art ID for an art ID, which is empty when there
+// is no art
+func coverArtID(artID int) string {
+	if artID == 0 {
+		return ""
+	}
+	return strconv.Itoa(artID)
+}
+
+// toArtistID3 converts an artist along with their album count
+func toArtistID3(a db.Artist, albumCount int) ArtistID3 {
+	return ArtistID3{
+		ID:         strconv.Itoa(a.ID),
+		Name:       a.Title,
+		CoverArt:   coverArtID(a.ArtID),
+		AlbumCount: albumCount,
+	}
+}
+
+// toAlbumID3 converts an album along with its song totals
+func toAlbumID3(a db.Album, totals db.AlbumTotals) AlbumID3 {
+	return AlbumID3{
+		ID:        strconv.Itoa(a.ID),
+		Name:      a.Title,
+		Artist:    a.Artist,
+		ArtistID:  strconv.Itoa(a.ArtistID),
+		CoverArt:  coverArtID(a.ArtID),
+		SongCount: totals.Songs,
+		Duration:  totals.Length,
+		Year:      a.Year,
+
+		IsCompilation: a.Compilation,
+	}
+}
+
+// toChild converts a song. The album's art is used as the song's cover art,
+// and the song's own artist tag, when it has one, as its artist.
+func toChild(s db.Song, artID int) Child {
+	artist := s.Artist
+	if s.TrackArtist != "" {
+		artist = s.TrackArtist
+	}
+	return Child{
+		ID:          strconv.Itoa(s.ID),
+		Parent:      strconv.Itoa(s.AlbumID),
+		Title:       s.Title,
+		Album:       s.Album,
+		Artist:      artist,
+		Track:       s.Track,
+		DiscNumber:  s.Disc,
+		Year:        s.Year,
+		Genre:       s.Genre,
+		CoverArt:    coverArtID(artID),
+		Size:        s.FileSize,
+		ContentType: db.MIMEMap[s.FileTypeID],
+		Suffix:      strings.TrimPrefix(path.Ext(s.Path), "."),
+		Duration:    s.Length,
+		BitRate:     s.Bitrate,
+		Path:        s.Path,
+		AlbumID:     strconv.Itoa(s.AlbumID),
+		ArtistID:    strconv.Itoa(s.ArtistID),
+		Type:        "music",
+	}
+}
+
+// artistsID3 converts a slice of artists, loading their album counts
+func artistsID3(artists []db.Artist) ([]ArtistID3, error) {
+	IDs := make([]int, 0, len(artists))
+	for _, a := range artists {
+		IDs = append(IDs, a.ID)
+	}
+
+	counts, err := db.DB.AlbumCountsForArtists(IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]ArtistID3, 0, len(artists))
+	for _, a := range artists {
+		out = append(out, toArtistID3(a, counts[a.ID]))
+	}
+	return out, nil
+}
+
+// albumsID3 converts a slice of albums, loading their song totals
+func albumsID3(albums []db.Album) ([]AlbumID3, error) {
+	IDs := make([]int, 0, len(albums))
+	for _, a := range albums {
+		IDs = append(IDs, a.ID)
+	}
+
+	totals, err := db.DB.TotalsForAlbums(IDs)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]AlbumID3, 0, len(albums))
+	for _, a := range albums {
+		out = append(out, toAlbumID3(a, totals[a.ID]))
+	}
+	return out, nil
+}
+
+// children converts a slice of songs, looking up the art of their albums
+func children(songs []db.Song) ([]Child, error) {
+	art := make(map[int]int)
+	out := make([]Child, 0, len(songs))
+	for _, s := range songs {
+		artID, ok := art[s.AlbumID]
+		if !ok {
+			album := &db.Album{ID: s.AlbumID}
+			if err := album.Load(); err != nil {
+				return nil, err
+			}
+			artID = album.ArtID
+			art[s.AlbumID] = artID
+		}
+		out = append(out, toChild(s, artID))
+	}
+	return out, nil
+}
@@ -0,0 +1,366 @@
+package subsonic
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/eHoward1996/aiomst/api"
+	"github.com/eHoward1996/aiomst/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxListSize is the largest number of items returned by a list request
+const maxListSize = 500
+
+// listSize returns the size parameter clamped to maxListSize
+func listSize(c *gin.Context, name string, def int) (int, bool) {
+	size, ok := intParam(c, name, def)
+	if !ok {
+		return 0, false
+	}
+	if size < 0 {
+		size = 0
+	}
+	if size > maxListSize {
+		size = maxListSize
+	}
+	return size, true
+}
+
+// getAlbumList2 returns a list of albums chosen by the type parameter, in the
+// libraries the request is limited to
+func getAlbumList2(c *gin.Context) {
+	listType := c.Request.FormValue("type")
+	if listType == "" {
+		writeError(c, errMissingParam, "Required parameter is missing: type")
+		return
+	}
+
+	size, ok := listSize(c, "size", 10)
+	if !ok {
+		return
+	}
+	offset, ok := intParam(c, "offset", 0)
+	if !ok {
+		return
+	}
+	libraries, ok := musicFolderFilter(c)
+	if !ok {
+		return
+	}
+
+	// A count of zero lists every album, so an empty page is not loaded
+	o := db.ListOptions{Libraries: libraries, Offset: offset, Count: size}
+	albums := make([]db.Album, 0)
+	var err error
+	switch listType {
+	case "random":
+		albums, err = db.DB.RandomAlbumsInLibraries(libraries, size)
+	case "newest":
+		o.Sort, o.Desc = "added", true
+	case "alphabeticalByName":
+		o.Sort = "title"
+	case "alphabeticalByArtist":
+		o.Sort = "artist"
+	case "byYear":
+		from, ok := intParam(c, "fromYear", 0)
+		if !ok {
+			return
+		}
+		to, ok := intParam(c, "toYear", 9999)
+		if !ok {
+			return
+		}
+
+		// Albums are listed newest first when the years are reversed
+		o.Sort, o.FromYear, o.ToYear = "year", from, to
+		if from > to {
+			o.Desc, o.FromYear, o.ToYear = true, to, from
+		}
+	case "frequent":
+		albums, err = playedAlbums(db.DB.MostPlayedAlbums(
+			api.CurrentUser(c).ID, libraries, offset, size))
+	case "recent":
+		albums, err = playedAlbums(db.DB.RecentlyPlayedAlbums(
+			api.CurrentUser(c).ID, libraries, offset, size))
+	case "byGenre":
+		name := c.Request.FormValue("genre")
+		if name == "" {
+			writeError(c, errMissingParam, "Required parameter is missing: genre")
+			return
+		}
+
+		// A genre which is not known has no albums
+		genre, err := db.DB.GenreByName(name)
+		if err == sql.ErrNoRows {
+			size = 0
+		} else if err != nil {
+			serverError(c, err)
+			return
+		}
+		o.Sort, o.GenreID = "title", genre.ID
+	case "highest", "starred":
+		// Not tracked yet, so these lists are always empty
+		size = 0
+	default:
+		writeError(c, errGeneric, "Unknown list type: "+listType)
+		return
+	}
+	if o.Sort != "" && size > 0 {
+		albums, _, err = db.DB.ListAlbums(o)
+	}
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	converted, err := albumsID3(albums)
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	r := newResponse()
+	r.AlbumList2 = &AlbumList2{Albums: converted}
+	write(c, r)
+}
+
+// playedAlbums returns the albums of a play history query
+func playedAlbums(played []db.PlayedAlbum, err error) ([]db.Album, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	albums := make([]db.Album, 0, len(played))
+	for _, a := range played {
+		albums = append(albums, a.Album)
+	}
+	return albums, nil
+}
+
+// getRandomSongs returns a list of random songs in the libraries the request
+// is limited to
+func getRandomSongs(c *gin.Context) {
+	size, ok := listSize(c, "size", 10)
+	if !ok {
+		return
+	}
+	libraries, ok := musicFolderFilter(c)
+	if !ok {
+		return
+	}
+
+	songs, err := db.DB.RandomSongsInLibraries(libraries, size)
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	converted, err := children(songs)
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	r := newResponse()
+	r.RandomSongs = &Songs{Songs: converted}
+	write(c, r)
+}
+
+// getSongsByGenre returns the songs of a genre in the libraries the request is
+// limited to
+func getSongsByGenre(c *gin.Context) {
+	name := c.Request.FormValue("genre")
+	if name == "" {
+		writeError(c, errMissingParam, "Required parameter is missing: genre")
+		return
+	}
+	size, ok := listSize(c, "count", 10)
+	if !ok {
+		return
+	}
+	offset, ok := intParam(c, "offset", 0)
+	if !ok {
+		return
+	}
+	libraries, ok := musicFolderFilter(c)
+	if !ok {
+		return
+	}
+
+	// A genre which is not known has no songs, and a count of zero lists
+	// every song, so an empty page is not loaded
+	songs := make([]db.Song, 0)
+	genre, err := db.DB.GenreByName(name)
+	if err == nil && size > 0 {
+		songs, _, err = db.DB.ListSongs(db.ListOptions{
+			Libraries: libraries,
+			Sort:      "title",
+			Offset:    offset,
+			Count:     size,
+			GenreID:   genre.ID,
+		})
+	} else if err == sql.ErrNoRows {
+		err = nil
+	}
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	converted, err := children(songs)
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	r := newResponse()
+	r.SongsByGenre = &Songs{Songs: converted}
+	write(c, r)
+}
+
+// getGenres returns the genres in the libraries the user may access, along
+// with their song and album counts
+func getGenres(c *gin.Context) {
+	libraries, ok := musicFolderFilter(c)
+	if !ok {
+		return
+	}
+
+	genres, err := db.DB.GenresInLibraries(libraries)
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	result := &Genres{Genres: make([]Genre, 0, len(genres))}
+	for _, g := range genres {
+		result.Genres = append(result.Genres, Genre{
+			SongCount:  g.SongCount,
+			AlbumCount: g.AlbumCount,
+			Value:      g.Name,
+		})
+	}
+
+	r := newResponse()
+	r.Genres = result
+	write(c, r)
+}
+
+// search3 returns the artists, albums and songs matching a query. An empty
+// query matches everything, which clients use to synchronize the library.
+func search3(c *gin.Context) {
+	// FormValue parses the form, so check for a missing query afterwards
+	query := strings.Trim(c.Request.FormValue("query"), `"`)
+	if _, ok := c.Request.Form["query"]; !ok {
+		writeError(c, errMissingParam, "Required parameter is missing: query")
+		return
+	}
+
+	params := []string{
+		"artistCount", "artistOffset",
+		"albumCount", "albumOffset",
+		"songCount", "songOffset",
+	}
+	values := make(map[string]int)
+	for _, p := range params {
+		def := 0
+		if strings.HasSuffix(p, "Count") {
+			def = 20
+		}
+
+		n, ok := intParam(c, p, def)
+		if !ok {
+			return
+		}
+		if strings.HasSuffix(p, "Count") && n > maxListSize {
+			n = maxListSize
+		}
+		values[p] = n
+	}
+
+	libraries, ok := musicFolderFilter(c)
+	if !ok {
+		return
+	}
+
+	var artists []db.Artist
+	var albums []db.Album
+	var songs []db.Song
+	var err error
+	if query == "" {
+		artists, err = db.DB.LimitArtistsInLibraries(
+			libraries, values["artistOffset"], values["artistCount"])
+		if err == nil {
+			albums, err = db.DB.LimitAlbumsInLibraries(
+				libraries, values["albumOffset"], values["albumCount"])
+		}
+		if err == nil {
+			songs, err = db.DB.LimitSongsInLibraries(
+				libraries, values["songOffset"], values["songCount"])
+		}
+	} else {
+		var match string
+		if match, err = db.ParseSearch(query); err != nil {
+			writeError(c, errGeneric, err.Error())
+			return
+		}
+
+		artists, albums, songs, err = searchAll(match, libraries, values)
+	}
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	result := new(SearchResult3)
+	if result.Artists, err = artistsID3(artists); err == nil {
+		if result.Albums, err = albumsID3(albums); err == nil {
+			result.Songs, err = children(songs)
+		}
+	}
+	if err != nil {
+		serverError(c, err)
+		return
+	}
+
+	r := newResponse()
+	r.SearchResult3 = result
+	write(c, r)
+}
+
+// searchAll runs a ranked search of artists, albums and songs in a set of
+// libraries, paginated by the search3 count and offset parameters
+func searchAll(match string, libraries []int, values map[string]int) (
+	[]db.Artist, []db.Album, []db.Song, error) {
+	artistHits, err := db.DB.SearchArtists(
+		match, libraries, values["artistOffset"], values["artistCount"])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	albumHits, err := db.DB.SearchAlbums(
+		match, libraries, values["albumOffset"], values["albumCount"])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	songHits, err := db.DB.SearchSongs(
+		match, libraries, values["songOffset"], values["songCount"])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	artists := make([]db.Artist, 0, len(artistHits))
+	for _, h := range artistHits {
+		artists = append(artists, h.Artist)
+	}
+	albums := make([]db.Album, 0, len(albumHits))
+	for _, h := range albumHits {
+		albums = append(albums, h.Album)
+	}
+	songs := make([]db.Song, 0, len(songHits))
+	for _, h := range songHits {
+		songs = append(songs, h.Song)
+	}
+	return artists, albums, songs, nil
+}
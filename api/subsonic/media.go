@@ -0,0 +1,99 @@
+package subsonic
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/eHoward1996/aiomst/api"
+	"github.com/eHoward1996/aiomst/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loadSong loads the song selected by the id parameter. False is returned,
+// after writing an error, when it could not be loaded.
+func loadSong(c *gin.Context) (*db.Song, bool) {
+	id, ok := idParam(c)
+	if !ok {
+		return nil, false
+	}
+
+	song := &db.Song{ID: id}
+	if err := song.Load(); err != nil {
+		loadError(c, "Song", err)
+		return nil, false
+	}
+
+	if !accessible(c, song, "Song") {
+		return nil, false
+	}
+	return song, true
+}
+
+// accessible reports whether the user making a request may access an item's
+// library. False is returned, after writing an error, when it may not be
+// accessed; the item is reported as not found, so it is not revealed.
+func accessible(c *gin.Context, item interface {
+	AccessibleBy(u *db.User) (bool, error)
+}, kind string) bool {
+	ok, err := item.AccessibleBy(api.CurrentUser(c))
+	if err != nil {
+		loadError(c, kind, err)
+		return false
+	}
+	if !ok {
+		writeError(c, errNotFound, kind+" not found")
+		return false
+	}
+	return true
+}
+
+// stream serves a song, transcoding it when the client asks for a different
+// format or a lower bit rate
+func stream(c *gin.Context) {
+	song, ok := loadSong(c)
+	if !ok {
+		return
+	}
+
+	maxBitRate, ok := intParam(c, "maxBitRate", 0)
+	if !ok {
+		return
+	}
+
+	if err := api.StreamSong(
+		c, song, c.Request.FormValue("format"), maxBitRate); err != nil {
+		writeError(c, errGeneric, err.Error())
+	}
+}
+
+// download serves a song's original file as an attachment
+func download(c *gin.Context) {
+	song, ok := loadSong(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Disposition",
+		fmt.Sprintf("attachment; filename=%q", path.Base(song.Path)))
+	api.ServeSong(c, song)
+}
+
+// getCoverArt serves an art file
+func getCoverArt(c *gin.Context) {
+	id, ok := idParam(c)
+	if !ok {
+		return
+	}
+
+	art := &db.Art{ID: id}
+	if err := art.Load(); err != nil {
+		loadError(c, "Cover art", err)
+		return
+	}
+	if !accessible(c, art, "Cover art") {
+		return
+	}
+
+	api.ServeArt(c, art)
+}
@@ -0,0 +1,239 @@
+package subsonic
+
+import (
+	"encoding/xml"
+
+	"github.com/eHoward1996/aiomst/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// apiVersion is the Subsonic REST API version implemented
+	apiVersion = "1.16.1"
+	// serverName and serverVersion identify this server to OpenSubsonic clients
+	serverName    = "aiomst"
+	serverVersion = "0.0.1-beta"
+)
+
+// Subsonic error codes
+const (
+	errGeneric        = 0
+	errMissingParam   = 10
+	errClientVersion  = 20
+	errServerVersion  = 30
+	errBadCredentials = 40
+	errNotAuthorized  = 50
+	errNotFound       = 70
+)
+
+// Response is the root element of every Subsonic response
+type Response struct {
+	XMLName       xml.Name `xml:"http://subsonic.org/restapi subsonic-response" json:"-"`
+	Status        string   `xml:"status,attr" json:"status"`
+	Version       string   `xml:"version,attr" json:"version"`
+	Type          string   `xml:"type,attr" json:"type"`
+	ServerVersion string   `xml:"serverVersion,attr" json:"serverVersion"`
+	OpenSubsonic  bool     `xml:"openSubsonic,attr" json:"openSubsonic"`
+
+	Error         *Error               `xml:"error,omitempty" json:"error,omitempty"`
+	License       *License             `xml:"license,omitempty" json:"license,omitempty"`
+	MusicFolders  *MusicFolders        `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Indexes       *Indexes             `xml:"indexes,omitempty" json:"indexes,omitempty"`
+	Artists       *ArtistsID3          `xml:"artists,omitempty" json:"artists,omitempty"`
+	Artist        *ArtistWithAlbumsID3 `xml:"artist,omitempty" json:"artist,omitempty"`
+	Album         *AlbumWithSongsID3   `xml:"album,omitempty" json:"album,omitempty"`
+	Song          *Child               `xml:"song,omitempty" json:"song,omitempty"`
+	AlbumList2    *AlbumList2          `xml:"albumList2,omitempty" json:"albumList2,omitempty"`
+	SearchResult3 *SearchResult3       `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+	RandomSongs   *Songs               `xml:"randomSongs,omitempty" json:"randomSongs,omitempty"`
+	SongsByGenre  *Songs               `xml:"songsByGenre,omitempty" json:"songsByGenre,omitempty"`
+	Genres        *Genres              `xml:"genres,omitempty" json:"genres,omitempty"`
+}
+
+// Error describes why a request failed
+type Error struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+// License is always valid
+type License struct {
+	Valid bool `xml:"valid,attr" json:"valid"`
+}
+
+// MusicFolders lists the libraries
+type MusicFolders struct {
+	Folders []MusicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+// MusicFolder is a library
+type MusicFolder struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+// Indexes groups artists by their first letter, for folder based browsing
+type Indexes struct {
+	LastModified    int64   `xml:"lastModified,attr" json:"lastModified"`
+	IgnoredArticles string  `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []Index `xml:"index" json:"index"`
+}
+
+// Index is a group of artists sharing a first letter
+type Index struct {
+	Name    string   `xml:"name,attr" json:"name"`
+	Artists []Artist `xml:"artist" json:"artist"`
+}
+
+// Artist is an artist as shown in getIndexes
+type Artist struct {
+	ID   string `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+// ArtistsID3 groups artists by their first letter, for tag based browsing
+type ArtistsID3 struct {
+	IgnoredArticles string     `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Index           []IndexID3 `xml:"index" json:"index"`
+}
+
+// IndexID3 is a group of artists sharing a first letter
+type IndexID3 struct {
+	Name    string      `xml:"name,attr" json:"name"`
+	Artists []ArtistID3 `xml:"artist" json:"artist"`
+}
+
+// ArtistID3 is an artist built from song tags
+type ArtistID3 struct {
+	ID         string `xml:"id,attr" json:"id"`
+	Name       string `xml:"name,attr" json:"name"`
+	CoverArt   string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	AlbumCount int    `xml:"albumCount,attr" json:"albumCount"`
+}
+
+// ArtistWithAlbumsID3 is an artist along with all of their albums
+type ArtistWithAlbumsID3 struct {
+	ArtistID3
+	Albums []AlbumID3 `xml:"album" json:"album"`
+}
+
+// AlbumID3 is an album built from song tags
+type AlbumID3 struct {
+	ID        string `xml:"id,attr" json:"id"`
+	Name      string `xml:"name,attr" json:"name"`
+	Artist    string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	ArtistID  string `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	CoverArt  string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	SongCount int    `xml:"songCount,attr" json:"songCount"`
+	Duration  int    `xml:"duration,attr" json:"duration"`
+	Year      int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	// IsCompilation is an OpenSubsonic extension marking compilations
+	IsCompilation bool `xml:"isCompilation,attr,omitempty" json:"isCompilation,omitempty"`
+	// DiscTitles is an OpenSubsonic extension naming an album's discs
+	DiscTitles []DiscTitle `xml:"discTitles,omitempty" json:"discTitles,omitempty"`
+}
+
+// DiscTitle is the subtitle of one of an album's discs
+type DiscTitle struct {
+	Disc  int    `xml:"disc,attr" json:"disc"`
+	Title string `xml:"title,attr" json:"title"`
+}
+
+// AlbumWithSongsID3 is an album along with all of its songs
+type AlbumWithSongsID3 struct {
+	AlbumID3
+	Songs []Child `xml:"song" json:"song"`
+}
+
+// Child is a song
+type Child struct {
+	ID          string `xml:"id,attr" json:"id"`
+	Parent      string `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir       bool   `xml:"isDir,attr" json:"isDir"`
+	Title       string `xml:"title,attr" json:"title"`
+	Album       string `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Artist      string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Track       int    `xml:"track,attr,omitempty" json:"track,omitempty"`
+	DiscNumber  int    `xml:"discNumber,attr,omitempty" json:"discNumber,omitempty"`
+	Year        int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre       string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	CoverArt    string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	Size        int64  `xml:"size,attr,omitempty" json:"size,omitempty"`
+	ContentType string `xml:"contentType,attr,omitempty" json:"contentType,omitempty"`
+	Suffix      string `xml:"suffix,attr,omitempty" json:"suffix,omitempty"`
+	Duration    int    `xml:"duration,attr,omitempty" json:"duration,omitempty"`
+	BitRate     int    `xml:"bitRate,attr,omitempty" json:"bitRate,omitempty"`
+	Path        string `xml:"path,attr,omitempty" json:"path,omitempty"`
+	AlbumID     string `xml:"albumId,attr,omitempty" json:"albumId,omitempty"`
+	ArtistID    string `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	Type        string `xml:"type,attr,omitempty" json:"type,omitempty"`
+}
+
+// AlbumList2 is a list of albums
+type AlbumList2 struct {
+	Albums []AlbumID3 `xml:"album" json:"album"`
+}
+
+// SearchResult3 holds the artists, albums and songs matching a search
+type SearchResult3 struct {
+	Artists []ArtistID3 `xml:"artist" json:"artist"`
+	Albums  []AlbumID3  `xml:"album" json:"album"`
+	Songs   []Child     `xml:"song" json:"song"`
+}
+
+// Songs is a list of songs
+type Songs struct {
+	Songs []Child `xml:"song" json:"song"`
+}
+
+// Genres lists every genre
+type Genres struct {
+	Genres []Genre `xml:"genre" json:"genre"`
+}
+
+// Genre is a genre along with its song and album counts
+type Genre struct {
+	SongCount  int    `xml:"songCount,attr" json:"songCount"`
+	AlbumCount int    `xml:"albumCount,attr" json:"albumCount"`
+	Value      string `xml:",chardata" json:"value"`
+}
+
+// newResponse creates a successful response
+func newResponse() *Response {
+	return &Response{
+		Status:        "ok",
+		Version:       apiVersion,
+		Type:          serverName,
+		ServerVersion: serverVersion,
+		OpenSubsonic:  true,
+	}
+}
+
+// write sends a response in the format requested by the client. Subsonic
+// responses always use status 200, even for errors.
+func write(c *gin.Context, r *Response) {
+	switch c.Request.FormValue("f") {
+	case "json":
+		c.JSON(200, gin.H{"subsonic-response": r})
+	case "jsonp":
+		c.JSONP(200, gin.H{"subsonic-response": r})
+	default:
+		c.XML(200, r)
+	}
+}
+
+// writeError sends a failed response with the given error code and message
+func writeError(c *gin.Context, code int, message string) {
+	r := newResponse()
+	r.Status = "failed"
+	r.Error = &Error{Code: code, Message: message}
+	write(c, r)
+}
+
+// serverError logs an unexpected error and sends a generic failed response, so
+// internal errors are not exposed to clients
+func serverError(c *gin.Context, err error) {
+	util.Logger.Print(err)
+	writeError(c, errGeneric, "Server error")
+}
@@ -0,0 +1,42 @@
+// Package subsonic implements the subset of the Subsonic REST API (and its
+// OpenSubsonic extensions) needed by common Subsonic clients.
+package subsonic
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// endpoints maps each Subsonic method to its handler
+var endpoints = map[string]gin.HandlerFunc{
+	"ping":            ping,
+	"getLicense":      getLicense,
+	"getMusicFolders": getMusicFolders,
+	"getIndexes":      getIndexes,
+	"getArtists":      getArtists,
+	"getArtist":       getArtist,
+	"getAlbum":        getAlbum,
+	"getSong":         getSong,
+	"getAlbumList2":   getAlbumList2,
+	"search3":         search3,
+	"stream":          stream,
+	"download":        download,
+	"getCoverArt":     getCoverArt,
+	"getRandomSongs":  getRandomSongs,
+	"getSongsByGenre": getSongsByGenre,
+	"getGenres":       getGenres,
+	"scrobble":        scrobbleSongs,
+}
+
+// Register mounts every Subsonic method on the group, which is normally
+// /rest. Each method is reachable with and without the ".view" suffix, using
+// either GET or a form encoded POST.
+func Register(g *gin.RouterGroup) {
+	g.Use(authenticate)
+
+	for name, handler := range endpoints {
+		for _, route := range []string{"/" + name, "/" + name + ".view"} {
+			g.GET(route, handler)
+			g.POST(route, handler)
+		}
+	}
+}
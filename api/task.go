@@ -0,0 +1,220 @@
+package api
+
+import (
+	"io"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/fs"
+	"github.com/eHoward1996/aiomst/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// taskKeepAlive is how often an idle event stream is sent a comment, so
+// proxies do not close it
+const taskKeepAlive = 30 * time.Second
+
+// scanRequest is the body of a request scanning a library, or a folder within
+// it. Sizes are only used when resizing art.
+type scanRequest struct {
+	LibraryID int    `form:"libraryId" json:"libraryId"`
+	Path      string `form:"path" json:"path"`
+	Sizes     []int  `form:"sizes" json:"sizes"`
+}
+
+// GetTasks returns the queued and running filesystem tasks, along with the
+// most recently finished ones
+func GetTasks(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	c.IndentedJSON(200, fs.Tasks.List())
+}
+
+// PostTaskAction routes POST /tasks/{action}. The router cannot match a fixed
+// segment alongside the {id} of /tasks/{id}/cancel, so actions are dispatched
+// here.
+func PostTaskAction(c *gin.Context) {
+	switch c.Param("id") {
+	case "scan":
+		PostTaskScan(c)
+	case "thumbnails":
+		PostTaskThumbnails(c)
+	default:
+		c.Header("Content-Type", "application/json; charset=UTF-8")
+		c.IndentedJSON(404, "unknown task action")
+	}
+}
+
+// PostTaskScan queues an orphan scan and a media scan of a library. When a
+// path is sent only that folder, which must be within the library, is
+// scanned.
+func PostTaskScan(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	_, l, p, ok := bindScanRequest(c)
+	if !ok {
+		return
+	}
+
+	// Record the scan, so a scheduled scan is not also queued
+	if p == l.Root {
+		if err := db.DB.UpdateLibraryScan(l, time.Now().Unix()); err != nil {
+			util.Logger.Print(err)
+			c.IndentedJSON(500, serverErr)
+			return
+		}
+	}
+
+	o := new(fs.OrphanScan)
+	m := new(fs.MediaScan)
+	if p == l.Root {
+		o.SetFolders(p, "")
+	} else {
+		o.SetFolders("", p)
+	}
+	m.SetFolders(p, "")
+
+	tasks := make([]fs.TaskInfo, 0, 2)
+	for _, t := range []fs.Task{o, m} {
+		t.SetLibrary(l)
+		t.Verbose(true)
+		tasks = append(tasks, fs.Tasks.Queue(t))
+	}
+
+	util.Logger.Printf("API: Queued scan of %s in library %s", p, l)
+	c.IndentedJSON(202, tasks)
+}
+
+// PostTaskThumbnails queues resizing the art of a library, or of a folder
+// within it, to the sent sizes, or to those made after each scan
+func PostTaskThumbnails(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	req, l, p, ok := bindScanRequest(c)
+	if !ok {
+		return
+	}
+
+	sizes := req.Sizes
+	if len(sizes) == 0 {
+		sizes = util.Current().Art.Pregenerate
+	}
+	if len(sizes) == 0 {
+		c.IndentedJSON(400, ErrMissingParam)
+		return
+	}
+	for _, size := range sizes {
+		if size <= 0 {
+			c.IndentedJSON(400, "Invalid integer art size")
+			return
+		}
+	}
+
+	t := new(fs.ThumbnailScan)
+	t.SetFolders(p, "")
+	t.SetLibrary(l)
+	t.SetSizes(sizes)
+	t.Verbose(true)
+	info := fs.Tasks.Queue(t)
+
+	util.Logger.Printf("API: Queued thumbnails of %s in library %s", p, l)
+	c.IndentedJSON(202, info)
+}
+
+// bindScanRequest binds a scanRequest, loading its library and resolving its
+// path within the library. The library's root is used when no path is sent.
+// False is returned once an error has been written.
+func bindScanRequest(c *gin.Context) (scanRequest, *db.Library, string, bool) {
+	var req scanRequest
+	if err := c.ShouldBind(&req); err != nil || req.LibraryID == 0 {
+		c.IndentedJSON(400, ErrMissingParam)
+		return req, nil, "", false
+	}
+
+	l, ok := loadLibrary(c, strconv.Itoa(req.LibraryID))
+	if !ok {
+		return req, nil, "", false
+	}
+
+	p := l.Root
+	if req.Path != "" {
+		p = path.Clean(util.ExpandHomeDir(req.Path))
+		if !path.IsAbs(p) {
+			p = path.Join(l.Root, p)
+		}
+		if !l.Contains(p) {
+			c.IndentedJSON(400, "path is not within the library")
+			return req, nil, "", false
+		}
+	}
+	return req, l, p, true
+}
+
+// PostTaskCancel cancels a queued or running task. Running tasks keep the
+// changes made before they stop. Third party integration cannot be cancelled.
+func PostTaskCancel(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(400, "Invalid integer task ID")
+		return
+	}
+
+	info, err := fs.Tasks.Cancel(id)
+	switch err {
+	case nil:
+	case fs.ErrTaskNotFound:
+		c.IndentedJSON(404, "task ID not found")
+		return
+	case fs.ErrTaskFinished:
+		c.IndentedJSON(409, "task has already finished")
+		return
+	case fs.ErrTaskNotCancellable:
+		c.IndentedJSON(409, "task cannot be cancelled")
+		return
+	default:
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	util.Logger.Printf("API: Cancelled task %d (%s)", info.ID, info.Type)
+	c.IndentedJSON(202, info)
+}
+
+// GetTaskEvents streams every change to a task as server-sent events, until
+// the client disconnects. Each event is named "task" and holds the task as
+// JSON; the current tasks are sent first.
+func GetTaskEvents(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	// Subscribe before listing, so no change is missed in between
+	events, unsubscribe := fs.Tasks.Subscribe()
+	defer unsubscribe()
+
+	for _, info := range fs.Tasks.List() {
+		c.SSEvent("task", info)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(taskKeepAlive)
+	defer keepAlive.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case info := <-events:
+			c.SSEvent("task", info)
+		case <-keepAlive.C:
+			io.WriteString(w, ":\n\n")
+		}
+		return true
+	})
+}
@@ -0,0 +1,302 @@
+package api
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserResponse is a user along with the name of their role, and whether they
+// have set up scrobbling to each service
+type UserResponse struct {
+	db.User
+	Role         string `json:"role"`
+	LastFM       bool   `json:"lastfm"`
+	ListenBrainz bool   `json:"listenbrainz"`
+}
+
+// userRequest is the body of a request creating or changing a user. Empty
+// fields are left unchanged, except for the scrobbling tokens, which are
+// removed when set to an empty string.
+type userRequest struct {
+	Username          string  `form:"username" json:"username"`
+	Password          string  `form:"password" json:"password"`
+	Role              string  `form:"role" json:"role"`
+	LastFMToken       *string `form:"lastfmToken" json:"lastfmToken"`
+	ListenBrainzToken *string `form:"listenbrainzToken" json:"listenbrainzToken"`
+}
+
+// keyRequest is the body of a request creating an API key
+type keyRequest struct {
+	Client string `form:"client" json:"client"`
+}
+
+func userResponse(u *db.User) UserResponse {
+	return UserResponse{
+		User:         *u,
+		Role:         u.Role(),
+		LastFM:       u.LastFMToken != "",
+		ListenBrainz: u.ListenBrainzToken != "",
+	}
+}
+
+// loadUserParam loads the user selected by the id path parameter. False is
+// returned, after writing a response, when it could not be loaded.
+func loadUserParam(c *gin.Context) (*db.User, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(400, "Invalid integer user ID")
+		return nil, false
+	}
+
+	user := &db.User{ID: id}
+	if err := user.Load(); err != nil {
+		if err == sql.ErrNoRows {
+			c.IndentedJSON(404, "user ID not found")
+			return nil, false
+		}
+
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return nil, false
+	}
+	return user, true
+}
+
+// GetCurrentUser returns the authenticated user
+func GetCurrentUser(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	c.IndentedJSON(200, userResponse(CurrentUser(c)))
+}
+
+// GetUsers returns every user account
+func GetUsers(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	users, err := db.DB.AllUsers()
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	res := make([]UserResponse, 0, len(users))
+	for i := range users {
+		res = append(res, userResponse(&users[i]))
+	}
+	c.IndentedJSON(200, res)
+}
+
+// PostUser creates a new user account. The role defaults to "user".
+func PostUser(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	var req userRequest
+	if err := c.ShouldBind(&req); err != nil ||
+		req.Username == "" || req.Password == "" {
+		c.IndentedJSON(400, ErrMissingParam)
+		return
+	}
+	if req.Role == "" {
+		req.Role = db.RoleMap[db.RoleUser]
+	}
+
+	roleID, err := db.RoleID(req.Role)
+	if err != nil {
+		c.IndentedJSON(400, err.Error())
+		return
+	}
+
+	// Usernames are unique
+	existing := &db.User{Username: req.Username}
+	if err := existing.Load(); err == nil {
+		c.IndentedJSON(409, "username already exists")
+		return
+	}
+
+	user, err := db.NewUser(req.Username, req.Password, roleID)
+	if err == nil {
+		err = user.Save()
+	}
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	c.IndentedJSON(201, userResponse(user))
+}
+
+// PutUser changes a user's name, password, scrobbling tokens or role. Users
+// may change their own name, password and tokens; everything else requires
+// an admin.
+func PutUser(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	user, ok := loadUserParam(c)
+	if !ok {
+		return
+	}
+
+	current := CurrentUser(c)
+	isAdmin := current.HasRole(db.RoleAdmin)
+	if !isAdmin && current.ID != user.ID {
+		c.IndentedJSON(403, permissionErr)
+		return
+	}
+
+	var req userRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.IndentedJSON(400, ErrMissingParam)
+		return
+	}
+
+	if req.Role != "" {
+		if !isAdmin {
+			c.IndentedJSON(403, permissionErr)
+			return
+		}
+
+		roleID, err := db.RoleID(req.Role)
+		if err != nil {
+			c.IndentedJSON(400, err.Error())
+			return
+		}
+		user.RoleID = roleID
+	}
+	if req.Username != "" && req.Username != user.Username {
+		// Usernames are unique
+		existing := &db.User{Username: req.Username}
+		if err := existing.Load(); err == nil {
+			c.IndentedJSON(409, "username already exists")
+			return
+		}
+		user.Username = req.Username
+	}
+	if req.Password != "" {
+		if err := user.SetPassword(req.Password); err != nil {
+			util.Logger.Print(err)
+			c.IndentedJSON(500, serverErr)
+			return
+		}
+	}
+	if req.LastFMToken != nil {
+		user.LastFMToken = *req.LastFMToken
+	}
+	if req.ListenBrainzToken != nil {
+		user.ListenBrainzToken = *req.ListenBrainzToken
+	}
+
+	if err := user.Update(); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	c.IndentedJSON(200, userResponse(user))
+}
+
+// DeleteUser removes a user account along with their sessions, API keys, play
+// history and playlists
+func DeleteUser(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	user, ok := loadUserParam(c)
+	if !ok {
+		return
+	}
+
+	// Admins cannot lock themselves out
+	if user.ID == CurrentUser(c).ID {
+		c.IndentedJSON(400, "cannot delete the current user")
+		return
+	}
+
+	if err := user.Delete(); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	c.Status(204)
+}
+
+// GetKeys returns the API keys of the authenticated user
+func GetKeys(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	keys, err := db.DB.APIKeysForUser(CurrentUser(c).ID)
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	c.IndentedJSON(200, keys)
+}
+
+// PostKey issues a new API key to the authenticated user for a client
+func PostKey(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	var req keyRequest
+	if err := c.ShouldBind(&req); err != nil || req.Client == "" {
+		c.IndentedJSON(400, ErrMissingParam)
+		return
+	}
+
+	key, err := db.NewAPIKey(CurrentUser(c), req.Client)
+	if err == nil {
+		err = key.Save()
+	}
+	if err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	c.IndentedJSON(201, key)
+}
+
+// DeleteKey revokes an API key. Users may revoke their own keys, admins may
+// revoke any key.
+func DeleteKey(c *gin.Context) {
+	c.Header("Content-Type", "application/json; charset=UTF-8")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.IndentedJSON(400, "Invalid integer key ID")
+		return
+	}
+
+	key := &db.APIKey{ID: id}
+	if err := key.Load(); err != nil {
+		if err == sql.ErrNoRows {
+			c.IndentedJSON(404, "key ID not found")
+			return
+		}
+
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	current := CurrentUser(c)
+	if key.UserID != current.ID && !current.HasRole(db.RoleAdmin) {
+		c.IndentedJSON(403, permissionErr)
+		return
+	}
+
+	if err := key.Delete(); err != nil {
+		util.Logger.Print(err)
+		c.IndentedJSON(500, serverErr)
+		return
+	}
+
+	c.Status(204)
+}
@@ -8,12 +8,54 @@ import (
 	"time"
 
 	"github.com/eHoward1996/aiomst/api"
+	"github.com/eHoward1996/aiomst/api/subsonic"
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/transcode"
 	"github.com/eHoward1996/aiomst/util"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
+// redactedParams are the query parameters holding secrets, which are not
+// written to the access log: API and session keys, and Subsonic passwords and
+// tokens
+var redactedParams = []string{"key", "p", "t"}
+
+// accessLog formats a request like gin's default logger, with the values of
+// redactedParams replaced
+func accessLog(param gin.LogFormatterParams) string {
+	if param.Request != nil && param.Request.URL.RawQuery != "" {
+		q := param.Request.URL.Query()
+		redacted := false
+		for _, name := range redactedParams {
+			if _, ok := q[name]; ok {
+				q.Set(name, "REDACTED")
+				redacted = true
+			}
+		}
+		if redacted {
+			param.Path = param.Request.URL.Path + "?" + q.Encode()
+		}
+	}
+
+	var statusColor, methodColor, resetColor string
+	if param.IsOutputColor() {
+		statusColor = param.StatusCodeColor()
+		methodColor = param.MethodColor()
+		resetColor = param.ResetColor()
+	}
+	return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		statusColor, param.StatusCode, resetColor,
+		param.Latency,
+		param.ClientIP,
+		methodColor, param.Method, resetColor,
+		param.Path,
+		param.ErrorMessage,
+	)
+}
+
 func apiManager(apikillChan chan struct{})	{
 	util.Logger.Print("API MANAGER STARTED")
 	gracefulChan := make(chan struct{}, 0)
@@ -22,20 +64,36 @@ func apiManager(apikillChan chan struct{})	{
 	// Initialize Gin (api router)
 	r := gin.New()
 	r.Use(gin.Recovery())
-	r.Use(gin.Logger())
+	r.Use(gin.LoggerWithFormatter(accessLog))
 	r.Use(cors.New(cors.Config{
-		AllowAllOrigins:     	true,
+		// Origins are checked against the current configuration, so they
+		// may be changed by reloading it
+		AllowOriginFunc: func(origin string) bool {
+			return util.Current().AllowsOrigin(origin)
+		},
 		AllowHeaders:     	 	[]string{
 			"Content-Type",
 			"Origin",
 			"Accept-Ranges",
-			"Ranges",
+			"Range",
+			"If-Range",
+			"If-Modified-Since",
+			"If-None-Match",
 			"Access-Control-Allow-Origin",
+			"Authorization",
+			"X-API-Key",
 		},
 		ExposeHeaders:    []string{
 			"Content-Type", 
 			"Content-Length", 
+			"Content-Range",
+			"Accept-Ranges",
+			"ETag",
+			"Last-Modified",
 			"Origin",
+			"Link",
+			"X-Total-Count",
+			"X-Next-Cursor",
 		},
 		MaxAge: 12 * time.Hour,
 	}))
@@ -49,20 +107,86 @@ func apiManager(apikillChan chan struct{})	{
 	
 	// r.GET("/", func(c *gin.Context) {c.String(http.StatusOK, "pong")})
 	r.StaticFile("/", "./core/public.html")
-	r.GET("/albums",    api.GetAlbums)
-	r.GET("/album/:id", api.GetAlbum)
+	r.POST("/login", api.PostLogin)
+
+	// Every other route requires a session or API key
+	auth := r.Group("/", api.Authenticate)
+	auth.POST("/logout", api.PostLogout)
+
+	auth.GET("/albums",    api.GetAlbums)
+	auth.GET("/album/:id", api.GetAlbum)
+
+	auth.GET("/artists",       api.GetArtists)
+	auth.GET("/artists/index", api.GetArtistIndex)
+	auth.GET("/artist/:id",    api.GetArtist)
+
+	auth.GET("/genres",           api.GetGenres)
+	auth.GET("/genre/:id/albums", api.GetGenreAlbums)
+	auth.GET("/genre/:id/songs",  api.GetGenreSongs)
+
+	auth.GET("/songs",    api.GetSongs)
+	auth.GET("/song/:id", api.GetSong)
+
+	auth.GET("/libraries", api.GetLibraries)
+	auth.GET("/library/:id", api.GetLibrary)
+
+	auth.GET("/search",  api.GetSearch)
+	auth.GET("/art/:id", api.GetArt)
+	auth.GET("/stream",  api.GetStream)
+	auth.GET("/stream/:id", api.GetStream)
+
+	auth.GET("/user", api.GetCurrentUser)
+	auth.PUT("/user/:id", api.PutUser)
+
+	auth.GET("/plays", api.GetPlays)
+	auth.GET("/plays/most", api.GetMostPlayed)
+	auth.GET("/plays/recent", api.GetRecentlyPlayed)
+	auth.GET("/plays/never", api.GetNeverPlayed)
+
+	auth.GET("/playlists", api.GetPlaylists)
+	auth.GET("/playlist/:id", api.GetPlaylist)
+	auth.GET("/playlist/:id/export", api.GetPlaylistExport)
+
+	// Guests may only read
+	user := auth.Group("/", api.RequireRole(db.RoleUser))
+	user.GET("/keys", api.GetKeys)
+	user.POST("/keys", api.PostKey)
+	user.DELETE("/key/:id", api.DeleteKey)
+
+	user.POST("/plays", api.PostPlay)
+
+	user.POST("/playlists", api.PostPlaylist)
+	user.POST("/playlists/import", api.PostPlaylistImport)
+	user.PUT("/playlist/:id", api.PutPlaylist)
+	user.DELETE("/playlist/:id", api.DeletePlaylist)
+	user.PUT("/playlist/:id/songs", api.PutPlaylistSongs)
+	user.POST("/playlist/:id/songs", api.PostPlaylistSongs)
+	user.POST("/playlist/:id/move", api.PostPlaylistMove)
+	user.DELETE("/playlist/:id/song/:position", api.DeletePlaylistSong)
+
+	admin := auth.Group("/", api.RequireRole(db.RoleAdmin))
+	admin.GET("/users", api.GetUsers)
+	admin.POST("/users", api.PostUser)
+	admin.DELETE("/user/:id", api.DeleteUser)
+
+	admin.POST("/libraries", api.PostLibrary)
+	admin.PUT("/library/:id", api.PutLibrary)
+	admin.DELETE("/library/:id", api.DeleteLibrary)
 
-	r.GET("/artists",    api.GetArtists)
-	r.GET("/artist/:id", api.GetArtist)
+	admin.GET("/tasks", api.GetTasks)
+	admin.POST("/tasks/:id", api.PostTaskAction)
+	admin.POST("/tasks/:id/cancel", api.PostTaskCancel)
+	admin.GET("/events/tasks", api.GetTaskEvents)
 
-	r.GET("/songs",    api.GetSongs)
-	r.GET("/song/:id", api.GetSong)
+	// Subsonic compatible API
+	subsonic.Register(r.Group("/rest"))
 
-	r.GET("/search",  api.GetSearch)
-	r.GET("/art/:id", api.GetArt)
-	r.GET("/stream",  api.GetStream)
+	sConf := util.C
+	transcode.Default = transcode.New(
+		sConf.Transcoder.Binary, sConf.Transcoder.MaxJobs)
+	util.Logger.Printf("API: Transcoding with %s (max jobs: %d)",
+		sConf.Transcoder.Binary, sConf.Transcoder.MaxJobs)
 
-	sConf := util.LoadConfig()
 	server := &http.Server{
 		Addr:    sConf.Host,
 		Handler: r,
@@ -3,6 +3,7 @@ package core
 import (
 	"log"
 	"os"
+	"path"
 
 	"github.com/eHoward1996/aiomst/db"
 	"github.com/eHoward1996/aiomst/util"
@@ -34,10 +35,106 @@ func dbManager(conf util.Config, dbLaunchChan, dbKillChan chan struct{})	{
 		log.Fatalf("DB: Could not open database: %s", err)
 	}
 
+	// Bring the schema up to date
+	if err := db.DB.Migrate(); err != nil {
+		log.Fatalf("DB: Could not migrate database: %s", err)
+	}
+
+	// Remove the metadata files left in the media folders by earlier versions
+	if err := cleanMetadataFiles(conf); err != nil {
+		log.Fatalf("DB: Could not clean up metadata files: %s", err)
+	}
+
+	// Make sure there is an account to log in with
+	if err := createAdmin(conf); err != nil {
+		log.Fatalf("DB: Could not create admin account: %s", err)
+	}
+
+	// Make sure there is a library to scan
+	if err := createLibrary(conf); err != nil {
+		log.Fatalf("DB: Could not create library: %s", err)
+	}
+
 	close(dbLaunchChan)
 	dbWatchKillSig(dbKillChan)
 }
 
+// createAdmin creates the admin account when the database has no users. If no
+// password was configured a random one is generated and logged once.
+func createAdmin(conf util.Config) error {
+	count, err := db.DB.CountUsers()
+	if err != nil || count > 0 {
+		return err
+	}
+
+	password := conf.Admin.Password
+	if password == "" {
+		if password, err = util.RandomKey(12); err != nil {
+			return err
+		}
+		util.Logger.Printf("DB: Generated password for admin %q: %s",
+			conf.Admin.User, password)
+	}
+
+	admin, err := db.NewUser(conf.Admin.User, password, db.RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	util.Logger.Print("DB: Creating admin account: ", admin)
+	return admin.Save()
+}
+
+// createLibrary creates a music library at the configured media folder when
+// the database has no libraries. Anything scanned before libraries existed is
+// moved into it.
+func createLibrary(conf util.Config) error {
+	count, err := db.DB.CountLibraries()
+	if err != nil || count > 0 {
+		return err
+	}
+
+	root := conf.MediaFolderPath()
+	library := db.NewLibrary(path.Base(root), root, db.LibraryMusic, 0)
+	util.Logger.Print("DB: Creating library: ", library)
+	return library.Save()
+}
+
+// cleanMetadataFiles deletes the metadata files earlier versions wrote into
+// the media folders, whose contents were imported into the database. Unless
+// asked to, and never when the media is read only, the files are only counted.
+func cleanMetadataFiles(conf util.Config) error {
+	files, err := db.DB.AllMetadataFiles()
+	if err != nil || len(files) == 0 {
+		return err
+	}
+
+	if !conf.CleanMetadata || conf.ReadOnly {
+		util.Logger.Printf("DB: %d metadata files in the media folders are no longer "+
+			"used, start with -clean-metadata=true to delete them", len(files))
+		return nil
+	}
+
+	removed := 0
+	for i := range files {
+		// Only ever delete the files this program created
+		if path.Base(files[i].Path) != "metadata" {
+			continue
+		}
+		if err := os.Remove(files[i].Path); err != nil && !os.IsNotExist(err) {
+			util.Logger.Printf("DB: Could not delete metadata file: %v", err)
+			continue
+		}
+		if err := db.DB.DeleteMetadataFile(&files[i]); err != nil {
+			return err
+		}
+		removed++
+	}
+
+	util.Logger.Printf("DB: Deleted %d of %d metadata files", removed, len(files))
+	return nil
+}
+
 func dbWatchKillSig(dbKillChan chan struct{})	{
 	// Trigger events via channel
 	for {
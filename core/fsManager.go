@@ -2,87 +2,217 @@ package core
 
 import (
 	// "errors"
+	"context"
+	"database/sql"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/eHoward1996/aiomst/db"
 	"github.com/eHoward1996/aiomst/fs"
 	"github.com/eHoward1996/aiomst/fs/integrated"
 	"github.com/eHoward1996/aiomst/util"
+)
+
+// libraryCheckInterval is how often libraries are checked for scheduled scans
+// and new roots to watch
+const libraryCheckInterval = time.Minute
 
-	"github.com/radovskyb/watcher"
+// The filesystem watcher, and the library roots it watches
+var (
+	watchMutex   sync.Mutex
+	fsWatcher    fs.Watcher
+	watchedRoots = map[string]bool{}
 )
 
-// fsTaskQueue is a queue of tasks to be performed by the filesystem
-var fsTaskQueue = make(chan fs.Task, 10)
+func fsManager(sqlFile string, fsKillChan chan struct{})	{
+	util.Logger.Print("FS MANAGER STARTED")
 
-// Track the number of file system events
-var fsTaskCount = 0
+	libraries, err := db.DB.AllLibraries()
+	if err != nil {
+		log.Fatalf("FS: Could not load libraries: %s", err)
+	}
 
-// Initialize a queue to cancel filesystem tasks
-var cancelQueue = make(chan chan struct{}, 10)
+	// Tasks run until the manager is stopped
+	ctx, cancel := context.WithCancel(context.Background())
+	tasksDone := make(chan struct{})
 
-func fsManager(mediaPath string, sqlFile string, fsKillChan chan struct{})	{
-	util.Logger.Print("FS MANAGER STARTED")
+	// Queue an orphan scan and a media scan of every library
+	initialTasks := make(map[int]bool)
+	for i := range libraries {
+		for _, ID := range queueLibraryScan(&libraries[i], true) {
+			initialTasks[ID] = true
+		}
+	}
 
 	// Initialize filesystem watcher
 	watcherChan := make(chan struct{})
-
-	// Queue an orphan scan
-	o := new(fs.OrphanScan)
-	o.SetFolders(mediaPath, "")
-	o.Verbose(true)
-	fsTaskQueue <- o
-
-	// Queue a media scan
-	m := new(fs.MediaScan)
-	m.SetFolders(mediaPath, "")
-	m.Verbose(true)
-	fsTaskQueue <- m
+	go handleFSTasks(ctx, watcherChan, initialTasks, tasksDone)
 
 	// Queue a job to integrate third party data
 	go func(watcherChan chan struct{}) {
 		<- watcherChan
 
 		for {
-			tpi := new(integrated.TPIntegrator)
-			tpi.Integrate()
-			time.Sleep(time.Hour * 24)
+			// Integration is listed with the other tasks, but is not
+			// cancelled until it finishes
+			info := fs.Tasks.Add("Third Party Integration", nil, "")
+			fs.Tasks.Run(ctx, info.ID, func(ctx context.Context) (int, fs.TaskState, error) {
+				tpi := new(integrated.TPIntegrator)
+				tpi.Integrate()
+				return 0, fs.TaskCompleted, nil
+			})
+
+			select {
+			case <- ctx.Done():
+				return
+			case <- time.After(util.Current().Integration.Interval.Duration()):
+			}
 		}
 	}(watcherChan)
 
-	go handleFSTasks(watcherChan)
 	go handleFSEvents(watcherChan)
-	fsWatchKillSig(fsKillChan)
+	go scheduleScans(watcherChan)
+	fsWatchKillSig(fsKillChan, cancel, tasksDone)
 }
 
-// Handle fs tasks in goroutine so they can be halted by the Task Manager
-func handleFSTasks(watcherChan chan struct{}) {
+// queueLibraryScan queues an orphan scan and a media scan of a whole library,
+// and records when the scan started. The IDs of the queued tasks are returned.
+func queueLibraryScan(l *db.Library, verbose bool) []int {
+	if err := db.DB.UpdateLibraryScan(l, time.Now().Unix()); err != nil {
+		util.Logger.Printf("FS: Could not record scan of library %s: %v", l, err)
+	}
+
+	o := new(fs.OrphanScan)
+	o.SetFolders(l.Root, "")
+	o.SetLibrary(l)
+	o.Verbose(verbose)
+	orphanInfo := fs.Tasks.Queue(o)
+
+	m := new(fs.MediaScan)
+	m.SetFolders(l.Root, "")
+	m.SetLibrary(l)
+	m.Verbose(verbose)
+	mediaInfo := fs.Tasks.Queue(m)
+	return []int{orphanInfo.ID, mediaInfo.ID}
+}
+
+// queueTask queues a task within the library containing path p. Tasks for
+// paths which are in no library are dropped.
+func queueTask(task fs.Task, p string) {
+	l, err := db.DB.LibraryForPath(p)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			util.Logger.Print(err)
+		}
+		return
+	}
+
+	task.SetLibrary(l)
+	fs.Tasks.Queue(task)
+}
+
+// queueThumbnails queues resizing the art within the folders of a finished
+// media scan, when sizes are set to be made ahead of requests
+func queueThumbnails(m fs.Task) {
+	sizes := util.Current().Art.Pregenerate
+	if len(sizes) == 0 {
+		return
+	}
+
+	t := new(fs.ThumbnailScan)
+	t.SetFolders(m.Folders())
+	t.SetLibrary(m.Library())
+	t.SetSizes(sizes)
+	t.Verbose(false)
+	fs.Tasks.Queue(t)
+}
+
+// scheduleScans checks the libraries once the initial scans are complete,
+// queueing full scans of those which are due and watching new roots, so
+// libraries added while running are picked up
+func scheduleScans(watcherChan chan struct{}) {
+	<- watcherChan
+
 	for {
-		select {
-		case task := <- fsTaskQueue:
-			// Create a channel to halt the scan
-			util.Logger.Printf("FS: Got new task (WhoAmI ==> %s)", task.WhoAmI())
-			cancelChan := make(chan struct{})
-			cancelQueue <- cancelChan
-
-			// Retrieve the folder for the scan
-			baseFolder, subFolder := task.Folders()
-			
-			changes, err := task.Scan(baseFolder, subFolder, cancelChan)
-			if err != nil	{
-				util.Logger.Printf("FS: Task Errored: %v", err)
-			}
+		time.Sleep(libraryCheckInterval)
+
+		libraries, err := db.DB.AllLibraries()
+		if err != nil {
+			util.Logger.Printf("FS: Could not load libraries: %v", err)
+			continue
+		}
 
-			if changes > 0 {
-				util.UpdateScanTime()
-				util.Logger.Printf("FS: New Scan Time: %v", util.ScanTimePretty())
+		now := time.Now().Unix()
+		for i := range libraries {
+			l := &libraries[i]
+			watchLibrary(l)
+			if l.ScanDue(now) {
+				util.Logger.Printf("FS: Scheduled scan of library %s", l)
+				queueLibraryScan(l, true)
 			}
+		}
+	}
+}
+
+// watchLibrary adds a library's root to the filesystem watcher, unless it is
+// already watched or the watcher has not started
+func watchLibrary(l *db.Library) {
+	watchMutex.Lock()
+	defer watchMutex.Unlock()
+
+	if fsWatcher == nil || watchedRoots[l.Root] {
+		return
+	}
+	if err := fsWatcher.Add(l.Root); err != nil {
+		util.Logger.Printf("FS: Could not watch library %s: %v", l, err)
+		return
+	}
+	watchedRoots[l.Root] = true
+	util.Logger.Print("FS: Watching folder:", l.Root)
+}
 
-			cancelChan = <- cancelQueue
-			close(cancelChan)
-			fsTaskCount++
+// Handle the tasks queued in the registry in goroutine until ctx is cancelled
+// by the Task Manager, which also cancels the running task. Done is closed
+// once no task is running. The watcher is started after the initial tasks,
+// given by ID, whether they finished or were cancelled; tasks they queue, such
+// as thumbnail scans, are not waited for.
+func handleFSTasks(
+	ctx context.Context, watcherChan chan struct{}, initialTasks map[int]bool, done chan struct{}) {
+	defer close(done)
 
-			if fsTaskCount == 2 {
+	if len(initialTasks) == 0 {
+		util.Logger.Print("FS: No libraries to scan")
+		close(watcherChan)
+	}
+
+	for {
+		id, task, ok := fs.Tasks.Next(ctx)
+		if !ok {
+			return
+		}
+		util.Logger.Printf("FS: Got new task %d (WhoAmI ==> %s)", id, task.WhoAmI())
+
+		changes, state, err := fs.Tasks.Run(ctx, id, func(ctx context.Context) (int, fs.TaskState, error) {
+			return fs.Run(ctx, task)
+		})
+		if state == fs.TaskFailed {
+			util.Logger.Printf("FS: Task %d %s %s: %v", id, task.WhoAmI(), state, err)
+		} else {
+			util.Logger.Printf("FS: Task %d %s %s [changes: %d]", id, task.WhoAmI(), state, changes)
+		}
+
+		if _, ok := task.(*fs.MediaScan); ok && state == fs.TaskCompleted {
+			queueThumbnails(task)
+		}
+
+		if changes > 0 {
+			util.UpdateScanTime()
+			util.Logger.Printf("FS: New Scan Time: %v", util.ScanTimePretty())
+		}
+		if initialTasks[id] {
+			delete(initialTasks, id)
+			if len(initialTasks) == 0 {
 				util.Logger.Print("FS: Finished initial media and orphan scans")
 				close(watcherChan)
 			}
@@ -90,70 +220,84 @@ func handleFSTasks(watcherChan chan struct{}) {
 	}
 }
 
-// Handle fs events such as modify/rename/delete/create files.
+// Handle fs events such as modify/rename/delete/create files. Changes are
+// debounced, so each changed folder is scanned once it has been quiet. Media
+// scans are queued before orphan scans, so moved songs are found in their new
+// folder before they are missed in their old one.
 func handleFSEvents(watcherChan chan struct{}) {
 	<- watcherChan
-	w := watcher.New()
 
-	go func() 	{
-		for {
-			select {
-			case ev := <- w.Event: 
-				switch ev.Op.String() {
-				case "MOVE":
-					o := new(fs.OrphanScan)
-					o.SetFolders("", ev.OldPath)
-					o.Verbose(false)
-					fsTaskQueue <- o
-					fallthrough
-				case "CREATE":
-					m := new(fs.MediaScan)
-					m.SetFolders(ev.Path, "")
-					m.Verbose(false)
-					fsTaskQueue <- m
-				case "RENAME":
-					fallthrough
-				case "REMOVE":
-					o := new(fs.OrphanScan)
-					o.SetFolders("", ev.Path)
-					o.Verbose(false)
-					fsTaskQueue <- o
-				}
-			case err := <- w.Error:
-				util.Logger.Print(err)
-				return 
-			}
-		}
-	}()
-
-	// Watch media folder
-	if err := w.AddRecursive(util.C.MediaFolderPath()); err != nil 	{
+	conf := util.C
+	w, err := fs.NewWatcher(conf.Watcher.Mode, conf.Watcher.Interval.Duration())
+	if err != nil {
 		log.Fatal(err)
 	}
-	if err := w.Start(1 * time.Minute); err != nil {
+
+	watchMutex.Lock()
+	fsWatcher = w
+	watchMutex.Unlock()
+
+	// Watch every library
+	libraries, err := db.DB.AllLibraries()
+	if err != nil {
 		log.Fatal(err)
 	}
-	util.Logger.Print("FS: Watching folder:", util.C.MediaFolderPath())
+	for i := range libraries {
+		watchLibrary(&libraries[i])
+	}
+	util.Logger.Printf("FS: Watching for changes using %s", w.Name())
+
+	quiet := conf.Watcher.Debounce.Duration()
+	debouncer := fs.NewDebouncer(quiet)
+	ticker := time.NewTicker(quiet / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev, ok := <- w.Events():
+			if !ok {
+				return
+			}
+			debouncer.Add(ev, time.Now())
+		case err := <- w.Errors():
+			util.Logger.Print(err)
+		case now := <- ticker.C:
+			media, orphan := debouncer.Flush(now)
+			for _, p := range media {
+				m := new(fs.MediaScan)
+				m.SetFolders(p, "")
+				m.Verbose(false)
+				queueTask(m, p)
+			}
+			for _, p := range orphan {
+				o := new(fs.OrphanScan)
+				o.SetFolders("", p)
+				o.Verbose(false)
+				queueTask(o, p)
+			}
+		}
+	}
 }
 
-func fsWatchKillSig(fsKillChan chan struct{})	{
+// fsWatchKillSig waits for the Task Manager to stop the filesystem manager,
+// then cancels the running task and stops the watcher
+func fsWatchKillSig(
+	fsKillChan chan struct{}, cancel context.CancelFunc, tasksDone chan struct{})	{
 	for {		
 		select {
 		// Stop filesystem manager
 		case <- fsKillChan:
-			// Halt any in-progress tasks
+			// Halt any in-progress task, and wait for it to stop
 			util.Logger.Print("FS: halting tasks")
-			for i := 0; i < len(cancelQueue); i++ {
-				// Receive a channel
-				f := <-cancelQueue
-				if f == nil {
-					continue
-				}
-
-				// Send termination
-				f <- struct{}{}
-				util.Logger.Print("FS: task halted")
+			cancel()
+			<- tasksDone
+			util.Logger.Print("FS: tasks halted")
+
+			watchMutex.Lock()
+			if fsWatcher != nil {
+				fsWatcher.Close()
 			}
+			watchMutex.Unlock()
 
 			// Inform manager that shutdown is complete
 			util.Logger.Print("FS MANAGER STOPPED!")
@@ -161,4 +305,4 @@ func fsWatchKillSig(fsKillChan chan struct{})	{
 			return
 		}
 	}
-}
\ No newline at end of file
+}
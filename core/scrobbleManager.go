@@ -0,0 +1,44 @@
+package core
+
+import (
+	"context"
+
+	"github.com/eHoward1996/aiomst/scrobble"
+	"github.com/eHoward1996/aiomst/util"
+)
+
+func scrobbleManager(conf util.Config, scrobbleLaunchChan, scrobbleKillChan chan struct{}) {
+	util.Logger.Print("SCROBBLE MANAGER STARTED")
+
+	submitters := make([]scrobble.Submitter, 0)
+	if conf.Scrobble.ListenBrainzURL != "" {
+		submitters = append(submitters,
+			scrobble.NewListenBrainz(conf.Scrobble.ListenBrainzURL))
+		util.Logger.Print("SCROBBLE: ListenBrainz:", conf.Scrobble.ListenBrainzURL)
+	}
+	if conf.Scrobble.LastFMKey != "" && conf.Scrobble.LastFMSecret != "" {
+		submitters = append(submitters, scrobble.NewLastFM(
+			conf.Scrobble.LastFMURL, conf.Scrobble.LastFMKey, conf.Scrobble.LastFMSecret))
+		util.Logger.Print("SCROBBLE: Last.fm:", conf.Scrobble.LastFMURL)
+	}
+
+	q := scrobble.New(conf.Scrobble.Threshold, submitters...)
+	scrobble.Default = q
+	close(scrobbleLaunchChan)
+
+	// Forward plays until shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+
+	<-scrobbleKillChan
+	cancel()
+	<-done
+
+	// Inform manager that shutdown is complete
+	util.Logger.Print("SCROBBLE MANAGER STOPPED")
+	scrobbleKillChan <- struct{}{}
+}
@@ -1,25 +1,36 @@
 package core
 
 import (
+	"log"
+
+	"github.com/eHoward1996/aiomst/scrobble"
 	"github.com/eHoward1996/aiomst/util"
 )
 
-var dbKillChan, fsKillChan chan struct{}
+var dbKillChan, fsKillChan, scrobbleKillChan chan struct{}
 
 // TaskManager begins AIOMST. It controls all sub-tasks.
 func TaskManager(killChan chan struct{}, exitChan chan int)	{
 	util.Logger.Print("TASK MANAGER STARTED")
 	
-	util.C = util.LoadConfig()
-	config := util.C 
+	config, err := util.LoadConfig()
+	if err != nil {
+		log.Fatalf("TASK MANAGER: Invalid configuration: %s", err)
+	}
+	util.SetConfig(config)
 	
 	dbLaunchFinishChan := make(chan struct{})
 	dbKillChan         = make(chan struct{})
 	go dbManager(config, dbLaunchFinishChan, dbKillChan)
 	<- dbLaunchFinishChan
 
-	fsKillChan := make(chan struct{})
-	go fsManager(config.MediaFolderPath(), config.SqlFilePath(), fsKillChan)
+	scrobbleLaunchChan := make(chan struct{})
+	scrobbleKillChan   = make(chan struct{})
+	go scrobbleManager(config, scrobbleLaunchChan, scrobbleKillChan)
+	<- scrobbleLaunchChan
+
+	fsKillChan         = make(chan struct{})
+	go fsManager(config.SqlFilePath(), fsKillChan)
 
 	apiKillChan := make(chan struct{})
 	go apiManager(apiKillChan)
@@ -27,6 +38,25 @@ func TaskManager(killChan chan struct{}, exitChan chan int)	{
 	watchKillChans(killChan, exitChan)
 }
 
+// ReloadConfig reloads the configuration, applying the settings which are
+// safe to change while running. It is triggered by SIGHUP.
+func ReloadConfig() {
+	applied, ignored, err := util.ReloadConfig()
+	if err != nil {
+		util.Logger.Printf("TASK MANAGER: Configuration not reloaded: %s", err)
+		return
+	}
+
+	if scrobble.Default != nil {
+		scrobble.Default.SetThreshold(util.Current().Scrobble.Threshold)
+	}
+
+	util.Logger.Printf("TASK MANAGER: Configuration reloaded (changed: %v)", applied)
+	if len(ignored) > 0 {
+		util.Logger.Printf("TASK MANAGER: Restart to apply changes to: %v", ignored)
+	}
+}
+
 func watchKillChans(killChan chan struct{}, exitChan chan int)	{
 	for {
 		select {
@@ -37,6 +67,10 @@ func watchKillChans(killChan chan struct{}, exitChan chan int)	{
 			<- fsKillChan
 			close(fsKillChan)
 
+			scrobbleKillChan <- struct{}{}
+			<- scrobbleKillChan
+			close(scrobbleKillChan)
+
 			dbKillChan <- struct{}{}
 			<- dbKillChan
 			close(dbKillChan)
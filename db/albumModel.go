@@ -18,7 +18,9 @@ type Album struct {
 	FolderID        int     `db:"folder_id" json:"folderId"`
 	Title    	      string 	`db:"title" json:"title"`
 	NormalizedTitle string  `db:"normalized_title" json:"normalizedTitle"`
+	SortTitle       string  `db:"sort_title" json:"sortTitle"`
 	Year     	      int    	`db:"year" json:"year"`
+	Compilation     bool    `db:"compilation" json:"compilation"`
 }
 
 // GetAlbumFromSong creates a new Album from a Song model, extracting its
@@ -28,7 +30,9 @@ func GetAlbumFromSong(song *Song) *Album {
 		Artist:          song.Artist,
 		Title:           song.Album,
 		NormalizedTitle: util.NormalizeString(song.Album),
+		SortTitle:       song.AlbumSort,
 		Year:            song.Year,
+		Compilation:     song.Compilation,
 	}
 }
 
@@ -42,17 +46,27 @@ func (a *Album) GetArt() (*Art, error)	{
 	return art, nil
 }
 
-// GetMetadataObj returns a Metadata object based on the MetadataID from this
-// struct
-func (a *Album) GetMetadataObj() (*Metadata, error) {
-	md := new(Metadata)
-	md.ID = a.MetadataID
-	if err := md.Load(); err != nil {
+// GetMetadata returns the third party metadata stored for this album. The
+// metadata is empty when none has been stored.
+func (a *Album) GetMetadata() (*AlbumMetadata, error) {
+	md := new(AlbumMetadata)
+	if err := loadItemMetadata(KindAlbum, a.ID, md); err != nil {
 		return nil, err
 	}
 	return md, nil
 }
 
+// SetMetadata stores the third party metadata of this album
+func (a *Album) SetMetadata(md *AlbumMetadata) error {
+	return saveItemMetadata(KindAlbum, a.ID, md)
+}
+
+// AccessibleBy reports whether a user may access a library holding one of
+// the album's songs
+func (a *Album) AccessibleBy(u *User) (bool, error) {
+	return DB.AlbumAccessibleBy(a.ID, u)
+}
+
 // Delete removes an existing Album from the database
 func (a *Album) Delete() error {
 	return DB.DeleteAlbum(a)
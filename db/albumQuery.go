@@ -2,6 +2,8 @@ package db
 
 import (
 	"database/sql"
+
+	"github.com/jmoiron/sqlx"
 )
 
 // albumQuery loads a slice of Album structs matching the input query
@@ -46,7 +48,7 @@ func (s *SqlBackend) AllAlbums() ([]Album, error) {
 }
 
 // AllAlbumsByTitle loads a slice of all Album structs from the database ordered
-// by their title case insensitive
+// by their sort title case insensitive
 func (s *SqlBackend) AllAlbumsByTitle() ([]Album, error) {
 	return s.albumQuery(
 		`SELECT 
@@ -54,8 +56,7 @@ func (s *SqlBackend) AllAlbumsByTitle() ([]Album, error) {
 			artists.title AS artist 
 		FROM albums
 		JOIN artists ON albums.artist_id = artists.id
-		ORDER BY albums.title
-		COLLATE NOCASE ASC;`,
+		ORDER BY `+sortBy("albums")+` ASC;`,
 	)
 }
 
@@ -74,7 +75,74 @@ func (s *SqlBackend) LimitAlbums(offset int, count int) ([]Album, error) {
 	)
 }
 
-// AlbumsForArtist loads a slice of all Album structs with matching artist ID
+// LimitAlbumsInLibraries loads a slice of the Album structs with songs in the
+// given libraries, or in every library when IDs is nil, ordered by their sort
+// title case insensitive, using an offset and an item count
+func (s *SqlBackend) LimitAlbumsInLibraries(IDs []int, offset int, count int) ([]Album, error) {
+	cond, args := inLibraries("songs.library_id", IDs)
+	return s.albumQuery(
+		`SELECT 
+			albums.*,
+			artists.title AS artist 
+		FROM albums
+		JOIN artists ON albums.artist_id = artists.id 
+		WHERE albums.id IN (SELECT album_id FROM songs WHERE `+cond+`)
+		ORDER BY `+sortBy("albums")+` ASC
+		LIMIT ?, ?;`, 
+		append(args, offset, count)...,
+	)
+}
+
+// RandomAlbumsInLibraries loads a slice of 'n' random Album structs with songs
+// in the given libraries, or in every library when IDs is nil
+func (s *SqlBackend) RandomAlbumsInLibraries(IDs []int, n int) ([]Album, error) {
+	cond, args := inLibraries("songs.library_id", IDs)
+	return s.albumQuery(
+		`SELECT
+			albums.*,
+			artists.title AS artist
+		FROM albums
+		JOIN artists ON albums.artist_id = artists.id
+		WHERE albums.id IN (SELECT album_id FROM songs WHERE `+cond+`)
+		ORDER BY RANDOM() LIMIT ?;`,
+		append(args, n)...,
+	)
+}
+
+// AlbumTotals holds the number of songs on an album and their total length
+type AlbumTotals struct {
+	AlbumID int `db:"album_id"`
+	Songs   int `db:"songs"`
+	Length  int `db:"length"`
+}
+
+// TotalsForAlbums loads the song count and total length for each of the given
+// album IDs, keyed by album ID
+func (s *SqlBackend) TotalsForAlbums(IDs []int) (map[int]AlbumTotals, error) {
+	totals := make(map[int]AlbumTotals)
+	err := s.inQuery(
+		`SELECT 
+			album_id,
+			COUNT(*) AS songs,
+			SUM(length) AS length
+		FROM songs
+		WHERE album_id IN (?)
+		GROUP BY album_id;`,
+		IDs,
+		func(rows *sqlx.Rows) error {
+			t := AlbumTotals{}
+			if err := rows.StructScan(&t); err != nil {
+				return err
+			}
+			totals[t.AlbumID] = t
+			return nil
+		},
+	)
+	return totals, err
+}
+
+// AlbumsForArtist loads a slice of all Album structs with matching artist ID,
+// ordered by year and then by sort title
 func (s *SqlBackend) AlbumsForArtist(ID int) ([]Album, error) {
 	return s.albumQuery(
 		`SELECT 
@@ -82,22 +150,29 @@ func (s *SqlBackend) AlbumsForArtist(ID int) ([]Album, error) {
 			artists.title AS artist 
 		FROM albums
 		JOIN artists ON albums.artist_id = artists.id 
-		WHERE albums.artist_id = ?;`,
+		WHERE albums.artist_id = ?
+		ORDER BY albums.year, `+sortBy("albums")+`;`,
 		ID,
 	)
 }
 
-// SearchAlbums loads a slice of all Album structs from the database which contain
-// titles that match the specified search query
-func (s *SqlBackend) SearchAlbums(query string) ([]Album, error) {
+// AlbumAppearancesForArtist loads a slice of the Album structs of other
+// artists with songs crediting the artist with the matching ID
+func (s *SqlBackend) AlbumAppearancesForArtist(ID int) ([]Album, error) {
 	return s.albumQuery(
 		`SELECT 
 			albums.*,
 			artists.title AS artist 
 		FROM albums
 		JOIN artists ON albums.artist_id = artists.id 
-		WHERE albums.normalized_title LIKE ?;`, 
-		"%"+query+"%",
+		WHERE albums.artist_id != ? AND albums.id IN (
+			SELECT songs.album_id
+			FROM song_artists
+			JOIN songs ON song_artists.song_id = songs.id
+			WHERE song_artists.artist_id = ?
+		)
+		ORDER BY albums.year, `+sortBy("albums")+`;`,
+		ID, ID,
 	)
 }
 
@@ -168,14 +243,16 @@ func (s *SqlBackend) SaveAlbum(a *Album) error {
 		(
 			art_id, mb_id, discogs_id,
 			metadata_id, artist_id, folder_id,
-			title, normalized_title, year
+			title, normalized_title, sort_title,
+			year, compilation
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
 	tx := s.db.MustBegin()
 	tx.MustExec(query, 
 		a.ArtID, a.MBID, a.DiscogsID, 
 		a.MetadataID, a.ArtistID,	a.FolderID, 
-		a.Title, a.NormalizedTitle, a.Year);
+		a.Title, a.NormalizedTitle, a.SortTitle,
+		a.Year, a.Compilation);
 	
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
@@ -204,7 +281,9 @@ func (s *SqlBackend) UpdateAlbum(a *Album) error {
 			folder_id = ?,
 			title = ?,
 			normalized_title = ?,
-			year = ?
+			sort_title = ?,
+			year = ?,
+			compilation = ?
 		WHERE id = ?;`
 	tx := s.db.MustBegin()
 	tx.Exec(
@@ -217,7 +296,9 @@ func (s *SqlBackend) UpdateAlbum(a *Album) error {
 		a.FolderID, 
 		a.Title, 
 		a.NormalizedTitle, 
+		a.SortTitle,
 		a.Year,
+		a.Compilation,
 		a.ID,
 	)
 	return tx.Commit()
@@ -264,6 +345,18 @@ func (s *SqlBackend) PurgeOrphanAlbums() (int, error) {
 	return total, tx.Commit()
 }
 
+// AlbumsWithoutArt loads a slice of the Album structs which have no art
+func (s *SqlBackend) AlbumsWithoutArt() ([]Album, error) {
+	return s.albumQuery(
+		`SELECT
+			albums.*,
+			artists.title AS artist
+		FROM albums
+		JOIN artists ON albums.artist_id = artists.id
+		WHERE albums.art_id = 0;`,
+	)
+}
+
 // AlbumsWithErroredThirdPartyId returns a list of Albums where any id used by 
 // a third party is "errored" or non-unique.
 // Currently, the only Third Party APIs being used are
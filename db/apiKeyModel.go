@@ -0,0 +1,48 @@
+package db
+
+import (
+	"time"
+
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// APIKey is a long lived credential issued to a single client of a user, for
+// clients which cannot log in interactively
+type APIKey struct {
+	ID      int    `json:"id"`
+	UserID  int    `db:"user_id" json:"userId"`
+	Client  string `json:"client"`
+	Created int64  `json:"created"`
+	Key     string `json:"key"`
+}
+
+// NewAPIKey creates a new APIKey for a user's client with a random key. The
+// key is not saved.
+func NewAPIKey(u *User, client string) (*APIKey, error) {
+	key, err := util.RandomKey(32)
+	if err != nil {
+		return nil, err
+	}
+
+	return &APIKey{
+		UserID:  u.ID,
+		Client:  client,
+		Created: time.Now().Unix(),
+		Key:     key,
+	}, nil
+}
+
+// Delete removes an existing APIKey from the database
+func (k *APIKey) Delete() error {
+	return DB.DeleteAPIKey(k)
+}
+
+// Load pulls an existing APIKey from the database
+func (k *APIKey) Load() error {
+	return DB.LoadAPIKey(k)
+}
+
+// Save creates a new APIKey in the database
+func (k *APIKey) Save() error {
+	return DB.SaveAPIKey(k)
+}
@@ -0,0 +1,99 @@
+package db
+
+import (
+	"database/sql"
+)
+
+// apiKeyQuery loads a slice of APIKey structs matching the input query
+func (s *SqlBackend) apiKeyQuery(query string, args ...interface{}) ([]APIKey, error) {
+	// Perform input query with arguments
+	rows, err := s.db.Queryx(query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate all rows
+	keys := make([]APIKey, 0)
+	k := APIKey{}
+	for rows.Next() {
+		// Scan key into struct
+		if err := rows.StructScan(&k); err != nil {
+			return nil, err
+		}
+
+		// Append to list
+		keys = append(keys, k)
+	}
+
+	// Error check rows
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// APIKeysForUser loads a slice of all APIKey structs belonging to a user
+func (s *SqlBackend) APIKeysForUser(userID int) ([]APIKey, error) {
+	return s.apiKeyQuery("SELECT * FROM api_keys WHERE user_id = ? ORDER BY id;", userID)
+}
+
+// DeleteAPIKey removes an APIKey from the database
+func (s *SqlBackend) DeleteAPIKey(k *APIKey) error {
+	// Attempt to delete this key by its ID, if available
+	tx := s.db.MustBegin()
+	if k.ID != 0 {
+		tx.Exec("DELETE FROM api_keys WHERE id = ?;", k.ID)
+		return tx.Commit()
+	}
+
+	// Else, attempt to remove the key by its value
+	tx.Exec("DELETE FROM api_keys WHERE key = ?;", k.Key)
+	return tx.Commit()
+}
+
+// LoadAPIKey loads an APIKey from the database, populating the parameter
+// struct
+func (s *SqlBackend) LoadAPIKey(k *APIKey) error {
+	// Load the key via ID if available
+	if k.ID != 0 {
+		if err := s.db.Get(k, "SELECT * FROM api_keys WHERE id = ?;", k.ID);
+		err != nil {
+			return err
+		}
+		return nil
+	}
+
+	// Load via key
+	if err := s.db.Get(k, "SELECT * FROM api_keys WHERE key = ?;", k.Key);
+	err != nil {
+		return err
+	}
+	return nil
+}
+
+// SaveAPIKey attempts to save an APIKey to the database
+func (s *SqlBackend) SaveAPIKey(k *APIKey) error {
+	query := `INSERT INTO api_keys
+		(user_id, client, created, key)
+		VALUES (?, ?, ?, ?);`
+	tx := s.db.MustBegin()
+	if _, err := tx.Exec(query, k.UserID, k.Client, k.Created, k.Key); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Commit transaction
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// If no ID, reload to grab it
+	if k.ID == 0 {
+		if err := s.LoadAPIKey(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,17 +1,39 @@
 package db
 
 import (
+	"bytes"
 	"io"
 	"os"
 )
 
-// Art is folder, artist, or album art.
+// Sources of Art
+const (
+	// ArtFile is an image file found in a folder
+	ArtFile = "file"
+	// ArtEmbedded is a picture embedded in an audio file's tags
+	ArtEmbedded = "embedded"
+	// ArtDownloaded is an image fetched from a third party, kept in the art
+	// cache rather than the library
+	ArtDownloaded = "downloaded"
+)
+
+// Art is folder, artist, or album art. Embedded art's path is the audio file
+// holding the picture, and its hash identifies the picture, so songs sharing
+// a cover share one Art.
 type Art struct {
 	ID           int
 	FileSize		 int64 	`db:"file_size"`
 	FolderID     int    `db:"folder_id" json:"folderId"`
+	LibraryID    int    `db:"library_id" json:"libraryId"`
 	LastModified int64  `db:"last_modified"`
 	Path     		 string `db:"path"` 
+	Source       string `db:"source" json:"source"`
+	Hash         string `db:"hash" json:"-"`
+}
+
+// AccessibleBy reports whether a user may access the art
+func (a *Art) AccessibleBy(u *User) (bool, error) {
+	return DB.ArtAccessibleBy(a, u)
 }
 
 // Delete removes existing Art from the database
@@ -29,7 +51,24 @@ func (a *Art) Save() error {
 	return DB.SaveArt(a)
 }
 
-// Stream returns an art stream from the art file
+// Update changes existing Art in the database
+func (a *Art) Update() error {
+	return DB.UpdateArt(a)
+}
+
+// Stream returns an art stream from the art file, or from the picture
+// embedded in the audio file
 func (a Art) Stream() (io.ReadSeeker, error) {
-	return os.Open(a.Path)
-}
\ No newline at end of file
+	if a.Source != ArtEmbedded {
+		return os.Open(a.Path)
+	}
+
+	pic, err := ReadEmbeddedArt(a.Path)
+	if err != nil {
+		return nil, err
+	}
+	if pic == nil {
+		return nil, ErrNoEmbeddedArt
+	}
+	return bytes.NewReader(pic), nil
+}
@@ -49,6 +49,39 @@ func (s *SqlBackend) ArtNotInPath(path string) ([]Art, error) {
 	return s.artQuery("SELECT * FROM art WHERE path NOT LIKE ?;", path+"%")
 }
 
+// ArtOutsideLibrary loads a slice of all Art structs belonging to a library,
+// or to no library, which are NOT contained within the library's root.
+// Downloaded art is kept outside every library, so is never included.
+func (s *SqlBackend) ArtOutsideLibrary(l *Library) ([]Art, error) {
+	return s.artQuery(
+		`SELECT * FROM art
+		WHERE library_id IN (0, ?) AND path != ? AND path NOT LIKE ?
+		AND source != ?;`,
+		l.ID, l.Root, l.Root+"/%", ArtDownloaded,
+	)
+}
+
+// UnusedDownloadedArt loads the downloaded Art which no album or artist uses,
+// such as when they have since been given art from the library
+func (s *SqlBackend) UnusedDownloadedArt() ([]Art, error) {
+	return s.artQuery(
+		`SELECT * FROM art
+		WHERE source = ?
+		AND id NOT IN (SELECT art_id FROM albums)
+		AND id NOT IN (SELECT art_id FROM artists);`,
+		ArtDownloaded,
+	)
+}
+
+// ArtWithHash loads the embedded Art in a library whose picture has the
+// specified hash
+func (s *SqlBackend) ArtWithHash(libraryID int, hash string) ([]Art, error) {
+	return s.artQuery(
+		"SELECT * FROM art WHERE library_id = ? AND source = ? AND hash = ?;",
+		libraryID, ArtEmbedded, hash,
+	)
+}
+
 // CountArt fetches the total number of Art structs from the database
 func (s *SqlBackend) CountArt() (int64, error) {
 	return s.integerQuery("SELECT COUNT(*) AS int FROM art;")
@@ -60,8 +93,10 @@ func (s *SqlBackend) DeleteArt(a *Art) error {
 	tx := s.db.MustBegin()
 	tx.Exec("DELETE FROM art WHERE id = ?;", a.ID)
 
-	// Update any songs using this art ID to have a zero ID
+	// Update any songs, albums and artists using this art ID to have a zero ID
 	tx.Exec("UPDATE songs SET art_id = 0 WHERE art_id = ?;", a.ID)
+	tx.Exec("UPDATE albums SET art_id = 0 WHERE art_id = ?;", a.ID)
+	tx.Exec("UPDATE artists SET art_id = 0 WHERE art_id = ?;", a.ID)
 	return tx.Commit()
 }
 
@@ -86,11 +121,19 @@ func (s *SqlBackend) LoadArt(a *Art) error {
 
 // SaveArt attempts to save Art to the database
 func (s *SqlBackend) SaveArt(a *Art) error {
+	if a.Source == "" {
+		a.Source = ArtFile
+	}
+
 	// Insert new artist
 	query := "INSERT INTO art " +
-	"(path, file_size, last_modified) VALUES (?, ?, ?);"
+	"(path, file_size, last_modified, folder_id, library_id, source, hash) " +
+	"VALUES (?, ?, ?, ?, ?, ?, ?);"
 	tx := s.db.MustBegin()
-	tx.Exec(query, a.Path, a.FileSize, a.LastModified)
+	tx.Exec(
+		query, a.Path, a.FileSize, a.LastModified, a.FolderID, a.LibraryID,
+		a.Source, a.Hash,
+	)
 
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
@@ -104,4 +147,21 @@ func (s *SqlBackend) SaveArt(a *Art) error {
 		}
 	}
 	return nil
-}
\ No newline at end of file
+}
+// UpdateArt updates the file of existing Art in the database
+func (s *SqlBackend) UpdateArt(a *Art) error {
+	query := `UPDATE art
+		SET
+			path = ?, file_size = ?, last_modified = ?, folder_id = ?,
+			library_id = ?, hash = ?
+		WHERE id = ?;`
+	tx := s.db.MustBegin()
+	if _, err := tx.Exec(
+		query, a.Path, a.FileSize, a.LastModified, a.FolderID,
+		a.LibraryID, a.Hash, a.ID,
+	); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
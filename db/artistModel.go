@@ -14,6 +14,7 @@ type Artist struct {
 	FolderID 	      int 		`db:"folder_id" json:"folderId"`
 	Title 		      string 	`db:"title" json:"title"`
 	NormalizedTitle string  `db:"normalized_title" json:"normalizedTitle"`
+	SortTitle       string  `db:"sort_title" json:"sortTitle"`
 }
 
 // GetArtistFromSong creates a new Artist from a Song model, extracting its
@@ -23,6 +24,7 @@ func GetArtistFromSong(song *Song) *Artist {
 	return &Artist{
 		Title:           song.Artist,
 		NormalizedTitle: util.NormalizeString(song.Artist),
+		SortTitle:       song.ArtistSort,
 	}
 }
 
@@ -35,16 +37,26 @@ func (a *Artist) GetArt() (*Art, error) {
 	return art, nil
 }
 
-// GetMetadataObj returns a Metadata object based on the MetadataID from this
-// struct
-func (a *Artist) GetMetadataObj() (*Metadata, error) {
-	md := &Metadata{ID: a.MetadataID}
-	if err := md.Load(); err != nil {
+// GetMetadata returns the third party metadata stored for this artist. The
+// metadata is empty when none has been stored.
+func (a *Artist) GetMetadata() (*ArtistMetadata, error) {
+	md := new(ArtistMetadata)
+	if err := loadItemMetadata(KindArtist, a.ID, md); err != nil {
 		return nil, err
 	}
 	return md, nil
 }
 
+// SetMetadata stores the third party metadata of this artist
+func (a *Artist) SetMetadata(md *ArtistMetadata) error {
+	return saveItemMetadata(KindArtist, a.ID, md)
+}
+
+// AccessibleBy reports whether a user may access a library holding one of
+// the artist's songs
+func (a *Artist) AccessibleBy(u *User) (bool, error) {
+	return DB.ArtistAccessibleBy(a.ID, u)
+}
 
 // Delete removes an existing Artist from the database
 func (a *Artist) Delete() error {
@@ -2,6 +2,8 @@ package db
 
 import (
 	"database/sql"
+
+	"github.com/jmoiron/sqlx"
 )
 
 // artistQuery loads a slice of Artist structs matching the input query
@@ -39,9 +41,20 @@ func (s *SqlBackend) AllArtists() ([]Artist, error) {
 	return s.artistQuery("SELECT * FROM artists;")
 }
 
-// AllArtistsByTitle loads a slice of all Artist structs from the database, sorted alphabetically by title
+// AllArtistsByTitle loads a slice of all Artist structs from the database, sorted alphabetically by sort title
 func (s *SqlBackend) AllArtistsByTitle() ([]Artist, error) {
-	return s.artistQuery("SELECT * FROM artists ORDER BY title COLLATE NOCASE;")
+	return s.artistQuery("SELECT * FROM artists ORDER BY " + sortBy("artists") + ";")
+}
+
+// ArtistsWithoutArt loads a slice of the Artist structs which have no art
+func (s *SqlBackend) ArtistsWithoutArt() ([]Artist, error) {
+	return s.artistQuery("SELECT * FROM artists WHERE art_id = 0;")
+}
+
+// ArtistsWithoutSortTitle loads a slice of the Artist structs which have no
+// sort title
+func (s *SqlBackend) ArtistsWithoutSortTitle() ([]Artist, error) {
+	return s.artistQuery("SELECT * FROM artists WHERE sort_title = '';")
 }
 
 // LimitArtists loads a slice of Artist structs from the database using SQL limit, where the first parameter
@@ -50,11 +63,67 @@ func (s *SqlBackend) LimitArtists(offset int, count int) ([]Artist, error) {
 	return s.artistQuery("SELECT * FROM artists LIMIT ?, ?;", offset, count)
 }
 
-// SearchArtists loads a slice of all Artist structs from the database which contain
-// titles that match the specified search query
-func (s *SqlBackend) SearchArtists(query string) ([]Artist, error) {
-	return s.artistQuery("SELECT * FROM artists WHERE normalized_title LIKE ?;",
-		"%"+query+"%")
+// ArtistsInLibraries loads a slice of the Artist structs with songs, or
+// credits on songs, in the given libraries, or in every library when IDs is
+// nil, sorted alphabetically by sort title
+func (s *SqlBackend) ArtistsInLibraries(IDs []int) ([]Artist, error) {
+	cond, args := inLibraries("songs.library_id", IDs)
+	return s.artistQuery(
+		`SELECT * FROM artists
+		WHERE id IN (SELECT artist_id FROM songs WHERE `+cond+`)
+			OR id IN (
+				SELECT song_artists.artist_id
+				FROM song_artists
+				JOIN songs ON song_artists.song_id = songs.id
+				WHERE `+cond+`
+			)
+		ORDER BY `+sortBy("artists")+`;`,
+		append(args, args...)...,
+	)
+}
+
+// LimitArtistsInLibraries loads a slice of the Artist structs with songs, or
+// credits on songs, in the given libraries, or in every library when IDs is
+// nil, sorted alphabetically by sort title, using an offset and an item count
+func (s *SqlBackend) LimitArtistsInLibraries(IDs []int, offset int, count int) ([]Artist, error) {
+	cond, args := inLibraries("songs.library_id", IDs)
+	return s.artistQuery(
+		`SELECT * FROM artists
+		WHERE id IN (SELECT artist_id FROM songs WHERE `+cond+`)
+			OR id IN (
+				SELECT song_artists.artist_id
+				FROM song_artists
+				JOIN songs ON song_artists.song_id = songs.id
+				WHERE `+cond+`
+			)
+		ORDER BY `+sortBy("artists")+`
+		LIMIT ?, ?;`,
+		append(append(args, args...), offset, count)...,
+	)
+}
+
+// AlbumCountsForArtists loads the number of albums belonging to each of the
+// given artist IDs, keyed by artist ID
+func (s *SqlBackend) AlbumCountsForArtists(IDs []int) (map[int]int, error) {
+	counts := make(map[int]int)
+	err := s.inQuery(
+		`SELECT 
+			artist_id,
+			COUNT(*) AS albums
+		FROM albums
+		WHERE artist_id IN (?)
+		GROUP BY artist_id;`,
+		IDs,
+		func(rows *sqlx.Rows) error {
+			var artistID, albums int
+			if err := rows.Scan(&artistID, &albums); err != nil {
+				return err
+			}
+			counts[artistID] = albums
+			return nil
+		},
+	)
+	return counts, err
 }
 
 // CountArtists fetches the total number of Artist structs from the database
@@ -95,6 +164,12 @@ func (s *SqlBackend) LoadArtist(a *Artist) error {
 	return nil
 }
 
+// LoadArtistByNormalizedTitle loads the Artist whose normalized title
+// matches the parameter struct's, populating it
+func (s *SqlBackend) LoadArtistByNormalizedTitle(a *Artist) error {
+	return s.db.Get(a, "SELECT * FROM artists WHERE normalized_title = ?;", a.NormalizedTitle)
+}
+
 // SaveArtist attempts to save an Artist to the database
 func (s *SqlBackend) SaveArtist(a *Artist) error {
 	// Insert new artist
@@ -102,15 +177,15 @@ func (s *SqlBackend) SaveArtist(a *Artist) error {
 		(
 			art_id, mb_id, discogs_id, 
 			metadata_id, folder_id, title,
-			normalized_title
+			normalized_title, sort_title
 		)
-		VALUES (?, ?, ?, ?, ?, ?, ?);`
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?);`
 	tx := s.db.MustBegin()
 	tx.MustExec(
 		query,
 		a.ArtID, a.MBID, a.DiscogsID,
 		a.MetadataID, a.FolderID, a.Title,
-		a.NormalizedTitle)
+		a.NormalizedTitle, a.SortTitle)
 
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
@@ -136,23 +211,25 @@ func (s *SqlBackend) UpdateArtist(a *Artist) error {
 			art_id = ?,
 			folder_id = ?,
 			title = ?,
-			normalized_title = ?
+			normalized_title = ?,
+			sort_title = ?
 		WHERE id = ?;`
 	tx := s.db.MustBegin()
 	tx.Exec(
 		query, 
 		a.MBID, a.DiscogsID, a.MetadataID, a.ArtID,
-		a.FolderID, a.Title, a.NormalizedTitle, a.ID,
+		a.FolderID, a.Title, a.NormalizedTitle, a.SortTitle, a.ID,
 	)
 	return tx.Commit()
 }
 
 // PurgeOrphanArtists deletes all artists who are "orphaned", meaning that they no
-// longer have any songs which reference their ID
+// longer have any songs which reference their ID, or credit them
 func (s *SqlBackend) PurgeOrphanArtists() (int, error) {
 	// Select all artists without a song referencing their artist ID
 	rows, err := s.db.Queryx("SELECT artists.id FROM artists LEFT JOIN songs ON " +
-		"artists.id = songs.artist_id WHERE songs.artist_id IS NULL;")
+		"artists.id = songs.artist_id WHERE songs.artist_id IS NULL " +
+		"AND artists.id NOT IN (SELECT artist_id FROM song_artists);")
 	if err != nil && err != sql.ErrNoRows {
 		return -1, err
 	}
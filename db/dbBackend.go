@@ -16,6 +16,9 @@ import (
 type SqlBackend struct {
 	Path string
 	db   *sqlx.DB
+
+	// created is set when Setup created a new db file
+	created bool
 }
 
 // DB is the database backend.
@@ -64,6 +67,8 @@ func (s *SqlBackend) Setup()	error {
 
 	util.Logger.Print("DB: Setup: Executing Init Schema")
 	database.MustExec(initSchema)
+	database.Close()
+	s.created = true
 
 	// Close file
 	if err := dest.Close(); err != nil {
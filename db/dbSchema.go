@@ -1,5 +1,7 @@
 package db
 
+// dbSchema is the initial (version 0) schema. It must not change; schema
+// changes are made by migrations.
 const dbSchema = `
 PRAGMA foreign_keys = OFF;
 BEGIN TRANSACTION;
@@ -85,26 +87,404 @@ CREATE TABLE IF NOT EXISTS "metadata" (
 CREATE UNIQUE INDEX "metadata_unique_path" ON "metadata" ("path");
 COMMIT;`
 
-// /* sessions */
-// CREATE TABLE "sessions" (
-// 	"id"      INTEGER PRIMARY KEY AUTOINCREMENT,
-// 	"user_id" INTEGER NOT NULL,
-// 	"client"  TEXT,
-// 	"expire"  INTEGER NOT NULL,
-// 	"key"     TEXT
-// );
-// CREATE UNIQUE INDEX "sessions_unique_key" ON "sessions" ("key");
-// /* users */
-// CREATE TABLE "users" (
-// 	"id"           INTEGER PRIMARY KEY AUTOINCREMENT,
-// 	"username"     TEXT,
-// 	"password"     TEXT,
-// 	"role_id"      INTEGER,
-// 	"lastfm_token" TEXT
-// );
-// CREATE UNIQUE INDEX "users_unique_username" ON "users" ("username");
+// authSchema holds the tables used for user accounts, applied by migration 1.
+// The tables are created with IF NOT EXISTS as earlier versions created them
+// outside of the migrations.
+const authSchema = `
 
+/* users */
+CREATE TABLE IF NOT EXISTS "users" (
+	"id"           INTEGER PRIMARY KEY AUTOINCREMENT,
+	"username"     TEXT NOT NULL UNIQUE,
+	"password"     TEXT NOT NULL,
+	"role_id"      INTEGER NOT NULL,
+	"lastfm_token" TEXT NOT NULL DEFAULT ''
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "users_unique_username" ON "users" ("username");
+
+/* sessions */
+CREATE TABLE IF NOT EXISTS "sessions" (
+	"id"      INTEGER PRIMARY KEY AUTOINCREMENT,
+	"user_id" INTEGER NOT NULL,
+	"client"  TEXT NOT NULL,
+	"expire"  INTEGER NOT NULL,
+	"key"     TEXT NOT NULL UNIQUE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "sessions_unique_key" ON "sessions" ("key");
+
+/* api_keys */
+CREATE TABLE IF NOT EXISTS "api_keys" (
+	"id"      INTEGER PRIMARY KEY AUTOINCREMENT,
+	"user_id" INTEGER NOT NULL,
+	"client"  TEXT NOT NULL,
+	"created" INTEGER NOT NULL,
+	"key"     TEXT NOT NULL UNIQUE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "api_keys_unique_key" ON "api_keys" ("key");`
+
+// searchSchema creates the full text search index, applied by migration 3.
+// Every song, album, artist and folder has one row in the index, whose rowid
+// is the item's ID * 4 plus 0, 1, 2 or 3 respectively, so triggers are able
+// to find it without scanning the index.
+const searchSchema = `
+CREATE VIRTUAL TABLE "search_index" USING fts5(
+	"kind" UNINDEXED,
+	"item_id" UNINDEXED,
+	"title",
+	"artist",
+	"album",
+	"genre",
+	"year",
+	tokenize = 'unicode61 remove_diacritics 2'
+);
+
+/* Titles weigh more than artists, which weigh more than albums */
+INSERT INTO "search_index" ("search_index", "rank")
+	VALUES ('rank', 'bm25(0, 0, 10.0, 5.0, 3.0, 1.0, 1.0)');` + searchTriggerSchema
+
+// searchTableSchema creates the search index as a plain table, searched with
+// LIKE, when SQLite was built without FTS5 and so cannot apply searchSchema.
+const searchTableSchema = `
+CREATE TABLE "search_index" (
+	"kind"    TEXT NOT NULL,
+	"item_id" INTEGER NOT NULL,
+	"title"   TEXT,
+	"artist"  TEXT,
+	"album"   TEXT,
+	"genre"   TEXT,
+	"year"    INTEGER
+);
+CREATE INDEX "search_index_kind" ON "search_index" ("kind");` + searchTriggerSchema
+
+// searchTriggerSchema keeps the search index up to date with the library, and
+// indexes the existing library
+const searchTriggerSchema = `
+/* songs */
+CREATE TRIGGER "songs_search_insert" AFTER INSERT ON "songs" BEGIN
+	INSERT INTO "search_index" (rowid, kind, item_id, title, artist, album, genre, year)
+	VALUES (
+		NEW.id * 4, 'song', NEW.id, NEW.title,
+		(SELECT title FROM artists WHERE id = NEW.artist_id),
+		(SELECT title FROM albums WHERE id = NEW.album_id),
+		NEW.genre, NULLIF(NEW.year, 0)
+	);
+END;
+CREATE TRIGGER "songs_search_update" AFTER UPDATE ON "songs" BEGIN
+	DELETE FROM "search_index" WHERE rowid = OLD.id * 4;
+	INSERT INTO "search_index" (rowid, kind, item_id, title, artist, album, genre, year)
+	VALUES (
+		NEW.id * 4, 'song', NEW.id, NEW.title,
+		(SELECT title FROM artists WHERE id = NEW.artist_id),
+		(SELECT title FROM albums WHERE id = NEW.album_id),
+		NEW.genre, NULLIF(NEW.year, 0)
+	);
+END;
+CREATE TRIGGER "songs_search_delete" AFTER DELETE ON "songs" BEGIN
+	DELETE FROM "search_index" WHERE rowid = OLD.id * 4;
+END;
+
+/* albums */
+CREATE TRIGGER "albums_search_insert" AFTER INSERT ON "albums" BEGIN
+	INSERT INTO "search_index" (rowid, kind, item_id, title, artist, year)
+	VALUES (
+		NEW.id * 4 + 1, 'album', NEW.id, NEW.title,
+		(SELECT title FROM artists WHERE id = NEW.artist_id),
+		NULLIF(NEW.year, 0)
+	);
+END;
+CREATE TRIGGER "albums_search_update" AFTER UPDATE ON "albums" BEGIN
+	DELETE FROM "search_index" WHERE rowid = OLD.id * 4 + 1;
+	INSERT INTO "search_index" (rowid, kind, item_id, title, artist, year)
+	VALUES (
+		NEW.id * 4 + 1, 'album', NEW.id, NEW.title,
+		(SELECT title FROM artists WHERE id = NEW.artist_id),
+		NULLIF(NEW.year, 0)
+	);
+	UPDATE "search_index" SET album = NEW.title
+		WHERE rowid IN (SELECT id * 4 FROM songs WHERE album_id = NEW.id);
+END;
+CREATE TRIGGER "albums_search_delete" AFTER DELETE ON "albums" BEGIN
+	DELETE FROM "search_index" WHERE rowid = OLD.id * 4 + 1;
+END;
+
+/* artists */
+CREATE TRIGGER "artists_search_insert" AFTER INSERT ON "artists" BEGIN
+	INSERT INTO "search_index" (rowid, kind, item_id, title, artist)
+	VALUES (NEW.id * 4 + 2, 'artist', NEW.id, NEW.title, NEW.title);
+END;
+CREATE TRIGGER "artists_search_update" AFTER UPDATE OF "title" ON "artists" BEGIN
+	UPDATE "search_index" SET title = NEW.title, artist = NEW.title
+		WHERE rowid = NEW.id * 4 + 2;
+	UPDATE "search_index" SET artist = NEW.title
+		WHERE rowid IN (SELECT id * 4 FROM songs WHERE artist_id = NEW.id)
+		OR rowid IN (SELECT id * 4 + 1 FROM albums WHERE artist_id = NEW.id);
+END;
+CREATE TRIGGER "artists_search_delete" AFTER DELETE ON "artists" BEGIN
+	DELETE FROM "search_index" WHERE rowid = OLD.id * 4 + 2;
+END;
+
+/* folders */
+CREATE TRIGGER "folders_search_insert" AFTER INSERT ON "folders" BEGIN
+	INSERT INTO "search_index" (rowid, kind, item_id, title)
+	VALUES (NEW.id * 4 + 3, 'folder', NEW.id, NEW.title);
+END;
+CREATE TRIGGER "folders_search_update" AFTER UPDATE OF "title" ON "folders" BEGIN
+	UPDATE "search_index" SET title = NEW.title WHERE rowid = NEW.id * 4 + 3;
+END;
+CREATE TRIGGER "folders_search_delete" AFTER DELETE ON "folders" BEGIN
+	DELETE FROM "search_index" WHERE rowid = OLD.id * 4 + 3;
+END;
+
+/* Index the existing library */
+INSERT INTO "search_index" (rowid, kind, item_id, title, artist, album, genre, year)
+	SELECT
+		songs.id * 4, 'song', songs.id, songs.title,
+		artists.title, albums.title, songs.genre, NULLIF(songs.year, 0)
+	FROM songs
+	LEFT JOIN artists ON songs.artist_id = artists.id
+	LEFT JOIN albums ON songs.album_id = albums.id;
+INSERT INTO "search_index" (rowid, kind, item_id, title, artist, year)
+	SELECT
+		albums.id * 4 + 1, 'album', albums.id, albums.title,
+		artists.title, NULLIF(albums.year, 0)
+	FROM albums
+	LEFT JOIN artists ON albums.artist_id = artists.id;
+INSERT INTO "search_index" (rowid, kind, item_id, title, artist)
+	SELECT id * 4 + 2, 'artist', id, title, title FROM artists;
+INSERT INTO "search_index" (rowid, kind, item_id, title)
+	SELECT id * 4 + 3, 'folder', id, title FROM folders;`
+
+// playlistSchema creates the playlist tables, applied by migration 4.
+// Playlists imported from files keep the file's path, and belong to no user.
+const playlistSchema = `
+/* playlists */
+CREATE TABLE "playlists" (
+	"id"            INTEGER PRIMARY KEY AUTOINCREMENT,
+	"user_id"       INTEGER NOT NULL,
+	"name"          TEXT NOT NULL,
+	"comment"       TEXT NOT NULL DEFAULT '',
+	"path"          TEXT NOT NULL DEFAULT '',
+	"created"       INTEGER NOT NULL,
+	"last_modified" INTEGER NOT NULL
+);
+CREATE INDEX "playlists_user_id" ON "playlists" ("user_id");
+CREATE UNIQUE INDEX "playlists_unique_path" ON "playlists" ("path") WHERE "path" != '';
+
+/* playlist_items */
+CREATE TABLE "playlist_items" (
+	"id"          INTEGER PRIMARY KEY AUTOINCREMENT,
+	"playlist_id" INTEGER NOT NULL,
+	"song_id"     INTEGER NOT NULL,
+	"position"    INTEGER NOT NULL
+);
+CREATE INDEX "playlist_items_playlist_id_position" ON "playlist_items" ("playlist_id", "position");
+CREATE INDEX "playlist_items_song_id" ON "playlist_items" ("song_id");
+
+/* Songs removed from the library are removed from playlists */
+CREATE TRIGGER "songs_playlist_delete" AFTER DELETE ON "songs" BEGIN
+	DELETE FROM "playlist_items" WHERE song_id = OLD.id;
+END;`
+
+// playSchema creates the play history and the queue of plays waiting to be
+// forwarded to scrobbling services, applied by migration 5. Plays are kept
+// when their song is removed, but are only listed while the song exists.
+const playSchema = `
+ALTER TABLE "users" ADD COLUMN "listenbrainz_token" TEXT NOT NULL DEFAULT '';
+
+/* plays */
+CREATE TABLE "plays" (
+	"id"      INTEGER PRIMARY KEY AUTOINCREMENT,
+	"user_id" INTEGER NOT NULL,
+	"song_id" INTEGER NOT NULL,
+	"time"    INTEGER NOT NULL,
+	"client"  TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX "plays_user_id_time" ON "plays" ("user_id", "time");
+CREATE INDEX "plays_song_id" ON "plays" ("song_id");
+
+/* scrobbles */
+CREATE TABLE "scrobbles" (
+	"id"           INTEGER PRIMARY KEY AUTOINCREMENT,
+	"play_id"      INTEGER NOT NULL,
+	"service"      TEXT NOT NULL,
+	"attempts"     INTEGER NOT NULL DEFAULT 0,
+	"next_attempt" INTEGER NOT NULL DEFAULT 0,
+	"error"        TEXT NOT NULL DEFAULT ''
+);
+CREATE UNIQUE INDEX "scrobbles_unique_play_service" ON "scrobbles" ("play_id", "service");
+CREATE INDEX "scrobbles_service_next_attempt" ON "scrobbles" ("service", "next_attempt");`
+
+// librarySchema creates the media libraries and their access lists, applied
+// by migration 6. Libraries which are not shared are only visible to the users
+// on their access list. Folders, songs and art belong to the library they were
+// scanned from; items scanned before libraries existed belong to library 0
+// until a library is created over them.
+const librarySchema = `
+/* libraries */
+CREATE TABLE "libraries" (
+	"id"            INTEGER PRIMARY KEY AUTOINCREMENT,
+	"name"          TEXT NOT NULL,
+	"root"          TEXT NOT NULL UNIQUE,
+	"type"          TEXT NOT NULL DEFAULT 'music',
+	"scan_interval" INTEGER NOT NULL DEFAULT 0,
+	"last_scan"     INTEGER NOT NULL DEFAULT 0,
+	"shared"        INTEGER NOT NULL DEFAULT 1,
+	"created"       INTEGER NOT NULL
+);
+
+/* library_users */
+CREATE TABLE "library_users" (
+	"library_id" INTEGER NOT NULL,
+	"user_id"    INTEGER NOT NULL
+);
+CREATE UNIQUE INDEX "library_users_unique_library_id_user_id" ON "library_users" ("library_id", "user_id");
+CREATE INDEX "library_users_user_id" ON "library_users" ("user_id");
+
+ALTER TABLE "folders" ADD COLUMN "library_id" INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "songs" ADD COLUMN "library_id" INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "art" ADD COLUMN "library_id" INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX "folders_library_id" ON "folders" ("library_id");
+CREATE INDEX "songs_library_id" ON "songs" ("library_id");
+CREATE INDEX "art_library_id" ON "art" ("library_id");`
+
+// metadataSchema moves the third party metadata of artists and albums into the
+// database, applied by migration 7. Earlier versions wrote it to a file named
+// metadata in each media folder; those files are kept in metadata_files until
+// they are cleaned up. Metadata is removed along with its artist or album.
+const metadataSchema = `
+ALTER TABLE "metadata" RENAME TO "metadata_files";
+
+/* metadata */
+CREATE TABLE "metadata" (
+	"id"            INTEGER PRIMARY KEY AUTOINCREMENT,
+	"kind"          TEXT NOT NULL,
+	"item_id"       INTEGER NOT NULL,
+	"data"          TEXT NOT NULL,
+	"last_modified" INTEGER NOT NULL
+);
+CREATE UNIQUE INDEX "metadata_unique_kind_item_id" ON "metadata" ("kind", "item_id");
+
+CREATE TRIGGER "artists_metadata_delete" AFTER DELETE ON "artists" BEGIN
+	DELETE FROM "metadata" WHERE kind = 'artist' AND item_id = OLD.id;
+END;
+CREATE TRIGGER "albums_metadata_delete" AFTER DELETE ON "albums" BEGIN
+	DELETE FROM "metadata" WHERE kind = 'album' AND item_id = OLD.id;
+END;`
+
+// inodeSchema records the inode of each folder and song, applied by migration
+// 8, so folders and files moved within a library keep their IDs. Rows scanned
+// earlier have inode 0 until they are scanned again.
+const inodeSchema = `
+ALTER TABLE "folders" ADD COLUMN "inode" INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "songs" ADD COLUMN "inode" INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX "folders_library_id_inode" ON "folders" ("library_id", "inode");
+CREATE INDEX "songs_library_id_inode" ON "songs" ("library_id", "inode");`
+
+// fingerprintSchema records a fingerprint of each song's audio, applied by
+// migration 9, so songs copied to another filesystem and removed from their
+// old path keep their IDs. Songs scanned earlier have an empty fingerprint
+// until their next scan records it.
+const fingerprintSchema = `
+ALTER TABLE "songs" ADD COLUMN "fingerprint" TEXT NOT NULL DEFAULT '';
+CREATE INDEX "songs_library_id_fingerprint" ON "songs" ("library_id", "fingerprint");`
+
+// embeddedArtSchema records where art came from, applied by migration 10.
+// Embedded art is read from the tags of the audio file at its path when it is
+// served, and is found again by the hash of its picture. Songs record the
+// embedded art found in their tags.
+const embeddedArtSchema = `
+ALTER TABLE "art" ADD COLUMN "source" TEXT NOT NULL DEFAULT 'file';
+ALTER TABLE "art" ADD COLUMN "hash" TEXT NOT NULL DEFAULT '';
+ALTER TABLE "songs" ADD COLUMN "art_id" INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX "art_library_id_hash" ON "art" ("library_id", "hash");
+CREATE INDEX "songs_art_id" ON "songs" ("art_id");`
+
+// discSchema records the disc and track totals and disc subtitles read from
+// each song's tags, applied by migration 11. Every song's tags are read again
+// on the next scan to fill them in.
+const discSchema = `
+ALTER TABLE "songs" ADD COLUMN "disc_total" INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "songs" ADD COLUMN "track_total" INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "songs" ADD COLUMN "disc_subtitle" TEXT NOT NULL DEFAULT '';
+CREATE INDEX "songs_album_id_disc_track" ON "songs" ("album_id", "disc", "track");`
+
+// songArtistSchema credits every artist of a song, by role, applied by
+// migration 12. Songs keep the artist tag as their track artist, and albums
+// record whether they are compilations. Songs are credited to their album
+// artist until their tags are read again on the next scan.
+const songArtistSchema = `
+ALTER TABLE "songs" ADD COLUMN "track_artist" TEXT NOT NULL DEFAULT '';
+ALTER TABLE "albums" ADD COLUMN "compilation" INTEGER NOT NULL DEFAULT 0;
+
+/* song_artists */
+CREATE TABLE "song_artists" (
+	"song_id"   INTEGER NOT NULL,
+	"artist_id" INTEGER NOT NULL,
+	"role"      TEXT NOT NULL,
+	"position"  INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY ("song_id", "artist_id", "role")
+);
+CREATE INDEX "song_artists_artist_id_role" ON "song_artists" ("artist_id", "role");
+
+/* Songs removed from the library lose their credits */
+CREATE TRIGGER "songs_artists_delete" AFTER DELETE ON "songs" BEGIN
+	DELETE FROM "song_artists" WHERE song_id = OLD.id;
+END;
+
+INSERT INTO "song_artists" (song_id, artist_id, role)
+	SELECT id, artist_id, 'primary' FROM songs;`
+
+// genreSchema creates the genres and links songs to them, applied by migration
+// 13. A link's source is tag when the genre was read from the song's tags, or
+// metadata when a provider found it for the song's album. Songs are linked to
+// the genres in their tags on the next scan.
+const genreSchema = `
+/* genres */
+CREATE TABLE "genres" (
+	"id"   INTEGER PRIMARY KEY AUTOINCREMENT,
+	"name" TEXT NOT NULL COLLATE NOCASE
+);
+CREATE UNIQUE INDEX "genres_unique_name" ON "genres" ("name");
+
+/* song_genres */
+CREATE TABLE "song_genres" (
+	"song_id"  INTEGER NOT NULL,
+	"genre_id" INTEGER NOT NULL,
+	"source"   TEXT NOT NULL,
+	PRIMARY KEY ("song_id", "genre_id")
+);
+CREATE INDEX "song_genres_genre_id" ON "song_genres" ("genre_id");
+
+/* Songs removed from the library lose their genres */
+CREATE TRIGGER "songs_genres_delete" AFTER DELETE ON "songs" BEGIN
+	DELETE FROM "song_genres" WHERE song_id = OLD.id;
+END;`
+
+// sortTitleSchema adds the titles artists and albums are sorted by, applied by
+// migration 14. A sort title is empty until one is read from the sort tags on
+// the next scan, or found by a provider.
+const sortTitleSchema = `
+ALTER TABLE "artists" ADD COLUMN "sort_title" TEXT NOT NULL DEFAULT '';
+ALTER TABLE "albums" ADD COLUMN "sort_title" TEXT NOT NULL DEFAULT '';`
+
+// songAddedSchema records when each song was added to the library, applied by
+// migration 15. Songs already in the library are taken to have been added
+// when their file was last modified, or now when it is unknown, before
+// Migrate clears it for the migrations which rescan the library.
+const songAddedSchema = `
+ALTER TABLE "songs" ADD COLUMN "added" INTEGER NOT NULL DEFAULT 0;
+UPDATE "songs" SET "added" = CASE
+	WHEN "last_modified" > 0 THEN "last_modified"
+	ELSE CAST(strftime('%s', 'now') AS INTEGER)
+END;
+CREATE INDEX "songs_added" ON "songs" ("added");`
+
+// rescanSchema clears every song's last modified time, so its tags are read
+// again on the next scan. Migrate applies it once, after the migrations which
+// need it.
+const rescanSchema = `
+UPDATE "songs" SET "last_modified" = 0;`
 
 func getSchema() string {
 	return dbSchema
-}
\ No newline at end of file
+}
@@ -0,0 +1,418 @@
+package db
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// maxEmbeddedArt is the largest tag or picture read when looking for embedded
+// art, so a corrupt size cannot exhaust memory
+const maxEmbeddedArt = 32 << 20
+
+// frontCover is the picture type of a front cover in ID3 and FLAC tags
+const frontCover = 3
+
+var (
+	// ErrNoEmbeddedArt is returned when a file no longer contains the picture
+	// which was found in it
+	ErrNoEmbeddedArt = errors.New("art: no embedded picture found in file")
+)
+
+// ReadEmbeddedArt returns the cover picture embedded in an audio file's ID3v2
+// APIC frames, FLAC PICTURE blocks or MP4 covr atom. A front cover is
+// preferred over other pictures. Nil is returned when the file has none.
+func ReadEmbeddedArt(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+
+	var offset int64
+	header := make([]byte, 10)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// ID3v2 tags are found ahead of MP3s, and sometimes of other formats
+	if bytes.HasPrefix(header, []byte("ID3")) {
+		tagSize := int64(header[6])<<21 | int64(header[7])<<14 |
+			int64(header[8])<<7 | int64(header[9])
+		if tagSize <= maxEmbeddedArt && 10+tagSize <= size {
+			tag := make([]byte, tagSize)
+			if _, err := f.ReadAt(tag, 10); err != nil {
+				return nil, err
+			}
+			if pic := id3Picture(header, tag); pic != nil {
+				return pic, nil
+			}
+		}
+
+		offset = 10 + tagSize
+		if header[5]&0x10 != 0 {
+			offset += 10
+		}
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, err
+		}
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return flacPicture(f, offset+4, size)
+	case string(header[4:8]) == "ftyp":
+		return mp4Picture(f, offset, size)
+	}
+	return nil, nil
+}
+
+// id3Picture returns the cover found in the APIC (or v2.2 PIC) frames of an
+// ID3v2 tag, following its 10 byte header
+func id3Picture(header []byte, tag []byte) []byte {
+	version, flags := header[3], header[5]
+	if version < 2 || version > 4 {
+		return nil
+	}
+
+	// Before v2.4 unsynchronisation applies to the whole tag
+	if flags&0x80 != 0 && version < 4 {
+		tag = unsynchronise(tag)
+	}
+
+	pos := 0
+	if flags&0x40 != 0 && version > 2 && len(tag) >= 4 {
+		// The extended header's size leaves itself out in v2.3 only
+		if version == 3 {
+			pos = 4 + int(binary.BigEndian.Uint32(tag))
+		} else {
+			pos = int(syncsafe(tag[:4]))
+		}
+	}
+
+	idSize, headerSize := 4, 10
+	if version == 2 {
+		idSize, headerSize = 3, 6
+	}
+
+	var found []byte
+	for pos+headerSize <= len(tag) {
+		frame := tag[pos : pos+headerSize]
+		if frame[0] == 0 {
+			// Padding
+			break
+		}
+
+		id := string(frame[:idSize])
+		var frameSize int
+		switch version {
+		case 2:
+			frameSize = int(frame[3])<<16 | int(frame[4])<<8 | int(frame[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(frame[4:8]))
+		case 4:
+			frameSize = int(syncsafe(frame[4:8]))
+		}
+		pos += headerSize
+		if frameSize < 0 || pos+frameSize > len(tag) {
+			break
+		}
+		data := tag[pos : pos+frameSize]
+		pos += frameSize
+
+		if id != "APIC" && id != "PIC" {
+			continue
+		}
+		if version > 2 {
+			var ok bool
+			if data, ok = id3FrameData(version, frame[8:10], data); !ok {
+				continue
+			}
+		}
+
+		picType, pic := id3PictureFrame(version, data)
+		if pic == nil {
+			continue
+		}
+		if picType == frontCover {
+			return pic
+		}
+		if found == nil {
+			found = pic
+		}
+	}
+	return found
+}
+
+// id3FrameData undoes the grouping, unsynchronisation and compression of a
+// v2.3 or v2.4 frame. False is returned for encrypted frames.
+func id3FrameData(version byte, flags []byte, data []byte) ([]byte, bool) {
+	var grouped, compressed, encrypted, unsynced, hasLength bool
+	if version == 3 {
+		compressed = flags[1]&0x80 != 0
+		encrypted = flags[1]&0x40 != 0
+		grouped = flags[1]&0x20 != 0
+		// Compressed frames start with their decompressed size
+		hasLength = compressed
+	} else {
+		grouped = flags[1]&0x40 != 0
+		compressed = flags[1]&0x08 != 0
+		encrypted = flags[1]&0x04 != 0
+		unsynced = flags[1]&0x02 != 0
+		hasLength = flags[1]&0x01 != 0
+	}
+	if encrypted {
+		return nil, false
+	}
+
+	if grouped {
+		if len(data) < 1 {
+			return nil, false
+		}
+		data = data[1:]
+	}
+	if hasLength {
+		if len(data) < 4 {
+			return nil, false
+		}
+		data = data[4:]
+	}
+	if unsynced {
+		data = unsynchronise(data)
+	}
+	if compressed {
+		r, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, false
+		}
+		defer r.Close()
+		if data, err = ioutil.ReadAll(io.LimitReader(r, maxEmbeddedArt)); err != nil {
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+// id3PictureFrame returns the picture type and image of an APIC or PIC frame
+func id3PictureFrame(version byte, data []byte) (int, []byte) {
+	if len(data) < 2 {
+		return 0, nil
+	}
+	encoding := data[0]
+	pos := 1
+
+	if version == 2 {
+		// A three letter image format
+		pos += 3
+	} else {
+		// A null terminated MIME type
+		end := bytes.IndexByte(data[pos:], 0)
+		if end < 0 {
+			return 0, nil
+		}
+		pos += end + 1
+	}
+	if pos >= len(data) {
+		return 0, nil
+	}
+	picType := int(data[pos])
+	pos++
+
+	// The description is null terminated in its encoding, which is two bytes
+	// wide for UTF-16
+	if encoding == 1 || encoding == 2 {
+		for {
+			if pos+1 >= len(data) {
+				return 0, nil
+			}
+			if data[pos] == 0 && data[pos+1] == 0 {
+				pos += 2
+				break
+			}
+			pos += 2
+		}
+	} else {
+		end := bytes.IndexByte(data[pos:], 0)
+		if end < 0 {
+			return 0, nil
+		}
+		pos += end + 1
+	}
+
+	if pos >= len(data) {
+		return 0, nil
+	}
+	return picType, data[pos:]
+}
+
+// flacPicture returns the cover found in the PICTURE blocks among the FLAC
+// metadata blocks starting at offset
+func flacPicture(f io.ReaderAt, offset, size int64) ([]byte, error) {
+	var found []byte
+	header := make([]byte, 4)
+	for offset+4 <= size {
+		if _, err := f.ReadAt(header, offset); err != nil {
+			return nil, err
+		}
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		blockSize := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		offset += 4
+
+		if blockType == 6 && offset+blockSize <= size {
+			block := make([]byte, blockSize)
+			if _, err := f.ReadAt(block, offset); err != nil {
+				return nil, err
+			}
+			picType, pic := flacPictureBlock(block)
+			if pic != nil && picType == frontCover {
+				return pic, nil
+			}
+			if found == nil {
+				found = pic
+			}
+		}
+
+		offset += blockSize
+		if last {
+			break
+		}
+	}
+	return found, nil
+}
+
+// flacPictureBlock returns the picture type and image of a PICTURE block
+func flacPictureBlock(block []byte) (int, []byte) {
+	pos := 0
+	field := func() (uint32, bool) {
+		if pos+4 > len(block) {
+			return 0, false
+		}
+		v := binary.BigEndian.Uint32(block[pos:])
+		pos += 4
+		return v, true
+	}
+
+	picType, ok := field()
+	if !ok {
+		return 0, nil
+	}
+	// The MIME type and description are each preceded by their length
+	for i := 0; i < 2; i++ {
+		n, ok := field()
+		if !ok || uint64(pos)+uint64(n) > uint64(len(block)) {
+			return 0, nil
+		}
+		pos += int(n)
+	}
+	// Followed by the width, height, depth and colours
+	pos += 16
+	n, ok := field()
+	if !ok || n == 0 || uint64(pos)+uint64(n) > uint64(len(block)) {
+		return 0, nil
+	}
+	return int(picType), block[pos : pos+int(n)]
+}
+
+// mp4Picture returns the first image in the covr atom of an MP4 file, found at
+// moov.udta.meta.ilst.covr
+func mp4Picture(f io.ReaderAt, offset, size int64) ([]byte, error) {
+	start, end := offset, size
+	for _, name := range []string{"moov", "udta", "meta", "ilst", "covr"} {
+		var err error
+		start, end, err = mp4Atom(f, start, end, name)
+		if err != nil || start < 0 {
+			return nil, err
+		}
+		// meta is a full atom, with a version and flags ahead of its children
+		if name == "meta" {
+			start += 4
+		}
+	}
+
+	// covr holds data atoms, each with a type and locale ahead of the image
+	for start < end {
+		dataStart, dataEnd, err := mp4Atom(f, start, end, "data")
+		if err != nil || dataStart < 0 {
+			return nil, err
+		}
+		n := dataEnd - dataStart - 8
+		if n > 0 && n <= maxEmbeddedArt {
+			pic := make([]byte, n)
+			if _, err := f.ReadAt(pic, dataStart+8); err != nil {
+				return nil, err
+			}
+			return pic, nil
+		}
+		start = dataEnd
+	}
+	return nil, nil
+}
+
+// mp4Atom returns the bounds of the contents of the first atom with the given
+// name between start and end. A start of -1 is returned when there is none.
+func mp4Atom(f io.ReaderAt, start, end int64, name string) (int64, int64, error) {
+	header := make([]byte, 16)
+	for start+8 <= end {
+		if _, err := f.ReadAt(header[:8], start); err != nil {
+			return -1, -1, err
+		}
+		atomSize := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch atomSize {
+		case 0:
+			// The atom runs to the end of its parent
+			atomSize = end - start
+		case 1:
+			// A 64 bit size follows the name
+			if _, err := f.ReadAt(header[8:16], start+8); err != nil {
+				return -1, -1, err
+			}
+			atomSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if atomSize < headerSize || start+atomSize > end {
+			return -1, -1, nil
+		}
+
+		if string(header[4:8]) == name {
+			return start + headerSize, start + atomSize, nil
+		}
+		start += atomSize
+	}
+	return -1, -1, nil
+}
+
+// syncsafe decodes a 28 bit ID3v2 syncsafe integer
+func syncsafe(b []byte) int64 {
+	return int64(b[0]&0x7f)<<21 | int64(b[1]&0x7f)<<14 |
+		int64(b[2]&0x7f)<<7 | int64(b[3]&0x7f)
+}
+
+// unsynchronise removes the zero bytes ID3v2 inserts after 0xFF bytes
+func unsynchronise(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		out = append(out, b[i])
+		if b[i] == 0xff && i+1 < len(b) && b[i+1] == 0 {
+			i++
+		}
+	}
+	return out
+}
@@ -2,10 +2,12 @@ package db
 
 // Folder represents a filesystem folder
 type Folder struct	{
-	ID       int    `json:"id"`
-	ParentID int    `db:"parent_id" json:"parentId"`
-	Title    string `json:"title"`
-	Path		 string `json:"path"`
+	ID        int    `json:"id"`
+	ParentID  int    `db:"parent_id" json:"parentId"`
+	LibraryID int    `db:"library_id" json:"libraryId"`
+	Title     string `json:"title"`
+	Path      string `json:"path"`
+	Inode     int64  `json:"-"`
 }
 
 // SubFolders retrieves all folder with this folder as the parent
@@ -26,4 +28,9 @@ func (f *Folder) Load() error {
 // Save creates a new folder in the db
 func (f *Folder) Save() error {
 	return DB.SaveFolder(f)
+}
+
+// Update changes an existing folder in the db
+func (f *Folder) Update() error {
+	return DB.UpdateFolder(f)
 }
\ No newline at end of file
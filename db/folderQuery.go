@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"path"
 )
 
 // folderQuery loads a slice of Folder structs matching the input query
@@ -60,11 +61,21 @@ func (s *SqlBackend) FoldersNotInPath(path string) ([]Folder, error) {
 	return s.folderQuery("SELECT * FROM folders WHERE path NOT LIKE ?;", path+"%")
 }
 
-// SearchFolders loads a slice of all Folder structs from the database which contain
-// titles that match the specified search query
-func (s *SqlBackend) SearchFolders(query string) ([]Folder, error) {
+// FoldersOutsideLibrary loads a slice of all Folder structs belonging to a
+// library, or to no library, which are NOT contained within the library's root
+func (s *SqlBackend) FoldersOutsideLibrary(l *Library) ([]Folder, error) {
 	return s.folderQuery(
-		"SELECT * FROM folders WHERE title LIKE ?;", "%"+query+"%")
+		`SELECT * FROM folders
+		WHERE library_id IN (0, ?) AND path != ? AND path NOT LIKE ?;`,
+		l.ID, l.Root, l.Root+"/%",
+	)
+}
+
+// FoldersWithInode loads a slice of all Folder structs in a library with the
+// specified inode
+func (s *SqlBackend) FoldersWithInode(libraryID int, inode int64) ([]Folder, error) {
+	return s.folderQuery(
+		"SELECT * FROM folders WHERE library_id = ? AND inode = ?;", libraryID, inode)
 }
 
 // CountFolders fetches the total number of Folder structs from the database
@@ -108,9 +119,10 @@ func (s *SqlBackend) LoadFolder(f *Folder) error {
 // SaveFolder attempts to save an Folder to the database
 func (s *SqlBackend) SaveFolder(f *Folder) error {
 	// Insert new folder
-	query := "INSERT INTO folders (parent_id, title, path) VALUES (?, ?, ?);"
+	query := `INSERT INTO folders (parent_id, title, path, library_id, inode)
+		VALUES (?, ?, ?, ?, ?);`
 	tx := s.db.MustBegin()
-	tx.Exec(query, f.ParentID, f.Title, f.Path)
+	tx.Exec(query, f.ParentID, f.Title, f.Path, f.LibraryID, f.Inode)
 
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
@@ -124,4 +136,53 @@ func (s *SqlBackend) SaveFolder(f *Folder) error {
 		}
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// UpdateFolder attempts to update a Folder in the database
+func (s *SqlBackend) UpdateFolder(f *Folder) error {
+	query := `UPDATE folders
+		SET parent_id = ?, title = ?, path = ?, library_id = ?, inode = ?
+		WHERE id = ?;`
+	tx := s.db.MustBegin()
+	if _, err := tx.Exec(
+		query, f.ParentID, f.Title, f.Path, f.LibraryID, f.Inode, f.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// MoveFolder moves a Folder, along with everything scanned from within it, to
+// a new path in a single transaction. IDs are kept, so play history and
+// playlists are unaffected.
+func (s *SqlBackend) MoveFolder(f *Folder, newPath string, parentID int) error {
+	tx := s.db.MustBegin()
+	for _, table := range []string{"folders", "songs", "art", "playlists"} {
+		// Rewrite the old path prefix of the folder and its contents
+		if _, err := tx.Exec(
+			`UPDATE `+table+` SET path = ? || substr(path, length(?) + 1)
+			WHERE path = ? OR substr(path, 1, length(?)) = ?;`,
+			newPath, f.Path, f.Path, f.Path+"/", f.Path+"/",
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	title := path.Base(newPath)
+	if _, err := tx.Exec(
+		"UPDATE folders SET title = ?, parent_id = ? WHERE id = ?;",
+		title, parentID, f.ID,
+	); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	f.Path = newPath
+	f.Title = title
+	f.ParentID = parentID
+	return nil
+}
@@ -0,0 +1,32 @@
+package db
+
+import (
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// The sources of a song's genres
+const (
+	GenreTag      = "tag"
+	GenreMetadata = "metadata"
+)
+
+// Genre is a genre songs are linked to, along with the number of songs and
+// albums it has
+type Genre struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	SongCount  int    `db:"songs" json:"songCount"`
+	AlbumCount int    `db:"albums" json:"albumCount"`
+}
+
+// splitGenres splits a genre tag into its genres at any of the configured
+// genre separators
+func splitGenres(tag string) []string {
+	return splitTag(tag, util.Current().Tags.GenreSeparators)
+}
+
+// String is a method that returns a string with simple information about this
+// object.
+func (g Genre) String() string {
+	return g.Name
+}
@@ -0,0 +1,182 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// genreQuery loads a slice of Genre structs matching the input query
+func (s *SqlBackend) genreQuery(query string, args ...interface{}) ([]Genre, error) {
+	genres := make([]Genre, 0)
+	if err := s.db.Select(&genres, query, args...); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return genres, nil
+}
+
+// GenresInLibraries loads a slice of the Genre structs with songs in the given
+// libraries, or in every library when IDs is nil, along with the number of
+// those songs and their albums, sorted by name
+func (s *SqlBackend) GenresInLibraries(IDs []int) ([]Genre, error) {
+	cond, args := inLibraries("songs.library_id", IDs)
+	return s.genreQuery(
+		`SELECT
+			genres.id,
+			genres.name,
+			COUNT(DISTINCT songs.id) AS songs,
+			COUNT(DISTINCT songs.album_id) AS albums
+		FROM genres
+		JOIN song_genres ON song_genres.genre_id = genres.id
+		JOIN songs ON song_genres.song_id = songs.id
+		WHERE `+cond+`
+		GROUP BY genres.id
+		ORDER BY genres.name;`,
+		args...,
+	)
+}
+
+// GenreInLibraries loads the Genre with the matching ID along with the number
+// of its songs, and their albums, in the given libraries, or in every library
+// when IDs is nil. sql.ErrNoRows is returned when it has no songs in them.
+func (s *SqlBackend) GenreInLibraries(ID int, IDs []int) (Genre, error) {
+	cond, args := inLibraries("songs.library_id", IDs)
+	g := Genre{}
+	err := s.db.Get(&g,
+		`SELECT
+			genres.id,
+			genres.name,
+			COUNT(DISTINCT songs.id) AS songs,
+			COUNT(DISTINCT songs.album_id) AS albums
+		FROM genres
+		JOIN song_genres ON song_genres.genre_id = genres.id
+		JOIN songs ON song_genres.song_id = songs.id
+		WHERE genres.id = ? AND `+cond+`
+		GROUP BY genres.id;`,
+		append([]interface{}{ID}, args...)...,
+	)
+	return g, err
+}
+
+// GenreByName loads the Genre with the matching name, ignoring case, along
+// with the number of its songs and their albums
+func (s *SqlBackend) GenreByName(name string) (Genre, error) {
+	g := Genre{}
+	err := s.db.Get(&g,
+		`SELECT
+			genres.id,
+			genres.name,
+			COUNT(DISTINCT songs.id) AS songs,
+			COUNT(DISTINCT songs.album_id) AS albums
+		FROM genres
+		JOIN song_genres ON song_genres.genre_id = genres.id
+		JOIN songs ON song_genres.song_id = songs.id
+		WHERE genres.name = ?
+		GROUP BY genres.id;`,
+		name,
+	)
+	return g, err
+}
+
+// genreID returns the ID of the genre with the given name, ignoring case,
+// adding it within the transaction when it is new
+func genreID(tx *sqlx.Tx, name string) (int, error) {
+	if _, err := tx.Exec("INSERT OR IGNORE INTO genres (name) VALUES (?);", name); err != nil {
+		return 0, err
+	}
+	var ID int
+	err := tx.Get(&ID, "SELECT id FROM genres WHERE name = ?;", name)
+	return ID, err
+}
+
+// SaveSongGenres replaces the genres read from the tags of a batch of songs
+// with those in their Genres, in a single transaction. A genre read from the
+// tags replaces the same genre found in the metadata.
+func (s *SqlBackend) SaveSongGenres(songs []*Song) error {
+	tx := s.db.MustBegin()
+	for _, song := range songs {
+		if _, err := tx.Exec(
+			"DELETE FROM song_genres WHERE song_id = ? AND source = ?;", song.ID, GenreTag,
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		for _, name := range song.Genres {
+			ID, err := genreID(tx, name)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+			if _, err := tx.Exec(
+				`INSERT OR REPLACE INTO song_genres (song_id, genre_id, source)
+				VALUES (?, ?, ?);`,
+				song.ID, ID, GenreTag,
+			); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// SetAlbumGenres replaces the genres found in the metadata of the album with
+// the matching ID, linking each of its songs to them in a single transaction.
+// Songs already linked to a genre by their tags keep that link.
+func (s *SqlBackend) SetAlbumGenres(albumID int, names []string) error {
+	tx := s.db.MustBegin()
+	if _, err := tx.Exec(
+		`DELETE FROM song_genres
+		WHERE source = ? AND song_id IN (SELECT id FROM songs WHERE album_id = ?);`,
+		GenreMetadata, albumID,
+	); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, name := range names {
+		ID, err := genreID(tx, name)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(
+			`INSERT OR IGNORE INTO song_genres (song_id, genre_id, source)
+			SELECT id, ?, ? FROM songs WHERE album_id = ?;`,
+			ID, GenreMetadata, albumID,
+		); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ClearMetadataGenres removes every link to a genre found in the metadata
+func (s *SqlBackend) ClearMetadataGenres() error {
+	tx := s.db.MustBegin()
+	if _, err := tx.Exec("DELETE FROM song_genres WHERE source = ?;", GenreMetadata); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeOrphanGenres deletes all genres which no song is linked to, returning
+// how many were deleted
+func (s *SqlBackend) PurgeOrphanGenres() (int, error) {
+	tx := s.db.MustBegin()
+	res, err := tx.Exec(
+		"DELETE FROM genres WHERE id NOT IN (SELECT genre_id FROM song_genres);")
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+	total, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return -1, err
+	}
+	return int(total), tx.Commit()
+}
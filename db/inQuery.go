@@ -0,0 +1,47 @@
+package db
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// maxInIDs is the number of IDs bound to a single IN (?) list, which keeps
+// queries below SQLite's limit on the number of variables in a statement
+const maxInIDs = 500
+
+// inQuery runs a query with an IN (?) list once for each chunk of at most
+// maxInIDs of the given IDs, calling scan for every row
+func (s *SqlBackend) inQuery(query string, IDs []int, scan func(*sqlx.Rows) error) error {
+	for len(IDs) > 0 {
+		n := len(IDs)
+		if n > maxInIDs {
+			n = maxInIDs
+		}
+
+		if err := s.inQueryChunk(query, IDs[:n], scan); err != nil {
+			return err
+		}
+		IDs = IDs[n:]
+	}
+	return nil
+}
+
+// inQueryChunk runs a query with an IN (?) list for a single chunk of IDs
+func (s *SqlBackend) inQueryChunk(query string, IDs []int, scan func(*sqlx.Rows) error) error {
+	query, args, err := sqlx.In(query, IDs)
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.db.Queryx(s.db.Rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,112 @@
+package db
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// Library types
+const (
+	LibraryMusic      = "music"
+	LibraryAudiobooks = "audiobooks"
+	LibraryPodcasts   = "podcasts"
+)
+
+// LibraryTypes is the set of valid library types
+var LibraryTypes = map[string]bool{
+	LibraryMusic:      true,
+	LibraryAudiobooks: true,
+	LibraryPodcasts:   true,
+}
+
+// Library is a root folder of media. Each library is scanned and watched on
+// its own, and may be limited to the users on its access list.
+type Library struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	Root string `json:"root"`
+	Type string `json:"type"`
+	// ScanInterval is the number of seconds between full scans, or 0 to
+	// rely on the watcher after the first scan
+	ScanInterval int64 `db:"scan_interval" json:"scanInterval"`
+	LastScan     int64 `db:"last_scan" json:"lastScan"`
+	// Shared libraries are visible to every user, others only to admins and
+	// the users in UserIDs
+	Shared  bool  `json:"shared"`
+	Created int64 `json:"created"`
+	UserIDs []int `db:"-" json:"userIds"`
+}
+
+// NewLibrary creates a shared library of the given type at root
+func NewLibrary(name, root, libraryType string, scanInterval int64) *Library {
+	return &Library{
+		Name:         name,
+		Root:         path.Clean(root),
+		Type:         libraryType,
+		ScanInterval: scanInterval,
+		Shared:       true,
+		Created:      time.Now().Unix(),
+		UserIDs:      make([]int, 0),
+	}
+}
+
+// Contains reports whether a file or folder is within the library's root
+func (l *Library) Contains(p string) bool {
+	return p == l.Root || strings.HasPrefix(p, l.Root+"/")
+}
+
+// Overlaps reports whether either library's root contains the other's
+func (l *Library) Overlaps(o *Library) bool {
+	return l.Contains(o.Root) || o.Contains(l.Root)
+}
+
+// AccessibleBy reports whether a user may see the library
+func (l *Library) AccessibleBy(u *User) bool {
+	if l.Shared || u.HasRole(RoleAdmin) {
+		return true
+	}
+
+	for _, id := range l.UserIDs {
+		if id == u.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanDue reports whether the library should be scanned at time now. Libraries
+// which were never scanned are always due.
+func (l *Library) ScanDue(now int64) bool {
+	if l.LastScan == 0 {
+		return true
+	}
+	return l.ScanInterval > 0 && now-l.LastScan >= l.ScanInterval
+}
+
+// Delete removes an existing Library, along with everything scanned from it,
+// from the database
+func (l *Library) Delete() error {
+	return DB.DeleteLibrary(l)
+}
+
+// Load pulls an existing Library from the database
+func (l *Library) Load() error {
+	return DB.LoadLibrary(l)
+}
+
+// Save creates a new Library in the database
+func (l *Library) Save() error {
+	return DB.SaveLibrary(l)
+}
+
+// Update saves an existing Library in the database
+func (l *Library) Update() error {
+	return DB.UpdateLibrary(l)
+}
+
+// String is a method that returns a string with simple information about this
+// object.
+func (l Library) String() string {
+	return l.Name + " (" + l.Root + ")"
+}
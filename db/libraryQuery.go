@@ -0,0 +1,372 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// inLibraries returns a SQL condition limiting column, which holds a library
+// ID, to the given library IDs, along with its arguments. Nil IDs allow every
+// library, while an empty slice allows none.
+func inLibraries(column string, IDs []int) (string, []interface{}) {
+	if IDs == nil {
+		return "1", nil
+	}
+	if len(IDs) == 0 {
+		return "0", nil
+	}
+
+	args := make([]interface{}, 0, len(IDs))
+	for _, id := range IDs {
+		args = append(args, id)
+	}
+	return column + " IN (?" + strings.Repeat(", ?", len(IDs)-1) + ")", args
+}
+
+// libraryQuery loads a slice of Library structs matching the input query,
+// along with their access lists
+func (s *SqlBackend) libraryQuery(query string, args ...interface{}) ([]Library, error) {
+	// Perform input query with arguments
+	rows, err := s.db.Queryx(query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate all rows
+	libraries := make([]Library, 0)
+	for rows.Next() {
+		// Scan library into struct
+		l := Library{UserIDs: make([]int, 0)}
+		if err := rows.StructScan(&l); err != nil {
+			return nil, err
+		}
+
+		// Append to list
+		libraries = append(libraries, l)
+	}
+
+	// Error check rows
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	users, err := s.libraryUsers()
+	if err != nil {
+		return nil, err
+	}
+	for i := range libraries {
+		if IDs, ok := users[libraries[i].ID]; ok {
+			libraries[i].UserIDs = IDs
+		}
+	}
+	return libraries, nil
+}
+
+// libraryUsers loads the access list of every library, keyed by library ID
+func (s *SqlBackend) libraryUsers() (map[int][]int, error) {
+	rows, err := s.db.Queryx(
+		"SELECT library_id, user_id FROM library_users ORDER BY user_id;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make(map[int][]int)
+	for rows.Next() {
+		var libraryID, userID int
+		if err := rows.Scan(&libraryID, &userID); err != nil {
+			return nil, err
+		}
+		users[libraryID] = append(users[libraryID], userID)
+	}
+	return users, rows.Err()
+}
+
+// AllLibraries loads a slice of all Library structs from the database, ordered
+// by name case insensitive
+func (s *SqlBackend) AllLibraries() ([]Library, error) {
+	return s.libraryQuery("SELECT * FROM libraries ORDER BY name COLLATE NOCASE;")
+}
+
+// LibrariesForUser loads a slice of the Library structs a user may access
+func (s *SqlBackend) LibrariesForUser(u *User) ([]Library, error) {
+	all, err := s.AllLibraries()
+	if err != nil {
+		return nil, err
+	}
+
+	libraries := make([]Library, 0, len(all))
+	for _, l := range all {
+		if l.AccessibleBy(u) {
+			libraries = append(libraries, l)
+		}
+	}
+	return libraries, nil
+}
+
+// LibraryIDsForUser loads the IDs of the libraries a user may access. Nil is
+// returned for admins, who may access every library along with the songs in
+// none, which are only known to admins.
+func (s *SqlBackend) LibraryIDsForUser(u *User) ([]int, error) {
+	if u.HasRole(RoleAdmin) {
+		return nil, nil
+	}
+
+	libraries, err := s.LibrariesForUser(u)
+	if err != nil {
+		return nil, err
+	}
+	IDs := make([]int, 0, len(libraries))
+	for _, l := range libraries {
+		IDs = append(IDs, l.ID)
+	}
+	return IDs, nil
+}
+
+// LibraryForPath loads the Library whose root contains the given file or
+// folder. sql.ErrNoRows is returned when the path is in no library.
+func (s *SqlBackend) LibraryForPath(p string) (*Library, error) {
+	libraries, err := s.AllLibraries()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range libraries {
+		if libraries[i].Contains(p) {
+			return &libraries[i], nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// CountLibraries fetches the total number of Library structs from the database
+func (s *SqlBackend) CountLibraries() (int64, error) {
+	return s.integerQuery("SELECT COUNT(*) AS int FROM libraries;")
+}
+
+// DeleteLibrary removes a Library from the database, along with the folders,
+// songs and art scanned from it. Albums and artists left without songs are
+// removed as well.
+func (s *SqlBackend) DeleteLibrary(l *Library) error {
+	tx := s.db.MustBegin()
+	tx.Exec("DELETE FROM songs WHERE library_id = ?;", l.ID)
+	tx.Exec("DELETE FROM folders WHERE library_id = ?;", l.ID)
+	tx.Exec("DELETE FROM art WHERE library_id = ?;", l.ID)
+	tx.Exec("DELETE FROM library_users WHERE library_id = ?;", l.ID)
+	tx.Exec("DELETE FROM libraries WHERE id = ?;", l.ID)
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if _, err := s.PurgeOrphanAlbums(); err != nil {
+		return err
+	}
+	_, err := s.PurgeOrphanArtists()
+	return err
+}
+
+// LoadLibrary loads a Library from the database, populating the parameter
+// struct
+func (s *SqlBackend) LoadLibrary(l *Library) error {
+	// Load the library via ID if available
+	query, arg := "SELECT * FROM libraries WHERE id = ?;", interface{}(l.ID)
+	if l.ID == 0 {
+		// Load via root
+		query, arg = "SELECT * FROM libraries WHERE root = ?;", l.Root
+	}
+
+	libraries, err := s.libraryQuery(query, arg)
+	if err != nil {
+		return err
+	}
+	if len(libraries) == 0 {
+		return sql.ErrNoRows
+	}
+
+	*l = libraries[0]
+	return nil
+}
+
+// SaveLibrary attempts to save a Library to the database. Folders, songs and
+// art under its root which belong to no library are moved into it.
+func (s *SqlBackend) SaveLibrary(l *Library) error {
+	query := `INSERT INTO libraries
+		(name, root, type, scan_interval, last_scan, shared, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?);`
+	tx := s.db.MustBegin()
+	res, err := tx.Exec(query,
+		l.Name, l.Root, l.Type, l.ScanInterval, l.LastScan, l.Shared, l.Created)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	ID, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	l.ID = int(ID)
+
+	if err := saveLibraryUsers(tx, l); err != nil {
+		tx.Rollback()
+		return err
+	}
+	claimLibraryItems(tx, l)
+	return tx.Commit()
+}
+
+// UpdateLibrary updates an existing Library and its access list in the
+// database
+func (s *SqlBackend) UpdateLibrary(l *Library) error {
+	query := `UPDATE libraries
+		SET
+			name = ?,
+			root = ?,
+			type = ?,
+			scan_interval = ?,
+			last_scan = ?,
+			shared = ?
+		WHERE id = ?;`
+	tx := s.db.MustBegin()
+	if _, err := tx.Exec(query,
+		l.Name, l.Root, l.Type, l.ScanInterval, l.LastScan, l.Shared, l.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Exec("DELETE FROM library_users WHERE library_id = ?;", l.ID)
+	if err := saveLibraryUsers(tx, l); err != nil {
+		tx.Rollback()
+		return err
+	}
+	claimLibraryItems(tx, l)
+	return tx.Commit()
+}
+
+// UpdateLibraryScan records the time a full scan of a library was started
+func (s *SqlBackend) UpdateLibraryScan(l *Library, t int64) error {
+	l.LastScan = t
+	tx := s.db.MustBegin()
+	tx.Exec("UPDATE libraries SET last_scan = ? WHERE id = ?;", t, l.ID)
+	return tx.Commit()
+}
+
+// saveLibraryUsers inserts a library's access list
+func saveLibraryUsers(tx *sqlx.Tx, l *Library) error {
+	for _, userID := range l.UserIDs {
+		if _, err := tx.Exec(
+			"INSERT OR IGNORE INTO library_users (library_id, user_id) VALUES (?, ?);",
+			l.ID, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimLibraryItems moves the folders, songs and art under a library's root
+// which belong to no library into it, such as those scanned before libraries
+// existed
+func claimLibraryItems(tx *sqlx.Tx, l *Library) {
+	for _, table := range []string{"folders", "songs", "art"} {
+		tx.Exec("UPDATE "+table+" SET library_id = ? "+
+			"WHERE library_id = 0 AND (path = ? OR path LIKE ?);",
+			l.ID, l.Root, l.Root+"/%")
+	}
+}
+
+// LibraryAccessibleBy reports whether a user may access the library with the
+// given ID. Items in no library are only known to admins.
+func (s *SqlBackend) LibraryAccessibleBy(ID int, u *User) (bool, error) {
+	l := &Library{ID: ID}
+	if err := l.Load(); err != nil {
+		if err == sql.ErrNoRows {
+			return u.HasRole(RoleAdmin), nil
+		}
+		return false, err
+	}
+	return l.AccessibleBy(u), nil
+}
+
+// songsAccessibleBy reports whether a user may access the library of one of
+// the songs matching a condition, with its arguments. Admins may access every
+// item, including those without songs.
+func (s *SqlBackend) songsAccessibleBy(u *User, cond string, args ...interface{}) (bool, error) {
+	if u.HasRole(RoleAdmin) {
+		return true, nil
+	}
+
+	IDs, err := s.LibraryIDsForUser(u)
+	if err != nil {
+		return false, err
+	}
+	libraryCond, libraryArgs := inLibraries("songs.library_id", IDs)
+	n, err := s.integerQuery(
+		"SELECT COUNT(*) AS int FROM songs WHERE ("+cond+") AND "+libraryCond+";",
+		append(args, libraryArgs...)...)
+	return n > 0, err
+}
+
+// AlbumAccessibleBy reports whether a user may access the library of one of
+// the songs on the album with the given ID
+func (s *SqlBackend) AlbumAccessibleBy(ID int, u *User) (bool, error) {
+	return s.songsAccessibleBy(u, "songs.album_id = ?", ID)
+}
+
+// ArtistAccessibleBy reports whether a user may access the library of one of
+// the songs by, or crediting, the artist with the given ID
+func (s *SqlBackend) ArtistAccessibleBy(ID int, u *User) (bool, error) {
+	return s.songsAccessibleBy(u,
+		`songs.artist_id = ?
+		OR songs.id IN (SELECT song_id FROM song_artists WHERE artist_id = ?)`,
+		ID, ID)
+}
+
+// ArtAccessibleBy reports whether a user may access art. Art found in a
+// library belongs to it, while downloaded art may be accessed along with one
+// of the songs, albums or artists using it.
+func (s *SqlBackend) ArtAccessibleBy(a *Art, u *User) (bool, error) {
+	if a.LibraryID != 0 {
+		return s.LibraryAccessibleBy(a.LibraryID, u)
+	}
+
+	return s.songsAccessibleBy(u,
+		`songs.art_id = ?
+		OR songs.album_id IN (SELECT id FROM albums WHERE art_id = ?)
+		OR songs.artist_id IN (SELECT id FROM artists WHERE art_id = ?)
+		OR songs.id IN (
+			SELECT song_artists.song_id
+			FROM song_artists
+			JOIN artists ON song_artists.artist_id = artists.id
+			WHERE artists.art_id = ?
+		)`,
+		a.ID, a.ID, a.ID, a.ID)
+}
+
+// SongsAccessibleBy returns, in order, the songs whose library a user may
+// access
+func (s *SqlBackend) SongsAccessibleBy(songs []Song, u *User) ([]Song, error) {
+	if u.HasRole(RoleAdmin) {
+		return songs, nil
+	}
+
+	IDs, err := s.LibraryIDsForUser(u)
+	if err != nil {
+		return nil, err
+	}
+	accessible := make(map[int]bool, len(IDs))
+	for _, ID := range IDs {
+		accessible[ID] = true
+	}
+
+	filtered := make([]Song, 0, len(songs))
+	for _, song := range songs {
+		if accessible[song.LibraryID] {
+			filtered = append(filtered, song)
+		}
+	}
+	return filtered, nil
+}
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"errors"
+	"strings"
+)
+
+// The sorts of each list, the first of which is its default
+var (
+	ArtistSorts = []string{"title", "added"}
+	AlbumSorts  = []string{"title", "artist", "year", "added"}
+	SongSorts   = []string{"title", "artist", "album", "year", "added"}
+)
+
+var (
+	// ErrListSort is returned when a list is sorted by an unknown sort
+	ErrListSort = errors.New("db: unknown list sort")
+)
+
+// ListOptions page, sort and filter a list of artists, albums or songs. The
+// filters apply to songs, and artists and albums are listed when they have a
+// song which matches them. Zero values leave a filter unset.
+type ListOptions struct {
+	// Libraries limits the list to songs in the given libraries, or in every
+	// library when it is nil
+	Libraries []int
+	// Sort is one of the list's sorts, or its default when empty, and Desc
+	// reverses it
+	Sort string
+	Desc bool
+	// After is the ID of the last item of the previous page, which the list
+	// continues from, Offset the number of items skipped, and Count the number
+	// of items listed, or every item when it is zero
+	After  int
+	Offset int
+	Count  int
+
+	FromYear   int
+	ToYear     int
+	GenreID    int
+	FileTypes  []int
+	MinBitrate int
+	MaxBitrate int
+	// AddedSince is a UNIX time
+	AddedSince int64
+}
+
+// songFilter returns the SQL condition matching the songs which pass the
+// filters, along with its arguments
+func (o ListOptions) songFilter() (string, []interface{}) {
+	cond, args := inLibraries("songs.library_id", o.Libraries)
+	conds := []string{cond}
+	add := func(cond string, arg interface{}) {
+		conds = append(conds, cond)
+		args = append(args, arg)
+	}
+
+	if o.FromYear > 0 {
+		add("songs.year >= ?", o.FromYear)
+	}
+	if o.ToYear > 0 {
+		add("songs.year <= ?", o.ToYear)
+	}
+	if o.GenreID > 0 {
+		add("songs.id IN (SELECT song_id FROM song_genres WHERE genre_id = ?)", o.GenreID)
+	}
+	if len(o.FileTypes) > 0 {
+		conds = append(conds,
+			"songs.file_type_id IN (?"+strings.Repeat(", ?", len(o.FileTypes)-1)+")")
+		for _, t := range o.FileTypes {
+			args = append(args, t)
+		}
+	}
+	if o.MinBitrate > 0 {
+		add("songs.bitrate >= ?", o.MinBitrate)
+	}
+	if o.MaxBitrate > 0 {
+		add("songs.bitrate <= ?", o.MaxBitrate)
+	}
+	if o.AddedSince > 0 {
+		add("songs.added >= ?", o.AddedSince)
+	}
+	return strings.Join(conds, " AND "), args
+}
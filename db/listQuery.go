@@ -0,0 +1,177 @@
+package db
+
+import (
+	"strings"
+)
+
+// listTable describes how the rows of a table are listed
+type listTable struct {
+	table   string
+	columns string
+	from    string
+	// matches returns the condition matching the rows with a song matching
+	// the song filter, along with its arguments
+	matches func(filter string, args []interface{}) (string, []interface{})
+	// sort returns the expressions the rows are ordered by for a sort, and
+	// false when the sort is unknown
+	sort func(name string) ([]string, bool)
+}
+
+// artistList lists the artists with songs, or credits on songs
+var artistList = listTable{
+	table:   "artists",
+	columns: "artists.*",
+	from:    "artists",
+	matches: func(filter string, args []interface{}) (string, []interface{}) {
+		return `(artists.id IN (SELECT songs.artist_id FROM songs WHERE ` + filter + `)
+			OR artists.id IN (
+				SELECT song_artists.artist_id
+				FROM song_artists
+				JOIN songs ON song_artists.song_id = songs.id
+				WHERE ` + filter + `
+			))`, append(args, args...)
+	},
+	sort: func(name string) ([]string, bool) {
+		switch name {
+		case "", "title":
+			return []string{sortBy("artists")}, true
+		case "added":
+			return []string{
+				"(SELECT COALESCE(MIN(songs.added), 0) FROM songs WHERE songs.artist_id = artists.id)",
+			}, true
+		}
+		return nil, false
+	},
+}
+
+// albumList lists the albums with songs
+var albumList = listTable{
+	table: "albums",
+	columns: `albums.*,
+		artists.title AS artist`,
+	from: "albums JOIN artists ON albums.artist_id = artists.id",
+	matches: func(filter string, args []interface{}) (string, []interface{}) {
+		return "albums.id IN (SELECT songs.album_id FROM songs WHERE " + filter + ")", args
+	},
+	sort: func(name string) ([]string, bool) {
+		switch name {
+		case "", "title":
+			return []string{sortBy("albums")}, true
+		case "artist":
+			return []string{sortBy("artists"), "albums.year", sortBy("albums")}, true
+		case "year":
+			return []string{"albums.year", sortBy("albums")}, true
+		case "added":
+			return []string{
+				"(SELECT COALESCE(MIN(songs.added), 0) FROM songs WHERE songs.album_id = albums.id)",
+			}, true
+		}
+		return nil, false
+	},
+}
+
+// songList lists the songs
+var songList = listTable{
+	table: "songs",
+	columns: `songs.*,
+		artists.title AS artist,
+		albums.title AS album`,
+	from: `songs
+		JOIN artists ON songs.artist_id = artists.id
+		JOIN albums ON songs.album_id = albums.id`,
+	matches: func(filter string, args []interface{}) (string, []interface{}) {
+		return filter, args
+	},
+	sort: func(name string) ([]string, bool) {
+		switch name {
+		case "", "title":
+			return []string{"songs.title COLLATE NOCASE"}, true
+		case "artist":
+			return []string{
+				sortBy("artists"), sortBy("albums"), "songs.disc", "songs.track",
+			}, true
+		case "album":
+			return []string{sortBy("albums"), "songs.disc", "songs.track"}, true
+		case "year":
+			return []string{"songs.year", "songs.title COLLATE NOCASE"}, true
+		case "added":
+			return []string{"songs.added"}, true
+		}
+		return nil, false
+	},
+}
+
+// listQuery returns the query loading a page of the list of a table, along
+// with its arguments and the number of items in the whole list. Rows with the
+// same sort are ordered by ID, so that a page can continue after the row with
+// the ID in After by comparing with its sort expressions.
+func (s *SqlBackend) listQuery(t listTable, o ListOptions) (string, []interface{}, int, error) {
+	keys, ok := t.sort(o.Sort)
+	if !ok {
+		return "", nil, 0, ErrListSort
+	}
+	keys = append(keys, t.table+".id")
+
+	cond, args := t.matches(o.songFilter())
+	total, err := s.integerQuery(
+		"SELECT COUNT(*) AS int FROM "+t.from+" WHERE "+cond+";", args...)
+	if err != nil {
+		return "", nil, 0, err
+	}
+
+	order, cmp := " ASC", ">"
+	if o.Desc {
+		order, cmp = " DESC", "<"
+	}
+	if o.After > 0 {
+		row := strings.Join(keys, ", ")
+		cond += " AND (" + row + ") " + cmp + " (SELECT " + row + " FROM " + t.from +
+			" WHERE " + t.table + ".id = ?)"
+		args = append(args, o.After)
+	}
+
+	// A negative limit lists every row
+	count := o.Count
+	if count <= 0 {
+		count = -1
+	}
+	query := "SELECT " + t.columns + " FROM " + t.from +
+		" WHERE " + cond +
+		" ORDER BY " + strings.Join(keys, order+", ") + order +
+		" LIMIT ? OFFSET ?;"
+	return query, append(args, count, o.Offset), int(total), nil
+}
+
+// ListArtists loads a page of the Artist structs with songs, or credits on
+// songs, matching the options, along with the number of artists in the whole
+// list
+func (s *SqlBackend) ListArtists(o ListOptions) ([]Artist, int, error) {
+	query, args, total, err := s.listQuery(artistList, o)
+	if err != nil {
+		return nil, 0, err
+	}
+	artists, err := s.artistQuery(query, args...)
+	return artists, total, err
+}
+
+// ListAlbums loads a page of the Album structs with songs matching the
+// options, along with the number of albums in the whole list
+func (s *SqlBackend) ListAlbums(o ListOptions) ([]Album, int, error) {
+	query, args, total, err := s.listQuery(albumList, o)
+	if err != nil {
+		return nil, 0, err
+	}
+	albums, err := s.albumQuery(query, args...)
+	return albums, total, err
+}
+
+// ListSongs loads a page of the Song structs matching the options, along with
+// the number of songs in the whole list
+func (s *SqlBackend) ListSongs(o ListOptions) ([]Song, int, error) {
+	query, args, total, err := s.listQuery(songList, o)
+	if err != nil {
+		return nil, 0, err
+	}
+	songs, err := s.songQuery(query, args...)
+	return songs, total, err
+}
@@ -1,52 +1,94 @@
 package db
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
+	"time"
 )
 
-// Metadata represents a metadata file for an Artist or an Album
+// Metadata holds the third party metadata of an Artist or an Album, stored as
+// JSON in the database
 type Metadata struct {
-	ID 					 int    `json:"id"`
-	FolderID 		 int    `db:"folder_id" json:"folderId"`
-	FileSize     int64  `db:"file_size"`
-	LastModified int64  `db:"last_modified"`
-	Path 				 string `db:"path" json:"path"`
+	ID           int    `json:"id"`
+	Kind         string `json:"kind"`
+	ItemID       int    `db:"item_id" json:"itemId"`
+	Data         string `json:"data"`
+	LastModified int64  `db:"last_modified" json:"lastModified"`
 }
 
-// Save creates a new metadata object in the database
+// MetadataFile is a metadata file written into a media folder by an earlier
+// version. Its contents were imported into Metadata, and the file may be
+// deleted.
+type MetadataFile struct {
+	ID           int    `json:"id"`
+	FolderID     int    `db:"folder_id" json:"folderId"`
+	FileSize     int64  `db:"file_size" json:"fileSize"`
+	LastModified int64  `db:"last_modified" json:"lastModified"`
+	Path         string `json:"path"`
+}
+
+// Save stores the metadata, replacing any stored for the same item
 func (m *Metadata) Save() error {
 	return DB.SaveMetadata(m)
 }
 
-// Load pulls existing metadata from the db
+// Load pulls the metadata stored for an item from the db
 func (m *Metadata) Load() error {
 	return DB.LoadMetadata(m)
 }
 
-// Delete removes existing metadata from the db
+// Delete removes the metadata stored for an item from the db
 func (m *Metadata) Delete() error {
 	return DB.DeleteMetadata(m)
 }
 
-// ReadMetadata returns a map with the data found in the metadata file
-func (m *Metadata) ReadMetadata() ([]byte, error) {
-	return ioutil.ReadFile(m.Path)	
-} 
-
 // ToStruct takes in a pointer to either ArtistMetadata or AlbumMetadata
 // objects and attempts to unmarshal json into the object passed.
 func (m *Metadata) ToStruct(t interface{}) error {
-	mdBytes, e := m.ReadMetadata()
-	if e != nil {
-		return e
+	switch t.(type) {
+	case *ArtistMetadata, *AlbumMetadata:
+		return json.Unmarshal([]byte(m.Data), t)
+	default:
+		return fmt.Errorf("Unknown Object: %T", t)
 	}
+}
 
+// FromStruct takes in a pointer to either ArtistMetadata or AlbumMetadata
+// objects and marshals it into the metadata's json
+func (m *Metadata) FromStruct(t interface{}) error {
 	switch t.(type) {
 	case *ArtistMetadata, *AlbumMetadata:
-		return json.Unmarshal(mdBytes, t)
+		b, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		m.Data = string(b)
+		m.LastModified = time.Now().Unix()
+		return nil
 	default:
 		return fmt.Errorf("Unknown Object: %T", t)
-	}	
-}
\ No newline at end of file
+	}
+}
+
+// loadItemMetadata unmarshals the metadata stored for an item into t. T is
+// left unchanged when no metadata is stored.
+func loadItemMetadata(kind string, itemID int, t interface{}) error {
+	md := &Metadata{Kind: kind, ItemID: itemID}
+	if err := md.Load(); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	return md.ToStruct(t)
+}
+
+// saveItemMetadata stores t as the metadata of an item
+func saveItemMetadata(kind string, itemID int, t interface{}) error {
+	md := &Metadata{Kind: kind, ItemID: itemID}
+	if err := md.FromStruct(t); err != nil {
+		return err
+	}
+	return md.Save()
+}
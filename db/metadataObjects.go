@@ -8,8 +8,11 @@ type ArtistMetadata struct {
 	Discogs 		DiscogsMetadata     `json:"discogs,omitempty"`
 }
 
+// AlbumMetadata holds what each provider found of an album. Year is taken
+// from the highest priority provider to have one.
 type AlbumMetadata struct {
 	AlbumName    string              `json:"album_name"`
+	Year         int                 `json:"year,omitempty"`
 	MusicBrainz  MusicBrainzMetadata `json:"musicbrainz,omitempty"`
 	Discogs      DiscogsMetadata     `json:"discogs,omitempty"`
 }
@@ -99,13 +102,14 @@ type DiscogsTrack struct {
 }
 
 type DiscogsArtist struct {
-	ID          int      `json:"id"`
-	Name        string   `json:"name"`
-	Realname    string   `json:"real_name"`
-	ResourceURL string   `json:"resource_url"`
-	Role        string   `json:"role,omitempty"`
-	Tracks      string   `json:"tracks,omitempty"`
-	Members     []string `json:"members,omitempty"`
+	ID          int            `json:"id"`
+	Name        string         `json:"name"`
+	Realname    string         `json:"real_name"`
+	ResourceURL string         `json:"resource_url"`
+	Role        string         `json:"role,omitempty"`
+	Tracks      string         `json:"tracks,omitempty"`
+	Members     []string       `json:"members,omitempty"`
+	Images      []DiscogsImage `json:"images,omitempty"`
 }
 
 type DiscogsImage struct {
@@ -1,87 +1,50 @@
 package db
 
 import (
-	"database/sql"
-)
-
-// metadataQuery loads a slice of Metadata structs matching the input query
-func (s *SqlBackend) metadataQuery(query string, args ...interface{}) ([]Metadata, error) {
-	// Perform input query with arguments
-	rows, err := s.db.Queryx(query, args...)
-	if err != nil && err != sql.ErrNoRows {
-		return nil, err
-	}
-	defer rows.Close()
-
-	// Iterate all rows
-	meta := make([]Metadata, 0)
-	m := Metadata{}
-	for rows.Next() {
-		// Scan album into struct
-		if err := rows.StructScan(&m); err != nil {
-			return nil, err
-		}
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
 
-		// Append to list
-		meta = append(meta, m)
-	}
-
-	// Error check rows
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
+	"github.com/eHoward1996/aiomst/util"
 
-	return meta, nil
-}
+	"github.com/jmoiron/sqlx"
+)
 
-// LoadMetadata loads Metadata from the database, populating the parameter struct
+// LoadMetadata loads the Metadata stored for an item from the database,
+// populating the parameter struct
 func (s *SqlBackend) LoadMetadata(m *Metadata) error {
 	// Load metadata via ID if available
 	if m.ID != 0 {
-		if err := s.db.Get(m, "SELECT * FROM metadata WHERE id = ?;", m.ID); 
-		err != nil {
-			return err	
-		}
-		return nil
+		return s.db.Get(m, "SELECT * FROM metadata WHERE id = ?;", m.ID)
 	}
 
-	// Load via Path
-	if err := s.db.Get(m, "SELECT * FROM metadata WHERE path = ?;", m.Path);
-	err != nil {
-		return err
-	}
-	return nil
+	// Load via kind and item
+	return s.db.Get(m, "SELECT * FROM metadata WHERE kind = ? AND item_id = ?;",
+		m.Kind, m.ItemID)
 }
 
-
-// SaveMetadata attempts to save an Metadata to the database
+// SaveMetadata attempts to save Metadata to the database, replacing the
+// metadata already stored for the same item
 func (s *SqlBackend) SaveMetadata(m *Metadata) error {
-	// Insert new album
-	query := "INSERT INTO metadata " +
-		"(folder_id, file_size, last_modified, path) VALUES (?, ?, ?, ?);"
+	query := `INSERT INTO metadata (kind, item_id, data, last_modified)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (kind, item_id) DO UPDATE
+		SET data = excluded.data, last_modified = excluded.last_modified;`
 	tx := s.db.MustBegin()
-	tx.MustExec(query, m.FolderID, m.FileSize, m.LastModified, m.Path);
-	
-	// Commit transaction
-	if err := tx.Commit(); err != nil {
+	if _, err := tx.Exec(query, m.Kind, m.ItemID, m.Data, m.LastModified); err != nil {
+		tx.Rollback()
 		return err
 	}
 
-	// If no ID, reload to grab it
-	if m.ID == 0 {
-		if err := s.LoadMetadata(m); err != nil {
-			return err
-		}
+	// Commit transaction
+	if err := tx.Commit(); err != nil {
+		return err
 	}
-	return nil
-}
 
-// UpdateMetadata updates the Metadata object
-func (s *SqlBackend) UpdateMetadata(m *Metadata) error {
-	query := "UPDATE metadata SET folder_id = ?, path = ? WHERE id = ?;"
-	tx := s.db.MustBegin()
-	tx.Exec(query, m.FolderID, m.Path, m.ID)
-	return tx.Commit()
+	// Reload to grab the ID, which is unchanged when replacing
+	return s.db.Get(&m.ID, "SELECT id FROM metadata WHERE kind = ? AND item_id = ?;",
+		m.Kind, m.ItemID)
 }
 
 // DeleteMetadata removes Metadata from the database
@@ -93,7 +56,76 @@ func (s *SqlBackend) DeleteMetadata(m *Metadata) error {
 		return tx.Commit()
 	}
 
-	// Else, attempt to remove metadata by its path
-	tx.Exec("DELETE FROM metadata WHERE path = ?;", m.Path)
+	// Else, attempt to remove metadata by its item
+	tx.Exec("DELETE FROM metadata WHERE kind = ? AND item_id = ?;", m.Kind, m.ItemID)
+	return tx.Commit()
+}
+
+// AllMetadataFiles loads the metadata files earlier versions wrote into the
+// media folders, which have not been cleaned up yet
+func (s *SqlBackend) AllMetadataFiles() ([]MetadataFile, error) {
+	files := make([]MetadataFile, 0)
+	err := s.db.Select(&files, "SELECT * FROM metadata_files ORDER BY path;")
+	return files, err
+}
+
+// DeleteMetadataFile forgets a metadata file once it has been cleaned up
+func (s *SqlBackend) DeleteMetadataFile(f *MetadataFile) error {
+	tx := s.db.MustBegin()
+	tx.Exec("DELETE FROM metadata_files WHERE id = ?;", f.ID)
 	return tx.Commit()
-}
\ No newline at end of file
+}
+
+// importMetadataFiles copies the contents of the metadata files written by
+// earlier versions into the metadata table, for every artist and album which
+// referred to them. Files which are missing, empty or not JSON are skipped.
+func importMetadataFiles(tx *sqlx.Tx) error {
+	files := make([]MetadataFile, 0)
+	if err := tx.Select(&files, "SELECT * FROM metadata_files;"); err != nil {
+		return err
+	}
+
+	imported := 0
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f.Path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				util.Logger.Printf("DB: Migrate: Skipping metadata file %s: %v", f.Path, err)
+			}
+			continue
+		}
+		if b = bytes.TrimSpace(b); len(b) == 0 {
+			continue
+		}
+		if !json.Valid(b) {
+			util.Logger.Printf("DB: Migrate: Skipping metadata file %s: invalid JSON", f.Path)
+			continue
+		}
+
+		for _, item := range []struct{ kind, table string }{
+			{KindArtist, "artists"},
+			{KindAlbum, "albums"},
+		} {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO metadata
+				(kind, item_id, data, last_modified)
+				SELECT ?, id, ?, ? FROM `+item.table+` WHERE metadata_id = ?;`,
+				item.kind, string(b), f.LastModified, f.ID); err != nil {
+				return err
+			}
+		}
+		imported++
+	}
+
+	// Metadata is found by its item now
+	if _, err := tx.Exec("UPDATE artists SET metadata_id = 0;"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE albums SET metadata_id = 0;"); err != nil {
+		return err
+	}
+
+	if len(files) > 0 {
+		util.Logger.Printf("DB: Migrate: Imported %d of %d metadata files", imported, len(files))
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eHoward1996/aiomst/util"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// ErrSchemaTooNew is returned when the database was migrated by a newer
+	// version of aiomst than the one running
+	ErrSchemaTooNew = errors.New("db: database schema is newer than this version of aiomst")
+	// ErrNoFTS5 is returned when the search index was created with FTS5, but
+	// SQLite was built without it
+	ErrNoFTS5 = errors.New("db: search index needs FTS5, build aiomst with -tags sqlite_fts5")
+)
+
+// Migration is a single, ordered change to the database schema. Up is run in
+// a transaction, which also records the new schema version. Migrations which
+// need every song's tags read again set Rescan, instead of clearing
+// last_modified themselves, so an upgrade across several of them rescans the
+// library once, and later migrations still see when songs were last modified.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *sqlx.Tx) error
+	Rescan      bool
+}
+
+// execMigration returns an Up function which executes a SQL script
+func execMigration(script string) func(tx *sqlx.Tx) error {
+	return func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(script)
+		return err
+	}
+}
+
+// migrations upgrade the schema created by dbSchema to the current version.
+// Versions must be consecutive, starting at 1, and released migrations must
+// never be changed; add a new one instead.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "Add users, sessions and API keys",
+		Up:          execMigration(authSchema),
+	},
+	{
+		Version:     2,
+		Description: "Add songs.disc and art.folder_id",
+		Up: execMigration(`
+			ALTER TABLE "songs" ADD COLUMN "disc" INTEGER NOT NULL DEFAULT 1;
+			ALTER TABLE "art" ADD COLUMN "folder_id" INTEGER NOT NULL DEFAULT 0;`),
+	},
+	{
+		Version:     3,
+		Description: "Add full text search index",
+		Up: func(tx *sqlx.Tx) error {
+			if _, err := tx.Exec(searchSchema); err != nil {
+				if strings.Contains(err.Error(), "no such module: fts5") {
+					return ErrNoFTS5
+				}
+				return err
+			}
+			return nil
+		},
+	},
+	{
+		Version:     4,
+		Description: "Add playlists",
+		Up:          execMigration(playlistSchema),
+	},
+	{
+		Version:     5,
+		Description: "Add play history and scrobble queue",
+		Up:          execMigration(playSchema),
+	},
+	{
+		Version:     6,
+		Description: "Add libraries",
+		Up:          execMigration(librarySchema),
+	},
+	{
+		Version:     7,
+		Description: "Move metadata files into the database",
+		Up: func(tx *sqlx.Tx) error {
+			if _, err := tx.Exec(metadataSchema); err != nil {
+				return err
+			}
+			return importMetadataFiles(tx)
+		},
+	},
+	{
+		Version:     8,
+		Description: "Add inodes to folders and songs",
+		Up:          execMigration(inodeSchema),
+	},
+	{
+		Version:     9,
+		Description: "Add songs.fingerprint",
+		Up:          execMigration(fingerprintSchema),
+	},
+	{
+		Version:     10,
+		Description: "Add embedded art",
+		Up:          execMigration(embeddedArtSchema),
+	},
+	{
+		Version:     11,
+		Description: "Add disc and track totals and disc subtitles",
+		Up:          execMigration(discSchema),
+		Rescan:      true,
+	},
+	{
+		Version:     12,
+		Description: "Add song artists and compilations",
+		Up:          execMigration(songArtistSchema),
+		Rescan:      true,
+	},
+	{
+		Version:     13,
+		Description: "Add genres",
+		Up:          execMigration(genreSchema),
+		Rescan:      true,
+	},
+	{
+		Version:     14,
+		Description: "Add artist and album sort titles",
+		Up:          execMigration(sortTitleSchema),
+		Rescan:      true,
+	},
+	{
+		Version:     15,
+		Description: "Add songs.added",
+		Up:          execMigration(songAddedSchema),
+	},
+}
+
+// likeSearchMigration replaces migration 3 when SQLite was built without FTS5,
+// and so could never apply it, creating a search index searched with LIKE
+var likeSearchMigration = Migration{
+	Version:     3,
+	Description: "Add search index without FTS5",
+	Up:          execMigration(searchTableSchema),
+}
+
+// SchemaVersion returns the schema version recorded in the database
+func (s *SqlBackend) SchemaVersion() (int, error) {
+	var version int
+	if err := s.db.Get(&version, "PRAGMA user_version;"); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// LatestSchemaVersion returns the version the database is at after all
+// migrations have been applied
+func LatestSchemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// Migrate applies every migration newer than the database's schema version.
+// Unless the database was just created by Setup, it is backed up first.
+func (s *SqlBackend) Migrate() error {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	latest := LatestSchemaVersion()
+	if version > latest {
+		return ErrSchemaTooNew
+	}
+
+	// Every change to the library updates a full text search index
+	fts5 := hasFTS5(s.db)
+	fullText, err := fullTextIndex(s.db)
+	if err != nil {
+		return err
+	}
+	if fullText && !fts5 {
+		return ErrNoFTS5
+	}
+	if version == latest {
+		util.Logger.Printf("DB: Migrate: Schema is up to date (version %d)", version)
+		return nil
+	}
+
+	if !s.created {
+		backup, err := s.Backup(fmt.Sprintf("v%d", version))
+		if err != nil {
+			return fmt.Errorf("db: backup before migration failed: %s", err)
+		}
+		util.Logger.Print("DB: Migrate: Backed up database to ", backup)
+	}
+
+	pending := make([]Migration, 0)
+	rescan := false
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		if m.Version == likeSearchMigration.Version && !fts5 {
+			util.Logger.Print("DB: Migrate: SQLite lacks FTS5, searching with LIKE instead")
+			m = likeSearchMigration
+		}
+		pending = append(pending, m)
+		rescan = rescan || m.Rescan
+	}
+
+	// The library is rescanned by the last migration, after every other
+	// migration has seen when songs were last modified
+	for i, m := range pending {
+		last := i == len(pending)-1
+		util.Logger.Printf("DB: Migrate: Applying migration %d: %s", m.Version, m.Description)
+		if err := s.applyMigration(m, rescan && last); err != nil {
+			// Migrations already applied still need their rescan
+			for _, applied := range pending[:i] {
+				if applied.Rescan {
+					s.db.Exec(rescanSchema)
+					break
+				}
+			}
+			return fmt.Errorf("db: migration %d failed: %s", m.Version, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs a single migration, rescans the library if asked to,
+// and records its version in one transaction, so a failed migration leaves the
+// database untouched
+func (s *SqlBackend) applyMigration(m Migration, rescan bool) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if err := m.Up(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if rescan {
+		if _, err := tx.Exec(rescanSchema); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// PRAGMA does not accept bound parameters
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d;", m.Version)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Backup writes a consistent copy of the database next to the database file
+// and returns its path. The label is included in the file name.
+func (s *SqlBackend) Backup(label string) (string, error) {
+	dest := fmt.Sprintf("%s.%s-%s.bak", s.Path, label, time.Now().Format("20060102150405"))
+	if _, err := s.db.Exec("VACUUM INTO ?;", dest); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
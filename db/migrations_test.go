@@ -0,0 +1,305 @@
+package db
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/eHoward1996/aiomst/util"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// baselineDB writes a database with the initial schema, holding one song with
+// its artist, album, folder, art and metadata file, and returns its path
+func baselineDB(t *testing.T) string {
+	util.InitializeLogger()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "aiomst.db")
+	metadata := filepath.Join(dir, "metadata")
+	if err := ioutil.WriteFile(metadata, []byte(`{"name": "Pink Floyd"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := sqlx.Connect("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	d.MustExec(getSchema())
+	d.MustExec(`INSERT INTO folders (id, parent_id, title, path) VALUES (1, 0, 'Pink Floyd', ?);`, dir)
+	d.MustExec(`INSERT INTO metadata (id, folder_id, file_size, last_modified, path)
+		VALUES (1, 1, 22, 100, ?);`, metadata)
+	d.MustExec(`INSERT INTO artists
+		(id, mb_id, discogs_id, metadata_id, art_id, folder_id, title, normalized_title)
+		VALUES (1, '', '', 1, 0, 1, 'Pink Floyd', 'pink floyd');`)
+	d.MustExec(`INSERT INTO albums
+		(id, mb_id, discogs_id, metadata_id, art_id, artist_id, folder_id, title, normalized_title, year)
+		VALUES (1, '', 0, 0, 0, 1, 1, 'The Wall', 'the wall', 1979);`)
+	d.MustExec(`INSERT INTO songs
+		(id, mb_id, album_id, artist_id, bitrate, channels, comment, path, file_size,
+		file_type_id, folder_id, genre, last_modified, length, sample_rate, title,
+		normalized_title, track, year)
+		VALUES (1, '', 1, 1, 320, 2, '', ?, 1000, ?, 1, 'Rock', 1234, 280, 44100,
+		'Hey You', 'hey you', 3, 1979);`, filepath.Join(dir, "Hey You.mp3"), MP3)
+	d.MustExec(`INSERT INTO art (id, file_size, path, last_modified) VALUES (1, 10, ?, 1);`,
+		filepath.Join(dir, "folder.jpg"))
+	return path
+}
+
+// openDB sets up and opens the database at path
+func openDB(t *testing.T, path string) *SqlBackend {
+	s := &SqlBackend{}
+	s.DSN(path)
+	if err := s.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// schemaVersion returns the schema version of s, failing the test on error
+func schemaVersion(t *testing.T, s *SqlBackend) int {
+	version, err := s.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestMigrate(t *testing.T) {
+	path := baselineDB(t)
+	s := openDB(t, path)
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if version := schemaVersion(t, s); version != LatestSchemaVersion() {
+		t.Fatalf("schema version %d, expected %d", version, LatestSchemaVersion())
+	}
+
+	// The database is backed up at its old version
+	backups, err := filepath.Glob(path + ".v0-*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("expected one backup, found %v", backups)
+	}
+	backup := openDB(t, backups[0])
+	if version := schemaVersion(t, backup); version != 0 {
+		t.Fatalf("backup schema version %d, expected 0", version)
+	}
+	var songs int
+	if err := backup.db.Get(&songs, "SELECT COUNT(*) FROM songs;"); err != nil || songs != 1 {
+		t.Fatalf("backup holds %d songs, error %v", songs, err)
+	}
+
+	// The song survives, added when its file was last modified, with its tags
+	// to be read again
+	var song struct {
+		Title        string `db:"title"`
+		Disc         int    `db:"disc"`
+		LastModified int64  `db:"last_modified"`
+		Added        int64  `db:"added"`
+	}
+	if err := s.db.Get(&song,
+		"SELECT title, disc, last_modified, added FROM songs WHERE id = 1;"); err != nil {
+		t.Fatal(err)
+	}
+	if song.Title != "Hey You" || song.Disc != 1 || song.LastModified != 0 || song.Added != 1234 {
+		t.Fatalf("unexpected song after migration: %+v", song)
+	}
+
+	var credits int
+	if err := s.db.Get(&credits, `SELECT COUNT(*) FROM song_artists
+		WHERE song_id = 1 AND artist_id = 1 AND role = 'primary';`); err != nil || credits != 1 {
+		t.Fatalf("song has %d primary credits, error %v", credits, err)
+	}
+
+	var data string
+	if err := s.db.Get(&data, `SELECT data FROM metadata
+		WHERE kind = 'artist' AND item_id = 1;`); err != nil || data != `{"name": "Pink Floyd"}` {
+		t.Fatalf("artist metadata %q, error %v", data, err)
+	}
+
+	for kind, match := range map[string]string{
+		KindSong:   `"hey"*`,
+		KindAlbum:  `"wall"*`,
+		KindArtist: `"pink"*`,
+		KindFolder: `"floyd"*`,
+	} {
+		if n, err := s.CountSearch(kind, match, nil); err != nil || n != 1 {
+			t.Fatalf("search for %s %s found %d, error %v", kind, match, n, err)
+		}
+	}
+
+	// Migrating again changes nothing
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ = filepath.Glob(path + ".v*.bak"); len(backups) != 1 {
+		t.Fatalf("expected one backup, found %v", backups)
+	}
+}
+
+func TestMigrateFailure(t *testing.T) {
+	s := openDB(t, baselineDB(t))
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	latest := LatestSchemaVersion()
+	defer func(m []Migration) { migrations = m }(migrations)
+	migrations = append(migrations, Migration{
+		Version:     latest + 1,
+		Description: "Fail",
+		Up: func(tx *sqlx.Tx) error {
+			if _, err := tx.Exec(`CREATE TABLE "failed" ("id" INTEGER);`); err != nil {
+				return err
+			}
+			return errors.New("failed")
+		},
+	})
+
+	if err := s.Migrate(); err == nil {
+		t.Fatal("expected the failing migration to return an error")
+	}
+	if version := schemaVersion(t, s); version != latest {
+		t.Fatalf("schema version %d after a failed migration, expected %d", version, latest)
+	}
+
+	var tables int
+	if err := s.db.Get(&tables,
+		"SELECT COUNT(*) FROM sqlite_master WHERE name = 'failed';"); err != nil || tables != 0 {
+		t.Fatalf("failed migration left %d tables, error %v", tables, err)
+	}
+}
+
+func TestMigrateTooNew(t *testing.T) {
+	s := openDB(t, baselineDB(t))
+	if _, err := s.db.Exec("PRAGMA user_version = 1000;"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Migrate(); err != ErrSchemaTooNew {
+		t.Fatalf("expected %v, got %v", ErrSchemaTooNew, err)
+	}
+}
+
+func TestMigrateNewDB(t *testing.T) {
+	util.InitializeLogger()
+
+	path := filepath.Join(t.TempDir(), "aiomst.db")
+	s := openDB(t, path)
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if version := schemaVersion(t, s); version != LatestSchemaVersion() {
+		t.Fatalf("schema version %d, expected %d", version, LatestSchemaVersion())
+	}
+
+	// New databases are not backed up
+	if backups, _ := filepath.Glob(path + ".v*.bak"); len(backups) != 0 {
+		t.Fatalf("expected no backups, found %v", backups)
+	}
+}
+
+func TestMigrateRescan(t *testing.T) {
+	s := openDB(t, baselineDB(t))
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("UPDATE songs SET last_modified = 1234;"); err != nil {
+		t.Fatal(err)
+	}
+
+	lastModified := func(q sqlx.Queryer) int64 {
+		var n int64
+		if err := sqlx.Get(q, &n, "SELECT last_modified FROM songs WHERE id = 1;"); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// A migration after one which needs a rescan still sees when the song was
+	// last modified, and the library is rescanned once they are both applied
+	var seen int64
+	latest := LatestSchemaVersion()
+	defer func(m []Migration) { migrations = m }(migrations)
+	migrations = append(migrations,
+		Migration{
+			Version:     latest + 1,
+			Description: "Rescan",
+			Up:          func(tx *sqlx.Tx) error { return nil },
+			Rescan:      true,
+		},
+		Migration{
+			Version:     latest + 2,
+			Description: "Read last_modified",
+			Up: func(tx *sqlx.Tx) error {
+				seen = lastModified(tx)
+				return nil
+			},
+		})
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if seen != 1234 {
+		t.Fatalf("migration saw last_modified %d, expected 1234", seen)
+	}
+	if n := lastModified(s.db); n != 0 {
+		t.Fatalf("last_modified %d after a rescan, expected 0", n)
+	}
+
+	// Other migrations leave it alone
+	if _, err := s.db.Exec("UPDATE songs SET last_modified = 1234;"); err != nil {
+		t.Fatal(err)
+	}
+	migrations = append(migrations, Migration{
+		Version:     latest + 3,
+		Description: "No rescan",
+		Up:          func(tx *sqlx.Tx) error { return nil },
+	})
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if n := lastModified(s.db); n != 1234 {
+		t.Fatalf("last_modified %d without a rescan, expected 1234", n)
+	}
+}
+
+func TestMigrateSongAdded(t *testing.T) {
+	s := openDB(t, baselineDB(t))
+
+	// A database migrated before songs.added existed, whose songs were
+	// rescanned since
+	all := migrations
+	defer func() { migrations = all }()
+	migrations = all[:14]
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec("UPDATE songs SET last_modified = 1234;"); err != nil {
+		t.Fatal(err)
+	}
+
+	migrations = all
+	if err := s.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	var song struct {
+		LastModified int64 `db:"last_modified"`
+		Added        int64 `db:"added"`
+	}
+	if err := s.db.Get(&song, "SELECT last_modified, added FROM songs WHERE id = 1;"); err != nil {
+		t.Fatal(err)
+	}
+	if song.LastModified != 1234 || song.Added != 1234 {
+		t.Fatalf("unexpected song after migration: %+v", song)
+	}
+}
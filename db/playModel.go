@@ -0,0 +1,67 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// Play represents one completed play of a song by a user, either reported by
+// a client or inferred from a stream
+type Play struct {
+	ID     int    `json:"id"`
+	UserID int    `db:"user_id" json:"userId"`
+	SongID int    `db:"song_id" json:"songId"`
+	Time   int64  `json:"time"`
+	Client string `json:"client"`
+}
+
+// PlayedSong is a song along with how often, and when last, a user played it
+type PlayedSong struct {
+	Song
+	PlayCount  int   `db:"play_count" json:"playCount"`
+	LastPlayed int64 `db:"last_played" json:"lastPlayed"`
+}
+
+// PlayedAlbum is an album along with how often, and when last, a user played
+// one of its songs
+type PlayedAlbum struct {
+	Album
+	PlayCount  int   `db:"play_count" json:"playCount"`
+	LastPlayed int64 `db:"last_played" json:"lastPlayed"`
+}
+
+// NewPlay creates a new Play of a song. A zero time is replaced with the
+// current time. The play is not saved.
+func NewPlay(userID, songID int, t int64, client string) *Play {
+	if t == 0 {
+		t = time.Now().Unix()
+	}
+
+	return &Play{
+		UserID: userID,
+		SongID: songID,
+		Time:   t,
+		Client: client,
+	}
+}
+
+// Delete removes an existing Play from the database
+func (p *Play) Delete() error {
+	return DB.DeletePlay(p)
+}
+
+// Load pulls an existing Play from the database
+func (p *Play) Load() error {
+	return DB.LoadPlay(p)
+}
+
+// Save creates a new Play in the database
+func (p *Play) Save() error {
+	return DB.SavePlay(p)
+}
+
+// String is a method that returns a string with simple information about this
+// object.
+func (p Play) String() string {
+	return fmt.Sprintf("user %d played song %d at %d", p.UserID, p.SongID, p.Time)
+}
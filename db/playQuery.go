@@ -0,0 +1,303 @@
+package db
+
+import (
+	"database/sql"
+)
+
+// playedSongColumns selects a song along with its play count and last play,
+// from plays joined with songs, artists and albums
+const playedSongColumns = `
+	songs.*,
+	artists.title AS artist,
+	albums.title AS album,
+	COUNT(plays.id) AS play_count,
+	MAX(plays.time) AS last_played`
+
+// playedAlbumColumns selects an album along with its play count and last
+// play, from plays joined with songs, albums and artists
+const playedAlbumColumns = `
+	albums.*,
+	artists.title AS artist,
+	COUNT(plays.id) AS play_count,
+	MAX(plays.time) AS last_played`
+
+// playQuery loads a slice of Play structs matching the input query
+func (s *SqlBackend) playQuery(query string, args ...interface{}) ([]Play, error) {
+	// Perform input query with arguments
+	rows, err := s.db.Queryx(query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate all rows
+	plays := make([]Play, 0)
+	p := Play{}
+	for rows.Next() {
+		// Scan play into struct
+		if err := rows.StructScan(&p); err != nil {
+			return nil, err
+		}
+
+		// Append to list
+		plays = append(plays, p)
+	}
+
+	// Error check rows
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return plays, nil
+}
+
+// playedSongQuery loads a slice of PlayedSong structs matching the input query
+func (s *SqlBackend) playedSongQuery(query string, args ...interface{}) ([]PlayedSong, error) {
+	// Perform input query with arguments
+	rows, err := s.db.Queryx(query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate all rows
+	songs := make([]PlayedSong, 0)
+	for rows.Next() {
+		// Scan song into struct
+		a := PlayedSong{}
+		if err := rows.StructScan(&a); err != nil {
+			return nil, err
+		}
+
+		// Append to list
+		songs = append(songs, a)
+	}
+
+	// Error check rows
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return songs, nil
+}
+
+// playedAlbumQuery loads a slice of PlayedAlbum structs matching the input
+// query
+func (s *SqlBackend) playedAlbumQuery(query string, args ...interface{}) ([]PlayedAlbum, error) {
+	// Perform input query with arguments
+	rows, err := s.db.Queryx(query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate all rows
+	albums := make([]PlayedAlbum, 0)
+	for rows.Next() {
+		// Scan album into struct
+		a := PlayedAlbum{}
+		if err := rows.StructScan(&a); err != nil {
+			return nil, err
+		}
+
+		// Append to list
+		albums = append(albums, a)
+	}
+
+	// Error check rows
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return albums, nil
+}
+
+// PlaysForUser loads a slice of a user's Play structs, newest first, using
+// SQL limit, where the first parameter specifies an offset and the second
+// specifies an item count
+func (s *SqlBackend) PlaysForUser(userID, offset, count int) ([]Play, error) {
+	return s.playQuery(
+		`SELECT * FROM plays
+		WHERE user_id = ?
+		ORDER BY time DESC, id DESC
+		LIMIT ?, ?;`,
+		userID,
+		offset,
+		count,
+	)
+}
+
+// LastPlayOfSong loads the latest Play of a song by a user. sql.ErrNoRows is
+// returned when the user never played the song.
+func (s *SqlBackend) LastPlayOfSong(userID, songID int) (*Play, error) {
+	p := &Play{}
+	if err := s.db.Get(p,
+		`SELECT * FROM plays
+		WHERE user_id = ? AND song_id = ?
+		ORDER BY time DESC LIMIT 1;`,
+		userID, songID); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// CountPlays fetches the total number of plays by a user
+func (s *SqlBackend) CountPlays(userID int) (int64, error) {
+	return s.integerQuery(
+		"SELECT COUNT(*) AS int FROM plays WHERE user_id = ?;", userID)
+}
+
+// MostPlayedSongs loads a slice of the songs a user played most often, using
+// SQL limit, where the first parameter specifies an offset and the second
+// specifies an item count
+func (s *SqlBackend) MostPlayedSongs(userID, offset, count int) ([]PlayedSong, error) {
+	return s.playedSongQuery(
+		`SELECT`+playedSongColumns+`
+		FROM plays
+		JOIN songs ON plays.song_id = songs.id
+		JOIN artists ON songs.artist_id = artists.id
+		JOIN albums ON songs.album_id = albums.id
+		WHERE plays.user_id = ?
+		GROUP BY songs.id
+		ORDER BY play_count DESC, last_played DESC
+		LIMIT ?, ?;`,
+		userID,
+		offset,
+		count,
+	)
+}
+
+// RecentlyPlayedSongs loads a slice of the songs a user played, most recently
+// played first, using SQL limit, where the first parameter specifies an
+// offset and the second specifies an item count
+func (s *SqlBackend) RecentlyPlayedSongs(userID, offset, count int) ([]PlayedSong, error) {
+	return s.playedSongQuery(
+		`SELECT`+playedSongColumns+`
+		FROM plays
+		JOIN songs ON plays.song_id = songs.id
+		JOIN artists ON songs.artist_id = artists.id
+		JOIN albums ON songs.album_id = albums.id
+		WHERE plays.user_id = ?
+		GROUP BY songs.id
+		ORDER BY last_played DESC
+		LIMIT ?, ?;`,
+		userID,
+		offset,
+		count,
+	)
+}
+
+// NeverPlayedSongs loads a slice of the songs a user has not played, ordered
+// by artist, album and track, using SQL limit, where the first parameter
+// specifies an offset and the second specifies an item count
+func (s *SqlBackend) NeverPlayedSongs(userID, offset, count int) ([]PlayedSong, error) {
+	return s.playedSongQuery(
+		`SELECT
+			songs.*,
+			artists.title AS artist,
+			albums.title AS album,
+			0 AS play_count,
+			0 AS last_played
+		FROM songs
+		JOIN artists ON songs.artist_id = artists.id
+		JOIN albums ON songs.album_id = albums.id
+		WHERE songs.id NOT IN (SELECT song_id FROM plays WHERE user_id = ?)
+		ORDER BY
+			`+sortBy("artists")+`,
+			`+sortBy("albums")+`,
+			songs.disc,
+			songs.track
+		LIMIT ?, ?;`,
+		userID,
+		offset,
+		count,
+	)
+}
+
+// MostPlayedAlbums loads a slice of the albums a user played most often, in
+// the given libraries or in every library when IDs is nil, using SQL limit,
+// where the first parameter specifies an offset and the second specifies an
+// item count
+func (s *SqlBackend) MostPlayedAlbums(
+	userID int, IDs []int, offset, count int) ([]PlayedAlbum, error) {
+	cond, args := inLibraries("songs.library_id", IDs)
+	return s.playedAlbumQuery(
+		`SELECT`+playedAlbumColumns+`
+		FROM plays
+		JOIN songs ON plays.song_id = songs.id
+		JOIN albums ON songs.album_id = albums.id
+		JOIN artists ON albums.artist_id = artists.id
+		WHERE plays.user_id = ? AND `+cond+`
+		GROUP BY albums.id
+		ORDER BY play_count DESC, last_played DESC
+		LIMIT ?, ?;`,
+		append(append([]interface{}{userID}, args...), offset, count)...,
+	)
+}
+
+// RecentlyPlayedAlbums loads a slice of the albums a user played in the given
+// libraries, or in every library when IDs is nil, most recently played first,
+// using SQL limit, where the first parameter specifies an offset and the
+// second specifies an item count
+func (s *SqlBackend) RecentlyPlayedAlbums(
+	userID int, IDs []int, offset, count int) ([]PlayedAlbum, error) {
+	cond, args := inLibraries("songs.library_id", IDs)
+	return s.playedAlbumQuery(
+		`SELECT`+playedAlbumColumns+`
+		FROM plays
+		JOIN songs ON plays.song_id = songs.id
+		JOIN albums ON songs.album_id = albums.id
+		JOIN artists ON albums.artist_id = artists.id
+		WHERE plays.user_id = ? AND `+cond+`
+		GROUP BY albums.id
+		ORDER BY last_played DESC
+		LIMIT ?, ?;`,
+		append(append([]interface{}{userID}, args...), offset, count)...,
+	)
+}
+
+// DeletePlay removes a Play, along with any scrobbles still queued for it,
+// from the database
+func (s *SqlBackend) DeletePlay(p *Play) error {
+	tx := s.db.MustBegin()
+	tx.Exec("DELETE FROM scrobbles WHERE play_id = ?;", p.ID)
+	tx.Exec("DELETE FROM plays WHERE id = ?;", p.ID)
+	return tx.Commit()
+}
+
+// LoadPlay loads a Play from the database by its ID, populating the parameter
+// struct
+func (s *SqlBackend) LoadPlay(p *Play) error {
+	if err := s.db.Get(p, "SELECT * FROM plays WHERE id = ?;", p.ID); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SavePlay attempts to save a Play to the database
+func (s *SqlBackend) SavePlay(p *Play) error {
+	query := `INSERT INTO plays
+		(user_id, song_id, time, client)
+		VALUES (?, ?, ?, ?);`
+	tx := s.db.MustBegin()
+	res, err := tx.Exec(query, p.UserID, p.SongID, p.Time, p.Client)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Commit transaction
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// A song may be played many times, so use the inserted row's ID
+	if p.ID == 0 {
+		ID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		p.ID = int(ID)
+	}
+	return nil
+}
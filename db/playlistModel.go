@@ -0,0 +1,72 @@
+package db
+
+// Playlist is an ordered list of songs. Playlists created through the API
+// belong to a user, while playlists imported from files in the media folder
+// have a path, belong to no user and are shared by everyone.
+type Playlist struct {
+	ID           int    `json:"id"`
+	UserID       int    `db:"user_id" json:"userId"`
+	Name         string `json:"name"`
+	Comment      string `json:"comment"`
+	Path         string `json:"path"`
+	Created      int64  `json:"created"`
+	LastModified int64  `db:"last_modified" json:"lastModified"`
+	SongCount    int    `db:"song_count" json:"songCount"`
+	Length       int    `json:"length"`
+}
+
+// Shared reports whether the playlist is visible to every user
+func (p *Playlist) Shared() bool {
+	return p.UserID == 0
+}
+
+// VisibleTo reports whether a user may see the playlist
+func (p *Playlist) VisibleTo(u *User) bool {
+	return p.Shared() || p.UserID == u.ID || u.HasRole(RoleAdmin)
+}
+
+// EditableBy reports whether a user may change the playlist. Imported
+// playlists follow their file, so only admins may change them.
+func (p *Playlist) EditableBy(u *User) bool {
+	return p.UserID == u.ID || u.HasRole(RoleAdmin)
+}
+
+// Songs loads the songs of this playlist, in order
+func (p *Playlist) Songs() ([]Song, error) {
+	return DB.SongsForPlaylist(p.ID)
+}
+
+// SongsFor loads the songs of this playlist a user may access, in order
+func (p *Playlist) SongsFor(u *User) ([]Song, error) {
+	songs, err := p.Songs()
+	if err != nil {
+		return nil, err
+	}
+	return DB.SongsAccessibleBy(songs, u)
+}
+
+// Delete removes an existing Playlist from the database
+func (p *Playlist) Delete() error {
+	return DB.DeletePlaylist(p)
+}
+
+// Load pulls an existing Playlist from the database
+func (p *Playlist) Load() error {
+	return DB.LoadPlaylist(p)
+}
+
+// Save creates a new Playlist in the database
+func (p *Playlist) Save() error {
+	return DB.SavePlaylist(p)
+}
+
+// Update saves an existing Playlist in the database
+func (p *Playlist) Update() error {
+	return DB.UpdatePlaylist(p)
+}
+
+// String is a method that returns a string with simple information about this
+// object.
+func (p Playlist) String() string {
+	return p.Name
+}
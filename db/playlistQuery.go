@@ -0,0 +1,263 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrPlaylistPosition is returned when a playlist has no item at a position
+	ErrPlaylistPosition = errors.New("playlist: position out of range")
+)
+
+// playlistColumns selects a playlist along with its song count and length
+const playlistColumns = `
+	playlists.*,
+	(SELECT COUNT(*) FROM playlist_items
+		WHERE playlist_items.playlist_id = playlists.id) AS song_count,
+	(SELECT IFNULL(SUM(songs.length), 0) FROM playlist_items
+		JOIN songs ON playlist_items.song_id = songs.id
+		WHERE playlist_items.playlist_id = playlists.id) AS length`
+
+// playlistQuery loads a slice of Playlist structs matching the input query
+func (s *SqlBackend) playlistQuery(query string, args ...interface{}) ([]Playlist, error) {
+	// Perform input query with arguments
+	rows, err := s.db.Queryx(query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate all rows
+	playlists := make([]Playlist, 0)
+	p := Playlist{}
+	for rows.Next() {
+		// Scan playlist into struct
+		if err := rows.StructScan(&p); err != nil {
+			return nil, err
+		}
+
+		// Append to list
+		playlists = append(playlists, p)
+	}
+
+	// Error check rows
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return playlists, nil
+}
+
+// AllPlaylists loads a slice of all Playlist structs from the database
+func (s *SqlBackend) AllPlaylists() ([]Playlist, error) {
+	return s.playlistQuery(
+		"SELECT" + playlistColumns + " FROM playlists ORDER BY name COLLATE NOCASE;")
+}
+
+// PlaylistsForUser loads a slice of the Playlist structs a user owns, along
+// with the shared playlists
+func (s *SqlBackend) PlaylistsForUser(userID int) ([]Playlist, error) {
+	return s.playlistQuery(
+		"SELECT"+playlistColumns+`
+		FROM playlists
+		WHERE user_id = ? OR user_id = 0
+		ORDER BY name COLLATE NOCASE;`,
+		userID,
+	)
+}
+
+// ImportedPlaylistsInPath loads a slice of all Playlist structs imported from
+// files within the specified file path
+func (s *SqlBackend) ImportedPlaylistsInPath(path string) ([]Playlist, error) {
+	return s.playlistQuery(
+		"SELECT"+playlistColumns+" FROM playlists WHERE path != '' AND path LIKE ?;",
+		path+"%")
+}
+
+// SongsForPlaylist loads a slice of the Song structs in a playlist, in order
+func (s *SqlBackend) SongsForPlaylist(ID int) ([]Song, error) {
+	return s.songQuery(
+		`SELECT
+			songs.*,
+			artists.title AS artist,
+			albums.title AS album
+		FROM playlist_items
+		JOIN songs ON playlist_items.song_id = songs.id
+		JOIN artists ON songs.artist_id = artists.id
+		JOIN albums ON songs.album_id = albums.id
+		WHERE playlist_items.playlist_id = ?
+		ORDER BY playlist_items.position;`,
+		ID,
+	)
+}
+
+// playlistSongIDs loads the song IDs of a playlist, in order
+func (s *SqlBackend) playlistSongIDs(ID int) ([]int, error) {
+	IDs := make([]int, 0)
+	err := s.db.Select(&IDs,
+		`SELECT song_id FROM playlist_items
+		WHERE playlist_id = ?
+		ORDER BY position;`,
+		ID,
+	)
+	return IDs, err
+}
+
+// SetPlaylistSongs replaces the songs of a playlist with the given song IDs,
+// in order. This is also used to reorder a playlist.
+func (s *SqlBackend) SetPlaylistSongs(ID int, songIDs []int) error {
+	tx := s.db.MustBegin()
+	if _, err := tx.Exec(
+		"DELETE FROM playlist_items WHERE playlist_id = ?;", ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for i, songID := range songIDs {
+		if _, err := tx.Exec(
+			`INSERT INTO playlist_items
+			(playlist_id, song_id, position)
+			VALUES (?, ?, ?);`,
+			ID, songID, i); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	tx.Exec("UPDATE playlists SET last_modified = ? WHERE id = ?;",
+		time.Now().Unix(), ID)
+	return tx.Commit()
+}
+
+// AppendPlaylistSongs adds songs to the end of a playlist
+func (s *SqlBackend) AppendPlaylistSongs(ID int, songIDs []int) error {
+	current, err := s.playlistSongIDs(ID)
+	if err != nil {
+		return err
+	}
+	return s.SetPlaylistSongs(ID, append(current, songIDs...))
+}
+
+// MovePlaylistSong moves the song at one position of a playlist to another,
+// shifting the songs in between
+func (s *SqlBackend) MovePlaylistSong(ID, from, to int) error {
+	IDs, err := s.playlistSongIDs(ID)
+	if err != nil {
+		return err
+	}
+	if from < 0 || from >= len(IDs) || to < 0 || to >= len(IDs) {
+		return ErrPlaylistPosition
+	}
+
+	songID := IDs[from]
+	IDs = append(IDs[:from], IDs[from+1:]...)
+	IDs = append(IDs[:to], append([]int{songID}, IDs[to:]...)...)
+	return s.SetPlaylistSongs(ID, IDs)
+}
+
+// RemovePlaylistSong removes the song at a position of a playlist
+func (s *SqlBackend) RemovePlaylistSong(ID, position int) error {
+	IDs, err := s.playlistSongIDs(ID)
+	if err != nil {
+		return err
+	}
+	if position < 0 || position >= len(IDs) {
+		return ErrPlaylistPosition
+	}
+
+	return s.SetPlaylistSongs(ID, append(IDs[:position], IDs[position+1:]...))
+}
+
+// DeletePlaylist removes a Playlist and its items from the database
+func (s *SqlBackend) DeletePlaylist(p *Playlist) error {
+	tx := s.db.MustBegin()
+	if p.ID == 0 {
+		// Find the playlist's ID from its path
+		if err := tx.Get(&p.ID,
+			"SELECT id FROM playlists WHERE path = ? AND path != '';", p.Path);
+		err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	tx.Exec("DELETE FROM playlist_items WHERE playlist_id = ?;", p.ID)
+	tx.Exec("DELETE FROM playlists WHERE id = ?;", p.ID)
+	return tx.Commit()
+}
+
+// LoadPlaylist loads a Playlist from the database, populating the parameter
+// struct
+func (s *SqlBackend) LoadPlaylist(p *Playlist) error {
+	// Load the playlist via ID if available
+	if p.ID != 0 {
+		if err := s.db.Get(p,
+			"SELECT"+playlistColumns+" FROM playlists WHERE id = ?;", p.ID);
+		err != nil {
+			return err
+		}
+		return nil
+	}
+
+	// Load via the path of an imported playlist
+	if err := s.db.Get(p,
+		"SELECT"+playlistColumns+" FROM playlists WHERE path = ? AND path != '';",
+		p.Path); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SavePlaylist attempts to save a Playlist to the database
+func (s *SqlBackend) SavePlaylist(p *Playlist) error {
+	if p.Created == 0 {
+		p.Created = time.Now().Unix()
+	}
+	if p.LastModified == 0 {
+		p.LastModified = p.Created
+	}
+
+	query := `INSERT INTO playlists
+		(user_id, name, comment, path, created, last_modified)
+		VALUES (?, ?, ?, ?, ?, ?);`
+	tx := s.db.MustBegin()
+	res, err := tx.Exec(
+		query, p.UserID, p.Name, p.Comment, p.Path, p.Created, p.LastModified)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Commit transaction
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// User playlists may share names, so use the inserted row's ID
+	if p.ID == 0 {
+		ID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		p.ID = int(ID)
+	}
+	return nil
+}
+
+// UpdatePlaylist updates an existing Playlist in the database
+func (s *SqlBackend) UpdatePlaylist(p *Playlist) error {
+	p.LastModified = time.Now().Unix()
+	query := `UPDATE playlists
+		SET
+			user_id = ?,
+			name = ?,
+			comment = ?,
+			path = ?,
+			last_modified = ?
+		WHERE id = ?;`
+	tx := s.db.MustBegin()
+	tx.Exec(query, p.UserID, p.Name, p.Comment, p.Path, p.LastModified, p.ID)
+	return tx.Commit()
+}
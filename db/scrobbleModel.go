@@ -0,0 +1,39 @@
+package db
+
+import (
+	"fmt"
+)
+
+// Scrobble is a play waiting to be forwarded to a scrobbling service. Failed
+// submissions are retried once NextAttempt has passed.
+type Scrobble struct {
+	ID          int    `json:"id"`
+	PlayID      int    `db:"play_id" json:"playId"`
+	Service     string `json:"service"`
+	Attempts    int    `json:"attempts"`
+	NextAttempt int64  `db:"next_attempt" json:"nextAttempt"`
+	Error       string `json:"error"`
+
+	// The play being forwarded, filled in by DueScrobbles
+	UserID int    `db:"user_id" json:"userId"`
+	SongID int    `db:"song_id" json:"songId"`
+	Time   int64  `db:"time" json:"time"`
+	Client string `db:"client" json:"client"`
+}
+
+// Delete removes an existing Scrobble from the queue
+func (s *Scrobble) Delete() error {
+	return DB.DeleteScrobbles([]int{s.ID})
+}
+
+// Save queues a Scrobble. Queueing the same play for a service twice has no
+// effect.
+func (s *Scrobble) Save() error {
+	return DB.SaveScrobble(s)
+}
+
+// String is a method that returns a string with simple information about this
+// object.
+func (s Scrobble) String() string {
+	return fmt.Sprintf("play %d to %s", s.PlayID, s.Service)
+}
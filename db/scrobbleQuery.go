@@ -0,0 +1,128 @@
+package db
+
+import (
+	"database/sql"
+)
+
+// scrobbleQuery loads a slice of Scrobble structs matching the input query
+func (s *SqlBackend) scrobbleQuery(query string, args ...interface{}) ([]Scrobble, error) {
+	// Perform input query with arguments
+	rows, err := s.db.Queryx(query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate all rows
+	scrobbles := make([]Scrobble, 0)
+	a := Scrobble{}
+	for rows.Next() {
+		// Scan scrobble into struct
+		if err := rows.StructScan(&a); err != nil {
+			return nil, err
+		}
+
+		// Append to list
+		scrobbles = append(scrobbles, a)
+	}
+
+	// Error check rows
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return scrobbles, nil
+}
+
+// DueScrobbles loads up to count Scrobble structs for a service which are due
+// to be submitted at time now, along with their plays, ordered by user and
+// time of play
+func (s *SqlBackend) DueScrobbles(service string, now int64, count int) ([]Scrobble, error) {
+	return s.scrobbleQuery(
+		`SELECT
+			scrobbles.*,
+			plays.user_id,
+			plays.song_id,
+			plays.time,
+			plays.client
+		FROM scrobbles
+		JOIN plays ON scrobbles.play_id = plays.id
+		WHERE scrobbles.service = ? AND scrobbles.next_attempt <= ?
+		ORDER BY plays.user_id, plays.time
+		LIMIT ?;`,
+		service,
+		now,
+		count,
+	)
+}
+
+// NextScrobble returns the time at which the next Scrobble for a service is
+// due, or 0 if none are queued
+func (s *SqlBackend) NextScrobble(service string) (int64, error) {
+	return s.integerQuery(
+		`SELECT IFNULL(MIN(next_attempt), 0) AS int FROM scrobbles
+		WHERE service = ?;`, service)
+}
+
+// CountScrobbles fetches the number of Scrobble structs queued for a service
+func (s *SqlBackend) CountScrobbles(service string) (int64, error) {
+	return s.integerQuery(
+		"SELECT COUNT(*) AS int FROM scrobbles WHERE service = ?;", service)
+}
+
+// DeleteScrobbles removes Scrobble structs from the queue by their IDs
+func (s *SqlBackend) DeleteScrobbles(IDs []int) error {
+	tx := s.db.MustBegin()
+	for _, ID := range IDs {
+		tx.Exec("DELETE FROM scrobbles WHERE id = ?;", ID)
+	}
+	return tx.Commit()
+}
+
+// RetryScrobbles records a failed attempt to submit Scrobble structs, which
+// are retried at time next
+func (s *SqlBackend) RetryScrobbles(IDs []int, next int64, message string) error {
+	query := `UPDATE scrobbles
+		SET
+			attempts = attempts + 1,
+			next_attempt = ?,
+			error = ?
+		WHERE id = ?;`
+	tx := s.db.MustBegin()
+	for _, ID := range IDs {
+		tx.Exec(query, next, message, ID)
+	}
+	return tx.Commit()
+}
+
+// SaveScrobble attempts to queue a Scrobble in the database
+func (s *SqlBackend) SaveScrobble(a *Scrobble) error {
+	query := `INSERT OR IGNORE INTO scrobbles
+		(play_id, service, attempts, next_attempt, error)
+		VALUES (?, ?, ?, ?, ?);`
+	tx := s.db.MustBegin()
+	res, err := tx.Exec(
+		query, a.PlayID, a.Service, a.Attempts, a.NextAttempt, a.Error)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Commit transaction
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Grab the ID of the new row, if one was inserted
+	if a.ID == 0 {
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+		ID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		a.ID = int(ID)
+	}
+	return nil
+}
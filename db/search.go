@@ -0,0 +1,123 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// Kinds of items stored in the search index
+const (
+	KindSong   = "song"
+	KindAlbum  = "album"
+	KindArtist = "artist"
+	KindFolder = "folder"
+)
+
+// Markers placed around matching terms in search snippets, whose text is
+// otherwise HTML escaped
+const (
+	SnippetStart = "<mark>"
+	SnippetEnd   = "</mark>"
+)
+
+var (
+	// ErrEmptySearch is returned when a search query contains no terms
+	ErrEmptySearch = errors.New("search: query contains no search terms")
+)
+
+// searchFields are the fields which may be used as filters in a search query,
+// such as artist:foo or year:1999
+var searchFields = map[string]bool{
+	"title":  true,
+	"artist": true,
+	"album":  true,
+	"genre":  true,
+	"year":   true,
+}
+
+// SongHit is a Song matching a search, with a highlighted snippet
+type SongHit struct {
+	Song
+	Snippet string `json:"snippet"`
+}
+
+// AlbumHit is an Album matching a search, with a highlighted snippet
+type AlbumHit struct {
+	Album
+	Snippet string `json:"snippet"`
+}
+
+// ArtistHit is an Artist matching a search, with a highlighted snippet
+type ArtistHit struct {
+	Artist
+	Snippet string `json:"snippet"`
+}
+
+// FolderHit is a Folder matching a search, with a highlighted snippet
+type FolderHit struct {
+	Folder
+	Snippet string `json:"snippet"`
+}
+
+// ParseSearch converts a user's search query into an FTS5 match expression.
+// Every term must match, and unquoted terms match as prefixes. Quoted terms
+// match as phrases, and a term may be limited to a single field by prefixing
+// it with the field name, as in `artist:"pink floyd" year:1979 wall`.
+func ParseSearch(query string) (string, error) {
+	terms := make([]string, 0)
+	for _, token := range splitSearch(query) {
+		field := ""
+		if i := strings.Index(token, ":"); i > 0 && searchFields[strings.ToLower(token[:i])] {
+			field = strings.ToLower(token[:i])
+			token = token[i+1:]
+		}
+
+		// Quotes are only meaningful around the whole term
+		phrase := strings.HasPrefix(token, `"`)
+		token = strings.TrimSpace(strings.Replace(token, `"`, "", -1))
+		if token == "" {
+			continue
+		}
+
+		term := `"` + token + `"`
+		if !phrase && field != "year" {
+			term += "*"
+		}
+		if field != "" {
+			term = field + " : " + term
+		}
+		terms = append(terms, term)
+	}
+
+	if len(terms) == 0 {
+		return "", ErrEmptySearch
+	}
+	return strings.Join(terms, " AND "), nil
+}
+
+// splitSearch splits a search query on whitespace outside of double quotes
+func splitSearch(query string) []string {
+	tokens := make([]string, 0)
+	var b strings.Builder
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			b.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if b.Len() > 0 {
+				tokens = append(tokens, b.String())
+				b.Reset()
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	if b.Len() > 0 {
+		tokens = append(tokens, b.String())
+	}
+	return tokens
+}
@@ -0,0 +1,292 @@
+package db
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// searchLibraryItems selects the IDs of the items of each kind which are in
+// a set of libraries, given by each %[1]s. Albums and artists are in the
+// libraries of their songs, including the songs artists are credited on.
+var searchLibraryItems = map[string]string{
+	KindSong:  "SELECT id FROM songs WHERE %[1]s",
+	KindAlbum: "SELECT album_id FROM songs WHERE %[1]s",
+	KindArtist: `SELECT artist_id FROM songs WHERE %[1]s
+		UNION SELECT song_artists.artist_id
+		FROM song_artists
+		JOIN songs ON song_artists.song_id = songs.id
+		WHERE %[1]s`,
+	KindFolder: "SELECT id FROM folders WHERE %[1]s",
+}
+
+// searchLibraries returns a condition limiting the search index to items of
+// a kind in the given libraries, along with its arguments. Nil IDs search
+// every library.
+func searchLibraries(kind string, IDs []int) (string, []interface{}) {
+	if IDs == nil {
+		return "", nil
+	}
+
+	cond, args := inLibraries("library_id", IDs)
+	items := searchLibraryItems[kind]
+	allArgs := make([]interface{}, 0)
+	for i := strings.Count(items, "%[1]s"); i > 0; i-- {
+		allArgs = append(allArgs, args...)
+	}
+	return " AND search_index.item_id IN (" + fmt.Sprintf(items, cond) + ")", allArgs
+}
+
+// Markers placed around matching terms by SQLite, which are replaced by
+// SnippetStart and SnippetEnd once the snippet is escaped
+const (
+	snippetStart = "\x02"
+	snippetEnd   = "\x03"
+)
+
+// highlight HTML escapes a snippet of tag text, and marks its matching terms
+// with SnippetStart and SnippetEnd
+func highlight(snippet string) string {
+	return strings.NewReplacer(snippetStart, SnippetStart, snippetEnd, SnippetEnd).
+		Replace(html.EscapeString(snippet))
+}
+
+// hasFTS5 reports whether SQLite was built with FTS5
+func hasFTS5(q sqlx.Queryer) bool {
+	var used int
+	if err := sqlx.Get(q, &used, "SELECT sqlite_compileoption_used('ENABLE_FTS5');"); err != nil {
+		return false
+	}
+	return used == 1
+}
+
+// fullTextIndex reports whether the search index is an FTS5 table, rather than
+// the plain table created when SQLite lacks FTS5
+func fullTextIndex(q sqlx.Queryer) (bool, error) {
+	var n int
+	err := sqlx.Get(q, &n,
+		`SELECT COUNT(*)
+		FROM sqlite_master
+		WHERE name = 'search_index' AND sql LIKE 'CREATE VIRTUAL TABLE%';`)
+	return n > 0, err
+}
+
+// searchTerm matches a single term of an expression returned by ParseSearch,
+// capturing its field, its text and whether it is a prefix
+var searchTerm = regexp.MustCompile(`(?:(\w+) : )?"([^"]*)"(\*)?`)
+
+// searchColumns are the columns of the search index a term without a field
+// is matched against
+var searchColumns = []string{"title", "artist", "album", "genre", "year"}
+
+// likeSearch converts an expression returned by ParseSearch into a condition
+// matching the rows of the plain search index, along with its arguments.
+// Every term must match the start of a word in one of its columns, or whole
+// words when it is not a prefix.
+func likeSearch(match string) (string, []interface{}) {
+	escape := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+	terms := make([]string, 0)
+	args := make([]interface{}, 0)
+	for _, m := range searchTerm.FindAllStringSubmatch(match, -1) {
+		columns := searchColumns
+		if searchFields[m[1]] {
+			columns = []string{m[1]}
+		}
+
+		pattern := "% " + escape.Replace(m[2]) + " %"
+		if m[3] != "" {
+			pattern = "% " + escape.Replace(m[2]) + "%"
+		}
+
+		conds := make([]string, 0, len(columns))
+		for _, column := range columns {
+			conds = append(conds, fmt.Sprintf(
+				`(' ' || COALESCE(search_index.%s, '') || ' ') LIKE ? ESCAPE '\'`, column))
+			args = append(args, pattern)
+		}
+		terms = append(terms, "("+strings.Join(conds, " OR ")+")")
+	}
+
+	if len(terms) == 0 {
+		return "0", nil
+	}
+	return strings.Join(terms, " AND "), args
+}
+
+// searchMatch returns the condition matching the rows of the search index
+// matched by an expression returned by ParseSearch, along with its arguments,
+// the order of the matching rows and the snippet selected for each. Without
+// FTS5, rows are ordered by title and their snippet is their title.
+func (s *SqlBackend) searchMatch(match string) (string, []interface{}, string, string, error) {
+	fullText, err := fullTextIndex(s.db)
+	if err != nil {
+		return "", nil, "", "", err
+	}
+	if !fullText {
+		cond, args := likeSearch(match)
+		return cond, args, "search_index.title COLLATE NOCASE", "search_index.title", nil
+	}
+
+	snippet := fmt.Sprintf(
+		"snippet(search_index, -1, char(%d), char(%d), '…', 12)", snippetStart[0], snippetEnd[0])
+	return "search_index MATCH ?", []interface{}{match}, "search_index.rank", snippet, nil
+}
+
+// searchRows runs a ranked search of the items of one kind in a set of
+// libraries. The columns are selected from the item's table, which is joined
+// as the given name, along with any further joins, and a snippet of the best
+// matching field.
+func (s *SqlBackend) searchRows(
+	kind, table, columns, joins, match string, libraries []int,
+	offset, count int) (*sqlx.Rows, error) {
+	cond, args, order, snippet, err := s.searchMatch(match)
+	if err != nil {
+		return nil, err
+	}
+	libraryCond, libraryArgs := searchLibraries(kind, libraries)
+	query := fmt.Sprintf(
+		`SELECT
+			%s,
+			%s AS snippet
+		FROM search_index
+		JOIN %s ON %s.id = search_index.item_id
+		%s
+		WHERE %s AND search_index.kind = ?%s
+		ORDER BY %s
+		LIMIT ?, ?;`,
+		columns, snippet, table, table, joins, cond, libraryCond, order,
+	)
+
+	args = append(args, kind)
+	args = append(args, libraryArgs...)
+	return s.db.Queryx(query, append(args, offset, count)...)
+}
+
+// CountSearch fetches the total number of items of a kind in a set of
+// libraries matching a search. Nil libraries counts every library.
+func (s *SqlBackend) CountSearch(kind, match string, libraries []int) (int64, error) {
+	cond, args, _, _, err := s.searchMatch(match)
+	if err != nil {
+		return 0, err
+	}
+	libraryCond, libraryArgs := searchLibraries(kind, libraries)
+	args = append(args, kind)
+	return s.integerQuery(
+		`SELECT COUNT(*) AS int
+		FROM search_index
+		WHERE `+cond+` AND search_index.kind = ?`+libraryCond+`;`,
+		append(args, libraryArgs...)...,
+	)
+}
+
+// SearchSongs loads a ranked slice of Songs matching an FTS5 match expression,
+// as returned by ParseSearch, in a set of libraries, using an offset and an
+// item count
+func (s *SqlBackend) SearchSongs(match string, libraries []int, offset, count int) ([]SongHit, error) {
+	rows, err := s.searchRows(
+		KindSong, "songs",
+		`songs.*,
+			artists.title AS artist,
+			albums.title AS album`,
+		`JOIN artists ON songs.artist_id = artists.id
+		JOIN albums ON songs.album_id = albums.id`,
+		match, libraries, offset, count,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate all rows
+	hits := make([]SongHit, 0)
+	for rows.Next() {
+		h := SongHit{}
+		if err := rows.StructScan(&h); err != nil {
+			return nil, err
+		}
+		h.Snippet = highlight(h.Snippet)
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+// SearchAlbums loads a ranked slice of Albums matching an FTS5 match
+// expression, as returned by ParseSearch, in a set of libraries, using an
+// offset and an item count
+func (s *SqlBackend) SearchAlbums(match string, libraries []int, offset, count int) ([]AlbumHit, error) {
+	rows, err := s.searchRows(
+		KindAlbum, "albums",
+		`albums.*,
+			artists.title AS artist`,
+		`JOIN artists ON albums.artist_id = artists.id`,
+		match, libraries, offset, count,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate all rows
+	hits := make([]AlbumHit, 0)
+	for rows.Next() {
+		h := AlbumHit{}
+		if err := rows.StructScan(&h); err != nil {
+			return nil, err
+		}
+		h.Snippet = highlight(h.Snippet)
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+// SearchArtists loads a ranked slice of Artists matching an FTS5 match
+// expression, as returned by ParseSearch, in a set of libraries, using an
+// offset and an item count
+func (s *SqlBackend) SearchArtists(match string, libraries []int, offset, count int) ([]ArtistHit, error) {
+	rows, err := s.searchRows(
+		KindArtist, "artists", "artists.*", "", match, libraries, offset, count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate all rows
+	hits := make([]ArtistHit, 0)
+	for rows.Next() {
+		h := ArtistHit{}
+		if err := rows.StructScan(&h); err != nil {
+			return nil, err
+		}
+		h.Snippet = highlight(h.Snippet)
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
+
+// SearchFolders loads a ranked slice of Folders matching an FTS5 match
+// expression, as returned by ParseSearch, in a set of libraries, using an
+// offset and an item count
+func (s *SqlBackend) SearchFolders(match string, libraries []int, offset, count int) ([]FolderHit, error) {
+	rows, err := s.searchRows(
+		KindFolder, "folders", "folders.*", "", match, libraries, offset, count)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate all rows
+	hits := make([]FolderHit, 0)
+	for rows.Next() {
+		h := FolderHit{}
+		if err := rows.StructScan(&h); err != nil {
+			return nil, err
+		}
+		h.Snippet = highlight(h.Snippet)
+		hits = append(hits, h)
+	}
+	return hits, rows.Err()
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"time"
+
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// SessionTTL is how long a session stays valid after login
+const SessionTTL = 30 * 24 * time.Hour
+
+// Session represents a logged in client. The key is sent by the client with
+// every request in place of the user's credentials.
+type Session struct {
+	ID     int    `json:"id"`
+	UserID int    `db:"user_id" json:"userId"`
+	Client string `json:"client"`
+	Expire int64  `json:"expire"`
+	Key    string `json:"key"`
+}
+
+// NewSession creates a new Session for a user with a random key. The session
+// is not saved.
+func NewSession(u *User, client string) (*Session, error) {
+	key, err := util.RandomKey(32)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		UserID: u.ID,
+		Client: client,
+		Expire: time.Now().Add(SessionTTL).Unix(),
+		Key:    key,
+	}, nil
+}
+
+// Expired reports whether the session may no longer be used
+func (s Session) Expired() bool {
+	return time.Now().Unix() > s.Expire
+}
+
+// Delete removes an existing Session from the database
+func (s *Session) Delete() error {
+	return DB.DeleteSession(s)
+}
+
+// Load pulls an existing Session from the database
+func (s *Session) Load() error {
+	return DB.LoadSession(s)
+}
+
+// Save creates a new Session in the database
+func (s *Session) Save() error {
+	return DB.SaveSession(s)
+}
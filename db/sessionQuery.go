@@ -0,0 +1,73 @@
+package db
+
+import (
+	"time"
+)
+
+// DeleteSession removes a Session from the database
+func (s *SqlBackend) DeleteSession(session *Session) error {
+	// Attempt to delete this session by its ID, if available
+	tx := s.db.MustBegin()
+	if session.ID != 0 {
+		tx.Exec("DELETE FROM sessions WHERE id = ?;", session.ID)
+		return tx.Commit()
+	}
+
+	// Else, attempt to remove the session by its key
+	tx.Exec("DELETE FROM sessions WHERE key = ?;", session.Key)
+	return tx.Commit()
+}
+
+// LoadSession loads a Session from the database, populating the parameter
+// struct
+func (s *SqlBackend) LoadSession(session *Session) error {
+	// Load the session via ID if available
+	if session.ID != 0 {
+		if err := s.db.Get(session,
+			"SELECT * FROM sessions WHERE id = ?;", session.ID); err != nil {
+			return err
+		}
+		return nil
+	}
+
+	// Load via key
+	if err := s.db.Get(session,
+		"SELECT * FROM sessions WHERE key = ?;", session.Key); err != nil {
+		return err
+	}
+	return nil
+}
+
+// PurgeSessions removes all expired sessions from the database
+func (s *SqlBackend) PurgeSessions() error {
+	tx := s.db.MustBegin()
+	tx.Exec("DELETE FROM sessions WHERE expire < ?;", time.Now().Unix())
+	return tx.Commit()
+}
+
+// SaveSession attempts to save a Session to the database
+func (s *SqlBackend) SaveSession(session *Session) error {
+	query := `INSERT INTO sessions
+		(user_id, client, expire, key)
+		VALUES (?, ?, ?, ?);`
+	tx := s.db.MustBegin()
+	if _, err := tx.Exec(
+		query, session.UserID, session.Client, session.Expire, session.Key);
+	err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Commit transaction
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// If no ID, reload to grab it
+	if session.ID == 0 {
+		if err := s.LoadSession(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// The roles an artist is credited with on a song
+const (
+	CreditPrimary  = "primary"
+	CreditFeatured = "featured"
+	CreditRemixer  = "remixer"
+	CreditComposer = "composer"
+)
+
+// VariousArtists is the album artist of compilations without one
+const VariousArtists = "Various Artists"
+
+// SongArtist credits an artist with a role on a song. Position orders the
+// artists credited with the same role.
+type SongArtist struct {
+	SongID   int    `db:"song_id" json:"-"`
+	ArtistID int    `db:"artist_id" json:"artistId"`
+	Artist   string `json:"artist"`
+	Role     string `json:"role"`
+	Position int    `json:"position"`
+}
+
+// LoadCredits fills in the Credits of each song in the slice
+func (s SongSlice) LoadCredits() error {
+	IDs := make([]int, 0, len(s))
+	for _, song := range s {
+		IDs = append(IDs, song.ID)
+	}
+
+	credits, err := DB.CreditsForSongs(IDs)
+	if err != nil {
+		return err
+	}
+	for i := range s {
+		s[i].Credits = credits[s[i].ID]
+	}
+	return nil
+}
+
+// songCredits returns the artists credited on a song from its tags. The
+// primary artists are those of the artist tag, before any featured marker, or
+// the album artist when it has none. Featured artists follow a marker in the
+// artist tag or the title. The artist IDs are filled in when the song is
+// scanned.
+func songCredits(props map[string]string, albumArtist, title string) []SongArtist {
+	main, featured := splitFeatured(props["artist"])
+	_, titleFeatured := splitFeatured(title)
+
+	primary := splitArtists(main)
+	if len(primary) == 0 && albumArtist != "" {
+		primary = []string{albumArtist}
+	}
+
+	credits := make([]SongArtist, 0)
+	add := func(role string, names []string) {
+		for i, name := range names {
+			credits = append(credits, SongArtist{Artist: name, Role: role, Position: i})
+		}
+	}
+	add(CreditPrimary, primary)
+	add(CreditFeatured, uniqueNames(
+		append(splitArtists(featured), splitArtists(titleFeatured)...)))
+	add(CreditRemixer, splitArtists(props["remixer"]))
+	add(CreditComposer, splitArtists(props["composer"]))
+	return credits
+}
+
+// splitArtists splits a tag into its artists at any of the configured artist
+// separators, ignoring case
+func splitArtists(tag string) []string {
+	return splitTag(tag, util.Current().Tags.ArtistSeparators)
+}
+
+// splitTag splits a tag holding several values at any of the separators,
+// ignoring case, dropping the empty and repeated values
+func splitTag(tag string, separators []string) []string {
+	parts := []string{tag}
+	for _, sep := range separators {
+		split := make([]string, 0, len(parts))
+		for _, p := range parts {
+			split = append(split, splitFold(p, sep)...)
+		}
+		parts = split
+	}
+
+	names := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			names = append(names, p)
+		}
+	}
+	return uniqueNames(names)
+}
+
+// splitFold splits s at each instance of sep, ignoring case
+func splitFold(s, sep string) []string {
+	if sep == "" {
+		return []string{s}
+	}
+	lower, lowerSep := strings.ToLower(s), strings.ToLower(sep)
+	// Lowering may change the length of some characters
+	if len(lower) != len(s) || len(lowerSep) != len(sep) {
+		return strings.Split(s, sep)
+	}
+
+	parts := make([]string, 0)
+	for {
+		i := strings.Index(lower, lowerSep)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s, lower = s[i+len(sep):], lower[i+len(sep):]
+	}
+}
+
+// splitFeatured splits a tag at the first of the configured featured markers,
+// returning what comes before it and the featured artists after it. A marker
+// is a word of its own, and may open parentheses or brackets, as in
+// "Title (feat. Artist)", which are removed.
+func splitFeatured(tag string) (string, string) {
+	lower := strings.ToLower(tag)
+	if len(lower) != len(tag) {
+		return tag, ""
+	}
+
+	at, end := -1, 0
+	for _, m := range util.Current().Tags.FeaturedMarkers {
+		m = strings.ToLower(m)
+		for from := 0; ; {
+			i := strings.Index(lower[from:], m)
+			if i < 0 {
+				break
+			}
+			i += from
+			from = i + len(m)
+
+			before := i > 0 && strings.ContainsAny(lower[i-1:i], " ([")
+			after := from < len(lower) && lower[from] == ' '
+			if before && after && (at < 0 || i < at) {
+				at, end = i, from
+				break
+			}
+		}
+	}
+	if at < 0 {
+		return tag, ""
+	}
+
+	main := tag[:at]
+	featured := tag[end:]
+	if open := main[len(main)-1]; open == '(' || open == '[' {
+		main = main[:len(main)-1]
+		closing := ")"
+		if open == '[' {
+			closing = "]"
+		}
+		if i := strings.Index(featured, closing); i >= 0 {
+			featured = featured[:i]
+		}
+	}
+	return strings.TrimSpace(main), strings.TrimSpace(featured)
+}
+
+// uniqueNames removes the repeated names, ignoring case and accents, keeping
+// the order
+func uniqueNames(names []string) []string {
+	seen := make(map[string]bool)
+	unique := make([]string, 0, len(names))
+	for _, name := range names {
+		key := strings.ToLower(util.NormalizeString(name))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, name)
+	}
+	return unique
+}
+
+// isCompilation reports whether the compilation tag, which TCMP is read as,
+// marks a song as being part of a compilation
+func isCompilation(props map[string]string) bool {
+	c, err := strconv.ParseBool(strings.TrimSpace(props["compilation"]))
+	return err == nil && c
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+// SaveSongArtists replaces the credits of a batch of songs with those in their
+// Credits, in a single transaction. Credits whose artist has no ID are
+// skipped.
+func (s *SqlBackend) SaveSongArtists(songs []*Song) error {
+	tx := s.db.MustBegin()
+	for _, song := range songs {
+		if _, err := tx.Exec("DELETE FROM song_artists WHERE song_id = ?;", song.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		for _, c := range song.Credits {
+			if c.ArtistID == 0 {
+				continue
+			}
+			// An artist named twice with a role is credited once
+			if _, err := tx.Exec(
+				`INSERT OR IGNORE INTO song_artists (song_id, artist_id, role, position)
+				VALUES (?, ?, ?, ?);`,
+				song.ID, c.ArtistID, c.Role, c.Position,
+			); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// CreditsForSongs loads the credits of each of the given song IDs, keyed by
+// song ID, with the primary artists first
+func (s *SqlBackend) CreditsForSongs(IDs []int) (map[int][]SongArtist, error) {
+	credits := make(map[int][]SongArtist)
+	err := s.inQuery(
+		`SELECT
+			song_artists.*,
+			artists.title AS artist
+		FROM song_artists
+		JOIN artists ON song_artists.artist_id = artists.id
+		WHERE song_artists.song_id IN (?)
+		ORDER BY
+			song_artists.song_id,
+			CASE song_artists.role
+				WHEN 'primary' THEN 0
+				WHEN 'featured' THEN 1
+				WHEN 'remixer' THEN 2
+				ELSE 3
+			END,
+			song_artists.position;`,
+		IDs,
+		func(rows *sqlx.Rows) error {
+			c := SongArtist{}
+			if err := rows.StructScan(&c); err != nil {
+				return err
+			}
+			credits[c.SongID] = append(credits[c.SongID], c)
+			return nil
+		},
+	)
+	return credits, err
+}
@@ -82,15 +82,55 @@ type Song struct {
 	FileSize        int64  `db:"file_size" json:"fileSize"`
 	FileTypeID      int    `db:"file_type_id" json:"fileTypeId"`
 	FolderID        int    `db:"folder_id" json:"folderId"`
+	LibraryID       int    `db:"library_id" json:"libraryId"`
+	ArtID           int    `db:"art_id" json:"artId"`
 	Genre           string `json:"genre"`
 	LastModified    int64  `db:"last_modified" json:"lastModified"`
+	// Added is the UNIX time the song was added to the library
+	Added           int64  `db:"added" json:"added"`
 	Length          int    `json:"length"`
 	SampleRate      int    `db:"sample_rate" json:"sampleRate"`
 	Title           string `json:"title"`
 	NormalizedTitle string `db:"normalized_title" json:"normalizedTitle"`   
 	Track           int    `json:"track"`
-	Disc 						string `json:"disc"`
+	Disc            int    `json:"disc"`
+	DiscTotal       int    `db:"disc_total" json:"discTotal"`
+	TrackTotal      int    `db:"track_total" json:"trackTotal"`
+	DiscSubtitle    string `db:"disc_subtitle" json:"discSubtitle"`
 	Year            int    `json:"year"`
+	Inode           int64  `json:"-"`
+	Fingerprint     string `json:"-"`
+	// TrackArtist is the song's artist tag, where Artist is its album artist
+	TrackArtist     string `db:"track_artist" json:"trackArtist"`
+	// Compilation is read from the tags, and marks the song's album
+	Compilation     bool         `db:"-" json:"-"`
+	Credits         []SongArtist `db:"-" json:"credits,omitempty"`
+	// Genres are the genres split from Genre
+	Genres          []string     `db:"-" json:"-"`
+	// ArtistSort and AlbumSort are read from the sort tags, and are the sort
+	// titles of the song's artist and album
+	ArtistSort      string       `db:"-" json:"-"`
+	AlbumSort       string       `db:"-" json:"-"`
+}
+
+// SongFile is the file information of a song in the database, which is
+// compared with the file on disk to find songs changed since they were scanned
+type SongFile struct {
+	ID           int    `json:"id"`
+	AlbumID      int    `db:"album_id" json:"albumId"`
+	ArtistID     int    `db:"artist_id" json:"artistId"`
+	ArtID        int    `db:"art_id" json:"artId"`
+	Path         string `json:"path"`
+	FileSize     int64  `db:"file_size" json:"fileSize"`
+	LastModified int64  `db:"last_modified" json:"lastModified"`
+	Inode        int64  `json:"-"`
+	Fingerprint  string `json:"-"`
+}
+
+// Unchanged reports whether a file of the given size and modification time is
+// the one which was scanned
+func (f SongFile) Unchanged(size, lastModified int64) bool {
+	return f.FileSize == size && f.LastModified == lastModified
 }
 
 // SongFromFile creates a new Song from a file, extracting its tags and properties
@@ -112,18 +152,40 @@ func SongFromFile(file string) (*Song, error) {
 	title  := props["title"]
 	normalizedTitle := util.NormalizeString(title)
 
+	if title == "" {
+		title = "UNKNOWN SONG TITLE"
+		errs += "No song title provided\n"
+	}
+
+	// Songs without an album artist are filed under their first artist, or
+	// under Various Artists when they are part of a compilation
+	compilation := isCompilation(props)
+	credits := songCredits(props, artist, title)
+	if artist == "" && compilation {
+		artist = VariousArtists
+	} else if artist == "" && len(credits) > 0 && credits[0].Role == CreditPrimary {
+		artist = credits[0].Artist
+	}
 	if artist == "" {
 		artist = "UNKNOWN ALBUM ARTIST"
 		errs += "No album artist provided\n"
 	}
+
+	// The artist sort tag only applies when the whole artist tag is the artist
+	artistSort := props["albumartistsort"]
+	if props["albumartist"] == "" {
+		artistSort = ""
+		if artist == props["artist"] {
+			artistSort = props["artistsort"]
+		}
+	}
+	if len(credits) == 0 || credits[0].Role != CreditPrimary {
+		credits = append([]SongArtist{{Artist: artist, Role: CreditPrimary}}, credits...)
+	}
 	if album == "" {
 		album = "UNKNOWN ALBUM TITLE"
 		errs += "No album title provided\n"
 	}
-	if title == "" {
-		title = "UNKNOWN SONG TITLE"
-		errs += "No song title provided\n"
-	}
 
 	// Retrieve all properties, check for empty
 	// Note: length will probably be more useful as an integer, and a Duration method can
@@ -137,17 +199,23 @@ func SongFromFile(file string) (*Song, error) {
 		return nil, ErrSongProperties
 	}
 
-	discNum := props["discnumber"]
-	if discNum == "" {
-		discNum = "01/01"
+	// Disc numbers may be stored as "1/2", default to the first disc
+	discNum, discTotal, err := splitNumber(props["discnumber"])
+	if err != nil || discNum < 1 {
+		discNum = 1
+	}
+	if discTotal == 0 {
+		discTotal = firstNumber(props, "disctotal", "totaldiscs")
 	}
 
-	trackNumStr := strings.Split(props["tracknumber"], "/")[0]
-	trackNum, err := strconv.Atoi(trackNumStr)
+	trackNum, trackTotal, err := splitNumber(props["tracknumber"])
 	if err != nil {
 		trackNum = 1
 		errs += "Song missing track number property\n"
 	}
+	if trackTotal == 0 {
+		trackTotal = firstNumber(props, "tracktotal", "totaltracks")
+	}
 
 	sYear := props["date"]
 	year := 0
@@ -174,10 +242,52 @@ func SongFromFile(file string) (*Song, error) {
 		NormalizedTitle: normalizedTitle,
 		Track:           trackNum,
 		Disc:						 discNum,
+		DiscTotal:       discTotal,
+		TrackTotal:      trackTotal,
+		DiscSubtitle:    props["discsubtitle"],
 		Year:            year,
+		TrackArtist:     props["artist"],
+		Compilation:     compilation,
+		Credits:         credits,
+		Genres:          splitGenres(props["genre"]),
+		ArtistSort:      strings.TrimSpace(artistSort),
+		AlbumSort:       strings.TrimSpace(props["albumsort"]),
 	}, err
 }
 
+// splitNumber parses a tag holding a number, which may be followed by a total
+// as in "3/12". The total is zero when it is missing or invalid.
+func splitNumber(tag string) (int, int, error) {
+	parts := strings.SplitN(tag, "/", 2)
+	n, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	total := 0
+	if len(parts) == 2 {
+		total, _ = strconv.Atoi(strings.TrimSpace(parts[1]))
+	}
+	return n, total, nil
+}
+
+// firstNumber returns the number held by the first of the tags to hold one,
+// or zero when none do
+func firstNumber(props map[string]string, tags ...string) int {
+	for _, tag := range tags {
+		if n, err := strconv.Atoi(strings.TrimSpace(props[tag])); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// AccessibleBy reports whether a user may access the library the song was
+// scanned from
+func (s *Song) AccessibleBy(u *User) (bool, error) {
+	return DB.LibraryAccessibleBy(s.LibraryID, u)
+}
+
 // Delete removes an existing Song from the database
 func (s *Song) Delete() error {
 	return DB.DeleteSong(s)
@@ -198,8 +308,9 @@ func (s *Song) Update() error {
 	return DB.UpdateSong(s)
 }
 
-// Stream generates a binary file stream from this Song's file location
-func (s Song) Stream() (io.ReadSeeker, error) {
+// Stream generates a binary file stream from this Song's file location. The
+// caller is responsible for closing the stream.
+func (s Song) Stream() (io.ReadSeekCloser, error) {
 	// Attempt to open the file associated with this song
 	return os.Open(s.Path)
 }
@@ -219,6 +330,30 @@ func (s SongSlice) Length() int {
 	return length
 }
 
+// Disc is one disc of an album, with its songs
+type Disc struct {
+	Disc     int    `json:"disc"`
+	Subtitle string `json:"subtitle,omitempty"`
+	Songs    []Song `json:"songs"`
+}
+
+// Discs groups a slice of songs sorted by disc, such as an album's, by their
+// disc. A disc's subtitle is the first one found on its songs.
+func (s SongSlice) Discs() []Disc {
+	discs := make([]Disc, 0)
+	for _, song := range s {
+		if len(discs) == 0 || discs[len(discs)-1].Disc != song.Disc {
+			discs = append(discs, Disc{Disc: song.Disc, Songs: make([]Song, 0)})
+		}
+		d := &discs[len(discs)-1]
+		if d.Subtitle == "" {
+			d.Subtitle = song.DiscSubtitle
+		}
+		d.Songs = append(d.Songs, song)
+	}
+	return discs
+}
+
 // String is a method that returns a string with simple information about this
 // object.
 func (s Song) String() string {
@@ -2,6 +2,9 @@ package db
 
 import (
 	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
 )
 
 // songQuery loads a slice of Song structs matching the input query
@@ -79,6 +82,25 @@ func (s *SqlBackend) LimitSongs(offset int, count int) ([]Song, error) {
 	)
 }
 
+// LimitSongsInLibraries loads a slice of the Song structs in the given
+// libraries, or in every library when IDs is nil, using an offset and an item
+// count
+func (s *SqlBackend) LimitSongsInLibraries(IDs []int, offset int, count int) ([]Song, error) {
+	cond, args := inLibraries("songs.library_id", IDs)
+	return s.songQuery(
+		`SELECT 
+			songs.*,
+			artists.title AS artist,
+			albums.title AS album 
+		FROM songs
+		JOIN artists ON songs.artist_id = artists.id 
+		JOIN albums ON songs.album_id = albums.id 
+		WHERE `+cond+`
+		LIMIT ?, ?;`,
+		append(args, offset, count)...,
+	)
+}
+
 // RandomSongs loads a slice of 'n' random song structs from the database
 func (s *SqlBackend) RandomSongs(n int) ([]Song, error) {
 	return s.songQuery(
@@ -94,23 +116,26 @@ func (s *SqlBackend) RandomSongs(n int) ([]Song, error) {
 	)
 }
 
-// SearchSongs loads a slice of all Song structs from the database which contain
-// titles that match the specified search query
-func (s *SqlBackend) SearchSongs(query string) ([]Song, error) {
+// RandomSongsInLibraries loads a slice of 'n' random Song structs in the given
+// libraries, or in every library when IDs is nil
+func (s *SqlBackend) RandomSongsInLibraries(IDs []int, n int) ([]Song, error) {
+	cond, args := inLibraries("songs.library_id", IDs)
 	return s.songQuery(
-		`SELECT 
+		`SELECT
 			songs.*,
 			artists.title AS artist,
-			albums.title AS album 
+			albums.title AS album
 		FROM songs
-		JOIN artists ON songs.artist_id = artists.id 
+		JOIN artists ON songs.artist_id = artists.id
 		JOIN albums ON songs.album_id = albums.id
-		WHERE songs.normalized_title LIKE ?;`,
-		"%"+query+"%",
+		WHERE `+cond+`
+		ORDER BY RANDOM() LIMIT ?;`,
+		append(args, n)...,
 	)
 }
 
-// SongsForAlbum loads a slice of all Song structs which have the matching album ID
+// SongsForAlbum loads a slice of all Song structs which have the matching
+// album ID, sorted by disc and then track
 func (s *SqlBackend) SongsForAlbum(ID int) ([]Song, error) {
 	return s.songQuery(
 		`SELECT 
@@ -120,12 +145,14 @@ func (s *SqlBackend) SongsForAlbum(ID int) ([]Song, error) {
 		FROM songs
 		JOIN artists ON songs.artist_id = artists.id 
 		JOIN albums ON songs.album_id = albums.id
-		WHERE songs.album_id = ?;`,
+		WHERE songs.album_id = ?
+		ORDER BY songs.disc, songs.track, songs.title COLLATE NOCASE;`,
 		ID,
 	)
 }
 
-// SongsForArtist loads a slice of all Song structs which have the matching artist ID
+// SongsForArtist loads a slice of all Song structs which have the matching
+// artist ID, or which credit the artist
 func (s *SqlBackend) SongsForArtist(ID int) ([]Song, error) {
 	return s.songQuery(
 		`SELECT 
@@ -135,8 +162,10 @@ func (s *SqlBackend) SongsForArtist(ID int) ([]Song, error) {
 		FROM songs
 		JOIN artists ON songs.artist_id = artists.id 
 		JOIN albums ON songs.album_id = albums.id
-		WHERE songs.artist_id = ?;`, 
-		ID,
+		WHERE songs.artist_id = ? OR songs.id IN (
+			SELECT song_id FROM song_artists WHERE artist_id = ?
+		);`, 
+		ID, ID,
 	)
 }
 
@@ -171,6 +200,146 @@ func (s *SqlBackend) SongsInPath(path string) ([]Song, error) {
 	)
 }
 
+// SongFilesInPath loads the file information of every song residing under the
+// specified filesystem path, keyed by path
+func (s *SqlBackend) SongFilesInPath(path string) (map[string]SongFile, error) {
+	rows, err := s.db.Queryx(
+		`SELECT id, album_id, artist_id, art_id, path, file_size, last_modified, inode,
+			fingerprint
+		FROM songs WHERE path LIKE ?;`,
+		path+"%",
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := make(map[string]SongFile)
+	for rows.Next() {
+		var f SongFile
+		if err := rows.StructScan(&f); err != nil {
+			return nil, err
+		}
+		files[f.Path] = f
+	}
+	return files, rows.Err()
+}
+
+// SongFilesWithInode loads the file information of every song in a library
+// with the specified inode
+func (s *SqlBackend) SongFilesWithInode(libraryID int, inode int64) ([]SongFile, error) {
+	files := make([]SongFile, 0)
+	err := s.db.Select(&files,
+		`SELECT id, album_id, artist_id, art_id, path, file_size, last_modified, inode,
+			fingerprint
+		FROM songs WHERE library_id = ? AND inode = ?;`,
+		libraryID, inode,
+	)
+	return files, err
+}
+
+// SongsWithFingerprint loads a slice of all Song structs in a library whose
+// audio has the specified fingerprint
+func (s *SqlBackend) SongsWithFingerprint(libraryID int, fingerprint string) ([]Song, error) {
+	return s.songQuery(
+		`SELECT 
+			songs.*, 
+			artists.title AS artist,
+			albums.title AS album
+		FROM songs 
+		JOIN artists ON songs.artist_id = artists.id 
+		JOIN albums ON songs.album_id = albums.id 
+		WHERE songs.library_id = ? AND songs.fingerprint = ?;`,
+		libraryID, fingerprint,
+	)
+}
+
+// MoveSong moves a song's file to a new path and folder, keeping its ID
+func (s *SqlBackend) MoveSong(f *SongFile, newPath string, folderID int) error {
+	tx := s.db.MustBegin()
+	if _, err := tx.Exec(
+		"UPDATE songs SET path = ?, folder_id = ? WHERE id = ?;",
+		newPath, folderID, f.ID,
+	); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	f.Path = newPath
+	return nil
+}
+
+// UpdateSongFingerprints records the fingerprints of songs, keyed by song ID,
+// in a single transaction
+func (s *SqlBackend) UpdateSongFingerprints(fingerprints map[int]string) error {
+	tx := s.db.MustBegin()
+	for ID, fingerprint := range fingerprints {
+		if _, err := tx.Exec(
+			"UPDATE songs SET fingerprint = ? WHERE id = ?;", fingerprint, ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UpdateSongArt records the embedded art of songs, keyed by song ID, in a
+// single transaction
+func (s *SqlBackend) UpdateSongArt(art map[int]int) error {
+	tx := s.db.MustBegin()
+	for ID, artID := range art {
+		if _, err := tx.Exec("UPDATE songs SET art_id = ? WHERE id = ?;", artID, ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SongFilesWithArt loads the file information of every song whose tags hold
+// the specified embedded art
+func (s *SqlBackend) SongFilesWithArt(artID int) ([]SongFile, error) {
+	files := make([]SongFile, 0)
+	err := s.db.Select(&files,
+		`SELECT id, album_id, artist_id, art_id, path, file_size, last_modified, inode,
+			fingerprint
+		FROM songs WHERE art_id = ?;`,
+		artID,
+	)
+	return files, err
+}
+
+// UpdateSongInodes records the inodes of songs, keyed by song ID, in a single
+// transaction
+func (s *SqlBackend) UpdateSongInodes(inodes map[int]int64) error {
+	tx := s.db.MustBegin()
+	for ID, inode := range inodes {
+		if _, err := tx.Exec("UPDATE songs SET inode = ? WHERE id = ?;", inode, ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SongsWithPathSuffix loads a slice of all Song structs whose path ends with
+// the specified suffix from the database
+func (s *SqlBackend) SongsWithPathSuffix(suffix string) ([]Song, error) {
+	return s.songQuery(
+		`SELECT 
+			songs.*,
+			artists.title AS artist,
+			albums.title AS album
+		FROM songs 
+		JOIN artists ON songs.artist_id = artists.id 
+		JOIN albums ON songs.album_id = albums.id 
+		WHERE songs.path LIKE ?;`, 
+		"%"+suffix,
+	)
+}
+
 // SongsNotInPath loads a slice of all Song structs that do not reside under the specified
 // filesystem path from the database
 func (s *SqlBackend) SongsNotInPath(path string) ([]Song, error) {
@@ -187,6 +356,22 @@ func (s *SqlBackend) SongsNotInPath(path string) ([]Song, error) {
 	)
 }
 
+// SongsOutsideLibrary loads a slice of all Song structs belonging to a
+// library, or to no library, which do not reside under the library's root
+func (s *SqlBackend) SongsOutsideLibrary(l *Library) ([]Song, error) {
+	return s.songQuery(
+		`SELECT 
+			songs.*, 
+			artists.title AS artist,
+			albums.title AS album
+		FROM songs 
+		JOIN artists ON songs.artist_id = artists.id 
+		JOIN albums ON songs.album_id = albums.id 
+		WHERE songs.library_id IN (0, ?) AND songs.path NOT LIKE ?;`,
+		l.ID, l.Root+"/%",
+	)
+}
+
 // CountSongs fetches the total number of Artist structs from the database
 func (s *SqlBackend) CountSongs() (int64, error) {
 	return s.integerQuery("SELECT COUNT(*) AS int FROM songs;")
@@ -248,14 +433,24 @@ func (s *SqlBackend) LoadSong(a *Song) error {
 
 // SaveSong attempts to save a Song to the database
 func (s *SqlBackend) SaveSong(a *Song) error {
-	// Insert new song
+	return s.SaveSongs([]*Song{a})
+}
+
+// SaveSongs attempts to save a batch of Songs to the database in a single
+// transaction, setting their IDs
+func (s *SqlBackend) SaveSongs(songs []*Song) error {
+	// Insert new songs
 	query := `INSERT INTO songs (
 			mb_id, album_id, artist_id,
 			bitrate, channels, comment, 
 			path, file_size, file_type_id, 
 			folder_id, genre, last_modified, 
 			length, sample_rate, title,	
-			normalized_title, track, year
+			normalized_title, track, year,
+			disc, library_id, inode,
+			fingerprint, art_id, disc_total,
+			track_total, disc_subtitle, track_artist,
+			added
 		)  
 		VALUES (
 			?, ?, ?, 
@@ -263,54 +458,140 @@ func (s *SqlBackend) SaveSong(a *Song) error {
 			?, ?, ?, 
 			?, ?, ?,
 			?, ?, ?, 
-			?, ?, ?
+			?, ?, ?,
+			?, ?, ?,
+			?, ?, ?,
+			?, ?, ?,
+			?
 		);`
 	tx := s.db.MustBegin()
-	tx.MustExec(
-		query, 
-		a.MBID, a.AlbumID, a.ArtistID,
-		a.Bitrate, a.Channels, a.Comment,
-		a.Path, a.FileSize, a.FileTypeID,
-		a.FolderID, a.Genre, a.LastModified,
-		a.Length, a.SampleRate, a.Title,
-		a.NormalizedTitle, a.Track, a.Year,
-	)
-
-	// Commit transaction
-	if err := tx.Commit(); err != nil {
-		return err
-	}
+	for _, a := range songs {
+		if a.Added == 0 {
+			a.Added = time.Now().Unix()
+		}
+		res, err := tx.Exec(
+			query, 
+			a.MBID, a.AlbumID, a.ArtistID,
+			a.Bitrate, a.Channels, a.Comment,
+			a.Path, a.FileSize, a.FileTypeID,
+			a.FolderID, a.Genre, a.LastModified,
+			a.Length, a.SampleRate, a.Title,
+			a.NormalizedTitle, a.Track, a.Year,
+			a.Disc, a.LibraryID, a.Inode,
+			a.Fingerprint, a.ArtID, a.DiscTotal,
+			a.TrackTotal, a.DiscSubtitle, a.TrackArtist,
+			a.Added,
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
 
-	// If no ID, reload to grab it
-	if a.ID == 0 {
-		if err := s.LoadSong(a); err != nil {
+		ID, err := res.LastInsertId()
+		if err != nil {
+			tx.Rollback()
 			return err
 		}
+		a.ID = int(ID)
 	}
-	return nil
+
+	// Commit transaction
+	return tx.Commit()
 }
 
 // UpdateSong attempts to update a Song in the database
 func (s *SqlBackend) UpdateSong(a *Song) error {
-	// Update existing song
+	return s.UpdateSongs([]*Song{a})
+}
+
+// UpdateSongs attempts to update a batch of Songs in the database in a single
+// transaction
+func (s *SqlBackend) UpdateSongs(songs []*Song) error {
+	tx := s.db.MustBegin()
+	for _, a := range songs {
+		if err := updateSong(tx, a); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// updateSong updates a Song within a transaction. Its path is updated too, so
+// moved songs keep their ID. An empty MBID keeps the one already stored,
+// which was found by a third party lookup rather than read from the tags.
+func updateSong(tx *sqlx.Tx, a *Song) error {
 	query := `UPDATE songs 
 		SET 
-			mb_id = ?, album_id = ?, artist_id = ?, 
+			mb_id = COALESCE(NULLIF(?, ''), mb_id), album_id = ?, artist_id = ?, 
 			bitrate = ?, channels = ?, comment = ?,
-			file_size = ?, folder_id = ?, genre = ?,
+			path = ?, file_size = ?, folder_id = ?, genre = ?,
 			last_modified = ?, length = ?, sample_rate = ?, 
-			title = ?, track = ?, year = ? 
+			title = ?, track = ?, year = ?,
+			disc = ?, library_id = ?, inode = ?,
+			fingerprint = ?, art_id = ?, disc_total = ?,
+			track_total = ?, disc_subtitle = ?, track_artist = ?
 		WHERE id = ?;`
-	tx := s.db.MustBegin()
-	tx.Exec(
+	_, err := tx.Exec(
 		query, 
 		a.MBID, a.AlbumID, a.ArtistID,
 		a.Bitrate, a.Channels, a.Comment, 
-		a.FileSize, a.FolderID, a.Genre, 
+		a.Path, a.FileSize, a.FolderID, a.Genre, 
 		a.LastModified, a.Length, a.SampleRate, 
 		a.Title, a.Track, a.Year,
+		a.Disc, a.LibraryID, a.Inode,
+		a.Fingerprint, a.ArtID, a.DiscTotal,
+		a.TrackTotal, a.DiscSubtitle, a.TrackArtist,
 		a.ID,
 	)
+	return err
+}
 
-	return tx.Commit()
-}
\ No newline at end of file
+// MergeSong replaces a song whose file is missing with a song scanned from a
+// copy of it, in a single transaction. The missing song keeps its ID and MBID,
+// takes on everything else from the copy, including its credits and genres,
+// and gains the copy's plays and playlist entries. The copy is removed.
+func (s *SqlBackend) MergeSong(missing *Song, found *Song) error {
+	tx := s.db.MustBegin()
+	for _, query := range []string{
+		"DELETE FROM song_artists WHERE song_id = ?;",
+		"DELETE FROM song_genres WHERE song_id = ?;",
+	} {
+		if _, err := tx.Exec(query, missing.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, query := range []string{
+		"UPDATE song_artists SET song_id = ? WHERE song_id = ?;",
+		"UPDATE song_genres SET song_id = ? WHERE song_id = ?;",
+		"UPDATE plays SET song_id = ? WHERE song_id = ?;",
+		"UPDATE playlist_items SET song_id = ? WHERE song_id = ?;",
+	} {
+		if _, err := tx.Exec(query, missing.ID, found.ID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// The copy must be gone before its path is taken
+	if _, err := tx.Exec("DELETE FROM songs WHERE id = ?;", found.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	merged := *found
+	merged.ID = missing.ID
+	merged.MBID = missing.MBID
+	if err := updateSong(tx, &merged); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	*missing = merged
+	return nil
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// ArtistIndex is a group of artists whose sort titles start with the same
+// letter, or with something other than a letter when its name is "#"
+type ArtistIndex struct {
+	Name    string   `json:"name"`
+	Artists []Artist `json:"artists"`
+}
+
+// SortTitle returns the title an artist or album is sorted by, which is its
+// sort title when it has one, or else its title without any leading ignored
+// article
+func SortTitle(title, sortTitle string) string {
+	if sortTitle != "" {
+		return sortTitle
+	}
+
+	for _, article := range util.Current().Tags.IgnoredArticles {
+		prefix := article + " "
+		if len(title) > len(prefix) && strings.EqualFold(title[:len(prefix)], prefix) {
+			return title[len(prefix):]
+		}
+	}
+	return title
+}
+
+// IndexName returns the name of the index an artist belongs in, which is the
+// first letter of their sort title in upper case, or "#" when it does not
+// start with a letter
+func IndexName(title, sortTitle string) string {
+	r, _ := utf8.DecodeRuneInString(SortTitle(title, sortTitle))
+	if unicode.IsLetter(r) {
+		return strings.ToUpper(string(r))
+	}
+	return "#"
+}
+
+// IndexArtists groups artists by the index they belong in, keeping the order
+// of the artists and of the first artist in each index
+func IndexArtists(artists []Artist) []ArtistIndex {
+	indexes := make([]ArtistIndex, 0)
+	positions := make(map[string]int)
+	for _, a := range artists {
+		name := IndexName(a.Title, a.SortTitle)
+		i, ok := positions[name]
+		if !ok {
+			i = len(indexes)
+			positions[name] = i
+			indexes = append(indexes, ArtistIndex{Name: name, Artists: make([]Artist, 0)})
+		}
+		indexes[i].Artists = append(indexes[i].Artists, a)
+	}
+	return indexes
+}
+
+// sortBy returns an SQL expression ordering the rows of table, which is either
+// artists or albums, by their sort title as SortTitle returns it, ignoring case
+func sortBy(table string) string {
+	title := table + ".title"
+	expr := "CASE WHEN " + table + ".sort_title <> '' THEN " + table + ".sort_title"
+	for _, article := range util.Current().Tags.IgnoredArticles {
+		prefix := strings.ToLower(article) + " "
+		n := utf8.RuneCountInString(prefix)
+		expr += fmt.Sprintf(
+			" WHEN length(%s) > %d AND lower(substr(%s, 1, %d)) = '%s' THEN substr(%s, %d)",
+			title, n, title, n, strings.ReplaceAll(prefix, "'", "''"), title, n+1)
+	}
+	return expr + " ELSE " + title + " END COLLATE NOCASE"
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Constants representing the roles a user may have. Lower role IDs carry
+// more privileges.
+const (
+	RoleAdmin = iota + 1
+	RoleUser
+	RoleGuest
+)
+
+// RoleMap maps role IDs to their names
+var RoleMap = map[int]string{
+	RoleAdmin: "admin",
+	RoleUser:  "user",
+	RoleGuest: "guest",
+}
+
+var (
+	// ErrUnknownRole is returned when a role name or ID does not exist
+	ErrUnknownRole = errors.New("user: unknown role")
+	// ErrEmptyPassword is returned when attempting to set an empty password
+	ErrEmptyPassword = errors.New("user: password must not be empty")
+)
+
+// User represents a user account
+type User struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Password string `json:"-"`
+	RoleID   int    `db:"role_id" json:"roleId"`
+	// LastFMToken is the Last.fm session key plays are submitted with
+	LastFMToken string `db:"lastfm_token" json:"-"`
+	// ListenBrainzToken is the user token plays are submitted with
+	ListenBrainzToken string `db:"listenbrainz_token" json:"-"`
+}
+
+// RoleID returns the ID of the role with the given name
+func RoleID(name string) (int, error) {
+	for id, n := range RoleMap {
+		if n == name {
+			return id, nil
+		}
+	}
+	return 0, ErrUnknownRole
+}
+
+// NewUser creates a new User with a hashed password. The user is not saved.
+func NewUser(username, password string, roleID int) (*User, error) {
+	if _, ok := RoleMap[roleID]; !ok {
+		return nil, ErrUnknownRole
+	}
+
+	u := &User{
+		Username: username,
+		RoleID:   roleID,
+	}
+	if err := u.SetPassword(password); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// SetPassword hashes a password with bcrypt and stores the hash
+func (u *User) SetPassword(password string) error {
+	if password == "" {
+		return ErrEmptyPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u.Password = string(hash)
+	return nil
+}
+
+// CheckPassword reports whether password matches the stored hash
+func (u *User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) == nil
+}
+
+// HasRole reports whether the user holds at least the privileges of role
+func (u *User) HasRole(role int) bool {
+	return u.RoleID != 0 && u.RoleID <= role
+}
+
+// Role returns the name of the user's role
+func (u *User) Role() string {
+	return RoleMap[u.RoleID]
+}
+
+// Delete removes an existing User from the database
+func (u *User) Delete() error {
+	return DB.DeleteUser(u)
+}
+
+// Load pulls an existing User from the database
+func (u *User) Load() error {
+	return DB.LoadUser(u)
+}
+
+// Save creates a new User in the database
+func (u *User) Save() error {
+	return DB.SaveUser(u)
+}
+
+// Update saves an existing User in the database
+func (u *User) Update() error {
+	return DB.UpdateUser(u)
+}
+
+// String is a method that returns a string with simple information about this
+// object.
+func (u User) String() string {
+	return u.Username
+}
@@ -0,0 +1,138 @@
+package db
+
+import (
+	"database/sql"
+)
+
+// userQuery loads a slice of User structs matching the input query
+func (s *SqlBackend) userQuery(query string, args ...interface{}) ([]User, error) {
+	// Perform input query with arguments
+	rows, err := s.db.Queryx(query, args...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Iterate all rows
+	users := make([]User, 0)
+	u := User{}
+	for rows.Next() {
+		// Scan user into struct
+		if err := rows.StructScan(&u); err != nil {
+			return nil, err
+		}
+
+		// Append to list
+		users = append(users, u)
+	}
+
+	// Error check rows
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// AllUsers loads a slice of all User structs from the database, sorted by
+// username
+func (s *SqlBackend) AllUsers() ([]User, error) {
+	return s.userQuery("SELECT * FROM users ORDER BY username COLLATE NOCASE;")
+}
+
+// CountUsers fetches the total number of User structs from the database
+func (s *SqlBackend) CountUsers() (int64, error) {
+	return s.integerQuery("SELECT COUNT(*) AS int FROM users;")
+}
+
+// DeleteUser removes a User, along with their sessions, API keys, play
+// history and playlists, from the database
+func (s *SqlBackend) DeleteUser(u *User) error {
+	tx := s.db.MustBegin()
+	if u.ID == 0 {
+		// Find the user's ID from their username
+		if err := tx.Get(&u.ID,
+			"SELECT id FROM users WHERE username = ?;", u.Username); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	tx.Exec("DELETE FROM sessions WHERE user_id = ?;", u.ID)
+	tx.Exec("DELETE FROM api_keys WHERE user_id = ?;", u.ID)
+	tx.Exec(`DELETE FROM scrobbles
+		WHERE play_id IN (SELECT id FROM plays WHERE user_id = ?);`, u.ID)
+	tx.Exec("DELETE FROM plays WHERE user_id = ?;", u.ID)
+	tx.Exec("DELETE FROM library_users WHERE user_id = ?;", u.ID)
+	tx.Exec(`DELETE FROM playlist_items
+		WHERE playlist_id IN (SELECT id FROM playlists WHERE user_id = ?);`, u.ID)
+	tx.Exec("DELETE FROM playlists WHERE user_id = ?;", u.ID)
+	tx.Exec("DELETE FROM users WHERE id = ?;", u.ID)
+	return tx.Commit()
+}
+
+// LoadUser loads a User from the database, populating the parameter struct
+func (s *SqlBackend) LoadUser(u *User) error {
+	// Load the user via ID if available
+	if u.ID != 0 {
+		if err := s.db.Get(u, "SELECT * FROM users WHERE id = ?;", u.ID);
+		err != nil {
+			return err
+		}
+		return nil
+	}
+
+	// Load via username
+	if err := s.db.Get(u, "SELECT * FROM users WHERE username = ?;", u.Username);
+	err != nil {
+		return err
+	}
+	return nil
+}
+
+// SaveUser attempts to save a User to the database
+func (s *SqlBackend) SaveUser(u *User) error {
+	// Insert new user
+	query := `INSERT INTO users
+		(username, password, role_id, lastfm_token, listenbrainz_token)
+		VALUES (?, ?, ?, ?, ?);`
+	tx := s.db.MustBegin()
+	if _, err := tx.Exec(
+		query, u.Username, u.Password, u.RoleID, u.LastFMToken,
+		u.ListenBrainzToken); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Commit transaction
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// If no ID, reload to grab it
+	if u.ID == 0 {
+		if err := s.LoadUser(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateUser updates an existing User in the database
+func (s *SqlBackend) UpdateUser(u *User) error {
+	query := `UPDATE users
+		SET
+			username = ?,
+			password = ?,
+			role_id = ?,
+			lastfm_token = ?,
+			listenbrainz_token = ?
+		WHERE id = ?;`
+	tx := s.db.MustBegin()
+	if _, err := tx.Exec(query, u.Username, u.Password, u.RoleID, u.LastFMToken,
+		u.ListenBrainzToken, u.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
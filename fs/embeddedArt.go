@@ -0,0 +1,128 @@
+package fs
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"os"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// embeddedArtHash returns the hash and size of the picture embedded in an
+// audio file's tags. The hash is empty when there is none.
+func embeddedArtHash(p string) (string, int64, error) {
+	pic, err := db.ReadEmbeddedArt(p)
+	if err != nil || pic == nil {
+		return "", 0, err
+	}
+
+	sum := sha1.Sum(pic)
+	return hex.EncodeToString(sum[:]), int64(len(pic)), nil
+}
+
+// handleEmbeddedArt returns the Art for the picture embedded in a scanned
+// file. Songs sharing a picture share one Art, which is read from the first
+// file found holding it.
+func handleEmbeddedArt(result tagResult) (*db.Art, error) {
+	if art, ok := embeddedArtCache[result.artHash]; ok {
+		return art, nil
+	}
+
+	found, err := db.DB.ArtWithHash(scanLibraryID, result.artHash)
+	if err != nil {
+		return nil, err
+	}
+	for i := range found {
+		art := &found[i]
+		if _, err := os.Stat(art.Path); os.IsNotExist(err) {
+			// The file it was read from is gone, so read it from this one
+			setEmbeddedArtFile(art, result)
+			if err := art.Update(); err != nil {
+				return nil, err
+			}
+		}
+		embeddedArtCache[result.artHash] = art
+		return art, nil
+	}
+
+	// A file whose picture changed keeps its Art
+	art := &db.Art{Path: result.path}
+	if err := art.Load(); err == nil {
+		setEmbeddedArtFile(art, result)
+		if err := art.Update(); err != nil {
+			return nil, err
+		}
+		embeddedArtCache[result.artHash] = art
+		return art, nil
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	art.Source = db.ArtEmbedded
+	setEmbeddedArtFile(art, result)
+	if err := art.Save(); err != nil {
+		return nil, err
+	}
+
+	artCount++
+	embeddedArtCache[result.artHash] = art
+	util.Logger.Printf("FS: Media Scan: Embedded Art: [#%05d] %s", art.ID, art.Path)
+	return art, nil
+}
+
+// setEmbeddedArtFile points embedded Art at the scanned file holding it
+func setEmbeddedArtFile(art *db.Art, result tagResult) {
+	art.Path = result.path
+	art.Hash = result.artHash
+	art.FileSize = result.artSize
+	art.LastModified = result.info.ModTime().Unix()
+	art.FolderID = result.folder.ID
+	art.LibraryID = result.folder.LibraryID
+}
+
+// preferredArt returns the art an album uses when its folder holds an image
+// file and its songs have embedded pictures, as chosen by the art priority
+// setting. Either may be nil.
+func preferredArt(file, embedded *db.Art) *db.Art {
+	if embedded != nil && (file == nil || util.Current().Art.Priority == db.ArtEmbedded) {
+		return embedded
+	}
+	return file
+}
+
+// relinkEmbeddedArt points embedded Art whose file is missing at another song
+// still holding the same picture. False is returned when there is none.
+func relinkEmbeddedArt(art *db.Art) (bool, error) {
+	if art.Source != db.ArtEmbedded {
+		return false, nil
+	}
+
+	files, err := db.DB.SongFilesWithArt(art.ID)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range files {
+		info, err := os.Stat(f.Path)
+		if err != nil {
+			continue
+		}
+		// The song may have been retagged since it was scanned
+		hash, size, err := embeddedArtHash(f.Path)
+		if err != nil || hash != art.Hash {
+			continue
+		}
+
+		oldPath := art.Path
+		art.Path = f.Path
+		art.FileSize = size
+		art.LastModified = info.ModTime().Unix()
+		if err := art.Update(); err != nil {
+			return false, err
+		}
+		util.Logger.Printf("FS: Orphan Scan: Moved Embedded Art: %s -> %s", oldPath, art.Path)
+		return true, nil
+	}
+	return false, nil
+}
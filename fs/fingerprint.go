@@ -0,0 +1,155 @@
+package fs
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// fingerprintSample is the number of bytes hashed from each of the start,
+// middle and end of a file's audio
+const fingerprintSample = 16 * 1024
+
+// fingerprint identifies the audio of a file, so a song is recognised after
+// it is copied elsewhere. It hashes the length of the audio along with
+// samples of it, which reads little of large files. ID3, APE and FLAC tags
+// are left out, so retagging a file in those formats keeps its fingerprint.
+func fingerprint(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	start, err := audioStart(f)
+	if err != nil {
+		return "", err
+	}
+	end, err := audioEnd(f, info.Size())
+	if err != nil {
+		return "", err
+	}
+	if end <= start {
+		// Not a format whose tags are understood, so hash it all
+		start, end = 0, info.Size()
+	}
+
+	h := sha1.New()
+	fmt.Fprintf(h, "%d:", end-start)
+	for _, offset := range []int64{
+		start,
+		start + (end-start)/2 - fingerprintSample/2,
+		end - fingerprintSample,
+	} {
+		if offset < start {
+			offset = start
+		}
+		n := int64(fingerprintSample)
+		if offset+n > end {
+			n = end - offset
+		}
+		if _, err := io.Copy(h, io.NewSectionReader(f, offset, n)); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// audioStart returns the offset of a file's audio, after any ID3v2 tags or
+// FLAC metadata blocks at its start
+func audioStart(f io.ReaderAt) (int64, error) {
+	var offset int64
+	header := make([]byte, 10)
+	for {
+		if _, err := f.ReadAt(header, offset); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return 0, err
+		}
+
+		switch {
+		case bytes.HasPrefix(header, []byte("ID3")):
+			// The size is syncsafe, leaving out the header and any footer
+			size := int64(header[6])<<21 | int64(header[7])<<14 |
+				int64(header[8])<<7 | int64(header[9])
+			offset += 10 + size
+			if header[5]&0x10 != 0 {
+				offset += 10
+			}
+		case bytes.HasPrefix(header, []byte("fLaC")):
+			return flacAudioStart(f, offset+4)
+		default:
+			return offset, nil
+		}
+	}
+}
+
+// flacAudioStart returns the offset of the frames following the FLAC metadata
+// blocks starting at offset
+func flacAudioStart(f io.ReaderAt, offset int64) (int64, error) {
+	header := make([]byte, 4)
+	for {
+		if _, err := f.ReadAt(header, offset); err != nil {
+			if err == io.EOF {
+				// The blocks run past the end, so the whole file is hashed
+				return offset, nil
+			}
+			return 0, err
+		}
+
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		offset += 4 + size
+		if header[0]&0x80 != 0 {
+			return offset, nil
+		}
+	}
+}
+
+// audioEnd returns the offset following a file's audio, before any ID3v1 or
+// APEv2 tags at its end
+func audioEnd(f io.ReaderAt, end int64) (int64, error) {
+	for {
+		// ID3v1 is a fixed 128 bytes
+		if end >= 128 {
+			tag := make([]byte, 3)
+			if _, err := f.ReadAt(tag, end-128); err != nil {
+				return 0, err
+			}
+			if string(tag) == "TAG" {
+				end -= 128
+				continue
+			}
+		}
+
+		// APEv2 ends with a footer giving its size, leaving out any header
+		if end >= 32 {
+			footer := make([]byte, 32)
+			if _, err := f.ReadAt(footer, end-32); err != nil {
+				return 0, err
+			}
+			if bytes.HasPrefix(footer, []byte("APETAGEX")) {
+				size := int64(binary.LittleEndian.Uint32(footer[12:16]))
+				flags := binary.LittleEndian.Uint32(footer[20:24])
+				if flags&0x80000000 != 0 {
+					size += 32
+				}
+				if size >= 32 && size <= end {
+					end -= size
+					continue
+				}
+			}
+		}
+
+		return end, nil
+	}
+}
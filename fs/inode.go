@@ -0,0 +1,17 @@
+// +build !windows
+
+package fs
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode returns the inode of a file, which is kept when the file is moved
+// within its filesystem. 0 is returned when it is unknown.
+func fileInode(info os.FileInfo) int64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(stat.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,11 @@
+package fs
+
+import (
+	"os"
+)
+
+// fileInode returns 0, as inodes are not available on Windows. Moved files
+// are scanned as new files.
+func fileInode(info os.FileInfo) int64 {
+	return 0
+}
@@ -0,0 +1,310 @@
+package integrated
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Downloaded art is a JPEG or a PNG
+	_ "image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// artRequestInterval is the least time between requests to one host
+var artRequestInterval = time.Second
+
+const (
+	// maxArtDownload is the largest image downloaded
+	maxArtDownload = 32 << 20
+	// maxArtRetries is how many times a request which was rate limited is
+	// retried
+	maxArtRetries = 3
+	// artUserAgent identifies the requests, as the Cover Art Archive asks
+	artUserAgent = "All In One Media Server Tool/0.0.1-beta " +
+		"( http://github.com/eHoward1996/aiomst )"
+)
+
+// errArtRateLimited is returned when a host keeps refusing requests
+var errArtRateLimited = errors.New("Rate limited")
+
+// artFetcher downloads art, waiting between the requests to each host so
+// their rate limits are respected
+type artFetcher struct {
+	client *http.Client
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+func newArtFetcher() *artFetcher {
+	return &artFetcher{
+		client: &http.Client{Timeout: time.Minute},
+		next:   make(map[string]time.Time),
+	}
+}
+
+// wait blocks until a request may be sent to the host
+func (f *artFetcher) wait(host string) {
+	f.mu.Lock()
+	now := time.Now()
+	at := f.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	f.next[host] = at.Add(artRequestInterval)
+	f.mu.Unlock()
+
+	time.Sleep(at.Sub(now))
+}
+
+// delay holds off every request to the host for d
+func (f *artFetcher) delay(host string, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if at := time.Now().Add(d); at.After(f.next[host]) {
+		f.next[host] = at
+	}
+}
+
+// download returns the image at u, and its format. A nil image is returned
+// when there is none.
+func (f *artFetcher) download(u string) ([]byte, string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for retry := 0; retry <= maxArtRetries; retry++ {
+		f.wait(parsed.Host)
+
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, "", err
+		}
+		req.Header.Set("User-Agent", artUserAgent)
+
+		resp, err := f.client.Do(req)
+		if err != nil {
+			return nil, "", err
+		}
+		b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxArtDownload+1))
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotFound:
+			return nil, "", nil
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			// Wait as long as asked, or back off
+			d := time.Duration(retry+1) * requestRetryTimer * time.Second
+			if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				d = time.Duration(s) * time.Second
+			}
+			f.delay(parsed.Host, d)
+			continue
+		default:
+			return nil, "", fmt.Errorf("%s: %s", u, resp.Status)
+		}
+
+		if err != nil {
+			return nil, "", err
+		}
+		if len(b) > maxArtDownload {
+			return nil, "", fmt.Errorf("%s: Image is too large", u)
+		}
+		_, format, err := image.DecodeConfig(bytes.NewReader(b))
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %v", u, err)
+		}
+		return b, format, nil
+	}
+	return nil, "", fmt.Errorf("%s: %w", u, errArtRateLimited)
+}
+
+// fetch downloads the first of the urls to hold an image, and returns it as
+// Art. Nil is returned when none do.
+func (f *artFetcher) fetch(urls []string) (*db.Art, error) {
+	for _, u := range urls {
+		b, format, err := f.download(u)
+		if err != nil {
+			util.Logger.Printf("FS: Third Party Integrator: Art: %v", err)
+			continue
+		}
+		if b != nil {
+			return saveDownloadedArt(b, format)
+		}
+	}
+	return nil, nil
+}
+
+// downloadedArtPath returns the folder downloaded art is kept in, within the
+// art cache so nothing is written to the library
+func downloadedArtPath() string {
+	return path.Join(util.C.ArtCachePath(), "downloaded")
+}
+
+// saveDownloadedArt stores a downloaded image as Art. Each image is stored
+// once, named by its hash, however many albums and artists use it.
+func saveDownloadedArt(b []byte, format string) (*db.Art, error) {
+	ext := "png"
+	if format == "jpeg" {
+		ext = "jpg"
+	}
+	sum := sha1.Sum(b)
+	hash := hex.EncodeToString(sum[:])
+
+	art := &db.Art{Path: path.Join(downloadedArtPath(), hash+"."+ext)}
+	if err := art.Load(); err == nil {
+		return art, nil
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(downloadedArtPath(), 0755); err != nil {
+		return nil, err
+	}
+	// Written to a temporary file first, so a partial file is never served
+	tmp, err := ioutil.TempFile(downloadedArtPath(), ".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), art.Path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	info, err := os.Stat(art.Path)
+	if err != nil {
+		return nil, err
+	}
+	art.Source = db.ArtDownloaded
+	art.Hash = hash
+	art.FileSize = info.Size()
+	art.LastModified = info.ModTime().Unix()
+	if err := art.Save(); err != nil {
+		return nil, err
+	}
+	return art, nil
+}
+
+// artURLs returns the urls of the art for a pointer to either AlbumMetadata
+// or ArtistMetadata from every provider, highest priority first
+func artURLs(providers []MetadataProvider, md interface{}) []string {
+	urls := make([]string, 0)
+	for _, p := range providers {
+		urls = append(urls, p.FetchArt(md)...)
+	}
+	return urls
+}
+
+// fetchArt gives the albums and artists without art the covers and images
+// the providers find in their third party metadata
+func (i TPIntegrator) fetchArt() {
+	f := newArtFetcher()
+	providers := EnabledProviders()
+	count := 0
+
+	albums, err := db.DB.AlbumsWithoutArt()
+	if err != nil {
+		util.Logger.Printf(
+			"FS: Third Party Integrator: Errored finding Albums without art: %v", err)
+		return
+	}
+	for _, album := range albums {
+		album := album
+		md, err := album.GetMetadata()
+		if err != nil {
+			util.Logger.Printf(
+				"FS: Third Party Integrator: Error loading metadata for album: %v: %v",
+				album.ID, err)
+			continue
+		}
+		urls := artURLs(providers, md)
+		if len(urls) == 0 {
+			continue
+		}
+
+		art, err := f.fetch(urls)
+		if err != nil {
+			util.Logger.Printf(
+				"FS: Third Party Integrator: Error saving art for %v - %v: %v",
+				album.Artist, album.Title, err)
+			continue
+		}
+		if art == nil {
+			continue
+		}
+
+		album.ArtID = art.ID
+		if err := album.Update(); err != nil {
+			util.Logger.Printf(
+				"FS: Third Party Integrator: Error updating %v - %v: %v",
+				album.Artist, album.Title, err)
+			continue
+		}
+		count++
+	}
+
+	artists, err := db.DB.ArtistsWithoutArt()
+	if err != nil {
+		util.Logger.Printf(
+			"FS: Third Party Integrator: Errored finding Artists without art: %v", err)
+		return
+	}
+	for _, artist := range artists {
+		artist := artist
+		md, err := artist.GetMetadata()
+		if err != nil {
+			util.Logger.Printf(
+				"FS: Third Party Integrator: Error loading metadata for artist: %v: %v",
+				artist.ID, err)
+			continue
+		}
+		urls := artURLs(providers, md)
+		if len(urls) == 0 {
+			continue
+		}
+
+		art, err := f.fetch(urls)
+		if err != nil {
+			util.Logger.Printf(
+				"FS: Third Party Integrator: Error saving art for %v: %v",
+				artist.Title, err)
+			continue
+		}
+		if art == nil {
+			continue
+		}
+
+		artist.ArtID = art.ID
+		if err := artist.Update(); err != nil {
+			util.Logger.Printf(
+				"FS: Third Party Integrator: Error updating %v: %v", artist.Title, err)
+			continue
+		}
+		count++
+	}
+
+	util.Logger.Printf("FS: Third Party Integrator: Downloaded art for %d items", count)
+}
@@ -0,0 +1,123 @@
+package integrated
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eHoward1996/aiomst/db"
+)
+
+// coverArtServer starts a Cover Art Archive serving a PNG cover for the
+// release "found", none for "missing", and rate limiting requests for
+// "limited" once and for "busy" always. It returns the PNG and the number of
+// requests received for each release.
+func coverArtServer(t *testing.T) ([]byte, map[string]int) {
+	var cover bytes.Buffer
+	if err := png.Encode(&cover, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != artUserAgent {
+			t.Errorf("unexpected User-Agent %q", r.Header.Get("User-Agent"))
+		}
+
+		mu.Lock()
+		requests[r.URL.Path]++
+		n := requests[r.URL.Path]
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/release/found/front":
+		case "/release/limited/front":
+			if n == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+		case "/release/busy/front":
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(cover.Bytes())
+	}))
+	t.Cleanup(srv.Close)
+
+	archiveURL, interval := coverArtArchiveURL, artRequestInterval
+	t.Cleanup(func() { coverArtArchiveURL, artRequestInterval = archiveURL, interval })
+	coverArtArchiveURL, artRequestInterval = srv.URL, 0
+
+	return cover.Bytes(), requests
+}
+
+// coverURL returns the Cover Art Archive front cover URL of a release
+func coverURL(t *testing.T, release string) string {
+	md := &db.AlbumMetadata{}
+	md.MusicBrainz.Release.ID = release
+	urls := musicBrainzProvider{}.FetchArt(md)
+	if len(urls) != 1 {
+		t.Fatalf("expected one cover URL, got %v", urls)
+	}
+	return urls[0]
+}
+
+func TestDownloadArt(t *testing.T) {
+	cover, _ := coverArtServer(t)
+
+	b, format, err := newArtFetcher().download(coverURL(t, "found"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "png" || !bytes.Equal(b, cover) {
+		t.Fatalf("downloaded %d bytes of %q, expected the %d byte PNG", len(b), format, len(cover))
+	}
+}
+
+func TestDownloadArtMissing(t *testing.T) {
+	coverArtServer(t)
+
+	b, format, err := newArtFetcher().download(coverURL(t, "missing"))
+	if b != nil || format != "" || err != nil {
+		t.Fatalf("downloaded %d bytes of %q, error %v, expected nothing", len(b), format, err)
+	}
+}
+
+func TestDownloadArtRateLimited(t *testing.T) {
+	cover, requests := coverArtServer(t)
+	f := newArtFetcher()
+
+	// The request is retried once the Retry-After delay has passed
+	start := time.Now()
+	b, _, err := f.download(coverURL(t, "limited"))
+	if err != nil || !bytes.Equal(b, cover) {
+		t.Fatalf("downloaded %d bytes, error %v", len(b), err)
+	}
+	if d := time.Since(start); d < time.Second {
+		t.Fatalf("retried after %s, expected the Retry-After delay of 1s", d)
+	}
+	if n := requests["/release/limited/front"]; n != 2 {
+		t.Fatalf("%d requests, expected 2", n)
+	}
+
+	// A host which keeps refusing requests is given up on
+	if _, _, err := f.download(coverURL(t, "busy")); !errors.Is(err, errArtRateLimited) {
+		t.Fatalf("expected %v, got %v", errArtRateLimited, err)
+	}
+	if n := requests["/release/busy/front"]; n != maxArtRetries+1 {
+		t.Fatalf("%d requests, expected %d", n, maxArtRetries+1)
+	}
+}
@@ -2,6 +2,8 @@ package integrated
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/eHoward1996/aiomst/db"
 	"github.com/eHoward1996/aiomst/util"
@@ -10,24 +12,135 @@ import (
 
 var client discogs.Discogs
 
-func (i TPIntegrator) getDiscogsData(obj interface{}) (discogsResponse, error) {
-	var resp discogsResponse = discogsResponse{}
-	var err error = nil
+// discogsProvider finds albums and artists, and their images, on Discogs.
+// It is only used with a Discogs token.
+type discogsProvider struct{}
 
+func init() {
+	RegisterProvider(discogsProvider{})
+}
+
+// Name returns discogs
+func (discogsProvider) Name() string {
+	return "discogs"
+}
+
+// Start creates the Discogs client, which needs the configured token
+func (discogsProvider) Start() bool {
+	discogsClient = getDiscogsClient()
 	if discogsClient == nil {
-		return resp, fmt.Errorf("No Discogs Client available")
+		util.Logger.Print(
+			"FS: Third Party Integrator: No Discogs token configured, skipping Discogs")
+		return false
 	}
-
 	client = *discogsClient
-	switch t := obj.(type) {
-	case *sendAlbum:
-		resp.AlbumResponse, err = requestDiscogsMaster(t)
+	return true
+}
+
+// SearchAlbum finds the master release matching the album
+func (discogsProvider) SearchAlbum(q *AlbumQuery) (*AlbumMatch, error) {
+	if discogsClient == nil {
+		return nil, fmt.Errorf("No Discogs Client available")
 	}
 
-	return resp, err
+	master, err := requestDiscogsMaster(q)
+	if err != nil || master == nil {
+		return nil, err
+	}
+
+	md := &db.AlbumMetadata{
+		Discogs: buildDiscogsAlbumMetadata(master),
+		Year:    master.Year,
+	}
+	return &AlbumMatch{ID: strconv.Itoa(master.ID), Metadata: md, Data: master}, nil
+}
+
+// Identify stores the master's ID on the album, and the IDs of its artists
+// matching the artist on the artist
+func (discogsProvider) Identify(
+	match *AlbumMatch, album *db.Album, artist *db.Artist) {
+
+		master := match.Data.(*discogs.Master)
+		album.DiscogsID = master.ID
+
+		discList := make([]string, 0)
+		for _, aSource := range master.Artists {
+			if isSimilarString(aSource.Name, artist.Title) {
+				discList = append(discList, strconv.Itoa(aSource.ID))
+			}
+		}
+		if len(discList) != 0 {
+			artist.DiscogsID = strings.Join(discList, ",")
+		}
+}
+
+// LookupArtist looks up the artists of the master
+func (discogsProvider) LookupArtist(
+	q *AlbumQuery, match *AlbumMatch, stored *db.ArtistMetadata,
+) (*db.ArtistMetadata, error) {
+	if len(stored.Discogs.Artists) != 0 {
+		return nil, nil
+	}
+
+	master := match.Data.(*discogs.Master)
+	return &db.ArtistMetadata{
+		Discogs: buildDiscogsArtistMetadata(master.Artists),
+	}, nil
+}
+
+// FetchArt returns the Discogs images of an album or its artists, primary
+// images first
+func (discogsProvider) FetchArt(md interface{}) []string {
+	urls := make([]string, 0)
+	switch t := md.(type) {
+	case *db.AlbumMetadata:
+		urls = append(urls, discogsImageURLs(t.Discogs.Images)...)
+	case *db.ArtistMetadata:
+		for _, a := range t.Discogs.Artists {
+			urls = append(urls, discogsImageURLs(a.Images)...)
+		}
+	}
+	return urls
+}
+
+// FetchGenres returns the genres and styles of an album. Discogs gives
+// artists neither.
+func (discogsProvider) FetchGenres(md interface{}) []string {
+	album, ok := md.(*db.AlbumMetadata)
+	if !ok {
+		return nil
+	}
+	return append(append([]string{}, album.Discogs.Genres...), album.Discogs.Styles...)
+}
+
+// FetchSortName is not supported, Discogs has no sort names
+func (discogsProvider) FetchSortName(md *db.ArtistMetadata) string {
+	return ""
 }
 
-func requestDiscogsMaster(a *sendAlbum) (*discogs.Master, error) {
+// FetchLyrics is not supported, Discogs does not hold lyrics
+func (discogsProvider) FetchLyrics(q *SongQuery) (string, error) {
+	return "", ErrNotSupported
+}
+
+// discogsImageURLs returns the urls of Discogs images, the primary image
+// first
+func discogsImageURLs(images []db.DiscogsImage) []string {
+	urls := make([]string, 0)
+	for _, img := range images {
+		if img.URI == "" {
+			continue
+		}
+		if img.Type == "primary" {
+			urls = append([]string{img.URI}, urls...)
+		} else {
+			urls = append(urls, img.URI)
+		}
+	}
+	return urls
+}
+
+func requestDiscogsMaster(a *AlbumQuery) (*discogs.Master, error) {
 	// Remove any disambiguation/special edition info from the album title
 	// Discogs really doesn't like it.
 	albumTitle := stripAllParentheses(a.Title)
@@ -124,18 +237,7 @@ func buildDiscogsAlbumMetadata(master *discogs.Master) db.DiscogsMetadata {
 	}
 	dmd.Artists = artists
 
-	images := make([]db.DiscogsImage, 0)
-	for _, img := range master.Images {
-		i := db.DiscogsImage {
-			Height:      img.Height,
-			Width:       img.Width,
-			ResourceURL: img.ResourceURL,
-			Type:        img.Type,
-			URI:         img.URI,
-		}
-		images = append(images, i)
-	}
-	dmd.Images = images
+	dmd.Images = buildDiscogsImages(master.Images)
 
 	videos := make([]db.DiscogsVideo, 0)
 	for _, vid := range master.Videos {
@@ -172,8 +274,24 @@ func buildDiscogsArtistMetadata(
 			for _, member := range dArtist.Members {
 				aMD.Members = append(aMD.Members, member.Name)
 			}
+			aMD.Images = buildDiscogsImages(dArtist.Images)
 			dmd.Artists = append(dmd.Artists, aMD)
 		}
 
 		return dmd
-}
\ No newline at end of file
+}
+
+func buildDiscogsImages(imgs []discogs.Image) []db.DiscogsImage {
+	images := make([]db.DiscogsImage, 0)
+	for _, img := range imgs {
+		i := db.DiscogsImage {
+			Height:      img.Height,
+			Width:       img.Width,
+			ResourceURL: img.ResourceURL,
+			Type:        img.Type,
+			URI:         img.URI,
+		}
+		images = append(images, i)
+	}
+	return images
+}
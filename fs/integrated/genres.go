@@ -0,0 +1,82 @@
+package integrated
+
+import (
+	"strings"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// genreNames returns the genres found in a pointer to either AlbumMetadata or
+// ArtistMetadata by every provider, highest priority first
+func genreNames(providers []MetadataProvider, md interface{}) []string {
+	names := make([]string, 0)
+	for _, p := range providers {
+		for _, name := range p.FetchGenres(md) {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// fetchGenres links the songs of every album to the genres the providers find
+// in its third party metadata, and in that of its artist. When genres are not
+// wanted, the links made earlier are removed instead.
+func (i TPIntegrator) fetchGenres() {
+	if !util.Current().Integration.Genres {
+		if err := db.DB.ClearMetadataGenres(); err != nil {
+			util.Logger.Printf(
+				"FS: Third Party Integrator: Error removing genres: %v", err)
+		}
+		return
+	}
+
+	providers := EnabledProviders()
+	artistGenres := make(map[int][]string)
+	count := 0
+
+	albums, err := db.DB.AllAlbums()
+	if err != nil {
+		util.Logger.Printf(
+			"FS: Third Party Integrator: Errored finding Albums: %v", err)
+		return
+	}
+	for _, album := range albums {
+		md, err := album.GetMetadata()
+		if err != nil {
+			util.Logger.Printf(
+				"FS: Third Party Integrator: Error loading metadata for album: %v: %v",
+				album.ID, err)
+			continue
+		}
+
+		fromArtist, ok := artistGenres[album.ArtistID]
+		if !ok {
+			artist := &db.Artist{ID: album.ArtistID}
+			artistMd, err := artist.GetMetadata()
+			if err != nil {
+				util.Logger.Printf(
+					"FS: Third Party Integrator: Error loading metadata for artist: %v: %v",
+					artist.ID, err)
+				continue
+			}
+			fromArtist = genreNames(providers, artistMd)
+			artistGenres[album.ArtistID] = fromArtist
+		}
+
+		names := append(genreNames(providers, md), fromArtist...)
+		if err := db.DB.SetAlbumGenres(album.ID, names); err != nil {
+			util.Logger.Printf(
+				"FS: Third Party Integrator: Error saving genres for %v - %v: %v",
+				album.Artist, album.Title, err)
+			continue
+		}
+		if len(names) > 0 {
+			count++
+		}
+	}
+
+	util.Logger.Printf("FS: Third Party Integrator: Found genres for %d albums", count)
+}
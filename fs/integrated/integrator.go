@@ -28,11 +28,22 @@ func getMusicBrainzClient() *gomusicbrainz.WS2Client {
 	return mb
 }
 
+// getDiscogsClient returns a client using the configured token, or nil when
+// no token is configured
 func getDiscogsClient() *discogs.Discogs {
-	d, _ := discogs.New(&discogs.Options{
+	token := util.Current().Discogs.Token
+	if token == "" {
+		return nil
+	}
+
+	d, err := discogs.New(&discogs.Options{
 		UserAgent: "All In One Media Server Tool",
-		Token: "myPnYxaJxaTHrBHKNxzEEXuLDIviSIayFgmbmMiD",
+		Token: token,
 	})
+	if err != nil {
+		util.Logger.Printf("FS: Third Party Integrator: Discogs: %v", err)
+		return nil
+	}
 	return &d
 }
 
@@ -40,9 +51,18 @@ func (i TPIntegrator) Integrate() {
 	util.Logger.Printf("FS: Third Party Integrator: Starting to retrieve...")
 	startTime := time.Now()
 
-	mbClient = getMusicBrainzClient()
-	discogsClient = getDiscogsClient()
-	i.makeRequests()
+	started := make([]MetadataProvider, 0)
+	for _, p := range EnabledProviders() {
+		if p.Start() {
+			started = append(started, p)
+		}
+	}
+	i.makeRequests(started)
+	i.fetchGenres()
+	i.fetchSortNames()
+	if util.Current().Art.Download {
+		i.fetchArt()
+	}
 
 	util.Logger.Printf("FS: Third Party Integrator Complete [time: %s]",
 		time.Since(startTime).String())
@@ -52,7 +72,14 @@ func (i TPIntegrator) Integrate() {
 	}
 }
 
-func (i TPIntegrator) makeRequests() {
+// makeRequests asks each provider about the albums whose third party IDs are
+// missing, storing the IDs and metadata they find
+func (i TPIntegrator) makeRequests(providers []MetadataProvider) {
+	if len(providers) == 0 {
+		util.Logger.Print("FS: Third Party Integrator: No metadata providers enabled")
+		return
+	}
+
 	albums, err := db.DB.AlbumsWithErroredThirdPartyId()
 	if err != nil {
 		util.Logger.Printf(
@@ -63,14 +90,14 @@ func (i TPIntegrator) makeRequests() {
 		"FS: Third Party Integrator: Starting Albums Worker Pool...")
 	
 	albumResults := make(chan *recvAlbum)
-	wp := workerpool.New(maxRoutines)	
+	wp := workerpool.New(util.Current().Integration.Workers)
 	for _, album := range albums {
 		album := album
-		send := &sendAlbum{
-			ID:         album.ID,
-			MetadataID: album.MetadataID,
-			Artist:     album.Artist,
-			Title:      album.Title,
+		send := &AlbumQuery{
+			ID:       album.ID,
+			ArtistID: album.ArtistID,
+			Artist:   album.Artist,
+			Title:    album.Title,
 		}
 
 		wp.Submit(func() {
@@ -87,42 +114,31 @@ func (i TPIntegrator) makeRequests() {
 			recv := new(recvAlbum)
 			recv.AlbumID = send.ID
 			recv.Album = send.Title
-			recv.ArtistID = album.ArtistID
+			recv.ArtistID = send.ArtistID
 			recv.Artist = send.Artist
-			recv.MetadataID = send.MetadataID
+			recv.Matches = make([]providerMatch, len(providers))
 
 			util.Logger.Printf(
 				"FS: Third Party Integrator: Requesting data for: %v - %v",
 				send.Artist, send.Title)
 
 			var wg sync.WaitGroup
-			wg.Add(2)
-
-			go func(wg *sync.WaitGroup) {
-				defer wg.Done()
-				mbResp, err := i.getMusicBrainzData(send)
-				if err != nil {
-					util.Logger.Printf(
-						"FS: Third Party Integrator: Error getting MusicBrainz content for " +
-						"%v - %v: %v", send.Artist, send.Title, err)
-				} else if mbResp.AlbumResponse != nil {
-					recv.MBRelease = mbResp.AlbumResponse
-				}	
-			}(&wg)
-			
-			go func(wg *sync.WaitGroup) {
-				defer wg.Done()
-				dsResp, err := i.getDiscogsData(send)
-				if err != nil {
-					util.Logger.Printf(
-						"FS: Third Party Integrator: Error getting Discogs content for " +
-						"%v - %v: %v",
-						send.Artist, send.Title, err)
-				} else if dsResp.AlbumResponse != nil {
-					recv.DiscogsMaster = dsResp.AlbumResponse
-				}
-			}(&wg)	
-			
+			wg.Add(len(providers))
+			for n, p := range providers {
+				go func(n int, p MetadataProvider) {
+					defer wg.Done()
+					recv.Matches[n].provider = p
+					match, err := p.SearchAlbum(send)
+					if err != nil {
+						util.Logger.Printf(
+							"FS: Third Party Integrator: Error getting %v content for " +
+							"%v - %v: %v", p.Name(), send.Artist, send.Title, err)
+						return
+					}
+					recv.Matches[n].match = match
+				}(n, p)
+			}
+
 			wg.Wait()
 			albumResults <- recv
 		})
@@ -133,23 +149,24 @@ func (i TPIntegrator) makeRequests() {
 		close(albumResults)
 	}()
 
+	// Metadata is stored before returning, so art can be fetched from it
+	var metadataWG sync.WaitGroup
+	defer metadataWG.Wait()
+
 	count := 0
 	for result := range albumResults {
 		count++
-		go func() {
+		metadataWG.Add(1)
+		go func(count int, result *recvAlbum) {
+			defer metadataWG.Done()
 			printReceivedAlbum(count, len(albums), result)
 			i.createAlbumMetadata(result)
 			i.createArtistMetadata(result)
-		}()
-		if err := updateAlbum(result); err != nil {
+		}(count, result)
+		if err := updateIDs(result); err != nil {
 			util.Logger.Printf(
 				"FS: Third Party Integrator: Error updating %v - %v: %v", 
 				result.Artist, result.Album, err)
-		}	
-		if err := updateArtist(result); err != nil {
-			util.Logger.Printf(
-				"FS: Third Party Integrator: Error updating %v: %v", result.Artist, err)
 		}
 	}
 }
-
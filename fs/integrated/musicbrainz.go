@@ -3,7 +3,6 @@ package integrated
 import (
 	"fmt"
 	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 
@@ -14,29 +13,134 @@ import (
 	"github.com/pascoej/gomusicbrainz"
 )
 
-// getMusicBrainzID makes a request to the MusicBrainz API and then parses
-// responses to find the best matching MBID
-func (i TPIntegrator) getMusicBrainzData(
-	obj interface{}) (musicbrainzResponse, error) {	
-	
-		var resp musicbrainzResponse = musicbrainzResponse{}
-		var err error = nil
+// coverArtArchiveURL is where album covers are requested from by their
+// MusicBrainz release and release group IDs
+var coverArtArchiveURL = "https://coverartarchive.org"
+
+// musicBrainzProvider finds albums and artists on MusicBrainz, and their
+// covers on the Cover Art Archive
+type musicBrainzProvider struct{}
+
+func init() {
+	RegisterProvider(musicBrainzProvider{})
+}
+
+// Name returns musicbrainz
+func (musicBrainzProvider) Name() string {
+	return "musicbrainz"
+}
+
+// Start creates the MusicBrainz client
+func (musicBrainzProvider) Start() bool {
+	mbClient = getMusicBrainzClient()
+	return mbClient != nil
+}
+
+// SearchAlbum finds the release best matching the album
+func (musicBrainzProvider) SearchAlbum(q *AlbumQuery) (*AlbumMatch, error) {
+	if mbClient == nil {
+		return nil, fmt.Errorf("No MusicBrainz Client available")
+	}
+
+	release, err := findClosestAlbum(q)
+	if err != nil || release == nil {
+		return nil, err
+	}
+
+	md := &db.AlbumMetadata{MusicBrainz: buildMBAlbumMetadata(release)}
+	if release.Date != nil && !release.Date.IsZero() {
+		md.Year = release.Date.Year()
+	}
+	return &AlbumMatch{ID: string(release.ID), Metadata: md, Data: release}, nil
+}
+
+// Identify stores the release's MBID on the album, and the MBIDs of the
+// credited artists matching the artist on the artist
+func (musicBrainzProvider) Identify(
+	match *AlbumMatch, album *db.Album, artist *db.Artist) {
 
-		if mbClient == nil {
-			return resp, fmt.Errorf("No MusicBrainz Client available")
+		album.MBID = match.ID
+
+		release := match.Data.(*gomusicbrainz.Release)
+		if release.ArtistCredit == nil {
+			return
+		}
+		mbidList := make([]string, 0)
+		for _, aCredit := range release.ArtistCredit.NameCredits {
+			mbArtist := aCredit.Artist
+			if isSimilarString(mbArtist.Name, artist.Title) {
+				mbidList = append(mbidList, string(mbArtist.ID))
+			}
+		}
+		mbidString := strings.Join(mbidList, ",")
+		if mbidString != "" {
+			artist.MBID = mbidString
 		}
+}
 
-		switch t := obj.(type) {
-		case *sendAlbum:
-			resp.AlbumResponse, err = findClosestAlbum(t)
-		// case gomusicbrainz.MBID:
-		// 	resp.ArtistResponse, err = mbLookupArtist(fmt.Sprintf("%v", obj))
-		}	
+// LookupArtist looks up the credited artists of the release
+func (musicBrainzProvider) LookupArtist(
+	q *AlbumQuery, match *AlbumMatch, stored *db.ArtistMetadata,
+) (*db.ArtistMetadata, error) {
+	if len(stored.MusicBrainz.Artists) != 0 {
+		return nil, nil
+	}
 
-		return resp, err
+	release := match.Data.(*gomusicbrainz.Release)
+	return &db.ArtistMetadata{
+		MusicBrainz: buildMBArtistMetadata(q.Artist, release),
+	}, nil
 }
 
-func findClosestAlbum(album *sendAlbum) (*gomusicbrainz.Release, error) {
+// FetchArt returns the Cover Art Archive front covers of an album's release
+// and release groups. The archive has no artist images.
+func (musicBrainzProvider) FetchArt(md interface{}) []string {
+	urls := make([]string, 0)
+	album, ok := md.(*db.AlbumMetadata)
+	if !ok {
+		return urls
+	}
+
+	if id := album.MusicBrainz.Release.ID; id != "" {
+		urls = append(urls, coverArtArchiveURL+"/release/"+id+"/front")
+	}
+	for _, rg := range album.MusicBrainz.ReleaseGroups {
+		if rg.ID != "" {
+			urls = append(urls, coverArtArchiveURL+"/release-group/"+rg.ID+"/front")
+		}
+	}
+	return urls
+}
+
+// FetchGenres returns the tags MusicBrainz users gave the artists of an
+// album or an artist
+func (musicBrainzProvider) FetchGenres(md interface{}) []string {
+	switch t := md.(type) {
+	case *db.AlbumMetadata:
+		return t.MusicBrainz.RelatedTags
+	case *db.ArtistMetadata:
+		return t.MusicBrainz.RelatedTags
+	}
+	return nil
+}
+
+// FetchSortName returns the sort name of the credited artist whose name is
+// the artist's, as the credits may name several artists
+func (musicBrainzProvider) FetchSortName(md *db.ArtistMetadata) string {
+	for _, a := range md.MusicBrainz.Artists {
+		if strings.EqualFold(a.Name, md.ArtistName) {
+			return a.SortName
+		}
+	}
+	return ""
+}
+
+// FetchLyrics is not supported, MusicBrainz does not hold lyrics
+func (musicBrainzProvider) FetchLyrics(q *SongQuery) (string, error) {
+	return "", ErrNotSupported
+}
+
+func findClosestAlbum(album *AlbumQuery) (*gomusicbrainz.Release, error) {
 	releaseList, err := getReleaseList(album)
 	if err != nil {
 		return nil, err
@@ -47,7 +151,7 @@ func findClosestAlbum(album *sendAlbum) (*gomusicbrainz.Release, error) {
 		score int
 	}
 
-	wp := workerpool.New(maxWorkers)
+	wp := workerpool.New(util.Current().Integration.Workers)
 	max := &result{score: -1}
 	resultCh := make(chan *result, len(releaseList))		
 	for _, release := range releaseList {
@@ -79,7 +183,7 @@ func findClosestAlbum(album *sendAlbum) (*gomusicbrainz.Release, error) {
 	return max.release, nil
 }
 
-func getReleaseList(a *sendAlbum) ([]*gomusicbrainz.Release, error) {
+func getReleaseList(a *AlbumQuery) ([]*gomusicbrainz.Release, error) {
 	title := stripAllParentheses(a.Title)
 	q := fmt.Sprintf(`releasegroupaccent:"%s" OR release:"%s" OR artist:"%s"`,
 		title, a.Title, a.Artist)
@@ -117,7 +221,7 @@ func getReleaseList(a *sendAlbum) ([]*gomusicbrainz.Release, error) {
 	return releases, nil
 }
 
-func scoreAlbum(a *sendAlbum, mbRelease *gomusicbrainz.Release) int {
+func scoreAlbum(a *AlbumQuery, mbRelease *gomusicbrainz.Release) int {
 	var wg sync.WaitGroup
 	scoreCh := make(chan int, 3)
 	score := 0
@@ -140,7 +244,7 @@ func scoreAlbum(a *sendAlbum, mbRelease *gomusicbrainz.Release) int {
 	return score
 }
 
-func (a *sendAlbum) calculateDisambiguationScore(
+func (a *AlbumQuery) calculateDisambiguationScore(
 	mbDisambig string, ch chan int, wg *sync.WaitGroup) {
 
 		defer wg.Done()
@@ -179,7 +283,7 @@ func (a *sendAlbum) calculateDisambiguationScore(
 		ch <- disambigScore
 }
 
-func (a *sendAlbum) calculateArtistsScore(
+func (a *AlbumQuery) calculateArtistsScore(
 	mbArtists []gomusicbrainz.NameCredit, ch chan int, wg *sync.WaitGroup) {
 
 		defer wg.Done()
@@ -196,7 +300,7 @@ func (a *sendAlbum) calculateArtistsScore(
 		ch <- artistsScore
 }
 
-func (a *sendAlbum) calculateTracksScore(
+func (a *AlbumQuery) calculateTracksScore(
 	mediums []*gomusicbrainz.Medium, ch chan int, wg *sync.WaitGroup) {
 
 		defer wg.Done()
@@ -235,13 +339,7 @@ func (a *sendAlbum) calculateTracksScore(
 		}
 
 		for _, song := range songsOnAlbum {
-			songDiscStr := strings.Split(song.Disc, "/")[0]
-			songDisc, err := strconv.Atoi(songDiscStr)
-			if err != nil {
-				songDisc = 1
-			}
-
-			key := fmt.Sprintf("%v:%v", songDisc, song.Track)
+			key := fmt.Sprintf("%v:%v", song.Disc, song.Track)
 			if track, ok := trackList[key];
 				ok && isSimilarString(song.Title, track.title) {
 					tracksScore += 5
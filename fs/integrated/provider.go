@@ -0,0 +1,170 @@
+package integrated
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// ErrNotSupported is returned by a provider asked for something it does not
+// have, such as lyrics
+var ErrNotSupported = errors.New("Not supported by this provider")
+
+// AlbumQuery is an album, with its artist, which providers are asked about
+type AlbumQuery struct {
+	ID       int
+	ArtistID int
+	Artist   string
+	Title    string
+}
+
+// SongQuery is a song whose lyrics providers are asked for
+type SongQuery struct {
+	Artist string
+	Title  string
+}
+
+// AlbumMatch is an album found by a provider
+type AlbumMatch struct {
+	// ID identifies the album with the provider
+	ID string
+	// Metadata holds what the provider knows of the album, and is merged into
+	// the metadata stored for it
+	Metadata *db.AlbumMetadata
+	// Data is kept by the provider for identifying and looking up the artist
+	Data interface{}
+}
+
+// MetadataProvider is a source of third party metadata. Providers are
+// registered by name with RegisterProvider, and those listed in the
+// integration providers setting are asked in that order, the first having the
+// highest priority.
+type MetadataProvider interface {
+	// Name identifies the provider in the configuration and the logs
+	Name() string
+
+	// Start prepares the provider for a run of the integrator. False is
+	// returned when it cannot be used, such as when it has no credentials.
+	Start() bool
+
+	// SearchAlbum finds an album, returning nil when there is no match
+	SearchAlbum(q *AlbumQuery) (*AlbumMatch, error)
+
+	// Identify stores the provider's IDs for the album, and for its artist,
+	// found by SearchAlbum
+	Identify(match *AlbumMatch, album *db.Album, artist *db.Artist)
+
+	// LookupArtist returns the metadata of the artist of the album found by
+	// SearchAlbum. Nil is returned when there is none, or when the stored
+	// metadata already holds the provider's.
+	LookupArtist(
+		q *AlbumQuery, match *AlbumMatch, stored *db.ArtistMetadata,
+	) (*db.ArtistMetadata, error)
+
+	// FetchArt returns the urls of the art for a pointer to either
+	// AlbumMetadata or ArtistMetadata, best first
+	FetchArt(md interface{}) []string
+
+	// FetchGenres returns the genres found in a pointer to either
+	// AlbumMetadata or ArtistMetadata
+	FetchGenres(md interface{}) []string
+
+	// FetchSortName returns the name an artist is sorted by found in their
+	// ArtistMetadata, or an empty string when there is none
+	FetchSortName(md *db.ArtistMetadata) string
+
+	// FetchLyrics returns the lyrics of a song, ErrNotSupported when the
+	// provider has none, and an empty string when the song is not found
+	FetchLyrics(q *SongQuery) (string, error)
+}
+
+var (
+	providersMutex sync.RWMutex
+	providers      = make(map[string]MetadataProvider)
+)
+
+// RegisterProvider makes a provider available to be enabled in the
+// configuration by its name
+func RegisterProvider(p MetadataProvider) {
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+	providers[p.Name()] = p
+}
+
+// EnabledProviders returns the providers listed in the integration providers
+// setting, highest priority first. Unknown names are logged and skipped.
+func EnabledProviders() []MetadataProvider {
+	providersMutex.RLock()
+	defer providersMutex.RUnlock()
+
+	enabled := make([]MetadataProvider, 0)
+	for _, name := range util.Current().Integration.Providers {
+		p, ok := providers[name]
+		if !ok {
+			util.Logger.Printf(
+				"FS: Third Party Integrator: Unknown metadata provider: %v", name)
+			continue
+		}
+		enabled = append(enabled, p)
+	}
+	return enabled
+}
+
+// FetchLyrics returns the lyrics of a song from the first enabled provider to
+// have them, or an empty string when none do
+func FetchLyrics(artist, title string) string {
+	q := &SongQuery{Artist: artist, Title: title}
+	for _, p := range EnabledProviders() {
+		lyrics, err := p.FetchLyrics(q)
+		if err == ErrNotSupported {
+			continue
+		}
+		if err != nil {
+			util.Logger.Printf(
+				"FS: Third Party Integrator: Error getting %v lyrics for %v - %v: %v",
+				p.Name(), artist, title, err)
+			continue
+		}
+		if lyrics != "" {
+			return lyrics
+		}
+	}
+	return ""
+}
+
+// mergeMetadata fills the empty fields of the struct dst points to with those
+// of src, which points to the same type. Merging in priority order keeps the
+// values of the highest priority provider to have them.
+func mergeMetadata(dst, src interface{}) {
+	if reflect.ValueOf(src).IsNil() {
+		return
+	}
+
+	d := reflect.ValueOf(dst).Elem()
+	s := reflect.ValueOf(src).Elem()
+	for i := 0; i < d.NumField(); i++ {
+		if isEmptyValue(d.Field(i)) && !isEmptyValue(s.Field(i)) {
+			d.Field(i).Set(s.Field(i))
+		}
+	}
+}
+
+// isEmptyValue reports whether v holds nothing. Unlike a zero value, empty
+// slices and structs of empty fields are empty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !isEmptyValue(v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	return v.IsZero()
+}
@@ -0,0 +1,57 @@
+package integrated
+
+import (
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// sortName returns the sort name found in an artist's metadata by the highest
+// priority provider to have one
+func sortName(providers []MetadataProvider, md *db.ArtistMetadata) string {
+	for _, p := range providers {
+		if name := p.FetchSortName(md); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// fetchSortNames gives the artists without a sort title the sort name the
+// providers find in their third party metadata. Sort titles read from the
+// tags are kept.
+func (i TPIntegrator) fetchSortNames() {
+	providers := EnabledProviders()
+	count := 0
+
+	artists, err := db.DB.ArtistsWithoutSortTitle()
+	if err != nil {
+		util.Logger.Printf(
+			"FS: Third Party Integrator: Errored finding Artists: %v", err)
+		return
+	}
+	for _, artist := range artists {
+		artist := artist
+		md, err := artist.GetMetadata()
+		if err != nil {
+			util.Logger.Printf(
+				"FS: Third Party Integrator: Error loading metadata for artist: %v: %v",
+				artist.ID, err)
+			continue
+		}
+
+		name := sortName(providers, md)
+		if name == "" {
+			continue
+		}
+		artist.SortTitle = name
+		if err := artist.Update(); err != nil {
+			util.Logger.Printf(
+				"FS: Third Party Integrator: Error saving sort name for %v: %v",
+				artist.Title, err)
+			continue
+		}
+		count++
+	}
+
+	util.Logger.Printf("FS: Third Party Integrator: Found sort names for %d artists", count)
+}
@@ -1,9 +1,7 @@
 package integrated
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"regexp"
 	"strconv"
 	"strings"
@@ -12,54 +10,24 @@ import (
 	"github.com/divan/num2words"
 	"github.com/eHoward1996/aiomst/db"
 	"github.com/eHoward1996/aiomst/util"
-	"github.com/irlndts/go-discogs"
-	"github.com/pascoej/gomusicbrainz"
 )
 
-// Object representing album data sent to methods to find their third party ids.
-type sendAlbum struct {
-	ID         int
-	MetadataID int
-	Artist     string
-	Title      string
-}
-
-type sendArtist struct {
-	ID 				 int
-	Title      string
-	MetadataID int
-	AlbumMBID  string
-	DiscogsID  int
-}
-
-// Object that holds a musicbrainz response. Either a Album or Artist type.
-type musicbrainzResponse struct {
-	AlbumResponse  *gomusicbrainz.Release
-	ArtistResponse *gomusicbrainz.Artist
-}
-
-type discogsArtistResponse struct {}
-
-// Object that holds a discogs response. Either a Album or Artist type.
-type discogsResponse struct {
-	AlbumResponse  *discogs.Master
-	ArtistResponse *discogsArtistResponse
+// providerMatch is an album found by a provider
+type providerMatch struct {
+	provider MetadataProvider
+	match    *AlbumMatch
 }
 
 type recvAlbum struct {
-	AlbumID       int
-	Album         string
-	ArtistID      int
-	Artist        string
-	MetadataID    int
-	MBRelease     *gomusicbrainz.Release
-	DiscogsMaster *discogs.Master
+	AlbumID  int
+	Album    string
+	ArtistID int
+	Artist   string
+	Matches  []providerMatch
 }
 
 const (
-	maxRoutines       = 5
 	maxRequestRetries = 20
-	maxWorkers        = 5
 	requestRetryTimer = 3
 	discogsPerPage    = 25
 	mbLimit           = 10
@@ -240,71 +208,40 @@ func repeatRequest(req func() (interface{}, error)) (interface{}, error) {
 
 
 func printReceivedAlbum(count, numAlbums int, result *recvAlbum) {	
-	mbId := "errored"
-	dsId := -1
-	if result.MBRelease != nil {
-		mbId = string(result.MBRelease.ID)
-	}
-	if result.DiscogsMaster != nil {
-		dsId = result.DiscogsMaster.ID
-	}
-
 	artistString := formatOutput(result.Artist, 18)
 	albumString := formatOutput(result.Album, 18)
 	processedStr := fmt.Sprintf(
-		"(%v of %v) %v - %v MBID: %v\tDiscogsID: %v", count, numAlbums,
-		artistString, albumString, formatOutput(mbId, 13), dsId)
+		"(%v of %v) %v - %v", count, numAlbums, artistString, albumString)
+	for _, m := range result.Matches {
+		id := "errored"
+		if m.match != nil {
+			id = m.match.ID
+		}
+		processedStr += fmt.Sprintf("\t%v: %v", m.provider.Name(), id)
+	}
 	util.Logger.Print(processedStr)
 }
 
-func updateAlbum(recv *recvAlbum) error {
+// updateIDs stores the IDs found by each provider on the album and its artist
+func updateIDs(recv *recvAlbum) error {
 	album := &db.Album{ID: recv.AlbumID}
 	if err := db.DB.LoadAlbum(album); err != nil {
 		return err
 	}
-
-	if recv.MBRelease != nil {
-		album.MBID = string(recv.MBRelease.ID)
-	}
-	if recv.DiscogsMaster != nil {
-		album.DiscogsID = recv.DiscogsMaster.ID
-	}
-	
-	return album.Update()
-}
-
-func updateArtist(recv *recvAlbum) error {
 	artist := &db.Artist{ID: recv.ArtistID}
 	if err := db.DB.LoadArtist(artist); err != nil {
 		return err
 	}
 
-	if recv.MBRelease != nil && recv.MBRelease.ArtistCredit != nil {
-		mbidList := make([]string, 0)
-		for _, aCredit := range recv.MBRelease.ArtistCredit.NameCredits {
-			mbArtist := aCredit.Artist
-			if isSimilarString(mbArtist.Name, artist.Title) {
-				mbidList = append(mbidList, string(mbArtist.ID))
-			}
-		}
-		mbidString := strings.Join(mbidList, ",")
-		if mbidString != "" {
-			artist.MBID = mbidString
+	for _, m := range recv.Matches {
+		if m.match != nil {
+			m.provider.Identify(m.match, album, artist)
 		}
 	}
 
-	if recv.DiscogsMaster != nil {
-		discList := make([]string, 0)
-		for _, aSource := range recv.DiscogsMaster.Artists {
-			if isSimilarString(aSource.Name, artist.Title) {
-				discList = append(discList, strconv.Itoa(aSource.ID))
-			}
-		}
-		if len(discList) != 0 {
-			artist.DiscogsID = strings.Join(discList, ",")
-		}
+	if err := album.Update(); err != nil {
+		return err
 	}
-
 	return artist.Update()
 }
 
@@ -317,32 +254,28 @@ func (i TPIntegrator) createAlbumMetadata(recv *recvAlbum) {
 		return
 	}
 
-	md, err := albumObj.GetMetadataObj()
+	stored, err := albumObj.GetMetadata()
 	if err != nil {
 		util.Logger.Printf(
-			"FS: Third Party Integrator: Error loading metadata with ID: %v",
-			albumObj.MetadataID)
+			"FS: Third Party Integrator: Error loading metadata for album: %v: %v",
+			albumObj.ID, err)
 		return
 	}
 
-	mdOnFileAsObj := new(db.AlbumMetadata)
-	md.ToStruct(mdOnFileAsObj)
-	mMD := mdOnFileAsObj.MusicBrainz
-	dMD := mdOnFileAsObj.Discogs
-
-	if mMD.Release.Title == "" && recv.MBRelease != nil {
-		mMD = buildMBAlbumMetadata(recv.MBRelease)	
-	}
-	if dMD.Title == "" && recv.DiscogsMaster != nil {
-		dMD = buildDiscogsAlbumMetadata(recv.DiscogsMaster)
+	// What is stored is kept, and the rest is filled in by priority
+	albumMD := stored
+	albumMD.AlbumName = recv.Album
+	for _, m := range recv.Matches {
+		if m.match != nil {
+			mergeMetadata(albumMD, m.match.Metadata)
+		}
 	}
 
-	albumMD := db.AlbumMetadata{
-		AlbumName: recv.Album,
-		MusicBrainz: mMD,
-		Discogs: dMD,
+	if err := albumObj.SetMetadata(albumMD); err != nil {
+		util.Logger.Printf(
+			"FS: Third Party Integrator: Error saving metadata for album: %v: %v",
+			albumObj.ID, err)
 	}
-	writeMetadata(recv.MetadataID, albumMD)
 }
 
 func (i TPIntegrator) createArtistMetadata(recv *recvAlbum) {
@@ -354,57 +287,42 @@ func (i TPIntegrator) createArtistMetadata(recv *recvAlbum) {
 			return
 	}
 
-	md, err := artistObj.GetMetadataObj()
+	stored, err := artistObj.GetMetadata()
 	if err != nil {
 		util.Logger.Printf(
-			"FS: Third Party Integrator: Error loading metadata with ID: %v",
-			artistObj.MetadataID)
+			"FS: Third Party Integrator: Error loading metadata for artist: %v: %v",
+			artistObj.ID, err)
 			return
 	}
 
-	mdOnFileAsObj := new(db.ArtistMetadata)
-	md.ToStruct(mdOnFileAsObj)
-	mMD := mdOnFileAsObj.MusicBrainz
-	dMD := mdOnFileAsObj.Discogs
-
-	mbArtistLen := len(mMD.Artists)
-	diArtistLen := len(dMD.Artists)
-
-	if mbArtistLen == 0 && recv.MBRelease != nil {
-		mMD = buildMBArtistMetadata(recv.Artist, recv.MBRelease)
-	}
-	if diArtistLen == 0 && recv.DiscogsMaster != nil {
-		discMaster := *recv.DiscogsMaster
-		dMD = buildDiscogsArtistMetadata(discMaster.Artists)
-	}
-
-	artistMD := db.ArtistMetadata{
-		ArtistName: recv.Artist,	
-		MusicBrainz: mMD,
-		Discogs: dMD,
+	// What is stored is kept, and the rest is filled in by priority
+	artistMD := &db.ArtistMetadata{}
+	*artistMD = *stored
+	artistMD.ArtistName = recv.Artist
+	q := &AlbumQuery{
+		ID:       recv.AlbumID,
+		ArtistID: recv.ArtistID,
+		Artist:   recv.Artist,
+		Title:    recv.Album,
 	}
-	writeMetadata(artistObj.MetadataID, artistMD)
-}
-
-func writeMetadata(metadataID int, md interface{}) {
-	bytes, err := json.MarshalIndent(md, "", "  ")
-	if err != nil {
-		util.Logger.Printf(
-			"FS: Third Party Integrator: Error writing Metadata file: %v", err)
-		return
+	for _, m := range recv.Matches {
+		if m.match == nil {
+			continue
+		}
+		md, err := m.provider.LookupArtist(q, m.match, stored)
+		if err != nil {
+			util.Logger.Printf(
+				"FS: Third Party Integrator: Error getting %v content for %v: %v",
+				m.provider.Name(), recv.Artist, err)
+			continue
+		}
+		mergeMetadata(artistMD, md)
 	}
 
-	tmp := new(db.Metadata)
-	tmp.ID = metadataID
-	if err := tmp.Load(); err != nil {
+	if err := artistObj.SetMetadata(artistMD); err != nil {
 		util.Logger.Printf(
-			"FS: Third Party Integrator: Error loading Metadata object: %v", err)
-		return
-	}
-	if err := ioutil.WriteFile(tmp.Path, bytes, 0644); err != nil {
-		util.Logger.Printf(
-			"FS: Third Party Integrator: Error writing Metadata File: %v", err)
-		return
+			"FS: Third Party Integrator: Error saving metadata for artist: %v: %v",
+			artistObj.ID, err)
 	}
 }
 
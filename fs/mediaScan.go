@@ -1,6 +1,7 @@
 package fs
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/playlist"
 	"github.com/eHoward1996/aiomst/util"
 
 	"github.com/karrick/godirwalk"
@@ -20,32 +22,94 @@ import (
 type MediaScan struct	{
 	baseFolder 	string
 	subFolder 	string
+	library 		*db.Library
 	verbose 		bool
 }
 type attachables struct {
 	art *db.Art
-	md  *db.Metadata
 }
 
+// tagJob is an audio file whose tags are waiting to be read. For unchanged
+// songs only the fingerprint or embedded art which was not recorded is read.
+type tagJob struct {
+	path            string
+	info            os.FileInfo
+	folder          *db.Folder
+	unchanged       bool
+	known           db.SongFile
+	readFingerprint bool
+	readArt         bool
+}
+
+// tagResult is the song read from a tagJob's file, along with the hash and
+// size of its embedded picture
+type tagResult struct {
+	tagJob
+	song        *db.Song
+	fingerprint string
+	artHash     string
+	artSize     int64
+	err         error
+}
+
+// scanBatchSize is the number of new or changed songs saved per transaction
+const scanBatchSize = 500
+
+// progressInterval is the number of files walked between progress reports
+const progressInterval = 250
+
 // Cache repetitive entries
 var folderCache = map[string]*db.Folder{}
 var artistCache = map[string]*db.Artist{}
 var albumCache  = map[string]*db.Album{}
+var artistIDCache = map[int]*db.Artist{}
+var albumIDCache  = map[int]*db.Album{}
+
+// The songs already in the database, used to skip files which are unchanged
+var knownSongs = map[string]db.SongFile{}
+
+// New and changed songs waiting to be saved in a batch
+var newSongs     = []*db.Song{}
+var changedSongs = []*db.Song{}
+
+// The inodes, fingerprints and embedded art of unchanged songs which were not
+// recorded, keyed by song ID
+var songInodes       = map[int]int64{}
+var songFingerprints = map[int]string{}
+var songArt          = map[int]int{}
+
+// Embedded art by the hash of its picture, and the embedded art found for
+// each album by ID
+var embeddedArtCache = map[string]*db.Art{}
+var albumEmbeddedArt = map[int]*db.Art{}
+
+// The songs re-linked to a copy of their file by this scan, by ID
+var relinkedSongs = map[int]bool{}
 
 // Track folder IDs to HasAttachables (db.Artist & db.Album)
 var fIDHasAttachables = map[int]HasAttachables{}
 
-// Track folder IDs to Attachables (db.Art & db.Metadata)
+// Track folder IDs to Attachables (db.Art)
 var folderAttachables = map[int]attachables{}
 
+// The library new folders, songs and art are added to
+var scanLibraryID = 0
+
+// Playlist files are imported once every song has been scanned
+var playlistFiles = []string{}
+
 // Track Metrics
 var artCount      int = 0
 var artistCount   int = 0
 var albumCount    int = 0
 var songCount     int = 0
 var songUpdate    int = 0
+var songSkip      int = 0
+var songMoved     int = 0
+var fileCount     int = 0
 var folderCount   int = 0
-var metadataCount int = 0
+var folderMoved   int = 0
+var playlistCount int = 0
 
 
 // Folders returns the base and sub folders for scanning
@@ -59,6 +123,16 @@ func (fs *MediaScan) SetFolders(baseFolder, subFolder string)	{
 	fs.subFolder  = subFolder
 }
 
+// Library returns the library being scanned
+func (fs *MediaScan) Library() *db.Library {
+	return fs.library
+}
+
+// SetLibrary sets the library being scanned
+func (fs *MediaScan) SetLibrary(l *db.Library) {
+	fs.library = l
+}
+
 // Verbose is whether scanning has verbose output or not
 func (fs *MediaScan) Verbose(v bool)	{
 	fs.verbose = v
@@ -69,21 +143,13 @@ func (fs *MediaScan) WhoAmI() string {
 	return "Media Scan"
 }
 
-// Scan scans for media files in the filesystem
+// Scan scans for media files in the filesystem. When ctx is cancelled the
+// walk halts, and the songs already read are saved before returning.
 func (fs *MediaScan) Scan(
-	baseFolder, subFolder string, walkCancelChan chan struct{}) (int, error) {
-		// Halt file system walk if needed
-		var mutex sync.RWMutex
-		haltWalk := false
-		go func(haltWalk bool)	{
-			// Wait until signal recieved
-			<- walkCancelChan
-
-			// Halt fs walk
-			mutex.Lock()
-			haltWalk = true
-			mutex.Unlock()
-		}(haltWalk)
+	ctx context.Context, baseFolder, subFolder string) (int, error) {
+		if fs.library == nil {
+			return 0, errors.New("FS: Media Scan: No library set")
+		}
 
 		// Track metrics
 		artCount      = 0
@@ -91,20 +157,82 @@ func (fs *MediaScan) Scan(
 		albumCount    = 0
 		songCount     = 0
 		songUpdate    = 0
+		songSkip      = 0
+		songMoved     = 0
+		fileCount     = 0
 		folderCount   = 0
-		metadataCount = 0
+		folderMoved   = 0
+		playlistCount = 0
 		startTime := time.Now()
 
+		scanLibraryID = fs.library.ID
+		defer func() {
+			ReportProgress(ctx, fs.metrics())
+		}()
 		folderCache = map[string]*db.Folder{}
 		artistCache = map[string]*db.Artist{}
 		albumCache  = map[string]*db.Album{}
+		artistIDCache = map[int]*db.Artist{}
+		albumIDCache  = map[int]*db.Album{}
+		fIDHasAttachables = map[int]HasAttachables{}
+		folderAttachables = map[int]attachables{}
+		newSongs     = []*db.Song{}
+		changedSongs = []*db.Song{}
+		songInodes   = map[int]int64{}
+		songFingerprints = map[int]string{}
+		songArt          = map[int]int{}
+		relinkedSongs    = map[int]bool{}
+		embeddedArtCache = map[string]*db.Art{}
+		albumEmbeddedArt = map[int]*db.Art{}
+		playlistFiles = []string{}
+
+		known, err := db.DB.SongFilesInPath(baseFolder)
+		if err != nil {
+			return 0, fmt.Errorf("FS: Media Scan: Error loading songs: %v", err)
+		}
+		knownSongs = known
 		
 		if fs.verbose	{
-			util.Logger.Printf("FS: Scanning: %s", baseFolder)
+			util.Logger.Printf("FS: Scanning: %s [library: %s]", baseFolder, fs.library.Name)
+		}
+
+		// Tags are read by a pool of workers, while everything touching the
+		// database stays on this goroutine
+		jobs := make(chan tagJob)
+		results := make(chan tagResult)
+		var wg sync.WaitGroup
+		for i := 0; i < util.C.Scanner.Workers; i++ {
+			wg.Add(1)
+			go readTags(ctx, jobs, results, &wg)
+		}
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
+
+		// Handle finished files while waiting for a free worker
+		queueTags := func(job tagJob) {
+			for {
+				select {
+				case jobs <- job:
+					return
+				case result := <-results:
+					handleTags(result)
+				case <-ctx.Done():
+					return
+				}
+			}
 		}
 
-		godirwalk.Walk(baseFolder, &godirwalk.Options{
+		walkErr := godirwalk.Walk(baseFolder, &godirwalk.Options{
 			Callback: func(osPathname string, de *godirwalk.Dirent) error	{
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if fileCount++; fileCount%progressInterval == 0 {
+					ReportProgress(ctx, fs.metrics())
+				}
+
 				info := de.ModeType()
 				util.Logger.Printf("FS: Media Scan: Got new file: %s", osPathname)
 				
@@ -117,13 +245,13 @@ func (fs *MediaScan) Scan(
 				}
 				
 				ext := path.Ext(osPathname)
-				var isMetadata bool = false
-				if osPathname[len(osPathname)-len(metadataFile):] == metadataFile {
-					isMetadata = true
+
+				if _, ok := playlist.Format(osPathname); ok {
+					playlistFiles = append(playlistFiles, osPathname)
+					return nil
 				}
 
-				if img, audio := imgType[ext], audioType[ext];
-				!img && !audio && !isMetadata {
+				if img, audio := imgType[ext], audioType[ext]; !img && !audio {
 					return nil
 				}
 				
@@ -138,20 +266,8 @@ func (fs *MediaScan) Scan(
 					return nil
 				}
 
-				if isMetadata {
-					md, err := handleMetadata(osPathname, folder)
-					if err != nil {
-						return fmt.Errorf(
-							"FS: Media Scan: Error handling Metadata: %s", err)
-					}
-					currentVal := folderAttachables[folder.ID]
-					currentVal.md = md
-					folderAttachables[folder.ID] = currentVal
-					return nil
-				}
-
 				if _, ok := audioType[ext]; ok {
-					err := handleAudio(osPathname, info, folder)
+					err := handleAudio(osPathname, folder, queueTags)
 					if err != nil {
 						return fmt.Errorf(
 							"FS: Media Scan: Error handling audio file: %s", err)
@@ -160,13 +276,32 @@ func (fs *MediaScan) Scan(
 				return nil
 			},
 			ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
+				if ctx.Err() != nil {
+					return godirwalk.Halt
+				}
 				util.Logger.Printf("%s", err)
 				return godirwalk.SkipNode
 			},
 			Unsorted: true,
 		})
+
+		close(jobs)
+		for result := range results {
+			handleTags(result)
+		}
+		saveSongs()
+		if ctx.Err() != nil {
+			return fs.changes(), ctx.Err()
+		}
+		if walkErr != nil {
+			return fs.changes(), walkErr
+		}
 		
 		joinAttachables()
+		if err := ctx.Err(); err != nil {
+			return fs.changes(), err
+		}
+		importPlaylists()
 		if err := db.DB.TruncateLog(); err != nil {
 			util.Logger.Printf("FS: Media Scan: Could not truncate WAL File: %v", err)
 		}
@@ -177,47 +312,98 @@ func (fs *MediaScan) Scan(
 			util.Logger.Printf(
 				"FS: Media Scan: Added: " +
 				"[art: %d] [artists: %d] [albums: %d] [songs: %d] " + 
-				"[folders: %d] [metadata: %d]",
+				"[folders: %d] [playlists: %d]",
 				artCount, artistCount, albumCount,
-				songCount, folderCount, metadataCount,
+				songCount, folderCount, playlistCount,
 			)
 			
 			util.Logger.Printf("FS: Updated: [songs: %d]", songUpdate)
+			util.Logger.Printf("FS: Moved: [folders: %d] [songs: %d]", folderMoved, songMoved)
+			util.Logger.Printf("FS: Unchanged: [songs: %d]", songSkip)
 		}
 
-		sum := artCount + artistCount + albumCount + songCount + folderCount
-		return sum, nil
+		return fs.changes(), nil
 }
 
-func handleFolder(cPath string, info os.FileMode)	(*db.Folder, error) {
-	// Check for cached folder
-	if seenFolder, ok := folderCache[cPath]; ok	{
-		return seenFolder, nil
+// changes returns the number of items added or moved by the scan so far
+func (fs *MediaScan) changes() int {
+	return artCount + artistCount + albumCount + songCount + folderCount +
+		playlistCount + songMoved + folderMoved
+}
+
+// metrics returns the scan's counters, reported as its progress
+func (fs *MediaScan) metrics() map[string]int {
+	return map[string]int{
+		"files":          fileCount,
+		"art":            artCount,
+		"artists":        artistCount,
+		"albums":         albumCount,
+		"songs":          songCount,
+		"songsUpdated":   songUpdate,
+		"songsUnchanged": songSkip,
+		"songsMoved":     songMoved,
+		"folders":        folderCount,
+		"foldersMoved":   folderMoved,
+		"playlists":      playlistCount,
 	}
+}
 
+// importPlaylists imports the playlist files found during the scan. Their
+// entries are resolved against the songs in the database, so this must run
+// after the walk.
+func importPlaylists() {
+	for _, file := range playlistFiles {
+		p, changed, err := playlist.ImportFile(file)
+		if err != nil {
+			util.Logger.Printf("FS: Media Scan: Error importing playlist %s: %v", file, err)
+			continue
+		}
+
+		if changed {
+			playlistCount++
+			util.Logger.Printf(
+				"FS: Media Scan: Playlist: [#%05d] %s (%d songs)", p.ID, p.Name, p.SongCount)
+		}
+	}
+}
+
+func handleFolder(cPath string, info os.FileMode)	(*db.Folder, error) {
 	folder := new(db.Folder)
 	if info.IsDir() {
 		folder.Path = cPath
 	}	else	{
 		folder.Path = path.Dir(cPath)
 	}
+
+	// Check for cached folder
+	if seenFolder, ok := folderCache[folder.Path]; ok	{
+		return seenFolder, nil
+	}
 	
 	err := folder.Load()
 	if err == nil {
-		folderCache[cPath] = folder
+		if err := recordFolderInode(folder); err != nil {
+			return nil, err
+		}
+		folderCache[folder.Path] = folder
 		return folder, nil
 	}
 
 	if err == sql.ErrNoRows  {
-		if _, err := os.Stat(path.Join(cPath, metadataFile)); os.IsNotExist(err) {
-			if _, err := os.Create(path.Join(cPath, metadataFile)); err != nil {
-				util.Logger.Printf("FS: Media Scan: Error creating Metadata file: %v", err)
-			} else {
-				util.Logger.Printf(
-					"FS: Media Scan: Handle Folder: Created new Metadata File at: %#v",
-					path.Join(cPath, metadataFile))
-			}
+		// A folder moved within the library keeps its ID and its contents
+		moved, err := relinkFolder(folder.Path)
+		if err != nil {
+			return nil, err
+		} else if moved != nil {
+			folderCache[moved.Path] = moved
+			return moved, nil
+		}
+
+		stat, err := os.Stat(folder.Path)
+		if err != nil {
+			return nil, err
 		}
+		folder.Inode = fileInode(stat)
 
 		files, err := godirwalk.ReadDirents(folder.Path, nil)
 		if err != nil {
@@ -229,6 +415,7 @@ func handleFolder(cPath string, info os.FileMode)	(*db.Folder, error) {
 
 		util.Logger.Printf("FS: Media Scan: Found %v files in %v", len(files), cPath)
 		folder.Title = path.Base(folder.Path)
+		folder.LibraryID = scanLibraryID
 		parent := new(db.Folder)
 		if info.IsDir() {
 			parent.Path = path.Dir(cPath)
@@ -254,12 +441,87 @@ func handleFolder(cPath string, info os.FileMode)	(*db.Folder, error) {
 	return folder, nil
 }
 
+// recordFolderInode records the inode of a folder scanned before inodes were
+// recorded, or which was replaced by a copy
+func recordFolderInode(folder *db.Folder) error {
+	stat, err := os.Stat(folder.Path)
+	if err != nil {
+		return err
+	}
+	if inode := fileInode(stat); inode != folder.Inode {
+		folder.Inode = inode
+		return folder.Update()
+	}
+	return nil
+}
+
+// relinkFolder moves a folder in the library which is missing from its path
+// to p, when the folder at p has the same inode. Nil is returned when no
+// folder was moved there.
+func relinkFolder(p string) (*db.Folder, error) {
+	stat, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	inode := fileInode(stat)
+	if inode == 0 {
+		return nil, nil
+	}
+
+	folders, err := db.DB.FoldersWithInode(scanLibraryID, inode)
+	if err != nil {
+		return nil, err
+	}
+	for i := range folders {
+		folder := &folders[i]
+		if _, err := os.Stat(folder.Path); !os.IsNotExist(err) {
+			continue
+		}
+
+		parent := &db.Folder{Path: path.Dir(p)}
+		if err := parent.Load(); err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		oldPath := folder.Path
+		if err := db.DB.MoveFolder(folder, p, parent.ID); err != nil {
+			return nil, err
+		}
+
+		// The songs moved along with the folder are not new
+		moved, err := db.DB.SongFilesInPath(p)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range moved {
+			knownSongs[k] = v
+		}
+
+		folderMoved++
+		util.Logger.Printf("FS: Media Scan: Moved folder: %s -> %s", oldPath, p)
+		return folder, nil
+	}
+	return nil, nil
+}
+
 func handleImg(cPath string, folder *db.Folder) (*db.Art, error) {
 	art := new(db.Art)
 	art.Path = cPath
 
 	err := art.Load()
 	if err == nil {
+		// A replaced file is recorded, so its resized copies are remade
+		data, err := os.Stat(cPath)
+		if err != nil {
+			return nil, err
+		}
+		if data.Size() != art.FileSize || data.ModTime().Unix() != art.LastModified {
+			art.FileSize = data.Size()
+			art.LastModified = data.ModTime().Unix()
+			if err := art.Update(); err != nil {
+				return nil, err
+			}
+		}
 		return art, nil
 	}
 
@@ -270,6 +532,7 @@ func handleImg(cPath string, folder *db.Folder) (*db.Art, error) {
 		}
 
 		art.FolderID = folder.ID
+		art.LibraryID = folder.LibraryID
 		art.FileSize = data.Size()
 		art.LastModified = data.ModTime().Unix()
 		if art.FileSize == 0 {
@@ -285,53 +548,204 @@ func handleImg(cPath string, folder *db.Folder) (*db.Art, error) {
 	return nil, err
 }
 
-func handleMetadata(cPath string, folder *db.Folder) (*db.Metadata, error) {
-	md := new(db.Metadata)
-	md.Path = cPath
-	
-	err := md.Load()
-	if err == nil {
-		return md, nil
+// handleAudio queues an audio file to have its tags read, unless it is
+// unchanged since it was last scanned
+func handleAudio(cPath string, folder *db.Folder, queueTags func(tagJob)) error	{
+	data, err := os.Stat(cPath)
+	if err != nil {
+		return err
+	}
+	if data.Size() == 0 {
+		return errors.New("Audio File Size is 0")
 	}
 
-	if err == sql.ErrNoRows {
-		data, err := os.Stat(cPath)
-		if err != nil {
-			return nil, err
+	known, ok := knownSongs[cPath]
+	if !ok {
+		// A song moved within the library keeps its ID and play history
+		if known, ok, err = relinkSong(cPath, data, folder); err != nil {
+			return err
 		}
+	}
 
-		md.FolderID = folder.ID
-		md.FileSize = data.Size()
-		md.LastModified = data.ModTime().Unix()
-		if err := md.Save(); err != nil {
-			return nil, err
-		} 
+	if ok && known.Unchanged(data.Size(), data.ModTime().Unix()) {
+		if inode := fileInode(data); inode != known.Inode {
+			songInodes[known.ID] = inode
+		}
+		songSkip++
+		if err := handleUnchanged(known); err != nil {
+			return err
+		}
 
-		metadataCount++
-		return md, nil
+		// Songs scanned before embedded art was read are only read again
+		// while their album may need it
+		job := tagJob{path: cPath, info: data, folder: folder, unchanged: true, known: known}
+		job.readFingerprint = known.Fingerprint == ""
+		job.readArt = known.ArtID == 0 &&
+			(albumIDCache[known.AlbumID].ArtID == 0 ||
+				util.Current().Art.Priority == db.ArtEmbedded)
+		if job.readFingerprint || job.readArt {
+			queueTags(job)
+		}
+		return nil
 	}
-	return nil, err
+
+	queueTags(tagJob{path: cPath, info: data, folder: folder})
+	return nil
 }
 
-func handleAudio(cPath string, info os.FileMode, folder *db.Folder) error	{
-	song, err := db.SongFromFile(cPath)
-	if err != nil {
-		return err
+// relinkSong moves a song in the library whose file is missing from its path
+// to p, when the file at p has the same inode and size. False is returned
+// when no song was moved there.
+func relinkSong(
+	p string, info os.FileInfo, folder *db.Folder) (db.SongFile, bool, error) {
+	inode := fileInode(info)
+	if inode == 0 {
+		return db.SongFile{}, false, nil
 	}
 
-	data, err := os.Stat(cPath)
+	files, err := db.DB.SongFilesWithInode(scanLibraryID, inode)
 	if err != nil {
-		return err
+		return db.SongFile{}, false, err
 	}
-	if data.Size() == 0 {
-		return errors.New("Audio File Size is 0")
+	for _, f := range files {
+		// An inode may be reused by another file once the song's is deleted
+		if f.FileSize != info.Size() {
+			continue
+		}
+		if _, err := os.Stat(f.Path); !os.IsNotExist(err) {
+			continue
+		}
+
+		oldPath := f.Path
+		if err := db.DB.MoveSong(&f, p, folder.ID); err != nil {
+			return db.SongFile{}, false, err
+		}
+		knownSongs[p] = f
+
+		songMoved++
+		util.Logger.Printf("FS: Media Scan: Moved song: %s -> %s", oldPath, p)
+		return f, true, nil
+	}
+	return db.SongFile{}, false, nil
+}
+
+// handleUnchanged tracks the artist and album of a song which has not changed,
+// so art added to their folders is still attached
+func handleUnchanged(known db.SongFile) error {
+	artist, ok := artistIDCache[known.ArtistID]
+	if !ok {
+		artist = &db.Artist{ID: known.ArtistID}
+		if err := artist.Load(); err != nil {
+			return err
+		}
+		artistIDCache[artist.ID] = artist
+	}
+	fIDHasAttachables[artist.FolderID] = artist
+
+	album, ok := albumIDCache[known.AlbumID]
+	if !ok {
+		album = &db.Album{ID: known.AlbumID}
+		if err := album.Load(); err != nil {
+			return err
+		}
+		albumIDCache[album.ID] = album
+	}
+	fIDHasAttachables[album.FolderID] = album
+
+	// Keep the album's embedded art, so the art priority is still applied
+	if known.ArtID != 0 && albumEmbeddedArt[album.ID] == nil {
+		art := &db.Art{ID: known.ArtID}
+		if err := art.Load(); err == nil {
+			albumEmbeddedArt[album.ID] = art
+		} else if err != sql.ErrNoRows {
+			return err
+		}
 	}
+	return nil
+}
+
+// readTags reads the tags of the files sent on jobs until it is closed. Files
+// are skipped once ctx is cancelled.
+func readTags(
+	ctx context.Context, jobs <-chan tagJob, results chan<- tagResult, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for job := range jobs {
+		if ctx.Err() != nil {
+			continue
+		}
 
-	song.Path = cPath
-	song.FileSize = data.Size()
-	song.LastModified = data.ModTime().Unix()
-	song.FolderID = folder.ID
-	song.FileTypeID = db.FileTypeMap[path.Ext(cPath)]
+		// Songs are still scanned when they cannot be fingerprinted, or their
+		// pictures cannot be read
+		result := tagResult{tagJob: job}
+		if !job.unchanged || job.readFingerprint {
+			fp, err := fingerprint(job.path)
+			if err != nil {
+				util.Logger.Printf(
+					"FS: Media Scan: Error fingerprinting audio file: %s: %s", job.path, err)
+			}
+			result.fingerprint = fp
+		}
+		if !job.unchanged || job.readArt {
+			hash, size, err := embeddedArtHash(job.path)
+			if err != nil {
+				util.Logger.Printf(
+					"FS: Media Scan: Error reading embedded art: %s: %s", job.path, err)
+			}
+			result.artHash, result.artSize = hash, size
+		}
+		if job.unchanged {
+			results <- result
+			continue
+		}
+
+		result.song, result.err = db.SongFromFile(job.path)
+		if result.song != nil {
+			result.song.Fingerprint = result.fingerprint
+		}
+		results <- result
+	}
+}
+
+// handleTags adds the song read from an audio file, along with its artist and
+// album, to the database
+func handleTags(result tagResult) {
+	if result.unchanged {
+		if result.fingerprint != "" {
+			songFingerprints[result.known.ID] = result.fingerprint
+		}
+		if result.artHash != "" {
+			art, err := handleEmbeddedArt(result)
+			if err != nil {
+				util.Logger.Printf(
+					"FS: Media Scan: Error handling embedded art: %s: %s", result.path, err)
+				return
+			}
+			songArt[result.known.ID] = art.ID
+			albumEmbeddedArt[result.known.AlbumID] = art
+		}
+		return
+	}
+
+	if err := handleSong(result); err != nil {
+		util.Logger.Printf(
+			"FS: Media Scan: Error handling audio file: %s: %s", result.path, err)
+	}
+}
+
+func handleSong(result tagResult) error	{
+	if result.err != nil {
+		return result.err
+	}
+
+	song := result.song
+	song.Path = result.path
+	song.FileSize = result.info.Size()
+	song.LastModified = result.info.ModTime().Unix()
+	song.Inode = fileInode(result.info)
+	song.FolderID = result.folder.ID
+	song.LibraryID = result.folder.LibraryID
+	song.FileTypeID = db.FileTypeMap[path.Ext(result.path)]
 
 	artist, err := handleArtist(song)
 	if err != nil {
@@ -347,23 +761,44 @@ func handleAudio(cPath string, info os.FileMode, folder *db.Folder) error	{
 	song.AlbumID = album.ID
 	fIDHasAttachables[album.FolderID] = album
 
-	err = checkForModification(song)
-	if err != nil {
-		return err
+	// Songs are still saved when one of their credits cannot be
+	for i := range song.Credits {
+		credited, err := handleCredit(song.Credits[i].Artist)
+		if err != nil {
+			util.Logger.Printf(
+				"FS: Media Scan: Error handling artist %s: %s: %s",
+				song.Credits[i].Artist, result.path, err)
+			continue
+		}
+		song.Credits[i].ArtistID = credited.ID
 	}
+
+	// Songs are still saved when their picture cannot be
+	if result.artHash != "" {
+		art, err := handleEmbeddedArt(result)
+		if err != nil {
+			util.Logger.Printf(
+				"FS: Media Scan: Error handling embedded art: %s: %s", result.path, err)
+		} else {
+			song.ArtID = art.ID
+			albumEmbeddedArt[album.ID] = art
+		}
+	}
+
+	checkForModification(song)
 	return nil
 }
 
 func handleArtist(song *db.Song) (*db.Artist, error) {
 	artist := db.GetArtistFromSong(song)
 	if seenArtist, ok := artistCache[artist.Title]; ok {
-		return seenArtist, nil
+		return seenArtist, sortArtist(seenArtist, song)
 	}
 
-	err := artist.Load()
+	err := loadArtist(artist)
 	if err == nil {
 		artistCache[artist.Title] = artist
-		return artist, nil
+		return artist, sortArtist(artist, song)
 	}
 
 	if err == sql.ErrNoRows 	{
@@ -376,7 +811,6 @@ func handleArtist(song *db.Song) (*db.Artist, error) {
 		}
 		artist.MBID = errStartValueString
 		artist.DiscogsID = errStartValueString
-		artist.MetadataID = 0
 		if err := artist.Save(); err != nil {
 			return nil, fmt.Errorf(
 				"FS: Media Scan: Handle Artist: Error Saving: %v", err)
@@ -391,18 +825,54 @@ func handleArtist(song *db.Song) (*db.Artist, error) {
 	return nil, fmt.Errorf("FS: Media Scan: Handle Artist: Other Error: %v", err)
 }
 
+// handleCredit returns the artist credited on a song by name, adding them
+// when they are new. Artists only credited on songs have no folder.
+func handleCredit(title string) (*db.Artist, error) {
+	if seenArtist, ok := artistCache[title]; ok {
+		return seenArtist, nil
+	}
+
+	artist := db.GetArtistFromSong(&db.Song{Artist: title})
+	err := loadArtist(artist)
+	if err == sql.ErrNoRows {
+		artist.MBID = errStartValueString
+		artist.DiscogsID = errStartValueString
+		if err := artist.Save(); err != nil {
+			return nil, err
+		}
+
+		artistCount++
+		util.Logger.Printf("FS: Media Scan: Artist: [#%05d] %s", artist.ID, artist.Title)
+	} else if err != nil {
+		return nil, err
+	}
+
+	artistCache[title] = artist
+	return artist, nil
+}
+
+// loadArtist loads an artist by title, or by normalized title when it is
+// written differently, as titles which normalize the same are one artist
+func loadArtist(artist *db.Artist) error {
+	err := artist.Load()
+	if err == sql.ErrNoRows {
+		err = db.DB.LoadArtistByNormalizedTitle(artist)
+	}
+	return err
+}
+
 func handleAlbum(song *db.Song) (*db.Album, error) {
 	album := db.GetAlbumFromSong(song)
 	album.ArtistID = song.ArtistID
 	albumCacheKey := strconv.Itoa(album.ArtistID) + "_" + album.Title 
 	if seenAlbum, ok := albumCache[albumCacheKey]; ok {
-		return seenAlbum, nil
+		return seenAlbum, markAlbum(seenAlbum, song)
 	} 
 
 	err := album.Load()
 	if err == nil {
 		albumCache[albumCacheKey] = album
-		return album, nil
+		return album, markAlbum(album, song)
 	}
 
 	if err == sql.ErrNoRows {
@@ -410,7 +880,6 @@ func handleAlbum(song *db.Song) (*db.Album, error) {
 		album.FolderID = song.FolderID
 		album.MBID = errStartValueString
 		album.DiscogsID = errStartValueInt
-		album.MetadataID = 0
 		if err := album.Save(); err != nil {
 			return nil, fmt.Errorf(
 				"FS: Media Scan: Handle Album: Error Saving: %v", err)
@@ -427,40 +896,140 @@ func handleAlbum(song *db.Song) (*db.Album, error) {
 	return nil, fmt.Errorf("FS: Media Scan: Handle Album: Other Error: %s", err)
 }
 
-func checkForModification(origin *db.Song) error	{
-	song2 := new(db.Song)
-	song2.Path = origin.Path
-
-	// Check if the song exists
-	err := song2.Load()
-	if err == nil  {
-		if origin.LastModified > song2.LastModified {
-			// Update Existing
-			origin.ID = song2.ID
-			if err2 := origin.Update(); err2 != nil {
-				return fmt.Errorf("FS: Media Scan: Check Modifications: %v", err2)
-			}
+// sortArtist gives an artist the sort title read from a song's tags, which
+// takes the place of one found by a provider
+func sortArtist(artist *db.Artist, song *db.Song) error {
+	if song.ArtistSort == "" || artist.SortTitle == song.ArtistSort {
+		return nil
+	}
+	artist.SortTitle = song.ArtistSort
+	return artist.Update()
+}
 
-			songUpdate++
-			return nil
-		}
+// markAlbum marks an album as a compilation when one of its songs is, and
+// gives it the sort title read from the song's tags
+func markAlbum(album *db.Album, song *db.Song) error {
+	changed := false
+	if song.Compilation && !album.Compilation {
+		album.Compilation = true
+		changed = true
+	}
+	if song.AlbumSort != "" && album.SortTitle != song.AlbumSort {
+		album.SortTitle = song.AlbumSort
+		changed = true
+	}
 
-		// The song exists, but it hasn't been changed
-		origin = song2
+	if !changed {
 		return nil
 	}
+	return album.Update()
+}
 
-	if err == sql.ErrNoRows {
+// checkForModification queues a song to be saved, updating the existing song
+// when its file was scanned before, or when it is a copy of a song whose file
+// is missing
+func checkForModification(origin *db.Song)	{
+	if known, ok := knownSongs[origin.Path]; ok {
+		// Update Existing
+		origin.ID = known.ID
+		changedSongs = append(changedSongs, origin)
+	} else if moved := relinkCopy(origin); moved != nil {
+		// Keep the missing song's ID, play history and MBID
+		origin.ID = moved.ID
+		origin.MBID = ""
+		changedSongs = append(changedSongs, origin)
+
+		relinkedSongs[moved.ID] = true
+		songMoved++
+		util.Logger.Printf("FS: Media Scan: Moved song: %s -> %s", moved.Path, origin.Path)
+	} else {
 		// The song didn't save. So do that...
 		origin.MBID = errStartValueString
-		if err2 := origin.Save(); err2 != nil && err2 != sql.ErrNoRows {
-			return fmt.Errorf("FS: Media Scan: Check Modifications: %v", err2)
-		}	else if err2 == nil {
-			songCount++
-			return nil
+		newSongs = append(newSongs, origin)
+	}
+
+	if len(newSongs)+len(changedSongs) >= scanBatchSize {
+		saveSongs()
+	}
+}
+
+// relinkCopy returns the song in the library whose file is missing and has the
+// same fingerprint as a new song, such as when it was moved to another
+// filesystem. Nil is returned when there is none.
+func relinkCopy(origin *db.Song) *db.Song {
+	if origin.Fingerprint == "" {
+		return nil
+	}
+
+	songs, err := db.DB.SongsWithFingerprint(scanLibraryID, origin.Fingerprint)
+	if err != nil {
+		util.Logger.Printf("FS: Media Scan: Error loading songs: %v", err)
+		return nil
+	}
+	for i := range songs {
+		if relinkedSongs[songs[i].ID] {
+			continue
 		}
-	}	
-	return fmt.Errorf("FS: Media Scan: Check Modification Other Error: %v", err)
+		if _, err := os.Stat(songs[i].Path); os.IsNotExist(err) {
+			return &songs[i]
+		}
+	}
+	return nil
+}
+
+// saveSongs saves the queued new and changed songs, and their credits and
+// genres, each in one transaction
+func saveSongs() {
+	saved := make([]*db.Song, 0, len(newSongs)+len(changedSongs))
+	if len(newSongs) > 0 {
+		if err := db.DB.SaveSongs(newSongs); err != nil {
+			util.Logger.Printf("FS: Media Scan: Error saving songs: %v", err)
+		} else {
+			songCount += len(newSongs)
+			saved = append(saved, newSongs...)
+		}
+		newSongs = []*db.Song{}
+	}
+
+	if len(changedSongs) > 0 {
+		if err := db.DB.UpdateSongs(changedSongs); err != nil {
+			util.Logger.Printf("FS: Media Scan: Error updating songs: %v", err)
+		} else {
+			songUpdate += len(changedSongs)
+			saved = append(saved, changedSongs...)
+		}
+		changedSongs = []*db.Song{}
+	}
+
+	if len(saved) > 0 {
+		if err := db.DB.SaveSongArtists(saved); err != nil {
+			util.Logger.Printf("FS: Media Scan: Error saving song artists: %v", err)
+		}
+		if err := db.DB.SaveSongGenres(saved); err != nil {
+			util.Logger.Printf("FS: Media Scan: Error saving song genres: %v", err)
+		}
+	}
+
+	if len(songFingerprints) > 0 {
+		if err := db.DB.UpdateSongFingerprints(songFingerprints); err != nil {
+			util.Logger.Printf("FS: Media Scan: Error recording song fingerprints: %v", err)
+		}
+		songFingerprints = map[int]string{}
+	}
+
+	if len(songArt) > 0 {
+		if err := db.DB.UpdateSongArt(songArt); err != nil {
+			util.Logger.Printf("FS: Media Scan: Error recording song art: %v", err)
+		}
+		songArt = map[int]int{}
+	}
+
+	if len(songInodes) > 0 {
+		if err := db.DB.UpdateSongInodes(songInodes); err != nil {
+			util.Logger.Printf("FS: Media Scan: Error recording song inodes: %v", err)
+		}
+		songInodes = map[int]int64{}
+	}
 }
 
 func joinAttachables() {
@@ -470,31 +1039,27 @@ func joinAttachables() {
 			continue
 		}
 
+		// Skip folders without art, or whose art is already attached. Albums
+		// pick between file and embedded art by priority.
 		switch x := v.(type) {
 		case *db.Artist:
 			artist := *x
-			if folderAttachables[k].md != nil {
-				md := *folderAttachables[k].md
-				artist.MetadataID = md.ID
-			}
-			if folderAttachables[k].art != nil {
-				art := *folderAttachables[k].art
-				artist.ArtID = art.ID
+			art := folderAttachables[k].art
+			if art == nil || artist.ArtID == art.ID {
+				continue
 			}
+			artist.ArtID = art.ID
 			if err := artist.Update(); err != nil {
 				util.Logger.Printf(
 					"FS: Media Scan: Error updating Artist %v: %v", artist.Title, err)
 			}
 		case *db.Album:	
 			album := *x	
-			if folderAttachables[k].md != nil {
-				md := *folderAttachables[k].md
-				album.MetadataID = md.ID
-			}
-			if folderAttachables[k].art != nil {
-				art := *folderAttachables[k].art
-				album.ArtID = art.ID
+			art := preferredArt(folderAttachables[k].art, albumEmbeddedArt[album.ID])
+			if art == nil || album.ArtID == art.ID {
+				continue
 			}
+			album.ArtID = art.ID
 			if err := album.Update(); err != nil {
 				util.Logger.Printf(
 					"FS: Media Scan: Error updating Album %v - %v: %v", 
@@ -0,0 +1,169 @@
+package fs
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// Size of the library scanned by BenchmarkMediaScan
+const (
+	benchArtists = 5
+	benchAlbums  = 4
+	benchSongs   = 12
+)
+
+// id3Frame returns an ID3v2.3 text frame
+func id3Frame(id, text string) []byte {
+	var b bytes.Buffer
+	b.WriteString(id)
+	binary.Write(&b, binary.BigEndian, uint32(len(text)+1))
+	b.Write([]byte{0, 0, 0}) // Flags, then ISO-8859-1 text
+	b.WriteString(text)
+	return b.Bytes()
+}
+
+// mp3Fixture returns a tiny MP3 file: an ID3v2.3 tag with the given frames,
+// followed by a second of silent MPEG-1 Layer III frames whose padding holds
+// seed, so every file has different audio
+func mp3Fixture(frames map[string]string, seed int) []byte {
+	var tag bytes.Buffer
+	for id, text := range frames {
+		tag.Write(id3Frame(id, text))
+	}
+
+	var b bytes.Buffer
+	b.WriteString("ID3\x03\x00\x00")
+	size := tag.Len()
+	b.Write([]byte{
+		byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f),
+		byte(size >> 7 & 0x7f), byte(size & 0x7f),
+	})
+	b.Write(tag.Bytes())
+
+	// 128 kbps at 44.1 kHz, so 417 bytes a frame and 38 frames a second
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+	binary.BigEndian.PutUint32(frame[len(frame)-4:], uint32(seed))
+	for i := 0; i < 38; i++ {
+		b.Write(frame)
+	}
+	return b.Bytes()
+}
+
+// writeLibrary writes a library of tagged songs, with a cover in each album
+// folder, to dir
+func writeLibrary(b *testing.B, dir string) {
+	for a := 0; a < benchArtists; a++ {
+		for l := 0; l < benchAlbums; l++ {
+			artist := fmt.Sprintf("Artist %d", a)
+			album := fmt.Sprintf("Album %d", l)
+			folder := filepath.Join(dir, artist, album)
+			if err := os.MkdirAll(folder, 0755); err != nil {
+				b.Fatal(err)
+			}
+			if err := ioutil.WriteFile(
+				filepath.Join(folder, "cover.jpg"), []byte("jpg"), 0644); err != nil {
+				b.Fatal(err)
+			}
+
+			for s := 0; s < benchSongs; s++ {
+				data := mp3Fixture(map[string]string{
+					"TPE1": artist,
+					"TPE2": artist,
+					"TALB": album,
+					"TIT2": fmt.Sprintf("Song %d", s),
+					"TRCK": fmt.Sprintf("%d", s+1),
+					"TYER": "1979",
+				}, (a*benchAlbums+l)*benchSongs+s)
+				p := filepath.Join(folder, fmt.Sprintf("%02d.mp3", s+1))
+				if err := ioutil.WriteFile(p, data, 0644); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	}
+}
+
+// openLibrary opens a new database holding a library rooted at dir. The
+// caller closes the database.
+func openLibrary(b *testing.B, dir string) *db.Library {
+	db.DB.DSN(filepath.Join(b.TempDir(), "aiomst.db"))
+	if err := db.DB.Setup(); err != nil {
+		b.Fatal(err)
+	}
+	if err := db.DB.Open(); err != nil {
+		b.Fatal(err)
+	}
+	if err := db.DB.Migrate(); err != nil {
+		b.Fatal(err)
+	}
+
+	l := db.NewLibrary("Music", dir, db.LibraryMusic, 0)
+	if err := l.Save(); err != nil {
+		b.Fatal(err)
+	}
+	return l
+}
+
+// scanLibrary scans a library, failing the benchmark on error
+func scanLibrary(b *testing.B, l *db.Library) {
+	fs := &MediaScan{}
+	fs.SetLibrary(l)
+	if _, err := fs.Scan(context.Background(), l.Root, ""); err != nil {
+		b.Fatal(err)
+	}
+}
+
+// BenchmarkMediaScan measures scanning a library whose songs are all new,
+// which reads every file's tags, and rescanning it unchanged, which skips them
+func BenchmarkMediaScan(b *testing.B) {
+	util.InitializeLogger()
+	util.Logger.SetOutput(ioutil.Discard)
+	b.Cleanup(func() { util.Logger.SetOutput(os.Stdout) })
+	util.SetConfig(util.DefaultConfig())
+
+	dir := b.TempDir()
+	writeLibrary(b, dir)
+	total := benchArtists * benchAlbums * benchSongs
+
+	b.Run("Initial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			l := openLibrary(b, dir)
+			b.StartTimer()
+
+			scanLibrary(b, l)
+
+			b.StopTimer()
+			if songCount != total {
+				b.Fatalf("%d songs added, expected %d", songCount, total)
+			}
+			db.DB.Close()
+			b.StartTimer()
+		}
+	})
+
+	b.Run("Unchanged", func(b *testing.B) {
+		l := openLibrary(b, dir)
+		defer db.DB.Close()
+		scanLibrary(b, l)
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			scanLibrary(b, l)
+			if songSkip != total || songCount != 0 || songUpdate != 0 {
+				b.Fatalf("%d songs unchanged, %d added and %d updated, expected %d unchanged",
+					songSkip, songCount, songUpdate, total)
+			}
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package fs
+
+import (
+	"os"
+	"sync"
+
+	"github.com/eHoward1996/aiomst/util"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/karrick/godirwalk"
+)
+
+// notifyWatcher is told of changes by the operating system, using inotify on
+// Linux, so changes are seen at once without listing the roots. Every folder
+// within a root is watched, as notifications do not cover subfolders.
+type notifyWatcher struct {
+	w      *fsnotify.Watcher
+	events chan WatchEvent
+	done   chan struct{}
+
+	mu      sync.Mutex
+	watched map[string]bool
+}
+
+// newNotifyWatcher creates and starts a notifyWatcher
+func newNotifyWatcher() (*notifyWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	nw := &notifyWatcher{
+		w:       w,
+		events:  make(chan WatchEvent),
+		done:    make(chan struct{}),
+		watched: make(map[string]bool),
+	}
+	go nw.run()
+	return nw, nil
+}
+
+// run translates the notifications until the watcher is closed, watching
+// folders as they are created. Notifications are drained but dropped once
+// closing, as the watcher cannot stop while sending one.
+func (nw *notifyWatcher) run() {
+	defer close(nw.events)
+
+	for ev := range nw.w.Events {
+		// Notifications for a watch which was just removed have no name
+		if ev.Name == "" {
+			continue
+		}
+
+		var e WatchEvent
+		switch {
+		case ev.Op&(fsnotify.Create|fsnotify.Write) != 0:
+			if ev.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+					// The folder is still scanned when it cannot be watched
+					if err := nw.addTree(ev.Name); err != nil {
+						util.Logger.Printf("FS: Cannot watch %s for changes: %v", ev.Name, err)
+					}
+				}
+			}
+			e = WatchEvent{Op: WatchCreate, Path: ev.Name}
+		case ev.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+			// A moved folder's watch would report its old path, so it is
+			// watched again at its new path when that is created
+			nw.forget(ev.Name)
+			e = WatchEvent{Op: WatchRemove, Path: ev.Name}
+		default:
+			continue
+		}
+
+		select {
+		case nw.events <- e:
+		case <-nw.done:
+		}
+	}
+}
+
+// Add watches a root and every folder within it. When a folder cannot be
+// watched, the folders already watched for the root are forgotten.
+func (nw *notifyWatcher) Add(root string) error {
+	if err := nw.addTree(root); err != nil {
+		nw.forget(root)
+		return err
+	}
+	return nil
+}
+
+// addTree watches a folder and every folder within it
+func (nw *notifyWatcher) addTree(root string) error {
+	return godirwalk.Walk(root, &godirwalk.Options{
+		Callback: func(osPathname string, de *godirwalk.Dirent) error {
+			if !de.IsDir() {
+				return nil
+			}
+
+			nw.mu.Lock()
+			defer nw.mu.Unlock()
+			if nw.watched[osPathname] {
+				return nil
+			}
+			if err := nw.w.Add(osPathname); err != nil {
+				return err
+			}
+			nw.watched[osPathname] = true
+			return nil
+		},
+		ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
+			// Folders removed while walking are not an error
+			if os.IsNotExist(err) {
+				return godirwalk.SkipNode
+			}
+			return godirwalk.Halt
+		},
+		Unsorted: true,
+	})
+}
+
+// forget stops watching a folder and every folder within it
+func (nw *notifyWatcher) forget(root string) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+
+	for p := range nw.watched {
+		if p == root || (len(p) > len(root) && p[:len(root)+1] == root+"/") {
+			nw.w.Remove(p)
+			delete(nw.watched, p)
+		}
+	}
+}
+
+// Events returns the channel receiving the changes
+func (nw *notifyWatcher) Events() <-chan WatchEvent {
+	return nw.events
+}
+
+// Errors returns the channel receiving the errors
+func (nw *notifyWatcher) Errors() <-chan error {
+	return nw.w.Errors
+}
+
+// Close stops watching every folder
+func (nw *notifyWatcher) Close() error {
+	close(nw.done)
+	return nw.w.Close()
+}
+
+// Name describes the watcher
+func (nw *notifyWatcher) Name() string {
+	return "change notifications"
+}
@@ -1,12 +1,14 @@
 package fs
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"os"
-	"sync"
 	"time"
 
 	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/thumbnail"
 	"github.com/eHoward1996/aiomst/util"
 )
 
@@ -14,6 +16,7 @@ import (
 type OrphanScan struct	{
 	baseFolder 	string
 	subFolder 	string
+	library 		*db.Library
 	verbose 		bool
 }
 
@@ -28,6 +31,16 @@ func (fs *OrphanScan) SetFolders(baseFolder, subFolder string)	{
 	fs.subFolder  = subFolder
 }
 
+// Library returns the library being scanned
+func (fs *OrphanScan) Library() *db.Library {
+	return fs.library
+}
+
+// SetLibrary sets the library being scanned
+func (fs *OrphanScan) SetLibrary(l *db.Library) {
+	fs.library = l
+}
+
 // Verbose is whether scanning has verbose output or not
 func (fs *OrphanScan) Verbose(v bool)	{
 	fs.verbose = v
@@ -38,35 +51,55 @@ func (fs *OrphanScan) WhoAmI() string {
 	return "Orphan Scan"
 }
 
-// Scan scans for missing "orphaned" media files in the local filesystem
-func (fs *OrphanScan) Scan(baseFolder, subFolder string, orphanCancelChan chan struct{}) (int, error) {
-	// Halt scan if needed
-	var mutex sync.RWMutex
-	haltWalk := false
-	go func(haltWalk bool) {
-		// Wait for signal
-		<- orphanCancelChan
-
-		// Halt fs walk
-		mutex.Lock()
-		haltWalk = true
-		mutex.Unlock()
-	}(haltWalk)
-
+// Scan scans for missing "orphaned" media files in the local filesystem. When
+// ctx is cancelled the scan stops before the next item, keeping the items
+// already removed.
+func (fs *OrphanScan) Scan(ctx context.Context, baseFolder, subFolder string) (int, error) {
 	// Track metrics about the scan
 	artCount := 0
+	artistCount := 0
+	albumCount := 0
+	genreCount := 0
 	folderCount := 0
 	songCount := 0
+	songMoved := 0
+	playlistCount := 0
 	startTime := time.Now()
 
-	// Check if a baseFolder is set, meaning remove ANYTHING not under this base
+	// Sum up changes
+	changes := func() int {
+		return artCount + artistCount + albumCount + genreCount + songCount + songMoved +
+			folderCount + playlistCount
+	}
+
+	// Report the items removed or moved so far as the scan's progress
+	defer func() {
+		ReportProgress(ctx, map[string]int{
+			"art":        artCount,
+			"artists":    artistCount,
+			"albums":     albumCount,
+			"genres":     genreCount,
+			"songs":      songCount,
+			"songsMoved": songMoved,
+			"folders":    folderCount,
+			"playlists":  playlistCount,
+		})
+	}()
+
+	// Check if a baseFolder is set, meaning remove anything in the library, or
+	// in no library, which is not under its root. Other libraries are left
+	// untouched.
 	if baseFolder != "" {
+		if fs.library == nil {
+			return 0, errors.New("FS: Orphan Scan: No library set")
+		}
 		if fs.verbose {
-			util.Logger.Print("FS: Orphan Scan: Base Folder:", baseFolder)
+			util.Logger.Printf("FS: Orphan Scan: Base Folder: %s [library: %s]",
+				baseFolder, fs.library.Name)
 		}
 
-		// Scan for all art NOT under the base folder
-		art, err := db.DB.ArtNotInPath(baseFolder)
+		// Scan for all art NOT under the library root
+		art, err := db.DB.ArtOutsideLibrary(fs.library)
 		if err != nil {
 			util.Logger.Print(err)
 			return 0, err
@@ -74,18 +107,25 @@ func (fs *OrphanScan) Scan(baseFolder, subFolder string, orphanCancelChan chan s
 
 		// Remove all art which is not in this path
 		for _, a := range art {
+			if err := ctx.Err(); err != nil {
+				return changes(), err
+			}
+
 			// Remove art from database
 			filename := a.Path
 			if err := a.Delete(); err != nil {
 				util.Logger.Print(err)
 				return 0, err
 			}
+			if err := thumbnail.Remove(a.ID); err != nil {
+				util.Logger.Print(err)
+			}
 			util.Logger.Printf("FS: Orphan Scan: Removed File: %v", filename)
 			artCount++
 		}
 
-		// Scan for all songs NOT under the base folder
-		songs, err := db.DB.SongsNotInPath(baseFolder)
+		// Scan for all songs NOT under the library root
+		songs, err := db.DB.SongsOutsideLibrary(fs.library)
 		if err != nil {
 			util.Logger.Print(err)
 			return 0, err
@@ -93,6 +133,10 @@ func (fs *OrphanScan) Scan(baseFolder, subFolder string, orphanCancelChan chan s
 
 		// Remove all songs which are not in this path
 		for _, s := range songs {
+			if err := ctx.Err(); err != nil {
+				return changes(), err
+			}
+
 			// Remove song from database
 			filename := s.Path
 			if err := s.Delete(); err != nil {
@@ -103,8 +147,8 @@ func (fs *OrphanScan) Scan(baseFolder, subFolder string, orphanCancelChan chan s
 			songCount++
 		}
 
-		// Scan for all folders NOT under the base folder
-		folders, err := db.DB.FoldersNotInPath(baseFolder)
+		// Scan for all folders NOT under the library root
+		folders, err := db.DB.FoldersOutsideLibrary(fs.library)
 		if err != nil {
 			util.Logger.Print(err)
 			return 0, err
@@ -112,6 +156,10 @@ func (fs *OrphanScan) Scan(baseFolder, subFolder string, orphanCancelChan chan s
 
 		// Remove all folders which are not in this path
 		for _, f := range folders {
+			if err := ctx.Err(); err != nil {
+				return changes(), err
+			}
+
 			// Remove folder from database
 			path := f.Path
 			if err := f.Delete(); err != nil {
@@ -143,14 +191,31 @@ func (fs *OrphanScan) Scan(baseFolder, subFolder string, orphanCancelChan chan s
 
 	// Iterate all art in this path
 	for _, a := range art {
+		if err := ctx.Err(); err != nil {
+			return changes(), err
+		}
+
 		// Check that the art still exists in this place
 		if _, err := os.Stat(a.Path); os.IsNotExist(err) {
+			// Embedded art is read from another song holding the picture
+			moved, err := relinkEmbeddedArt(&a)
+			if err != nil {
+				util.Logger.Print(err)
+				return 0, err
+			}
+			if moved {
+				continue
+			}
+
 			// Remove art from database
 			filename := a.Path
 			if err := a.Delete(); err != nil {
 				util.Logger.Print(err)
 				return 0, err
 			}
+			if err := thumbnail.Remove(a.ID); err != nil {
+				util.Logger.Print(err)
+			}
 			util.Logger.Printf("FS: Orphan Scan: File Does Not Exist: %v", filename)
 			artCount++
 		}
@@ -165,8 +230,23 @@ func (fs *OrphanScan) Scan(baseFolder, subFolder string, orphanCancelChan chan s
 
 	// Iterate all songs in this path
 	for _, s := range songs {
+		if err := ctx.Err(); err != nil {
+			return changes(), err
+		}
+
 		// Check that the song still exists in this place
 		if _, err := os.Stat(s.Path); os.IsNotExist(err) {
+			// A copy scanned before the song went missing takes its place
+			moved, err := relinkMissing(&s)
+			if err != nil {
+				util.Logger.Print(err)
+				return 0, err
+			}
+			if moved {
+				songMoved++
+				continue
+			}
+
 			// Remove song from database
 			filename := s.Path
 			if err := s.Delete(); err != nil {
@@ -178,6 +258,32 @@ func (fs *OrphanScan) Scan(baseFolder, subFolder string, orphanCancelChan chan s
 		}
 	}
 
+	// Scan for all playlists imported from files in subfolder
+	playlists, err := db.DB.ImportedPlaylistsInPath(subFolder)
+	if err != nil {
+		util.Logger.Print(err)
+		return 0, err
+	}
+
+	// Iterate all playlists in this path
+	for _, p := range playlists {
+		if err := ctx.Err(); err != nil {
+			return changes(), err
+		}
+
+		// Check that the playlist file still exists in this place
+		if _, err := os.Stat(p.Path); os.IsNotExist(err) {
+			// Remove playlist from database
+			filename := p.Path
+			if err := p.Delete(); err != nil {
+				util.Logger.Print(err)
+				return 0, err
+			}
+			util.Logger.Printf("FS: Orphan Scan: File Does Not Exist: %v", filename)
+			playlistCount++
+		}
+	}
+
 	// Scan for all folders in subfolder
 	folders, err := db.DB.FoldersInPath(subFolder)
 	if err != nil {
@@ -186,6 +292,10 @@ func (fs *OrphanScan) Scan(baseFolder, subFolder string, orphanCancelChan chan s
 
 	// Iterate all folders in this path
 	for _, f := range folders {
+		if err := ctx.Err(); err != nil {
+			return changes(), err
+		}
+
 		// Check that the folder still has items within it
 		files, err := ioutil.ReadDir(f.Path)
 		if err != nil && !os.IsNotExist(err) {
@@ -205,29 +315,92 @@ func (fs *OrphanScan) Scan(baseFolder, subFolder string, orphanCancelChan chan s
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		return changes(), err
+	}
+
 	// Now that songs have been purged, check for albums
-	albumCount, err := db.DB.PurgeOrphanAlbums()
+	albumCount, err = db.DB.PurgeOrphanAlbums()
 	if err != nil {
 		util.Logger.Print(err)
 		return 0, err
 	}
 
 	// Check for artists
-	artistCount, err := db.DB.PurgeOrphanArtists()
+	artistCount, err = db.DB.PurgeOrphanArtists()
+	if err != nil {
+		util.Logger.Print(err)
+		return 0, err
+	}
+
+	// Check for genres
+	genreCount, err = db.DB.PurgeOrphanGenres()
+	if err != nil {
+		util.Logger.Print(err)
+		return 0, err
+	}
+
+	// Downloaded art which nothing uses any more is removed with its file
+	unused, err := db.DB.UnusedDownloadedArt()
 	if err != nil {
 		util.Logger.Print(err)
 		return 0, err
 	}
+	for _, a := range unused {
+		if err := a.Delete(); err != nil {
+			util.Logger.Print(err)
+			return 0, err
+		}
+		if err := os.Remove(a.Path); err != nil && !os.IsNotExist(err) {
+			util.Logger.Print(err)
+		}
+		if err := thumbnail.Remove(a.ID); err != nil {
+			util.Logger.Print(err)
+		}
+		util.Logger.Printf("FS: Orphan Scan: Removed Unused Art: %v", a.Path)
+		artCount++
+	}
 
 	// Print metrics
 	if fs.verbose {
 		util.Logger.Printf("FS: Orphan Scan: Complete [time: %s]", time.Since(startTime).String())
-		util.Logger.Printf("FS: Orphan Scan: Removed: [art: %d] [artists: %d] [albums: %d] [songs: %d] [folders: %d]",
-			artCount, artistCount, albumCount, songCount, folderCount)
+		util.Logger.Printf("FS: Orphan Scan: Removed: [art: %d] [artists: %d] [albums: %d] [genres: %d] [songs: %d] [folders: %d] [playlists: %d]",
+			artCount, artistCount, albumCount, genreCount, songCount, folderCount, playlistCount)
+		util.Logger.Printf("FS: Orphan Scan: Moved: [songs: %d]", songMoved)
 	}
 
-	// Sum up changes
-	sum := artCount + artistCount + albumCount + songCount + folderCount
+	return changes(), nil
+}
+
+// relinkMissing merges a song whose file is missing with a copy of it added
+// since, found by its fingerprint, such as when the file was copied to
+// another filesystem before being removed. The song keeps its ID, play
+// history and playlist entries. False is returned when there is no copy.
+func relinkMissing(s *db.Song) (bool, error) {
+	if s.Fingerprint == "" {
+		return false, nil
+	}
+
+	songs, err := db.DB.SongsWithFingerprint(s.LibraryID, s.Fingerprint)
+	if err != nil {
+		return false, err
+	}
+	for i := range songs {
+		// Only songs scanned later are copies of this one
+		found := &songs[i]
+		if found.ID <= s.ID {
+			continue
+		}
+		if _, err := os.Stat(found.Path); err != nil {
+			continue
+		}
 
-	return sum, nil
+		oldPath := s.Path
+		if err := db.DB.MergeSong(s, found); err != nil {
+			return false, err
+		}
+		util.Logger.Printf("FS: Orphan Scan: Moved song: %v -> %v", oldPath, s.Path)
+		return true, nil
+	}
+	return false, nil
 }
@@ -0,0 +1,85 @@
+package fs
+
+import (
+	"time"
+
+	"github.com/radovskyb/watcher"
+)
+
+// pollWatcher finds changes by listing its roots once every interval, which
+// works on any filesystem, including network shares
+type pollWatcher struct {
+	w      *watcher.Watcher
+	events chan WatchEvent
+	done   chan struct{}
+}
+
+// newPollWatcher creates and starts a pollWatcher
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	pw := &pollWatcher{
+		w:      watcher.New(),
+		events: make(chan WatchEvent),
+		done:   make(chan struct{}),
+	}
+	go pw.run()
+	go pw.w.Start(interval)
+	return pw
+}
+
+// run translates the poller's events until it is closed. Events are drained
+// but dropped once closing, as the poller cannot stop while sending one.
+func (pw *pollWatcher) run() {
+	defer close(pw.events)
+
+	for {
+		select {
+		case ev := <-pw.w.Event:
+			var e WatchEvent
+			switch ev.Op {
+			case watcher.Create, watcher.Write:
+				e = WatchEvent{Op: WatchCreate, Path: ev.Path}
+			case watcher.Remove:
+				e = WatchEvent{Op: WatchRemove, Path: ev.Path}
+			case watcher.Rename, watcher.Move:
+				e = WatchEvent{Op: WatchMove, Path: ev.Path, OldPath: ev.OldPath}
+			default:
+				continue
+			}
+
+			select {
+			case pw.events <- e:
+			case <-pw.done:
+			}
+		case <-pw.w.Closed:
+			return
+		}
+	}
+}
+
+// Add polls a root and everything within it
+func (pw *pollWatcher) Add(root string) error {
+	return pw.w.AddRecursive(root)
+}
+
+// Events returns the channel receiving the changes
+func (pw *pollWatcher) Events() <-chan WatchEvent {
+	return pw.events
+}
+
+// Errors returns the channel receiving the errors
+func (pw *pollWatcher) Errors() <-chan error {
+	return pw.w.Error
+}
+
+// Close stops polling. The poller stops once its current wait is over, which
+// is not waited for.
+func (pw *pollWatcher) Close() error {
+	close(pw.done)
+	go pw.w.Close()
+	return nil
+}
+
+// Name describes the watcher
+func (pw *pollWatcher) Name() string {
+	return "polling"
+}
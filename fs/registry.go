@@ -0,0 +1,357 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/eHoward1996/aiomst/db"
+)
+
+// States of a task which has not finished
+const (
+	TaskQueued  TaskState = "queued"
+	TaskRunning TaskState = "running"
+)
+
+var (
+	// ErrTaskNotFound is returned when no task has the given ID
+	ErrTaskNotFound = errors.New("fs: task not found")
+	// ErrTaskFinished is returned when cancelling a task which has finished
+	ErrTaskFinished = errors.New("fs: task has already finished")
+	// ErrTaskNotCancellable is returned when cancelling a task which runs
+	// until it finishes
+	ErrTaskNotCancellable = errors.New("fs: task cannot be cancelled")
+)
+
+// maxFinishedTasks is the number of finished tasks the registry remembers
+const maxFinishedTasks = 100
+
+// TaskInfo describes a task known to the registry. Times are unix seconds,
+// and are 0 until the task reaches them.
+type TaskInfo struct {
+	ID          int            `json:"id"`
+	Type        string         `json:"type"`
+	LibraryID   int            `json:"libraryId"`
+	Path        string         `json:"path"`
+	State       TaskState      `json:"state"`
+	Cancellable bool           `json:"cancellable"`
+	Error       string         `json:"error"`
+	Changes     int            `json:"changes"`
+	Progress    map[string]int `json:"progress"`
+	Queued      int64          `json:"queued"`
+	Started     int64          `json:"started"`
+	Ended       int64          `json:"ended"`
+}
+
+// Finished reports whether the task completed, was cancelled or failed
+func (t TaskInfo) Finished() bool {
+	return t.State != TaskQueued && t.State != TaskRunning
+}
+
+// registryEntry is a task along with the function which cancels it while it
+// runs
+type registryEntry struct {
+	info   TaskInfo
+	task   Task
+	cancel context.CancelFunc
+}
+
+// Registry tracks tasks from being queued until they finish, and publishes
+// every change to its subscribers
+type Registry struct {
+	mu          sync.Mutex
+	nextID      int
+	tasks       map[int]*registryEntry
+	order       []int
+	pending     []*registryEntry
+	wake        chan struct{}
+	subscribers map[chan TaskInfo]struct{}
+}
+
+// Tasks is the registry of the filesystem tasks
+var Tasks = NewRegistry()
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		nextID:      1,
+		tasks:       make(map[int]*registryEntry),
+		order:       make([]int, 0),
+		pending:     make([]*registryEntry, 0),
+		wake:        make(chan struct{}, 1),
+		subscribers: make(map[chan TaskInfo]struct{}),
+	}
+}
+
+// Add registers a task of the given type which is run by the caller through
+// Run, rather than queued. The library may be nil. Such tasks run until they
+// finish, so they cannot be cancelled.
+func (r *Registry) Add(taskType string, l *db.Library, p string) TaskInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e := r.add(taskType, l, p)
+	r.publish(e)
+	return e.copy()
+}
+
+// Queue registers a task and queues it to be returned by Next
+func (r *Registry) Queue(t Task) TaskInfo {
+	baseFolder, subFolder := t.Folders()
+	p := baseFolder
+	if subFolder != "" {
+		p = subFolder
+	}
+
+	r.mu.Lock()
+	e := r.add(t.WhoAmI(), t.Library(), p)
+	e.info.Cancellable = true
+	e.task = t
+	r.pending = append(r.pending, e)
+	r.publish(e)
+	info := e.copy()
+	r.mu.Unlock()
+
+	// Wake Next without blocking if it is already awake
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return info
+}
+
+// add registers a new queued task. The lock must be held.
+func (r *Registry) add(taskType string, l *db.Library, p string) *registryEntry {
+	e := &registryEntry{info: TaskInfo{
+		ID:       r.nextID,
+		Type:     taskType,
+		Path:     p,
+		State:    TaskQueued,
+		Progress: make(map[string]int),
+		Queued:   time.Now().Unix(),
+	}}
+	if l != nil {
+		e.info.LibraryID = l.ID
+	}
+
+	r.nextID++
+	r.tasks[e.info.ID] = e
+	r.order = append(r.order, e.info.ID)
+	return e
+}
+
+// Next waits for the next queued task. Tasks cancelled while queued are still
+// returned, so callers may account for them, and are skipped by Run. False is
+// returned when ctx is cancelled first.
+func (r *Registry) Next(ctx context.Context) (int, Task, bool) {
+	for {
+		r.mu.Lock()
+		if len(r.pending) > 0 {
+			e := r.pending[0]
+			r.pending = r.pending[1:]
+			r.mu.Unlock()
+			return e.info.ID, e.task, true
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return 0, nil, false
+		case <-r.wake:
+		}
+	}
+}
+
+// Run runs a registered task, recording when it starts and the state it
+// finishes in. The progress it reports through ReportProgress is published as
+// it runs. Tasks cancelled while queued are not run.
+func (r *Registry) Run(
+	ctx context.Context, id int,
+	run func(ctx context.Context) (int, TaskState, error)) (int, TaskState, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	r.mu.Lock()
+	e, ok := r.tasks[id]
+	if !ok {
+		r.mu.Unlock()
+		return 0, TaskFailed, ErrTaskNotFound
+	}
+	if e.info.State != TaskQueued {
+		state := e.info.State
+		r.mu.Unlock()
+		return 0, state, nil
+	}
+	e.cancel = cancel
+	e.info.State = TaskRunning
+	e.info.Started = time.Now().Unix()
+	r.publish(e)
+	r.mu.Unlock()
+
+	ctx = WithProgress(ctx, func(counters map[string]int) {
+		r.progress(id, counters)
+	})
+	changes, state, err := run(ctx)
+
+	r.mu.Lock()
+	e.cancel = nil
+	e.info.State = state
+	e.info.Changes = changes
+	e.info.Ended = time.Now().Unix()
+	if err != nil {
+		e.info.Error = err.Error()
+	}
+	r.publish(e)
+	r.prune()
+	r.mu.Unlock()
+
+	return changes, state, err
+}
+
+// progress records the counters reported by a running task
+func (r *Registry) progress(id int, counters map[string]int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.tasks[id]
+	if !ok || e.info.State != TaskRunning {
+		return
+	}
+	e.info.Progress = make(map[string]int, len(counters))
+	for k, v := range counters {
+		e.info.Progress[k] = v
+	}
+	r.publish(e)
+}
+
+// Cancel cancels a task. A queued task is never run, while a running task is
+// stopped at its next check of its context. Tasks which run until they finish
+// are refused with ErrTaskNotCancellable.
+func (r *Registry) Cancel(id int) (TaskInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.tasks[id]
+	if !ok {
+		return TaskInfo{}, ErrTaskNotFound
+	}
+	if !e.info.Cancellable && !e.info.Finished() {
+		return e.copy(), ErrTaskNotCancellable
+	}
+
+	switch e.info.State {
+	case TaskQueued:
+		e.info.State = TaskCancelled
+		e.info.Ended = time.Now().Unix()
+		r.publish(e)
+	case TaskRunning:
+		// The task is published as cancelled once it stops
+		e.cancel()
+	default:
+		return e.copy(), ErrTaskFinished
+	}
+	return e.copy(), nil
+}
+
+// Get returns the task with the given ID
+func (r *Registry) Get(id int) (TaskInfo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.tasks[id]
+	if !ok {
+		return TaskInfo{}, ErrTaskNotFound
+	}
+	return e.copy(), nil
+}
+
+// List returns every task the registry remembers, in the order they were
+// added
+func (r *Registry) List() []TaskInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tasks := make([]TaskInfo, 0, len(r.order))
+	for _, id := range r.order {
+		tasks = append(tasks, r.tasks[id].copy())
+	}
+	return tasks
+}
+
+// Subscribe returns a channel receiving every change to a task, and a
+// function which unsubscribes it. Changes are dropped when the subscriber
+// falls behind.
+func (r *Registry) Subscribe() (<-chan TaskInfo, func()) {
+	ch := make(chan TaskInfo, 64)
+
+	r.mu.Lock()
+	r.subscribers[ch] = struct{}{}
+	r.mu.Unlock()
+
+	return ch, func() {
+		r.mu.Lock()
+		delete(r.subscribers, ch)
+		r.mu.Unlock()
+	}
+}
+
+// publish sends a task to every subscriber without blocking. The lock must be
+// held, so subscribers receive changes in order.
+func (r *Registry) publish(e *registryEntry) {
+	for ch := range r.subscribers {
+		select {
+		case ch <- e.copy():
+		default:
+		}
+	}
+}
+
+// prune forgets the oldest finished tasks beyond maxFinishedTasks. The lock
+// must be held.
+func (r *Registry) prune() {
+	finished := 0
+	for _, id := range r.order {
+		if r.tasks[id].info.Finished() {
+			finished++
+		}
+	}
+
+	order := make([]int, 0, len(r.order))
+	for _, id := range r.order {
+		if finished > maxFinishedTasks && r.tasks[id].info.Finished() {
+			delete(r.tasks, id)
+			finished--
+			continue
+		}
+		order = append(order, id)
+	}
+	r.order = order
+}
+
+// copy returns the entry's info, with its own copy of the progress counters
+func (e *registryEntry) copy() TaskInfo {
+	info := e.info
+	info.Progress = make(map[string]int, len(e.info.Progress))
+	for k, v := range e.info.Progress {
+		info.Progress[k] = v
+	}
+	return info
+}
+
+// progressKey is the context key of the function receiving a task's progress
+type progressKey struct{}
+
+// WithProgress returns a context whose tasks report their progress to f
+func WithProgress(ctx context.Context, f func(counters map[string]int)) context.Context {
+	return context.WithValue(ctx, progressKey{}, f)
+}
+
+// ReportProgress reports the counters of the task running in ctx, if anything
+// is listening
+func ReportProgress(ctx context.Context, counters map[string]int) {
+	if f, ok := ctx.Value(progressKey{}).(func(counters map[string]int)); ok {
+		f(counters)
+	}
+}
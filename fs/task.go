@@ -0,0 +1,31 @@
+package fs
+
+import (
+	"context"
+	"errors"
+)
+
+// TaskState is the state a task finished in
+type TaskState string
+
+// Final states of a task
+const (
+	TaskCompleted TaskState = "completed"
+	TaskCancelled TaskState = "cancelled"
+	TaskFailed    TaskState = "failed"
+)
+
+// Run scans a task's folders until it finishes or ctx is cancelled. It returns
+// the number of changes made, which are kept when the task is cancelled, along
+// with the state the task finished in and the error which failed it.
+func Run(ctx context.Context, t Task) (int, TaskState, error) {
+	baseFolder, subFolder := t.Folders()
+	changes, err := t.Scan(ctx, baseFolder, subFolder)
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return changes, TaskCancelled, nil
+	case err != nil:
+		return changes, TaskFailed, err
+	}
+	return changes, TaskCompleted, nil
+}
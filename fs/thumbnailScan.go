@@ -0,0 +1,145 @@
+package fs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/thumbnail"
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// ThumbnailScan is a filesystem task that resizes the art in the given path
+// ahead of requests, in JPEG or PNG and in each extra format clients may
+// accept. Art which is already cached is skipped.
+type ThumbnailScan struct {
+	baseFolder string
+	subFolder  string
+	library    *db.Library
+	verbose    bool
+	sizes      []int
+}
+
+// Folders returns the base and sub folders for scanning
+func (fs *ThumbnailScan) Folders() (string, string) {
+	return fs.baseFolder, fs.subFolder
+}
+
+// SetFolders sets the base and sub folders for scanning
+func (fs *ThumbnailScan) SetFolders(baseFolder, subFolder string) {
+	fs.baseFolder = baseFolder
+	fs.subFolder = subFolder
+}
+
+// Library returns the library being scanned
+func (fs *ThumbnailScan) Library() *db.Library {
+	return fs.library
+}
+
+// SetLibrary sets the library being scanned
+func (fs *ThumbnailScan) SetLibrary(l *db.Library) {
+	fs.library = l
+}
+
+// Sizes returns the sizes art is resized to
+func (fs *ThumbnailScan) Sizes() []int {
+	return fs.sizes
+}
+
+// SetSizes sets the sizes art is resized to. They are rounded up to the cached
+// sizes when resizing.
+func (fs *ThumbnailScan) SetSizes(sizes []int) {
+	fs.sizes = sizes
+}
+
+// Verbose is whether scanning has verbose output or not
+func (fs *ThumbnailScan) Verbose(v bool) {
+	fs.verbose = v
+}
+
+// WhoAmI returns Thumbnail Scan
+func (fs *ThumbnailScan) WhoAmI() string {
+	return "Thumbnail Scan"
+}
+
+// Scan resizes the art within the sub folder, or the base folder when it is
+// empty. Nothing in the library is changed, so no changes are returned; the
+// number of thumbnails made is reported as progress.
+func (fs *ThumbnailScan) Scan(ctx context.Context, baseFolder, subFolder string) (int, error) {
+	if fs.library == nil {
+		return 0, errors.New("FS: Thumbnail Scan: No library set")
+	}
+	if subFolder == "" {
+		subFolder = baseFolder
+	}
+
+	artCount := 0
+	thumbnailCount := 0
+	startTime := time.Now()
+	defer func() {
+		ReportProgress(ctx, map[string]int{
+			"art":        artCount,
+			"thumbnails": thumbnailCount,
+		})
+	}()
+
+	art, err := db.DB.ArtInPath(subFolder)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range art {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		a := &art[i]
+		if a.LibraryID != fs.library.ID {
+			continue
+		}
+
+		source, err := thumbnail.SourceFormat(a)
+		if err != nil {
+			util.Logger.Printf("FS: Thumbnail Scan: Cannot read art %s: %v", a.Path, err)
+			continue
+		}
+
+		// The format sent to every client, and those sent to some
+		formats := []string{thumbnail.Negotiate("", source)}
+		for _, format := range util.Current().Art.Formats {
+			if thumbnail.Available(format) {
+				formats = append(formats, format)
+			}
+		}
+
+		for _, size := range fs.sizes {
+			size = thumbnail.Size(size)
+			for _, format := range formats {
+				if thumbnail.Cached(a, size, format) {
+					continue
+				}
+				if _, err := thumbnail.Get(a, size, format); err != nil {
+					util.Logger.Printf(
+						"FS: Thumbnail Scan: Cannot resize art %s to %d as %s: %v",
+						a.Path, size, format, err)
+					continue
+				}
+				thumbnailCount++
+			}
+		}
+
+		if artCount++; artCount%progressInterval == 0 {
+			ReportProgress(ctx, map[string]int{
+				"art":        artCount,
+				"thumbnails": thumbnailCount,
+			})
+		}
+	}
+
+	if fs.verbose {
+		util.Logger.Printf(
+			"FS: Thumbnail Scan Complete [time: %s] [art: %d] [thumbnails: %d]",
+			time.Since(startTime).String(), artCount, thumbnailCount)
+	}
+	return 0, nil
+}
@@ -1,12 +1,15 @@
 package fs
 
+import (
+	"context"
+
+	"github.com/eHoward1996/aiomst/db"
+)
+
 // Constant MBID Values
 const errStartValueString = "errored"
 const errStartValueInt = -1
 
-// The name of the default metadata file
-const metadataFile = "metadata"
-
 // imgType is a set of valid file extensions for images
 var imgType = map[string]bool	{
 	".jpg"  : true,
@@ -33,11 +36,15 @@ type HasAttachables interface {
 	HasAttachables()
 }
 
-// Task is an interface that defines a filesystem task
+// Task is an interface that defines a filesystem task. Tasks run within a
+// single library. Scan stops early, returning the context's error, when its
+// context is cancelled.
 type Task interface {
 	Folders() (string, string)
 	SetFolders(string, string)
-	Scan(string, string, chan struct{}) (int, error)
+	Library() *db.Library
+	SetLibrary(*db.Library)
+	Scan(context.Context, string, string) (int, error)
 	Verbose(bool)
 	WhoAmI() string
 }
\ No newline at end of file
@@ -0,0 +1,317 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// Watch modes, chosen by the watcher mode setting
+const (
+	// WatchAuto uses change notifications, polling roots they cannot watch
+	WatchAuto = "auto"
+	// WatchNotify uses change notifications only
+	WatchNotify = "notify"
+	// WatchPoll polls the roots for changes
+	WatchPoll = "poll"
+)
+
+// WatchOp is the kind of change reported by a Watcher
+type WatchOp int
+
+// Changes reported by a Watcher
+const (
+	// WatchCreate is a file or folder which was created or written to
+	WatchCreate WatchOp = iota
+	// WatchRemove is a file or folder which was removed or moved away
+	WatchRemove
+	// WatchMove is a file or folder moved from OldPath to Path
+	WatchMove
+)
+
+// WatchEvent is a change to a file or folder within a watched root
+type WatchEvent struct {
+	Op      WatchOp
+	Path    string
+	OldPath string
+}
+
+// Watcher reports the changes made within the roots it watches, until it is
+// closed. Events is closed along with the Watcher.
+type Watcher interface {
+	Add(root string) error
+	Events() <-chan WatchEvent
+	Errors() <-chan error
+	Close() error
+	Name() string
+}
+
+// NewWatcher creates a Watcher using the given mode. Polling watchers check
+// their roots once every interval.
+func NewWatcher(mode string, interval time.Duration) (Watcher, error) {
+	switch mode {
+	case WatchNotify:
+		return newNotifyWatcher()
+	case WatchPoll:
+		return newPollWatcher(interval), nil
+	case WatchAuto:
+		n, err := newNotifyWatcher()
+		if err != nil {
+			util.Logger.Printf("FS: Change notifications unavailable, polling instead: %v", err)
+			return newPollWatcher(interval), nil
+		}
+		return newAutoWatcher(n, interval), nil
+	}
+	return nil, fmt.Errorf("FS: Unknown watch mode: %q", mode)
+}
+
+// autoWatcher watches roots with change notifications, and falls back to
+// polling those which cannot be watched, such as when the limit on watches is
+// reached
+type autoWatcher struct {
+	notify   Watcher
+	interval time.Duration
+
+	mu     sync.Mutex
+	poll   Watcher
+	events chan WatchEvent
+	errors chan error
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// newAutoWatcher creates an autoWatcher around a notifying Watcher
+func newAutoWatcher(notify Watcher, interval time.Duration) *autoWatcher {
+	w := &autoWatcher{
+		notify:   notify,
+		interval: interval,
+		events:   make(chan WatchEvent),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+	}
+	w.forward(notify)
+	go func() {
+		w.wg.Wait()
+		close(w.events)
+	}()
+	return w
+}
+
+// forward sends the changes reported by a Watcher on to the autoWatcher's
+// subscribers until it is closed
+func (w *autoWatcher) forward(from Watcher) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		for {
+			select {
+			case ev, ok := <-from.Events():
+				if !ok {
+					return
+				}
+				select {
+				case w.events <- ev:
+				case <-w.done:
+					return
+				}
+			case err := <-from.Errors():
+				select {
+				case w.errors <- err:
+				case <-w.done:
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Add watches a root with change notifications, or polls it if it cannot be
+// watched
+func (w *autoWatcher) Add(root string) error {
+	err := w.notify.Add(root)
+	if err == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	util.Logger.Printf("FS: Cannot watch %s for changes, polling instead: %v", root, err)
+	if w.poll == nil {
+		w.poll = newPollWatcher(w.interval)
+		w.forward(w.poll)
+	}
+	return w.poll.Add(root)
+}
+
+// Events returns the channel receiving the changes
+func (w *autoWatcher) Events() <-chan WatchEvent {
+	return w.events
+}
+
+// Errors returns the channel receiving the errors
+func (w *autoWatcher) Errors() <-chan error {
+	return w.errors
+}
+
+// Close stops both watchers
+func (w *autoWatcher) Close() error {
+	close(w.done)
+	err := w.notify.Close()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.poll != nil {
+		w.poll.Close()
+	}
+	return err
+}
+
+// Name describes the watcher
+func (w *autoWatcher) Name() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.poll != nil {
+		return w.notify.Name() + " and " + w.poll.Name()
+	}
+	return w.notify.Name()
+}
+
+// pendingScan is a folder waiting to be scanned once it has been quiet
+type pendingScan struct {
+	media  bool
+	orphan bool
+	last   time.Time
+}
+
+// Debouncer groups changes by folder, so a burst of changes such as an album
+// being copied results in a single scan of each folder once it has been quiet
+// for a while
+type Debouncer struct {
+	quiet   time.Duration
+	mu      sync.Mutex
+	folders map[string]*pendingScan
+}
+
+// NewDebouncer creates a Debouncer releasing folders once they have seen no
+// changes for the quiet duration
+func NewDebouncer(quiet time.Duration) *Debouncer {
+	return &Debouncer{
+		quiet:   quiet,
+		folders: make(map[string]*pendingScan),
+	}
+}
+
+// Add records a change. Created files are media scanned within their folder,
+// and created folders in full, while removed items are orphan scanned within
+// their folder. A move is both.
+func (d *Debouncer) Add(ev WatchEvent, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch ev.Op {
+	case WatchCreate:
+		d.media(ev.Path, now)
+	case WatchRemove:
+		d.orphan(ev.Path, now)
+	case WatchMove:
+		d.media(ev.Path, now)
+		if ev.OldPath != "" {
+			d.orphan(ev.OldPath, now)
+		}
+	}
+}
+
+// media records a media scan of the folder containing p, or of p itself when
+// it is a folder. Items which are already gone are skipped.
+func (d *Debouncer) media(p string, now time.Time) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return
+	}
+	if !info.IsDir() {
+		p = path.Dir(p)
+	}
+	d.pending(p, now).media = true
+}
+
+// orphan records an orphan scan of the folder which contained p
+func (d *Debouncer) orphan(p string, now time.Time) {
+	d.pending(path.Dir(p), now).orphan = true
+}
+
+// pending returns the pending scan of a folder, marking it changed at now.
+// The lock must be held.
+func (d *Debouncer) pending(folder string, now time.Time) *pendingScan {
+	s, ok := d.folders[folder]
+	if !ok {
+		s = new(pendingScan)
+		d.folders[folder] = s
+	}
+	s.last = now
+	return s
+}
+
+// Flush returns the folders which have been quiet since before now, to be
+// media scanned and orphan scanned. Folders within another returned folder
+// are left out. Orphan scans are held back while any media scan is pending,
+// so songs moved between folders are found in their new folder before they
+// are missed in their old one.
+func (d *Debouncer) Flush(now time.Time) ([]string, []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	media := make([]string, 0)
+	for folder, s := range d.folders {
+		if s.media && now.Sub(s.last) >= d.quiet {
+			media = append(media, folder)
+			s.media = false
+		}
+	}
+
+	mediaPending := false
+	for _, s := range d.folders {
+		if s.media {
+			mediaPending = true
+			break
+		}
+	}
+
+	orphan := make([]string, 0)
+	for folder, s := range d.folders {
+		if s.orphan && !mediaPending && now.Sub(s.last) >= d.quiet {
+			orphan = append(orphan, folder)
+			s.orphan = false
+		}
+		if !s.media && !s.orphan {
+			delete(d.folders, folder)
+		}
+	}
+
+	return outermostFolders(media), outermostFolders(orphan)
+}
+
+// outermostFolders sorts folders, leaving out those within another of them
+func outermostFolders(folders []string) []string {
+	sort.Strings(folders)
+
+	outer := make([]string, 0, len(folders))
+	for _, f := range folders {
+		within := false
+		for _, o := range outer {
+			if f == o || strings.HasPrefix(f, o+"/") {
+				within = true
+				break
+			}
+		}
+		if !within {
+			outer = append(outer, f)
+		}
+	}
+	return outer
+}
@@ -3,8 +3,10 @@ module github.com/eHoward1996/aiomst
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/divan/num2words v0.0.0-20170904212200-57dba452f942
 	github.com/eHoward1996/audiotags v0.0.0-20200516204304-58d5ffddcc9c
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/gammazero/deque v0.0.0-20201010052221-3932da5530cc // indirect
 	github.com/gammazero/workerpool v1.1.1
 	github.com/gin-contrib/cors v1.3.1
@@ -17,6 +19,8 @@ require (
 	github.com/pascoej/gomusicbrainz v0.0.0-20201211092829-6ce720f751dd
 	github.com/radovskyb/watcher v1.0.7
 	github.com/smartystreets/goconvey v1.6.4 // indirect
-	golang.org/x/text v0.3.2
+	golang.org/x/crypto v0.14.0
+	golang.org/x/text v0.13.0
 	google.golang.org/appengine v1.6.7 // indirect
+	gopkg.in/yaml.v2 v2.2.8
 )
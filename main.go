@@ -33,6 +33,16 @@ func main()	{
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// Reload the configuration on SIGHUP
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			util.Logger.Print("AIOMST caught signal: SIGHUP... reloading configuration")
+			core.ReloadConfig()
+		}
+	}()
+
 	go func()	{
 		for sig := range sigChan {
 			util.Logger.Printf("AIOMST caught signal: %v... force halting", sig)
@@ -0,0 +1,76 @@
+package playlist
+
+import (
+	"database/sql"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/eHoward1996/aiomst/db"
+)
+
+// ImportFile imports a playlist file found in the media folder as a shared
+// playlist, keyed by the file's path. Files which have not been modified
+// since they were last imported are skipped. The returned bool reports
+// whether the playlist was created or updated.
+func ImportFile(file string) (*db.Playlist, bool, error) {
+	format, ok := Format(file)
+	if !ok {
+		return nil, false, ErrUnknownFormat
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Skip files which have not changed
+	pl := &db.Playlist{Path: file}
+	err = pl.Load()
+	if err != nil && err != sql.ErrNoRows {
+		return nil, false, err
+	}
+	exists := err == nil
+	if exists && info.ModTime().Unix() <= pl.LastModified {
+		return pl, false, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	p, err := Decode(f, format)
+	if err != nil {
+		return nil, false, err
+	}
+
+	IDs, _, err := Resolve(p, []string{path.Dir(file)}, nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	pl.Name = p.Name
+	if pl.Name == "" {
+		pl.Name = strings.TrimSuffix(path.Base(file), path.Ext(file))
+	}
+	if exists {
+		err = pl.Update()
+	} else {
+		err = pl.Save()
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := db.DB.SetPlaylistSongs(pl.ID, IDs); err != nil {
+		return nil, false, err
+	}
+
+	// Reload for the song count and length
+	if err := pl.Load(); err != nil {
+		return nil, false, err
+	}
+	return pl, true, nil
+}
@@ -0,0 +1,71 @@
+package playlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// decodeM3U reads an extended M3U playlist. Plain M3U files, which only list
+// paths, are a subset of this.
+func decodeM3U(r io.Reader) (*Playlist, error) {
+	p := &Playlist{Entries: make([]Entry, 0)}
+	next := Entry{}
+
+	scanner := bufio.NewScanner(r)
+	for first := true; scanner.Scan(); first = false {
+		line := strings.TrimSpace(scanner.Text())
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// #EXTINF:length,title
+			info := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)
+			if n, err := strconv.Atoi(strings.TrimSpace(info[0])); err == nil && n > 0 {
+				next.Length = n
+			}
+			if len(info) == 2 {
+				next.Title = strings.TrimSpace(info[1])
+			}
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			p.Name = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#"):
+			// Other directives and comments
+			continue
+		default:
+			next.Path = line
+			p.Entries = append(p.Entries, next)
+			next = Entry{}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// encodeM3U writes an extended M3U playlist
+func encodeM3U(w io.Writer, p *Playlist) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "#EXTM3U")
+	if p.Name != "" {
+		fmt.Fprintf(b, "#PLAYLIST:%s\n", p.Name)
+	}
+
+	for _, e := range p.Entries {
+		length := e.Length
+		if length == 0 {
+			length = -1
+		}
+		fmt.Fprintf(b, "#EXTINF:%d,%s\n", length, e.displayTitle())
+		fmt.Fprintln(b, e.Path)
+	}
+	return b.Flush()
+}
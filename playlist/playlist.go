@@ -0,0 +1,98 @@
+// Package playlist reads and writes M3U8, PLS and XSPF playlist files, and
+// resolves their entries to songs in the library.
+package playlist
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// Playlist file formats
+const (
+	M3U8 = "m3u8"
+	PLS  = "pls"
+	XSPF = "xspf"
+)
+
+var (
+	// ErrUnknownFormat is returned when a playlist format is not supported
+	ErrUnknownFormat = errors.New("playlist: unknown format")
+)
+
+// Extensions maps playlist file extensions to their format. Plain M3U files
+// are read as M3U8.
+var Extensions = map[string]string{
+	".m3u":  M3U8,
+	".m3u8": M3U8,
+	".pls":  PLS,
+	".xspf": XSPF,
+}
+
+// MIMETypes maps a playlist format to its MIME type
+var MIMETypes = map[string]string{
+	M3U8: "audio/x-mpegurl",
+	PLS:  "audio/x-scpls",
+	XSPF: "application/xspf+xml",
+}
+
+// Entry is a single track of a playlist. Only the path is required, the
+// other fields are informational.
+type Entry struct {
+	Path   string
+	Title  string
+	Artist string
+	Album  string
+	Length int
+}
+
+// Playlist is a named, ordered list of entries
+type Playlist struct {
+	Name    string
+	Entries []Entry
+}
+
+// Format returns the format of a playlist file from its extension
+func Format(file string) (string, bool) {
+	i := strings.LastIndex(file, ".")
+	if i < 0 {
+		return "", false
+	}
+
+	format, ok := Extensions[strings.ToLower(file[i:])]
+	return format, ok
+}
+
+// Decode reads a playlist in the given format
+func Decode(r io.Reader, format string) (*Playlist, error) {
+	switch format {
+	case M3U8:
+		return decodeM3U(r)
+	case PLS:
+		return decodePLS(r)
+	case XSPF:
+		return decodeXSPF(r)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Encode writes a playlist in the given format
+func Encode(w io.Writer, format string, p *Playlist) error {
+	switch format {
+	case M3U8:
+		return encodeM3U(w, p)
+	case PLS:
+		return encodePLS(w, p)
+	case XSPF:
+		return encodeXSPF(w, p)
+	}
+	return ErrUnknownFormat
+}
+
+// displayTitle returns the "Artist - Title" form used by M3U and PLS
+func (e Entry) displayTitle() string {
+	if e.Artist == "" {
+		return e.Title
+	}
+	return e.Artist + " - " + e.Title
+}
@@ -0,0 +1,92 @@
+package playlist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// decodePLS reads a PLS playlist. Entries are numbered keys, such as File1,
+// Title1 and Length1, in the [playlist] section.
+func decodePLS(r io.Reader) (*Playlist, error) {
+	entries := make(map[int]*Entry)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "\ufeff")
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			// Section headers, comments and blank lines
+			continue
+		}
+
+		key, value := strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+		var field string
+		for _, f := range []string{"file", "title", "length"} {
+			if strings.HasPrefix(key, f) {
+				field = f
+				break
+			}
+		}
+		n, err := strconv.Atoi(strings.TrimPrefix(key, field))
+		if field == "" || err != nil {
+			// NumberOfEntries, Version and unknown keys
+			continue
+		}
+
+		e, ok := entries[n]
+		if !ok {
+			e = &Entry{}
+			entries[n] = e
+		}
+		switch field {
+		case "file":
+			e.Path = value
+		case "title":
+			e.Title = value
+		case "length":
+			if l, err := strconv.Atoi(value); err == nil && l > 0 {
+				e.Length = l
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Entries are ordered by their number, which may have gaps
+	numbers := make([]int, 0, len(entries))
+	for n := range entries {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	p := &Playlist{Entries: make([]Entry, 0, len(numbers))}
+	for _, n := range numbers {
+		if entries[n].Path != "" {
+			p.Entries = append(p.Entries, *entries[n])
+		}
+	}
+	return p, nil
+}
+
+// encodePLS writes a PLS playlist
+func encodePLS(w io.Writer, p *Playlist) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "[playlist]")
+	for i, e := range p.Entries {
+		length := e.Length
+		if length == 0 {
+			length = -1
+		}
+		fmt.Fprintf(b, "File%d=%s\n", i+1, e.Path)
+		fmt.Fprintf(b, "Title%d=%s\n", i+1, e.displayTitle())
+		fmt.Fprintf(b, "Length%d=%d\n", i+1, length)
+	}
+	fmt.Fprintf(b, "NumberOfEntries=%d\n", len(p.Entries))
+	fmt.Fprintln(b, "Version=2")
+	return b.Flush()
+}
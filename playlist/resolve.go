@@ -0,0 +1,127 @@
+package playlist
+
+import (
+	"database/sql"
+	"path"
+	"strings"
+
+	"github.com/eHoward1996/aiomst/db"
+)
+
+// Resolve finds the songs referenced by the entries of a playlist, returning
+// the song IDs in order along with the entries which could not be found.
+// Relative paths are resolved against each of baseDirs in turn. Paths which
+// are not in the library are matched against the end of each Song.Path
+// instead, so that playlists written on other machines still resolve. Unless
+// u is nil, only songs u may access are found.
+func Resolve(p *Playlist, baseDirs []string, u *db.User) ([]int, []Entry, error) {
+	IDs := make([]int, 0, len(p.Entries))
+	missing := make([]Entry, 0)
+	for _, e := range p.Entries {
+		ID, err := resolveEntry(e, baseDirs, u)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if ID == 0 {
+			missing = append(missing, e)
+			continue
+		}
+		IDs = append(IDs, ID)
+	}
+	return IDs, missing, nil
+}
+
+// accessible returns the songs u may access, or every song when u is nil
+func accessible(songs []db.Song, u *db.User) ([]db.Song, error) {
+	if u == nil {
+		return songs, nil
+	}
+	return db.DB.SongsAccessibleBy(songs, u)
+}
+
+// resolveEntry returns the ID of the song an entry refers to, or 0
+func resolveEntry(e Entry, baseDirs []string, u *db.User) (int, error) {
+	// Playlists written on Windows use backslashes
+	p := strings.Replace(e.Path, `\`, "/", -1)
+	if strings.Contains(p, "://") {
+		// Streams and other URLs
+		return 0, nil
+	}
+
+	p = path.Clean(p)
+
+	paths := []string{p}
+	if !path.IsAbs(p) {
+		paths = make([]string, 0, len(baseDirs))
+		for _, dir := range baseDirs {
+			paths = append(paths, path.Join(dir, p))
+		}
+	}
+	for _, file := range paths {
+		song := &db.Song{Path: file}
+		err := song.Load()
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		songs, err := accessible([]db.Song{*song}, u)
+		if err != nil {
+			return 0, err
+		}
+		if len(songs) > 0 {
+			return song.ID, nil
+		}
+	}
+
+	// Try the containing folder and file name, then the file name alone if it
+	// is unique
+	elems := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	for n := 2; n >= 1; n-- {
+		if len(elems) < n {
+			continue
+		}
+
+		suffix := "/" + strings.Join(elems[len(elems)-n:], "/")
+		songs, err := db.DB.SongsWithPathSuffix(suffix)
+		if err != nil {
+			return 0, err
+		}
+
+		// The LIKE pattern treats underscores as wildcards, so check again
+		matches := make([]db.Song, 0, len(songs))
+		for _, s := range songs {
+			if strings.HasSuffix(s.Path, suffix) {
+				matches = append(matches, s)
+			}
+		}
+		if matches, err = accessible(matches, u); err != nil {
+			return 0, err
+		}
+		if len(matches) > 0 && (n > 1 || len(matches) == 1) {
+			return matches[0].ID, nil
+		}
+	}
+	return 0, nil
+}
+
+// FromSongs builds a playlist from a name and a list of songs
+func FromSongs(name string, songs []db.Song) *Playlist {
+	p := &Playlist{
+		Name:    name,
+		Entries: make([]Entry, 0, len(songs)),
+	}
+	for _, s := range songs {
+		p.Entries = append(p.Entries, Entry{
+			Path:   s.Path,
+			Title:  s.Title,
+			Artist: s.Artist,
+			Album:  s.Album,
+			Length: s.Length,
+		})
+	}
+	return p
+}
@@ -0,0 +1,100 @@
+package playlist
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// xspfPlaylist is the XML document of an XSPF playlist
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"http://xspf.org/ns/0/ playlist"`
+	Version string      `xml:"version,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+// xspfTrack is a track of an XSPF playlist. Durations are in milliseconds.
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	Duration int    `xml:"duration,omitempty"`
+}
+
+// decodeXSPF reads an XSPF playlist. Track locations are URIs, which are
+// converted back into paths.
+func decodeXSPF(r io.Reader) (*Playlist, error) {
+	var doc xspfPlaylist
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	p := &Playlist{
+		Name:    doc.Title,
+		Entries: make([]Entry, 0, len(doc.Tracks)),
+	}
+	for _, t := range doc.Tracks {
+		location := locationPath(strings.TrimSpace(t.Location))
+		if location == "" {
+			continue
+		}
+
+		p.Entries = append(p.Entries, Entry{
+			Path:   location,
+			Title:  t.Title,
+			Artist: t.Creator,
+			Album:  t.Album,
+			Length: t.Duration / 1000,
+		})
+	}
+	return p, nil
+}
+
+// locationPath converts an XSPF location into a path. Non-file URLs are kept
+// as they are, and will not resolve to a song.
+func locationPath(location string) string {
+	u, err := url.Parse(location)
+	if err != nil {
+		return location
+	}
+
+	switch u.Scheme {
+	case "file":
+		return u.Path
+	case "":
+		// Relative locations are percent encoded as well
+		if p, err := url.PathUnescape(location); err == nil {
+			return p
+		}
+	}
+	return location
+}
+
+// encodeXSPF writes an XSPF playlist
+func encodeXSPF(w io.Writer, p *Playlist) error {
+	doc := xspfPlaylist{
+		Version: "1",
+		Title:   p.Name,
+		Tracks:  make([]xspfTrack, 0, len(p.Entries)),
+	}
+	for _, e := range p.Entries {
+		location := (&url.URL{Scheme: "file", Path: e.Path}).String()
+		doc.Tracks = append(doc.Tracks, xspfTrack{
+			Location: location,
+			Title:    e.Title,
+			Creator:  e.Artist,
+			Album:    e.Album,
+			Duration: e.Length * 1000,
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,161 @@
+package scrobble
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/eHoward1996/aiomst/db"
+)
+
+// LastFM submits listens to a Last.fm compatible API, such as Libre.fm, using
+// each user's session key
+type LastFM struct {
+	// URL is the API endpoint, for example https://ws.audioscrobbler.com/2.0/
+	URL    string
+	APIKey string
+	Secret string
+	Client *http.Client
+}
+
+// Last.fm error codes which mean the request may succeed later
+var lastFMTemporary = map[int]bool{
+	11: true, // Service offline
+	16: true, // Temporarily unavailable
+	29: true, // Rate limit exceeded
+}
+
+// lastFMMinLength is the shortest track Last.fm accepts scrobbles for
+const lastFMMinLength = 30
+
+// NewLastFM creates a Last.fm submitter for the API at url
+func NewLastFM(url, apiKey, secret string) *LastFM {
+	return &LastFM{
+		URL:    url,
+		APIKey: apiKey,
+		Secret: secret,
+		Client: http.DefaultClient,
+	}
+}
+
+// Name returns the name of the service
+func (l *LastFM) Name() string {
+	return "lastfm"
+}
+
+// Enabled reports whether an API key is configured and the user has a
+// session key
+func (l *LastFM) Enabled(u *db.User) bool {
+	return l.URL != "" && l.APIKey != "" && l.Secret != "" && u.LastFMToken != ""
+}
+
+// BatchSize returns the number of listens submitted at once
+func (l *LastFM) BatchSize() int {
+	return 50
+}
+
+// Submit sends listens with the track.scrobble method. Tracks shorter than 30
+// seconds are skipped, as Last.fm ignores them.
+func (l *LastFM) Submit(ctx context.Context, u *db.User, listens []Listen) error {
+	params := url.Values{}
+	params.Set("method", "track.scrobble")
+	params.Set("api_key", l.APIKey)
+	params.Set("sk", u.LastFMToken)
+
+	i := 0
+	for _, li := range listens {
+		if li.Length > 0 && li.Length < lastFMMinLength {
+			continue
+		}
+
+		n := strconv.Itoa(i)
+		params.Set("artist["+n+"]", li.Artist)
+		params.Set("track["+n+"]", li.Track)
+		params.Set("timestamp["+n+"]", strconv.FormatInt(li.Time, 10))
+		if li.Album != "" {
+			params.Set("album["+n+"]", li.Album)
+		}
+		if li.Length > 0 {
+			params.Set("duration["+n+"]", strconv.Itoa(li.Length))
+		}
+		if li.TrackNumber > 0 {
+			params.Set("trackNumber["+n+"]", strconv.Itoa(li.TrackNumber))
+		}
+		if li.MBID != "" {
+			params.Set("mbid["+n+"]", li.MBID)
+		}
+		i++
+	}
+	if i == 0 {
+		return nil
+	}
+
+	return l.call(ctx, params)
+}
+
+// call signs and posts an API method. Last.fm reports errors in the body,
+// usually along with a 4xx status.
+func (l *LastFM) call(ctx context.Context, params url.Values) error {
+	params.Set("api_sig", l.sign(params))
+	params.Set("format", "json")
+
+	req, err := http.NewRequest("POST", l.URL, strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", userAgent)
+
+	res, err := l.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var e struct {
+		Error   int    `json:"error"`
+		Message string `json:"message"`
+	}
+	json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&e)
+	if e.Error != 0 {
+		return &Error{
+			Service:   l.Name(),
+			Status:    res.StatusCode,
+			Message:   fmt.Sprintf("%d: %s", e.Error, e.Message),
+			Temporary: lastFMTemporary[e.Error],
+		}
+	}
+	if res.StatusCode != http.StatusOK {
+		return statusError(l.Name(), res.StatusCode, http.StatusText(res.StatusCode))
+	}
+	return nil
+}
+
+// sign computes the api_sig of a request: the md5 sum of every parameter name
+// and value, sorted by name, followed by the shared secret
+func (l *LastFM) sign(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k != "format" && k != "callback" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	h := md5.New()
+	for _, k := range keys {
+		io.WriteString(h, k)
+		io.WriteString(h, params.Get(k))
+	}
+	io.WriteString(h, l.Secret)
+	return hex.EncodeToString(h.Sum(nil))
+}
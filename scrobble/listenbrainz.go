@@ -0,0 +1,132 @@
+package scrobble
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/eHoward1996/aiomst/db"
+)
+
+// ListenBrainz submits listens to a ListenBrainz compatible server, using
+// each user's ListenBrainz token
+type ListenBrainz struct {
+	// URL is the root of the server's API, without /1
+	URL    string
+	Client *http.Client
+}
+
+// lbListen is a listen as sent to ListenBrainz
+type lbListen struct {
+	ListenedAt int64 `json:"listened_at"`
+	Track      struct {
+		Artist string                 `json:"artist_name"`
+		Track  string                 `json:"track_name"`
+		Album  string                 `json:"release_name,omitempty"`
+		Info   map[string]interface{} `json:"additional_info"`
+	} `json:"track_metadata"`
+}
+
+// lbSubmission is the body of a submit-listens request
+type lbSubmission struct {
+	ListenType string     `json:"listen_type"`
+	Payload    []lbListen `json:"payload"`
+}
+
+// NewListenBrainz creates a ListenBrainz submitter for the server at url
+func NewListenBrainz(url string) *ListenBrainz {
+	return &ListenBrainz{
+		URL:    strings.TrimSuffix(url, "/"),
+		Client: http.DefaultClient,
+	}
+}
+
+// Name returns the name of the service
+func (l *ListenBrainz) Name() string {
+	return "listenbrainz"
+}
+
+// Enabled reports whether the user has a ListenBrainz token
+func (l *ListenBrainz) Enabled(u *db.User) bool {
+	return l.URL != "" && u.ListenBrainzToken != ""
+}
+
+// BatchSize returns the number of listens submitted at once
+func (l *ListenBrainz) BatchSize() int {
+	return 100
+}
+
+// Submit sends listens to the submit-listens endpoint. Single listens are
+// submitted as such, and batches of earlier plays as an import.
+func (l *ListenBrainz) Submit(ctx context.Context, u *db.User, listens []Listen) error {
+	sub := lbSubmission{
+		ListenType: "single",
+		Payload:    make([]lbListen, 0, len(listens)),
+	}
+	if len(listens) > 1 {
+		sub.ListenType = "import"
+	}
+
+	for _, li := range listens {
+		var p lbListen
+		p.ListenedAt = li.Time
+		p.Track.Artist = li.Artist
+		p.Track.Track = li.Track
+		p.Track.Album = li.Album
+		p.Track.Info = map[string]interface{}{
+			"submission_client": userAgent,
+		}
+		if li.Length > 0 {
+			p.Track.Info["duration_ms"] = li.Length * 1000
+		}
+		if li.TrackNumber > 0 {
+			p.Track.Info["tracknumber"] = li.TrackNumber
+		}
+		if li.MBID != "" {
+			p.Track.Info["recording_mbid"] = li.MBID
+		}
+		if li.Client != "" {
+			p.Track.Info["media_player"] = li.Client
+		}
+		sub.Payload = append(sub.Payload, p)
+	}
+
+	body, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", l.URL+"/1/submit-listens", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Token "+u.ListenBrainzToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	res, err := l.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		io.Copy(ioutil.Discard, res.Body)
+		return nil
+	}
+
+	// Errors carry a message in the error field
+	var e struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(io.LimitReader(res.Body, 1<<16)).Decode(&e)
+	if e.Error == "" {
+		e.Error = http.StatusText(res.StatusCode)
+	}
+	return statusError(l.Name(), res.StatusCode, e.Error)
+}
@@ -0,0 +1,231 @@
+package scrobble
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// Queue records plays and forwards them to scrobbling services in the
+// background
+type Queue struct {
+	// Submitters are the services plays are forwarded to
+	Submitters []Submitter
+	// Interval is how often the queue is checked for plays due for a retry
+	Interval time.Duration
+
+	wake chan struct{}
+
+	mu        sync.Mutex
+	threshold int
+	streams   map[streamKey]*streamState
+}
+
+// New creates a Queue forwarding plays to submitters. Threshold is the
+// percentage of a song which must be streamed before the stream counts as a
+// play; 0 disables inferring plays from streams.
+func New(threshold int, submitters ...Submitter) *Queue {
+	return &Queue{
+		Submitters: submitters,
+		Interval:   time.Minute,
+		wake:       make(chan struct{}, 1),
+		threshold:  threshold,
+		streams:    make(map[streamKey]*streamState),
+	}
+}
+
+// SetThreshold changes the percentage of a song which must be streamed before
+// the stream counts as a play
+func (q *Queue) SetThreshold(threshold int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.threshold = threshold
+}
+
+// Record saves a play of a song by a user at time t, which defaults to now,
+// and queues it for every service the user has enabled. If the user already
+// played the song within its length of t the play is a duplicate, and the
+// earlier play is returned instead along with false.
+func (q *Queue) Record(u *db.User, song *db.Song, t int64, client string) (*db.Play, bool, error) {
+	play := db.NewPlay(u.ID, song.ID, t, client)
+
+	gap := int64(song.Length)
+	if gap < minPlayGap {
+		gap = minPlayGap
+	}
+	last, err := db.DB.LastPlayOfSong(u.ID, song.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, false, err
+	}
+	if err == nil && last.Time > play.Time-gap && last.Time < play.Time+gap {
+		return last, false, nil
+	}
+
+	if err := play.Save(); err != nil {
+		return nil, false, err
+	}
+
+	for _, s := range q.Submitters {
+		if !s.Enabled(u) {
+			continue
+		}
+
+		scrobble := &db.Scrobble{PlayID: play.ID, Service: s.Name()}
+		if err := scrobble.Save(); err != nil {
+			return nil, false, err
+		}
+	}
+
+	// Wake the queue without blocking if it is already awake
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return play, true, nil
+}
+
+// Run forwards queued plays until ctx is done. Plays are submitted as soon as
+// they are recorded, and failed submissions are retried with an increasing
+// delay.
+func (q *Queue) Run(ctx context.Context) {
+	ticker := time.NewTicker(q.Interval)
+	defer ticker.Stop()
+
+	for {
+		q.Flush(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+// Flush submits every queued play which is due to each service
+func (q *Queue) Flush(ctx context.Context) {
+	for _, s := range q.Submitters {
+		if err := q.flush(ctx, s); err != nil && ctx.Err() == nil {
+			util.Logger.Printf("SCROBBLE: %s: %v", s.Name(), err)
+		}
+	}
+}
+
+// flushBatch is the number of queued plays loaded at once
+const flushBatch = 500
+
+// flush submits the queued plays due to one service, stopping at the first
+// temporary error as the service is likely offline
+func (q *Queue) flush(ctx context.Context, s Submitter) error {
+	for {
+		due, err := db.DB.DueScrobbles(s.Name(), time.Now().Unix(), flushBatch)
+		if err != nil || len(due) == 0 {
+			return err
+		}
+
+		// Plays are ordered by user, so submit each user's plays together
+		for start := 0; start < len(due); {
+			end := start + 1
+			for end < len(due) && due[end].UserID == due[start].UserID {
+				end++
+			}
+
+			if err := q.submitUser(ctx, s, due[start:end]); err != nil {
+				return err
+			}
+			start = end
+		}
+
+		if len(due) < flushBatch {
+			return nil
+		}
+	}
+}
+
+// submitUser submits one user's queued plays to a service in batches. Plays
+// which can no longer be submitted, because the user, the song or the user's
+// token is gone, are dropped.
+func (q *Queue) submitUser(ctx context.Context, s Submitter, due []db.Scrobble) error {
+	user := &db.User{ID: due[0].UserID}
+	if err := user.Load(); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if user.Username == "" || !s.Enabled(user) {
+		return db.DB.DeleteScrobbles(scrobbleIDs(due))
+	}
+
+	for len(due) > 0 {
+		n := s.BatchSize()
+		if n > len(due) {
+			n = len(due)
+		}
+		batch := due[:n]
+		due = due[n:]
+
+		IDs := make([]int, 0, len(batch))
+		listens := make([]Listen, 0, len(batch))
+		attempts := 0
+		dropped := make([]int, 0)
+		for _, a := range batch {
+			song := &db.Song{ID: a.SongID}
+			if err := song.Load(); err != nil {
+				if err != sql.ErrNoRows {
+					return err
+				}
+				dropped = append(dropped, a.ID)
+				continue
+			}
+
+			IDs = append(IDs, a.ID)
+			listens = append(listens, NewListen(song, a.Time, a.Client))
+			if a.Attempts > attempts {
+				attempts = a.Attempts
+			}
+		}
+		if err := db.DB.DeleteScrobbles(dropped); err != nil {
+			return err
+		}
+		if len(listens) == 0 {
+			continue
+		}
+
+		err := s.Submit(ctx, user, listens)
+		switch {
+		case err == nil:
+			if err := db.DB.DeleteScrobbles(IDs); err != nil {
+				return err
+			}
+		case ctx.Err() != nil:
+			// Shutting down, the plays stay queued as they were
+			return ctx.Err()
+		case temporary(err):
+			next := time.Now().Add(retryDelay(attempts)).Unix()
+			if rerr := db.DB.RetryScrobbles(IDs, next, err.Error()); rerr != nil {
+				return rerr
+			}
+			return err
+		default:
+			util.Logger.Printf("SCROBBLE: %s: dropping %d plays by %s: %v",
+				s.Name(), len(IDs), user, err)
+			if err := db.DB.DeleteScrobbles(IDs); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// scrobbleIDs returns the IDs of a slice of queued plays
+func scrobbleIDs(scrobbles []db.Scrobble) []int {
+	IDs := make([]int, 0, len(scrobbles))
+	for _, s := range scrobbles {
+		IDs = append(IDs, s.ID)
+	}
+	return IDs
+}
@@ -0,0 +1,220 @@
+package scrobble
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// testLibrary creates a database holding one song and a user with a
+// ListenBrainz token, which plays are recorded by
+func testLibrary(t *testing.T) (*db.User, *db.Song) {
+	util.InitializeLogger()
+	util.SetConfig(util.DefaultConfig())
+
+	db.DB.DSN(filepath.Join(t.TempDir(), "aiomst.db"))
+	if err := db.DB.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DB.Open(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+	if err := db.DB.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	artist := &db.Artist{Title: "Pink Floyd", NormalizedTitle: "pink floyd"}
+	if err := artist.Save(); err != nil {
+		t.Fatal(err)
+	}
+	album := &db.Album{
+		Title: "The Wall", NormalizedTitle: "the wall", ArtistID: artist.ID, Year: 1979}
+	if err := album.Save(); err != nil {
+		t.Fatal(err)
+	}
+	song := &db.Song{
+		Title:           "Hey You",
+		NormalizedTitle: "hey you",
+		AlbumID:         album.ID,
+		ArtistID:        artist.ID,
+		Path:            "/music/Hey You.mp3",
+		FileTypeID:      db.MP3,
+		Length:          280,
+		Track:           3,
+	}
+	if err := song.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := song.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := db.NewUser("alice", "password", db.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.ListenBrainzToken = "token"
+	if err := user.Save(); err != nil {
+		t.Fatal(err)
+	}
+	return user, song
+}
+
+// listenBrainzServer starts a ListenBrainz server which answers every
+// submission with status, and returns it along with the submissions received
+func listenBrainzServer(t *testing.T, status int) (*ListenBrainz, chan lbSubmission) {
+	submissions := make(chan lbSubmission, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1/submit-listens" || r.Header.Get("Authorization") != "Token token" {
+			t.Errorf("unexpected request %s with authorization %q",
+				r.URL.Path, r.Header.Get("Authorization"))
+		}
+
+		var sub lbSubmission
+		if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+			t.Error(err)
+		}
+		submissions <- sub
+
+		w.WriteHeader(status)
+		if status != http.StatusOK {
+			w.Write([]byte(`{"error": "rejected"}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return NewListenBrainz(srv.URL), submissions
+}
+
+// queued returns the number of plays queued for a service
+func queued(t *testing.T, service string) int64 {
+	n, err := db.DB.CountScrobbles(service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestFlushSubmits(t *testing.T) {
+	user, song := testLibrary(t)
+	lb, submissions := listenBrainzServer(t, http.StatusOK)
+	q := New(50, lb)
+
+	if _, recorded, err := q.Record(user, song, 1000, "client"); err != nil || !recorded {
+		t.Fatalf("play not recorded: %v", err)
+	}
+	if n := queued(t, lb.Name()); n != 1 {
+		t.Fatalf("%d plays queued, expected 1", n)
+	}
+
+	q.Flush(context.Background())
+
+	sub := <-submissions
+	if sub.ListenType != "single" || len(sub.Payload) != 1 {
+		t.Fatalf("unexpected submission: %+v", sub)
+	}
+	p := sub.Payload[0]
+	if p.ListenedAt != 1000 || p.Track.Track != "Hey You" ||
+		p.Track.Artist != "Pink Floyd" || p.Track.Album != "The Wall" {
+		t.Fatalf("unexpected listen: %+v", p)
+	}
+	if n := queued(t, lb.Name()); n != 0 {
+		t.Fatalf("%d plays still queued after a successful submission", n)
+	}
+}
+
+func TestFlushRetries(t *testing.T) {
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		status := status
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			user, song := testLibrary(t)
+			lb, submissions := listenBrainzServer(t, status)
+			q := New(50, lb)
+
+			if _, _, err := q.Record(user, song, 1000, ""); err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now().Unix()
+			q.Flush(context.Background())
+			<-submissions
+
+			// The play stays queued, but is not due until its retry
+			if n := queued(t, lb.Name()); n != 1 {
+				t.Fatalf("%d plays queued, expected 1", n)
+			}
+			due, err := db.DB.DueScrobbles(lb.Name(), time.Now().Unix(), 10)
+			if err != nil || len(due) != 0 {
+				t.Fatalf("%d plays due straight away, error %v", len(due), err)
+			}
+
+			next, err := db.DB.NextScrobble(lb.Name())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if delay := next - start; delay < 60 || delay > 61 {
+				t.Fatalf("retry after %d seconds, expected a minute", delay)
+			}
+			due, err = db.DB.DueScrobbles(lb.Name(), next, 10)
+			if err != nil || len(due) != 1 || due[0].Attempts != 1 || due[0].Error == "" {
+				t.Fatalf("unexpected retry %+v, error %v", due, err)
+			}
+		})
+	}
+}
+
+func TestFlushDrops(t *testing.T) {
+	user, song := testLibrary(t)
+	lb, submissions := listenBrainzServer(t, http.StatusBadRequest)
+	q := New(50, lb)
+
+	if _, _, err := q.Record(user, song, 1000, ""); err != nil {
+		t.Fatal(err)
+	}
+	q.Flush(context.Background())
+	<-submissions
+
+	if n := queued(t, lb.Name()); n != 0 {
+		t.Fatalf("%d plays still queued after a rejected submission", n)
+	}
+
+	// The play itself is kept in the history
+	if n, err := db.DB.CountPlays(user.ID); err != nil || n != 1 {
+		t.Fatalf("%d plays recorded, error %v", n, err)
+	}
+}
+
+func TestRecordDuplicate(t *testing.T) {
+	user, song := testLibrary(t)
+	q := New(50)
+
+	first, recorded, err := q.Record(user, song, 1000, "")
+	if err != nil || !recorded {
+		t.Fatalf("play not recorded: %v", err)
+	}
+
+	// Plays within the song's length of an earlier play are duplicates
+	for _, at := range []int64{1000, 1000 + 279, 1000 - 279} {
+		play, recorded, err := q.Record(user, song, at, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if recorded || play.ID != first.ID {
+			t.Fatalf("play at %d was recorded as play %d, expected duplicate of %d",
+				at, play.ID, first.ID)
+		}
+	}
+
+	if _, recorded, err := q.Record(user, song, 1000+280, ""); err != nil || !recorded {
+		t.Fatalf("play a song length later was not recorded: %v", err)
+	}
+	if n, err := db.DB.CountPlays(user.ID); err != nil || n != 2 {
+		t.Fatalf("%d plays recorded, error %v", n, err)
+	}
+}
@@ -0,0 +1,112 @@
+// Package scrobble records plays and forwards them to ListenBrainz and
+// Last.fm compatible scrobbling services. Plays are queued in the database,
+// so they survive restarts and are retried while a service is offline.
+package scrobble
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/eHoward1996/aiomst/db"
+)
+
+// userAgent identifies aiomst to scrobbling services
+const userAgent = "aiomst"
+
+// minPlayGap is the shortest time between two plays of the same song. A song
+// can not be played through twice within its own length, so plays closer
+// together than that are duplicates, for example a play reported by a client
+// which was also inferred from its stream.
+const minPlayGap = 30
+
+// Default is the queue plays are recorded with. It is nil until the
+// scrobble manager starts, in which case plays are not recorded.
+var Default *Queue
+
+// Listen is a play in the form submitted to scrobbling services
+type Listen struct {
+	Time        int64
+	Artist      string
+	Track       string
+	Album       string
+	Length      int
+	TrackNumber int
+	MBID        string
+	Client      string
+}
+
+// NewListen creates a Listen of a song played at time t
+func NewListen(song *db.Song, t int64, client string) Listen {
+	return Listen{
+		Time:        t,
+		Artist:      song.Artist,
+		Track:       song.Title,
+		Album:       song.Album,
+		Length:      song.Length,
+		TrackNumber: song.Track,
+		MBID:        song.MBID,
+		Client:      client,
+	}
+}
+
+// Submitter forwards listens to a scrobbling service
+type Submitter interface {
+	// Name identifies the service in the queue. It must never change.
+	Name() string
+	// Enabled reports whether plays by a user are forwarded to the service
+	Enabled(u *db.User) bool
+	// BatchSize is the largest number of listens accepted by one Submit
+	BatchSize() int
+	// Submit sends a user's listens to the service
+	Submit(ctx context.Context, u *db.User, listens []Listen) error
+}
+
+// Error is returned by a Submitter when a service rejects a submission.
+// Temporary errors are retried later; others are dropped from the queue.
+type Error struct {
+	Service   string
+	Status    int
+	Message   string
+	Temporary bool
+}
+
+// Error returns the service's error message
+func (e *Error) Error() string {
+	return fmt.Sprintf("scrobble: %s: %d %s", e.Service, e.Status, e.Message)
+}
+
+// temporary reports whether a failed submission should be retried. Errors
+// other than *Error mean the service could not be reached at all, so they
+// are always retried.
+func temporary(err error) bool {
+	if e, ok := err.(*Error); ok {
+		return e.Temporary
+	}
+	return true
+}
+
+// statusError builds an Error from an HTTP status. Rate limits and server
+// errors are temporary.
+func statusError(service string, status int, message string) *Error {
+	return &Error{
+		Service:   service,
+		Status:    status,
+		Message:   message,
+		Temporary: status == http.StatusTooManyRequests || status >= 500,
+	}
+}
+
+// retryDelay is how long to wait before retrying a submission which failed
+// attempts times, doubling from a minute up to six hours
+func retryDelay(attempts int) time.Duration {
+	const max = 6 * time.Hour
+	if attempts > 9 {
+		return max
+	}
+	if d := time.Minute << uint(attempts); d < max {
+		return d
+	}
+	return max
+}
@@ -0,0 +1,74 @@
+package scrobble
+
+import (
+	"time"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// streamIdle is how long a partly streamed song is remembered. Clients often
+// fetch a song in several range requests, which are added up until the
+// threshold is reached.
+const streamIdle = 30 * time.Minute
+
+// streamKey identifies a song streamed by a user
+type streamKey struct {
+	userID int
+	songID int
+}
+
+// streamState is the amount of a song streamed so far
+type streamState struct {
+	bytes   int64
+	updated time.Time
+}
+
+// Streamed records that n bytes of a song were sent to a user, out of the
+// size bytes of the whole stream. A play is recorded once the bytes streamed
+// pass the threshold percentage of the size. It reports whether a new play
+// was recorded.
+func (q *Queue) Streamed(u *db.User, song *db.Song, n, size int64, client string) bool {
+	if n <= 0 || size <= 0 {
+		return false
+	}
+
+	now := time.Now()
+	key := streamKey{u.ID, song.ID}
+
+	q.mu.Lock()
+	if q.threshold <= 0 {
+		q.mu.Unlock()
+		return false
+	}
+	for k, s := range q.streams {
+		if now.Sub(s.updated) > streamIdle {
+			delete(q.streams, k)
+		}
+	}
+
+	s, ok := q.streams[key]
+	if !ok {
+		s = &streamState{}
+		q.streams[key] = s
+	}
+	s.bytes += n
+	s.updated = now
+
+	played := s.bytes*100 >= size*int64(q.threshold)
+	if played {
+		delete(q.streams, key)
+	}
+	q.mu.Unlock()
+
+	if !played {
+		return false
+	}
+
+	_, recorded, err := q.Record(u, song, now.Unix(), client)
+	if err != nil {
+		util.Logger.Printf("SCROBBLE: Could not record play of %s: %v", song, err)
+		return false
+	}
+	return recorded
+}
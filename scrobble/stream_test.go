@@ -0,0 +1,73 @@
+package scrobble
+
+import (
+	"testing"
+
+	"github.com/eHoward1996/aiomst/db"
+)
+
+func TestStreamed(t *testing.T) {
+	user, song := testLibrary(t)
+	q := New(50)
+
+	// Range requests add up until half of the stream was sent
+	for i, n := range []int64{200, 200} {
+		if q.Streamed(user, song, n, 1000, "") {
+			t.Fatalf("stream %d recorded a play before the threshold", i)
+		}
+	}
+	if !q.Streamed(user, song, 100, 1000, "") {
+		t.Fatal("stream reaching the threshold did not record a play")
+	}
+	if n, err := db.DB.CountPlays(user.ID); err != nil || n != 1 {
+		t.Fatalf("%d plays recorded, error %v", n, err)
+	}
+
+	// Streaming the song again straight away is a duplicate play
+	if q.Streamed(user, song, 1000, 1000, "") {
+		t.Fatal("stream straight after a play recorded another play")
+	}
+	if n, err := db.DB.CountPlays(user.ID); err != nil || n != 1 {
+		t.Fatalf("%d plays recorded, error %v", n, err)
+	}
+}
+
+func TestStreamedSeparateUsers(t *testing.T) {
+	user, song := testLibrary(t)
+	other, err := db.NewUser("bob", "password", db.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Save(); err != nil {
+		t.Fatal(err)
+	}
+	q := New(50)
+
+	// Streams of the same song by different users do not add up
+	if q.Streamed(user, song, 300, 1000, "") || q.Streamed(other, song, 300, 1000, "") {
+		t.Fatal("streams by two users were added up")
+	}
+	if !q.Streamed(other, song, 200, 1000, "") {
+		t.Fatal("stream reaching the threshold did not record a play")
+	}
+	if n, err := db.DB.CountPlays(user.ID); err != nil || n != 0 {
+		t.Fatalf("%d plays recorded for the first user, error %v", n, err)
+	}
+}
+
+func TestStreamedDisabled(t *testing.T) {
+	user, song := testLibrary(t)
+	q := New(0)
+
+	if q.Streamed(user, song, 1000, 1000, "") {
+		t.Fatal("stream recorded a play with plays from streams disabled")
+	}
+
+	q.SetThreshold(100)
+	if q.Streamed(user, song, 999, 1000, "") {
+		t.Fatal("stream recorded a play before the threshold")
+	}
+	if !q.Streamed(user, song, 1, 1000, "") {
+		t.Fatal("whole stream did not record a play")
+	}
+}
@@ -0,0 +1,104 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eHoward1996/aiomst/transcode"
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// Formats art may be sent in
+const (
+	JPEG = "jpeg"
+	PNG  = "png"
+	WebP = "webp"
+	AVIF = "avif"
+)
+
+// MIMETypes maps each format to its MIME type
+var MIMETypes = map[string]string{
+	JPEG: "image/jpeg",
+	PNG:  "image/png",
+	WebP: "image/webp",
+	AVIF: "image/avif",
+}
+
+// encodeTimeout is the longest the encoder binary may take over one image
+const encodeTimeout = time.Minute
+
+// encoderArgs are the arguments passed to the encoder binary to convert a PNG
+// read from stdin into each format it is used for, written to stdout
+var encoderArgs = map[string][]string{
+	WebP: {"-c:v", "libwebp", "-quality", "80", "-f", "webp"},
+	AVIF: {"-c:v", "libaom-av1", "-still-picture", "1", "-crf", "32", "-f", "avif"},
+}
+
+var (
+	unavailableMutex sync.Mutex
+	// unavailable holds the formats the encoder binary failed to produce,
+	// which are not tried again until restarted
+	unavailable = map[string]bool{}
+)
+
+// Available reports whether art may be encoded in a format
+func Available(format string) bool {
+	if _, ok := encoderArgs[format]; !ok {
+		return format == JPEG || format == PNG
+	}
+
+	unavailableMutex.Lock()
+	defer unavailableMutex.Unlock()
+	return !unavailable[format]
+}
+
+// Encode writes an image to w in a format. WebP and AVIF are encoded by the
+// transcoder's binary.
+func Encode(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case JPEG:
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: 85})
+	case PNG:
+		return png.Encode(w, img)
+	}
+
+	args, ok := encoderArgs[format]
+	if !ok {
+		return fmt.Errorf("thumbnail: unknown format %q", format)
+	}
+
+	input := new(bytes.Buffer)
+	if err := png.Encode(input, img); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), encodeTimeout)
+	defer cancel()
+
+	stderr := new(bytes.Buffer)
+	args = append([]string{"-v", "error", "-nostdin", "-f", "png_pipe", "-i", "-"}, args...)
+	cmd := exec.CommandContext(ctx, transcode.Default.Binary, append(args, "-")...)
+	cmd.Stdin = input
+	cmd.Stdout = w
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		// Most likely the binary was built without the encoder
+		unavailableMutex.Lock()
+		unavailable[format] = true
+		unavailableMutex.Unlock()
+
+		util.Logger.Printf("ART: Cannot encode %s, it will not be sent: %v: %s",
+			format, err, strings.TrimSpace(stderr.String()))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package thumbnail
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Resize scales an image down to fit within a square of the given size,
+// keeping its aspect ratio. Each pixel is the average of the source pixels it
+// covers, which keeps fine detail from aliasing. Images which already fit are
+// returned at their own size.
+func Resize(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if size > 0 && (w > size || h > size) {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+
+	// Premultiplied pixels average correctly through transparency
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	if w == b.Dx() && h == b.Dy() {
+		return rgba
+	}
+
+	// Scale each row, then each column of the result
+	rows := scaleAxis(rgba.Pix, b.Dx(), b.Dy(), 4, rgba.Stride, w)
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	cols := scaleAxis(rows, b.Dy(), w, 4*w, 4, h)
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; y++ {
+			copy(out.Pix[y*out.Stride+4*x:y*out.Stride+4*x+4], cols[x*4*h+4*y:x*4*h+4*y+4])
+		}
+	}
+	return out
+}
+
+// scaleAxis scales lines of n pixels down to m pixels, for each of count
+// lines. Pixel i of a line is found at step*i, and line j at stride*j. The
+// result holds count lines of m pixels, one after another.
+func scaleAxis(pix []uint8, n, count, step, stride, m int) []uint8 {
+	out := make([]uint8, count*m*4)
+	sums := make([]float64, 4)
+
+	// Each output pixel covers n/m source pixels, partly covering those at
+	// its edges
+	scale := float64(n) / float64(m)
+	for j := 0; j < count; j++ {
+		line := pix[j*stride:]
+		for i := 0; i < m; i++ {
+			start, end := float64(i)*scale, float64(i+1)*scale
+			for c := range sums {
+				sums[c] = 0
+			}
+
+			for k := int(start); k < n && float64(k) < end; k++ {
+				weight := 1.0
+				if float64(k) < start {
+					weight -= start - float64(k)
+				}
+				if float64(k+1) > end {
+					weight -= float64(k+1) - end
+				}
+				p := line[k*step : k*step+4]
+				for c := range sums {
+					sums[c] += weight * float64(p[c])
+				}
+			}
+
+			o := out[(j*m+i)*4:]
+			for c := range sums {
+				o[c] = uint8(sums[c]/scale + 0.5)
+			}
+		}
+	}
+	return out
+}
+
+// max returns the larger of two ints
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,173 @@
+// Package thumbnail resizes and converts art, caching the results on disk so
+// each is only encoded once.
+package thumbnail
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"image"
+	_ "image/gif" // Embedded pictures may be GIFs
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eHoward1996/aiomst/db"
+	"github.com/eHoward1996/aiomst/util"
+)
+
+// locks serialize the encoding of cached files, so concurrent requests for
+// the same thumbnail encode it once. Files share one of the locks by hash.
+var locks [64]sync.Mutex
+
+// Size rounds a requested size up to the nearest configured size, so the
+// cache holds a few sizes of each image. Larger sizes become the largest, and
+// zero, the full size, is kept.
+func Size(requested int) int {
+	if requested <= 0 {
+		return 0
+	}
+
+	sizes := append([]int(nil), util.Current().Art.Sizes...)
+	sort.Ints(sizes)
+	for _, size := range sizes {
+		if size >= requested {
+			return size
+		}
+	}
+	return sizes[len(sizes)-1]
+}
+
+// SourceFormat returns the format of art's image, jpeg or png for art files
+func SourceFormat(art *db.Art) (string, error) {
+	stream, err := art.Stream()
+	if err != nil {
+		return "", err
+	}
+	if c, ok := stream.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	_, format, err := image.DecodeConfig(stream)
+	return format, err
+}
+
+// Negotiate picks the format art is sent in from a request's Accept header.
+// The configured formats are tried in order, and otherwise JPEGs stay JPEGs
+// while every other image is sent as a PNG, which keeps transparency.
+func Negotiate(accept string, source string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		accepted[strings.TrimSpace(params[0])] = true
+
+		// Types given a quality of zero are refused
+		for _, param := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) != 2 || kv[0] != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(kv[1], 64); err == nil && q == 0 {
+				delete(accepted, strings.TrimSpace(params[0]))
+			}
+		}
+	}
+
+	for _, format := range util.Current().Art.Formats {
+		if accepted[MIMETypes[format]] && Available(format) {
+			return format
+		}
+	}
+	if source == JPEG {
+		return JPEG
+	}
+	return PNG
+}
+
+// File returns the path of the cached copy of art at a size and format.
+// Cached files are kept in a folder per art ID.
+func File(artID int, size int, format string) string {
+	return path.Join(
+		util.C.ArtCachePath(), strconv.Itoa(artID),
+		fmt.Sprintf("%d.%s", size, format),
+	)
+}
+
+// Cached reports whether the cached copy of art at a size and format is
+// current. Cached files take on the modification time of their art.
+func Cached(art *db.Art, size int, format string) bool {
+	info, err := os.Stat(File(art.ID, size, format))
+	return err == nil && info.ModTime().Unix() == art.LastModified
+}
+
+// Get returns the path of art resized to fit a size and encoded in a format,
+// creating it unless a current copy is cached. A size of zero keeps the full
+// size.
+func Get(art *db.Art, size int, format string) (string, error) {
+	file := File(art.ID, size, format)
+
+	h := fnv.New32a()
+	io.WriteString(h, file)
+	lock := &locks[h.Sum32()%uint32(len(locks))]
+	lock.Lock()
+	defer lock.Unlock()
+	if Cached(art, size, format) {
+		return file, nil
+	}
+
+	stream, err := art.Stream()
+	if err != nil {
+		return "", err
+	}
+	if c, ok := stream.(io.Closer); ok {
+		defer c.Close()
+	}
+	img, _, err := image.Decode(bufio.NewReader(stream))
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return "", err
+	}
+
+	// Written to a temporary file first, so a partial file is never served
+	tmp, err := ioutil.TempFile(path.Dir(file), ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(tmp)
+	err = Encode(w, Resize(img, size), format)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		modified := time.Unix(art.LastModified, 0)
+		if err = os.Chtimes(tmp.Name(), modified, modified); err == nil {
+			err = os.Rename(tmp.Name(), file)
+		}
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return file, nil
+}
+
+// Remove deletes every cached copy of art
+func Remove(artID int) error {
+	dir := path.Dir(File(artID, 0, JPEG))
+	if err := os.RemoveAll(dir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package transcode
+
+import (
+	"strings"
+	"sync"
+)
+
+// Profile describes how to encode audio into one output format. Profiles are
+// looked up by the format name clients pass to /stream.
+type Profile struct {
+	// Name is the format name requested by clients (mp3, opus, ...)
+	Name string
+	// MIMEType is sent as the Content-Type of the transcoded stream
+	MIMEType string
+	// Codec is the encoder passed to the encoder binary
+	Codec string
+	// Container is the output muxer passed to the encoder binary
+	Container string
+	// DefaultBitRate is used when the client does not ask for a bit rate (kbps)
+	DefaultBitRate int
+	// MinBitRate and MaxBitRate clamp the requested bit rate (kbps)
+	MinBitRate int
+	MaxBitRate int
+	// Options holds any extra encoder arguments for this profile
+	Options []string
+}
+
+// BitRate clamps the requested bit rate to the range supported by this
+// profile. A requested bit rate of 0 returns the default bit rate.
+func (p Profile) BitRate(requested int) int {
+	if requested <= 0 || requested > p.DefaultBitRate {
+		requested = p.DefaultBitRate
+	}
+	if p.MinBitRate > 0 && requested < p.MinBitRate {
+		requested = p.MinBitRate
+	}
+	if p.MaxBitRate > 0 && requested > p.MaxBitRate {
+		requested = p.MaxBitRate
+	}
+	return requested
+}
+
+// DefaultFormat is the profile used when a client only limits the bit rate
+const DefaultFormat = "mp3"
+
+var (
+	profileMutex sync.RWMutex
+	profiles     = map[string]Profile{}
+)
+
+// init registers the built in encoder profiles
+func init() {
+	Register(Profile{
+		Name:           "mp3",
+		MIMEType:       "audio/mpeg",
+		Codec:          "libmp3lame",
+		Container:      "mp3",
+		DefaultBitRate: 320,
+		MinBitRate:     32,
+		MaxBitRate:     320,
+	})
+	Register(Profile{
+		Name:           "opus",
+		MIMEType:       "audio/ogg",
+		Codec:          "libopus",
+		Container:      "ogg",
+		DefaultBitRate: 128,
+		MinBitRate:     16,
+		MaxBitRate:     256,
+		Options:        []string{"-vbr", "on"},
+	})
+	Register(Profile{
+		Name:           "ogg",
+		MIMEType:       "audio/ogg",
+		Codec:          "libvorbis",
+		Container:      "ogg",
+		DefaultBitRate: 192,
+		MinBitRate:     64,
+		MaxBitRate:     320,
+	})
+	Register(Profile{
+		Name:           "aac",
+		MIMEType:       "audio/aac",
+		Codec:          "aac",
+		Container:      "adts",
+		DefaultBitRate: 256,
+		MinBitRate:     32,
+		MaxBitRate:     320,
+	})
+}
+
+// Register adds a profile, replacing any existing profile with the same name
+func Register(p Profile) {
+	profileMutex.Lock()
+	defer profileMutex.Unlock()
+	profiles[strings.ToLower(p.Name)] = p
+}
+
+// Lookup returns the profile registered for the given format name
+func Lookup(name string) (Profile, bool) {
+	profileMutex.RLock()
+	defer profileMutex.RUnlock()
+	p, ok := profiles[strings.ToLower(name)]
+	return p, ok
+}
+
+// Profiles returns the names of all registered profiles
+func Profiles() []string {
+	profileMutex.RLock()
+	defer profileMutex.RUnlock()
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	return names
+}
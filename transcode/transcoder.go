@@ -0,0 +1,102 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultBinary is the encoder binary used when none is configured
+	DefaultBinary = "ffmpeg"
+	// DefaultMaxJobs is the number of concurrent transcodes when none is configured
+	DefaultMaxJobs = 4
+)
+
+var (
+	// ErrUnknownProfile is returned when a format has no registered profile
+	ErrUnknownProfile = errors.New("transcode: unknown profile")
+)
+
+// Transcoder runs an external encoder binary, limiting the number of jobs
+// which may run at the same time.
+type Transcoder struct {
+	// Binary is the path to an ffmpeg compatible encoder. It may be swapped
+	// out for a fake encoder.
+	Binary string
+	jobs   chan struct{}
+}
+
+// Default is the transcoder used by the API
+var Default = New(DefaultBinary, DefaultMaxJobs)
+
+// New creates a Transcoder which runs at most maxJobs encoders at a time
+func New(binary string, maxJobs int) *Transcoder {
+	if binary == "" {
+		binary = DefaultBinary
+	}
+	if maxJobs <= 0 {
+		maxJobs = DefaultMaxJobs
+	}
+
+	return &Transcoder{
+		Binary: binary,
+		jobs:   make(chan struct{}, maxJobs),
+	}
+}
+
+// Args returns the encoder arguments used to transcode input with profile p
+// at the given bit rate (kbps). Output is always written to stdout.
+func Args(input string, p Profile, bitRate int) []string {
+	args := []string{
+		"-v", "error",
+		"-nostdin",
+		"-i", input,
+		"-map", "0:a:0",
+		"-vn",
+		"-c:a", p.Codec,
+		"-b:a", strconv.Itoa(p.BitRate(bitRate)) + "k",
+	}
+	args = append(args, p.Options...)
+	return append(args, "-f", p.Container, "-")
+}
+
+// Transcode encodes the file at input using profile p, writing the encoded
+// stream to w. It blocks while the maximum number of jobs are running. The
+// encoder is killed as soon as ctx is done, such as when a client disconnects.
+func (t *Transcoder) Transcode(
+	ctx context.Context, w io.Writer, input string, p Profile, bitRate int) error {
+
+	// Wait for a free job slot
+	select {
+	case t.jobs <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-t.jobs }()
+
+	stderr := new(bytes.Buffer)
+	cmd := exec.CommandContext(ctx, t.Binary, Args(input, p, bitRate)...)
+	cmd.Stdout = w
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		// A cancelled context is not an encoder failure
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("transcode: %s: %v: %s",
+			p.Name, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// Running returns the number of jobs currently being transcoded
+func (t *Transcoder) Running() int {
+	return len(t.jobs)
+}
@@ -0,0 +1,166 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeEncoder writes a shell script running body, which stands in for the
+// encoder binary, returning its path and the temporary directory holding it
+func fakeEncoder(t *testing.T, body string) (string, string) {
+	dir, err := ioutil.TempDir("", "transcode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	binary := filepath.Join(dir, "encoder")
+	script := "#!/bin/sh\n" + body + "\n"
+	if err := ioutil.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return binary, dir
+}
+
+func TestTranscodeArgs(t *testing.T) {
+	binary, _ := fakeEncoder(t, `echo "$@"`)
+	tr := New(binary, 1)
+
+	p, ok := Lookup("opus")
+	if !ok {
+		t.Fatal("opus profile is not registered")
+	}
+
+	out := new(bytes.Buffer)
+	if err := tr.Transcode(context.Background(), out, "in.flac", p, 96); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join(Args("in.flac", p, 96), " ")
+	if got := strings.TrimSpace(out.String()); got != want {
+		t.Fatalf("encoder arguments:\n got %q\nwant %q", got, want)
+	}
+	for _, arg := range []string{"-c:a libopus", "-b:a 96k", "-vbr on", "-f ogg -"} {
+		if !strings.Contains(want, arg) {
+			t.Errorf("arguments %q do not contain %q", want, arg)
+		}
+	}
+}
+
+func TestTranscodeBitRate(t *testing.T) {
+	p, _ := Lookup("mp3")
+	tests := []struct {
+		requested int
+		want      string
+	}{
+		{0, "320k"},
+		{128, "128k"},
+		{8, "32k"},
+		{1000, "320k"},
+	}
+	for _, test := range tests {
+		args := strings.Join(Args("in.flac", p, test.requested), " ")
+		if !strings.Contains(args, "-b:a "+test.want) {
+			t.Errorf("bit rate %d: arguments %q do not contain %q",
+				test.requested, args, test.want)
+		}
+	}
+}
+
+func TestTranscodeError(t *testing.T) {
+	binary, _ := fakeEncoder(t, `echo boom >&2; exit 3`)
+	tr := New(binary, 1)
+	p, _ := Lookup("mp3")
+
+	err := tr.Transcode(context.Background(), ioutil.Discard, "in.flac", p, 0)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected an error carrying the encoder output, got %v", err)
+	}
+	if n := tr.Running(); n != 0 {
+		t.Fatalf("%d jobs still running after a failure", n)
+	}
+}
+
+func TestTranscodeCancel(t *testing.T) {
+	binary, _ := fakeEncoder(t, `exec sleep 10`)
+	tr := New(binary, 1)
+	p, _ := Lookup("mp3")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := tr.Transcode(ctx, ioutil.Discard, "in.flac", p, 0)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("encoder was not killed when the context was done")
+	}
+}
+
+func TestTranscodeJobLimit(t *testing.T) {
+	binary, dir := fakeEncoder(t, `echo started >> "$(dirname "$0")/started"
+while [ ! -e "$(dirname "$0")/release" ]; do sleep 0.01; done`)
+	started := filepath.Join(dir, "started")
+	tr := New(binary, 2)
+	p, _ := Lookup("mp3")
+
+	countStarted := func() int {
+		b, _ := ioutil.ReadFile(started)
+		return strings.Count(string(b), "started")
+	}
+	waitStarted := func(n int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for countStarted() < n {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d of %d encoders started", countStarted(), n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- tr.Transcode(context.Background(), ioutil.Discard, "in.flac", p, 0)
+		}()
+	}
+
+	// Only two encoders may run, the third waiting for a free slot
+	waitStarted(2)
+	time.Sleep(100 * time.Millisecond)
+	if n := countStarted(); n != 2 {
+		t.Fatalf("%d encoders running, expected 2", n)
+	}
+	if n := tr.Running(); n != 2 {
+		t.Fatalf("Running returned %d, expected 2", n)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "release"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countStarted(); n != 3 {
+		t.Fatalf("%d encoders ran, expected 3", n)
+	}
+	if n := tr.Running(); n != 0 {
+		t.Fatalf("%d jobs still running", n)
+	}
+}
@@ -1,33 +1,239 @@
 package util
 
 import (
-	"flag"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
 	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 )
 
+// C is the configuration loaded at startup. Settings which may be changed by
+// ReloadConfig while running should be read through Current instead.
+var C Config
+
 var (
-	hostPort = flag.String("host", ":8090", "The port to bind to.")
-	driveLoc = flag.String("media", "~/MediaDrive/Media/Music", "The path to the media folder.")
-	sqlDBLoc = flag.String("sqlite", "~/MediaDrive/Media/mediadb.db", "The sql db location.")
+	configMutex sync.RWMutex
+	current     Config
 )
 
-// C is the current configuration
-var C Config
-
 // SqliteFile is the configuration for the SQLite backend.
 type SqliteFile struct {
-	File string  `json:"file"`
+	File string `json:"file"`
+}
+
+// Transcoder is the configuration for on the fly transcoding.
+type Transcoder struct {
+	Binary  string `json:"binary"`
+	MaxJobs int    `json:"maxJobs"`
+}
+
+// AdminAccount is the account created when the database has no users.
+type AdminAccount struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// Scrobble is the configuration for play tracking and scrobble forwarding.
+type Scrobble struct {
+	Threshold       int    `json:"threshold"`
+	ListenBrainzURL string `json:"listenBrainzUrl"`
+	LastFMURL       string `json:"lastFmUrl"`
+	LastFMKey       string `json:"lastFmKey"`
+	LastFMSecret    string `json:"lastFmSecret"`
+}
+
+// Discogs is the configuration for the Discogs API. Discogs is not queried
+// without a token.
+type Discogs struct {
+	Token string `json:"token"`
+}
+
+// Integration is the configuration for fetching third party metadata.
+// Providers lists the metadata providers used, highest priority first.
+type Integration struct {
+	Interval  Duration `json:"interval"`
+	Workers   int      `json:"workers"`
+	Providers []string `json:"providers"`
+	// Genres adds the genres and styles providers find for an album to its
+	// songs
+	Genres bool `json:"genres"`
+}
+
+// Scanner is the configuration for scanning the libraries for media.
+type Scanner struct {
+	Workers int `json:"workers"`
+}
+
+// Tags is the configuration for reading the artists and genres of songs from
+// their tags. The artist, remixer and composer tags are split into several
+// artists by any of ArtistSeparators, and the genre tag into several genres
+// by any of GenreSeparators, which are matched as they are written, ignoring
+// case. Artists following one of the FeaturedMarkers words, in the artist tag
+// or in the title, are credited as featured. Artists and albums without a
+// sort tag are sorted without any leading word of IgnoredArticles.
+type Tags struct {
+	ArtistSeparators []string `json:"artistSeparators"`
+	FeaturedMarkers  []string `json:"featuredMarkers"`
+	GenreSeparators  []string `json:"genreSeparators"`
+	IgnoredArticles  []string `json:"ignoredArticles"`
+}
+
+// Watcher is the configuration for watching the media folder for changes.
+// Mode is auto, notify or poll; auto polls the folders which cannot be
+// watched with change notifications. Changes are scanned once a folder has
+// been quiet for Debounce.
+type Watcher struct {
+	Mode     string   `json:"mode"`
+	Interval Duration `json:"interval"`
+	Debounce Duration `json:"debounce"`
+}
+
+// Art is the configuration for cover art. Priority is file or embedded, and
+// picks between an album folder's image files and the pictures embedded in its
+// songs' tags when it has both. Resized art is cached in CacheDir, in one of
+// Sizes; Formats lists the extra formats, webp and avif, sent to clients which
+// accept them. Art is resized to the Pregenerate sizes after each scan. When
+// Download is set, albums and artists without art are given covers and images
+// from the Cover Art Archive and Discogs, kept in the cache.
+type Art struct {
+	Priority    string   `json:"priority"`
+	Download    bool     `json:"download"`
+	CacheDir    string   `json:"cacheDir"`
+	Sizes       []int    `json:"sizes"`
+	Formats     []string `json:"formats"`
+	Pregenerate []int    `json:"pregenerate"`
+}
+
+// CORS is the configuration for cross origin requests. An origin of "*"
+// allows every origin.
+type CORS struct {
+	Origins []string `json:"origins"`
 }
 
 // Config is the programs configuration options.
 type Config struct {
-	Host 				string 		  `json:"host"`
-	MediaFolder string		  `json:"mediaFolder"`
-	Sqlite			*SqliteFile	`json:"sqlite"`
+	Host        string `json:"host"`
+	MediaFolder string `json:"mediaFolder"`
+	// ReadOnly guarantees nothing is ever written under a library root
+	ReadOnly bool `json:"readOnly"`
+	// CleanMetadata deletes the metadata files earlier versions wrote into
+	// the media folders
+	CleanMetadata bool          `json:"cleanMetadata"`
+	Sqlite        *SqliteFile   `json:"sqlite"`
+	Transcoder    *Transcoder   `json:"transcoder"`
+	Admin         *AdminAccount `json:"admin"`
+	Scrobble      *Scrobble     `json:"scrobble"`
+	Discogs       *Discogs      `json:"discogs"`
+	Integration   *Integration  `json:"integration"`
+	Scanner       *Scanner      `json:"scanner"`
+	Tags          *Tags         `json:"tags"`
+	Watcher       *Watcher      `json:"watcher"`
+	Art           *Art          `json:"art"`
+	CORS          *CORS         `json:"cors"`
+}
+
+// Duration is a time.Duration which is written as a string such as "24h" in
+// configuration files. Plain numbers are read as seconds.
+type Duration time.Duration
+
+// Duration returns d as a time.Duration
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+// String formats the duration like time.Duration
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON reads the duration from a string, or a number of seconds
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(b, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// DefaultConfig returns the configuration used for settings which are not set
+// by a file, the environment or a flag.
+func DefaultConfig() Config {
+	return Config{
+		Host:        ":8090",
+		MediaFolder: "~/MediaDrive/Media/Music",
+		Sqlite: &SqliteFile{
+			File: "~/MediaDrive/Media/mediadb.db",
+		},
+		Transcoder: &Transcoder{
+			Binary:  "ffmpeg",
+			MaxJobs: 4,
+		},
+		Admin: &AdminAccount{
+			User: "admin",
+		},
+		Scrobble: &Scrobble{
+			Threshold:       50,
+			ListenBrainzURL: "https://api.listenbrainz.org",
+			LastFMURL:       "https://ws.audioscrobbler.com/2.0/",
+		},
+		Discogs: &Discogs{},
+		Integration: &Integration{
+			Interval:  Duration(24 * time.Hour),
+			Workers:   5,
+			Providers: []string{"musicbrainz", "discogs"},
+		},
+		Scanner: &Scanner{
+			Workers: runtime.NumCPU(),
+		},
+		Tags: &Tags{
+			ArtistSeparators: []string{";", " / "},
+			FeaturedMarkers:  []string{"feat.", "ft.", "featuring"},
+			GenreSeparators:  []string{";", "/", ","},
+			IgnoredArticles:  []string{"The", "El", "La", "Los", "Las", "Le", "Les"},
+		},
+		Watcher: &Watcher{
+			Mode:     "auto",
+			Interval: Duration(time.Minute),
+			Debounce: Duration(2 * time.Second),
+		},
+		Art: &Art{
+			Priority:    "file",
+			Download:    true,
+			Sizes:       []int{64, 128, 256, 512, 1024},
+			Formats:     []string{"webp"},
+			Pregenerate: []int{},
+		},
+		CORS: &CORS{
+			Origins: []string{"*"},
+		},
+	}
 }
 
 // MediaFolderPath returns the fully expanded path to the media folder.
-func (c Config) MediaFolderPath()	string {
+func (c Config) MediaFolderPath() string {
 	return path.Clean(ExpandHomeDir(c.MediaFolder))
 }
 
@@ -36,15 +242,168 @@ func (c Config) SqlFilePath() string {
 	return path.Clean(ExpandHomeDir(c.Sqlite.File))
 }
 
-// LoadConfig returns the configuration from the command line.
-func LoadConfig() Config	{
-	flag.Parse()
+// ArtCachePath returns the fully expanded path to the art cache, which is a
+// thumbnails folder next to the SQL file unless set.
+func (c Config) ArtCachePath() string {
+	if c.Art.CacheDir == "" {
+		return path.Join(path.Dir(c.SqlFilePath()), "thumbnails")
+	}
+	return path.Clean(ExpandHomeDir(c.Art.CacheDir))
+}
 
-	return Config	{
-		Host:        *hostPort,
-		MediaFolder: *driveLoc,
-		Sqlite: &SqliteFile {
-			File: *sqlDBLoc,
-		},
+// AllowsOrigin reports whether cross origin requests from origin are allowed
+func (c Config) AllowsOrigin(origin string) bool {
+	for _, o := range c.CORS.Origins {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks every setting, returning an error which lists all of the
+// invalid ones.
+func (c Config) Validate() error {
+	problems := make([]string, 0)
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	_, port, err := net.SplitHostPort(c.Host)
+	check(err == nil && port != "", "host %q must be [address]:port", c.Host)
+
+	info, err := os.Stat(c.MediaFolderPath())
+	check(err == nil && info.IsDir(), "media folder %q is not a directory", c.MediaFolder)
+	check(c.Sqlite.File != "", "sqlite file must be set")
+	if c.Sqlite.File != "" {
+		dir := path.Dir(c.SqlFilePath())
+		info, err := os.Stat(dir)
+		check(err == nil && info.IsDir(), "sqlite folder %q does not exist", dir)
+		check(!c.ReadOnly || !strings.HasPrefix(dir+"/", c.MediaFolderPath()+"/"),
+			"sqlite file %q must be outside the media folder when read only", c.Sqlite.File)
+	}
+	check(!c.ReadOnly || !c.CleanMetadata, "cleanMetadata can not be used when read only")
+
+	check(c.Transcoder.Binary != "", "transcoder binary must be set")
+	check(c.Transcoder.MaxJobs > 0, "transcoder maxJobs must be at least 1")
+	check(c.Admin.User != "", "admin user must be set")
+
+	check(c.Scrobble.Threshold >= 0 && c.Scrobble.Threshold <= 100,
+		"scrobble threshold must be between 0 and 100")
+	check(validURL(c.Scrobble.ListenBrainzURL),
+		"scrobble listenBrainzUrl %q is not an http(s) URL", c.Scrobble.ListenBrainzURL)
+	check(validURL(c.Scrobble.LastFMURL),
+		"scrobble lastFmUrl %q is not an http(s) URL", c.Scrobble.LastFMURL)
+	check((c.Scrobble.LastFMKey == "") == (c.Scrobble.LastFMSecret == ""),
+		"scrobble lastFmKey and lastFmSecret must be set together")
+
+	check(c.Integration.Interval.Duration() >= time.Minute,
+		"integration interval must be at least 1m")
+	check(c.Integration.Workers > 0, "integration workers must be at least 1")
+	check(c.Scanner.Workers > 0, "scanner workers must be at least 1")
+	for _, sep := range c.Tags.ArtistSeparators {
+		check(strings.TrimSpace(sep) != "", "tags artist separator %q is blank", sep)
+	}
+	for _, sep := range c.Tags.GenreSeparators {
+		check(strings.TrimSpace(sep) != "", "tags genre separator %q is blank", sep)
+	}
+	for _, m := range c.Tags.FeaturedMarkers {
+		check(m != "" && !strings.ContainsAny(m, " \t"),
+			"tags featured marker %q must be a single word", m)
+	}
+	for _, a := range c.Tags.IgnoredArticles {
+		check(a != "" && !strings.ContainsAny(a, " \t"),
+			"tags ignored article %q must be a single word", a)
+	}
+	check(c.Watcher.Mode == "auto" || c.Watcher.Mode == "notify" || c.Watcher.Mode == "poll",
+		"watcher mode %q is not auto, notify or poll", c.Watcher.Mode)
+	check(c.Watcher.Interval.Duration() >= time.Second,
+		"watcher interval must be at least 1s")
+	check(c.Watcher.Debounce.Duration() >= 100*time.Millisecond,
+		"watcher debounce must be at least 100ms")
+	check(c.Art.Priority == "file" || c.Art.Priority == "embedded",
+		"art priority %q is not file or embedded", c.Art.Priority)
+	check(len(c.Art.Sizes) > 0, "art sizes must not be empty")
+	for _, size := range append(c.Art.Sizes, c.Art.Pregenerate...) {
+		check(size >= 16 && size <= 4096, "art size %d must be between 16 and 4096", size)
 	}
-}
\ No newline at end of file
+	for _, f := range c.Art.Formats {
+		check(f == "webp" || f == "avif", "art format %q is not webp or avif", f)
+	}
+	check(!c.ReadOnly ||
+		!strings.HasPrefix(c.ArtCachePath()+"/", c.MediaFolderPath()+"/"),
+		"art cache %q must be outside the media folder when read only", c.ArtCachePath())
+
+	for _, o := range c.CORS.Origins {
+		check(o == "*" || validURL(o), "cors origin %q is not * or an http(s) URL", o)
+	}
+
+	if len(problems) > 0 {
+		return errors.New("config: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validURL reports whether s is empty or an absolute http(s) URL
+func validURL(s string) bool {
+	if s == "" {
+		return true
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// SetConfig makes c the configuration loaded at startup
+func SetConfig(c Config) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	C = c
+	current = c
+}
+
+// Current returns the configuration, including any settings changed by
+// ReloadConfig since startup.
+func Current() Config {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return current
+}
+
+// ReloadConfig loads the configuration again and applies the settings which
+// are safe to change while running. It returns the names of the settings
+// which changed, and of those which changed but require a restart.
+func ReloadConfig() ([]string, []string, error) {
+	c, err := LoadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	// Settings are copied into a new config, so that configs returned by
+	// Current earlier are never modified
+	next := DefaultConfig()
+	applied, ignored := make([]string, 0), make([]string, 0)
+	for _, s := range settings {
+		from := &current
+		if !s.equal(&current, &c) {
+			if s.reload {
+				from = &c
+				applied = append(applied, s.name)
+			} else {
+				ignored = append(ignored, s.name)
+			}
+		}
+		if err := s.copy(&next, from); err != nil {
+			return nil, nil, fmt.Errorf("config: %v", err)
+		}
+	}
+
+	current = next
+	return applied, ignored, nil
+}
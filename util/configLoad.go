@@ -0,0 +1,365 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// envPrefix is prepended to the name of each setting's environment variable
+const envPrefix = "AIOMST_"
+
+var (
+	configFile = flag.String("config", "", "A JSON, YAML or TOML configuration file. Also AIOMST_CONFIG.")
+	flagValues = make(map[string]*string)
+)
+
+// setting is a single configuration option which may be set by a flag and an
+// environment variable, along with its place in the configuration file
+type setting struct {
+	// name is the flag. The environment variable is the name in upper case,
+	// with dashes replaced by underscores and prefixed with AIOMST_.
+	name  string
+	usage string
+	// reload is set for settings which are safe to change while running
+	reload bool
+	// field returns a pointer to the setting within a Config
+	field func(c *Config) interface{}
+}
+
+// settings lists every option which may be set by a flag or the environment
+var settings = []setting{
+	{"host", "The address and port to bind to.", false,
+		func(c *Config) interface{} { return &c.Host }},
+	{"media", "The media folder, which becomes the first library.", false,
+		func(c *Config) interface{} { return &c.MediaFolder }},
+	{"read-only", "Never write to the library folders.", false,
+		func(c *Config) interface{} { return &c.ReadOnly }},
+	{"clean-metadata", "Delete the metadata files earlier versions wrote into the library folders.", false,
+		func(c *Config) interface{} { return &c.CleanMetadata }},
+	{"sqlite", "The sql db location.", false,
+		func(c *Config) interface{} { return &c.Sqlite.File }},
+	{"ffmpeg", "The encoder binary used for transcoding.", false,
+		func(c *Config) interface{} { return &c.Transcoder.Binary }},
+	{"transcodes", "The maximum number of concurrent transcodes.", false,
+		func(c *Config) interface{} { return &c.Transcoder.MaxJobs }},
+	{"admin", "The username of the admin account created on first run.", false,
+		func(c *Config) interface{} { return &c.Admin.User }},
+	{"admin-password", "The password of the admin account created on first run. Generated if empty.", false,
+		func(c *Config) interface{} { return &c.Admin.Password }},
+	{"play-threshold", "The percentage of a song which must be streamed to count as a play. 0 disables.", true,
+		func(c *Config) interface{} { return &c.Scrobble.Threshold }},
+	{"listenbrainz-url", "The ListenBrainz compatible server plays are forwarded to.", false,
+		func(c *Config) interface{} { return &c.Scrobble.ListenBrainzURL }},
+	{"lastfm-url", "The Last.fm compatible API plays are forwarded to.", false,
+		func(c *Config) interface{} { return &c.Scrobble.LastFMURL }},
+	{"lastfm-key", "The Last.fm API key. Last.fm scrobbling is disabled if empty.", false,
+		func(c *Config) interface{} { return &c.Scrobble.LastFMKey }},
+	{"lastfm-secret", "The Last.fm API shared secret.", false,
+		func(c *Config) interface{} { return &c.Scrobble.LastFMSecret }},
+	{"discogs-token", "The Discogs API token. Discogs is not queried if empty.", true,
+		func(c *Config) interface{} { return &c.Discogs.Token }},
+	{"integration-interval", "How often third party metadata is fetched.", true,
+		func(c *Config) interface{} { return &c.Integration.Interval }},
+	{"integration-workers", "The number of concurrent third party requests.", true,
+		func(c *Config) interface{} { return &c.Integration.Workers }},
+	{"integration-providers", "Comma separated metadata providers used, highest priority first: musicbrainz, discogs.", true,
+		func(c *Config) interface{} { return &c.Integration.Providers }},
+	{"integration-genres", "Add the genres and styles found by metadata providers to songs.", true,
+		func(c *Config) interface{} { return &c.Integration.Genres }},
+	{"scan-workers", "The number of files whose tags are read at once while scanning.", false,
+		func(c *Config) interface{} { return &c.Scanner.Workers }},
+	{"tag-artist-separators", "Comma separated separators between the artists in a tag.", true,
+		func(c *Config) interface{} { return &c.Tags.ArtistSeparators }},
+	{"tag-featured-markers", "Comma separated words which precede featured artists, such as feat.", true,
+		func(c *Config) interface{} { return &c.Tags.FeaturedMarkers }},
+	{"tag-genre-separators", "Comma separated separators between the genres in a tag.", true,
+		func(c *Config) interface{} { return &c.Tags.GenreSeparators }},
+	{"tag-ignored-articles", "Comma separated leading words ignored when sorting artists and albums.", true,
+		func(c *Config) interface{} { return &c.Tags.IgnoredArticles }},
+	{"watch-mode", "How changes are found: auto, notify or poll.", false,
+		func(c *Config) interface{} { return &c.Watcher.Mode }},
+	{"watch-interval", "How often folders are polled for changes.", false,
+		func(c *Config) interface{} { return &c.Watcher.Interval }},
+	{"watch-debounce", "How long a folder must be quiet before its changes are scanned.", false,
+		func(c *Config) interface{} { return &c.Watcher.Debounce }},
+	{"art-priority", "Which cover an album with both uses: file or embedded.", true,
+		func(c *Config) interface{} { return &c.Art.Priority }},
+	{"art-download", "Fetch covers and artist images for albums and artists without art.", true,
+		func(c *Config) interface{} { return &c.Art.Download }},
+	{"art-cache", "The folder resized art is cached in. Defaults to thumbnails next to the sqlite file.", false,
+		func(c *Config) interface{} { return &c.Art.CacheDir }},
+	{"art-sizes", "Comma separated sizes art is resized to; requested sizes are rounded up to one.", true,
+		func(c *Config) interface{} { return &c.Art.Sizes }},
+	{"art-formats", "Comma separated formats art is sent in when clients accept them: webp, avif.", true,
+		func(c *Config) interface{} { return &c.Art.Formats }},
+	{"art-pregenerate", "Comma separated sizes resized after each scan. None if empty.", true,
+		func(c *Config) interface{} { return &c.Art.Pregenerate }},
+	{"cors-origins", "Comma separated origins allowed to make cross origin requests, or *.", true,
+		func(c *Config) interface{} { return &c.CORS.Origins }},
+}
+
+// init registers a flag for every setting, showing its default
+func init() {
+	defaults := DefaultConfig()
+	for _, s := range settings {
+		flagValues[s.name] = flag.String(s.name, s.get(&defaults), s.usage)
+	}
+}
+
+// env returns the name of the setting's environment variable
+func (s setting) env() string {
+	return envPrefix + strings.ToUpper(strings.Replace(s.name, "-", "_", -1))
+}
+
+// get returns the setting's value within c as a string
+func (s setting) get(c *Config) string {
+	switch p := s.field(c).(type) {
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *Duration:
+		return p.String()
+	case *[]string:
+		return strings.Join(*p, ",")
+	case *[]int:
+		list := make([]string, 0, len(*p))
+		for _, n := range *p {
+			list = append(list, strconv.Itoa(n))
+		}
+		return strings.Join(list, ",")
+	}
+	panic("config: unsupported setting type for " + s.name)
+}
+
+// set parses value and stores it as the setting within c
+func (s setting) set(c *Config, value string) error {
+	switch p := s.field(c).(type) {
+	case *string:
+		*p = value
+	case *int:
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", s.name, value)
+		}
+		*p = n
+	case *bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s: invalid boolean %q", s.name, value)
+		}
+		*p = b
+	case *Duration:
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q", s.name, value)
+		}
+		*p = Duration(d)
+	case *[]string:
+		list := make([]string, 0)
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+		*p = list
+	case *[]int:
+		list := make([]int, 0)
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s: invalid integer %q", s.name, v)
+			}
+			list = append(list, n)
+		}
+		*p = list
+	}
+	return nil
+}
+
+// equal reports whether the setting has the same value within a and b
+func (s setting) equal(a, b *Config) bool {
+	x, y := reflect.ValueOf(s.field(a)).Elem(), reflect.ValueOf(s.field(b)).Elem()
+	if x.Kind() == reflect.Slice && x.Len() == 0 && y.Len() == 0 {
+		// Nil and empty lists are the same setting
+		return true
+	}
+	return reflect.DeepEqual(x.Interface(), y.Interface())
+}
+
+// copy stores the setting's value within src in dst. Values are copied as
+// they are, since a list round-tripped through a string would lose the
+// spaces and commas of its items.
+func (s setting) copy(dst, src *Config) error {
+	switch p := s.field(dst).(type) {
+	case *string:
+		*p = *s.field(src).(*string)
+	case *int:
+		*p = *s.field(src).(*int)
+	case *bool:
+		*p = *s.field(src).(*bool)
+	case *Duration:
+		*p = *s.field(src).(*Duration)
+	case *[]string:
+		*p = append([]string{}, *s.field(src).(*[]string)...)
+	case *[]int:
+		*p = append([]int{}, *s.field(src).(*[]int)...)
+	default:
+		return fmt.Errorf("%s: unsupported setting type %T", s.name, p)
+	}
+	return nil
+}
+
+// LoadConfig returns the configuration built from, in increasing order of
+// precedence, the defaults, the configuration file, AIOMST_* environment
+// variables and the command line flags. The configuration is validated.
+func LoadConfig() (Config, error) {
+	flag.Parse()
+	c := DefaultConfig()
+
+	// Only flags which were passed override the other sources
+	passed := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		passed[f.Name] = true
+	})
+
+	file := os.Getenv(envPrefix + "CONFIG")
+	if passed["config"] {
+		file = *configFile
+	}
+	if file != "" {
+		if err := loadConfigFile(&c, ExpandHomeDir(file)); err != nil {
+			return c, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env()); ok {
+			if err := s.set(&c, v); err != nil {
+				return c, fmt.Errorf("config: %s: %v", s.env(), err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if passed[s.name] {
+			if err := s.set(&c, *flagValues[s.name]); err != nil {
+				return c, fmt.Errorf("config: -%v", err)
+			}
+		}
+	}
+
+	return c, c.Validate()
+}
+
+// loadConfigFile reads a JSON, YAML or TOML file, chosen by its extension,
+// over c. Keys are the JSON names of the Config fields, and unknown keys are
+// an error.
+func loadConfigFile(c *Config, file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+
+	// YAML and TOML are converted to JSON, so every format is read the same
+	var values map[string]interface{}
+	switch strings.ToLower(path.Ext(file)) {
+	case ".json":
+	case ".yaml", ".yml":
+		var y map[interface{}]interface{}
+		if err := yaml.Unmarshal(b, &y); err != nil {
+			return fmt.Errorf("config: %s: %v", file, err)
+		}
+		values = yamlToJSON(y).(map[string]interface{})
+	case ".toml":
+		if _, err := toml.Decode(string(b), &values); err != nil {
+			return fmt.Errorf("config: %s: %v", file, err)
+		}
+	default:
+		return fmt.Errorf("config: %s: unknown format, use .json, .yaml or .toml", file)
+	}
+	if values != nil {
+		if b, err = json.Marshal(values); err != nil {
+			return fmt.Errorf("config: %s: %v", file, err)
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("config: %s: %v", file, err)
+	}
+
+	// Sections set to null fall back to their defaults
+	defaults := DefaultConfig()
+	if c.Sqlite == nil {
+		c.Sqlite = defaults.Sqlite
+	}
+	if c.Transcoder == nil {
+		c.Transcoder = defaults.Transcoder
+	}
+	if c.Admin == nil {
+		c.Admin = defaults.Admin
+	}
+	if c.Scrobble == nil {
+		c.Scrobble = defaults.Scrobble
+	}
+	if c.Discogs == nil {
+		c.Discogs = defaults.Discogs
+	}
+	if c.Integration == nil {
+		c.Integration = defaults.Integration
+	}
+	if c.Scanner == nil {
+		c.Scanner = defaults.Scanner
+	}
+	if c.Tags == nil {
+		c.Tags = defaults.Tags
+	}
+	if c.Watcher == nil {
+		c.Watcher = defaults.Watcher
+	}
+	if c.Art == nil {
+		c.Art = defaults.Art
+	}
+	if c.CORS == nil {
+		c.CORS = defaults.CORS
+	}
+	return nil
+}
+
+// yamlToJSON converts the maps decoded by yaml, which may have keys of any
+// type, into maps with string keys
+func yamlToJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = yamlToJSON(v)
+		}
+		return m
+	case []interface{}:
+		for i := range t {
+			t[i] = yamlToJSON(t[i])
+		}
+		return t
+	}
+	return v
+}
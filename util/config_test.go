@@ -0,0 +1,104 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// loadTestConfig loads the configuration from a file written with body, with
+// the media folder and database in a temporary folder, and makes it the
+// configuration loaded at startup
+func loadTestConfig(t *testing.T, name, body string) Config {
+	dir := t.TempDir()
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for env, value := range map[string]string{
+		"CONFIG": file,
+		"MEDIA":  dir,
+		"SQLITE": filepath.Join(dir, "aiomst.db"),
+	} {
+		os.Setenv(envPrefix+env, value)
+		env := env
+		t.Cleanup(func() { os.Unsetenv(envPrefix + env) })
+	}
+
+	c, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetConfig(c)
+	t.Cleanup(func() { SetConfig(DefaultConfig()) })
+	return c
+}
+
+func TestReloadConfigUnchanged(t *testing.T) {
+	loaded := loadTestConfig(t, "aiomst.json", `{
+		"tags": {
+			"artistSeparators": [";", " / ", " & "],
+			"featuredMarkers": ["feat.", "ft."],
+			"genreSeparators": [",", ";"],
+			"ignoredArticles": ["The", "A"]
+		},
+		"integration": {"providers": ["musicbrainz", "discogs"]},
+		"art": {"sizes": [64, 128], "pregenerate": []}
+	}`)
+
+	applied, ignored, err := ReloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 || len(ignored) != 0 {
+		t.Fatalf("unchanged reload changed %v, and %v needing a restart", applied, ignored)
+	}
+
+	c := Current()
+	if !reflect.DeepEqual(c.Tags, loaded.Tags) {
+		t.Fatalf("tags %+v after reload, expected %+v", *c.Tags, *loaded.Tags)
+	}
+	if !reflect.DeepEqual(c.Integration.Providers, loaded.Integration.Providers) ||
+		!reflect.DeepEqual(c.Art.Sizes, loaded.Art.Sizes) {
+		t.Fatalf("lists changed by reload: providers %v, sizes %v",
+			c.Integration.Providers, c.Art.Sizes)
+	}
+}
+
+func TestReloadConfigChanged(t *testing.T) {
+	loadTestConfig(t, "aiomst.toml", `
+host = ":8090"
+
+[tags]
+artistSeparators = [";"]
+`)
+	file := os.Getenv(envPrefix + "CONFIG")
+	if err := ioutil.WriteFile(file, []byte(`
+host = ":9000"
+
+[tags]
+artistSeparators = [";", " / "]
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	applied, ignored, err := ReloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(applied, []string{"tag-artist-separators"}) ||
+		!reflect.DeepEqual(ignored, []string{"host"}) {
+		t.Fatalf("reload applied %v and ignored %v", applied, ignored)
+	}
+
+	c := Current()
+	if c.Host != ":8090" {
+		t.Fatalf("host %q changed without a restart", c.Host)
+	}
+	if !reflect.DeepEqual(c.Tags.ArtistSeparators, []string{";", " / "}) {
+		t.Fatalf("artist separators %q after reload", c.Tags.ArtistSeparators)
+	}
+}
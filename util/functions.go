@@ -1,6 +1,8 @@
 package util
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"os"
 	"strings"
@@ -41,6 +43,16 @@ func NormalizeString(s string) string 	{
 	return result
 }
 
+// RandomKey returns a hex encoded string of n cryptographically random bytes.
+// It is used for session keys, API keys and generated passwords.
+func RandomKey(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
 func InitializeLogger() {
 	Logger = log.Default()
 	Logger.SetOutput(os.Stdout)